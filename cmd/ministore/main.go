@@ -2,14 +2,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ministore/ministore/ministore"
+	"github.com/ministore/ministore/ministore/server"
 	"github.com/ministore/ministore/ministore/storage"
 	"github.com/ministore/ministore/ministore/storage/postgres"
 	"github.com/ministore/ministore/ministore/storage/sqlite"
@@ -54,6 +65,12 @@ func main() {
 		handleDiscover(ctx, args)
 	case "stats":
 		handleStats(ctx, args)
+	case "query":
+		handleQuery(ctx, args)
+	case "serve":
+		handleServe(ctx, args)
+	case "export":
+		handleExport(ctx, args)
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
 		printMainHelp()
@@ -67,7 +84,7 @@ func printMainHelp() {
 Usage: ministore <COMMAND>
 
 Commands:
-  index     Manage indexes: create, optimize, schema
+  index     Manage indexes: create, optimize, reindex, schema
   put       Insert/update docs (--path or --json JSONL)
   get       Get document by path (full JSON)
   peek      Get document metadata only
@@ -75,6 +92,9 @@ Commands:
   search    Query documents (returns matches)
   discover  Explore field values
   stats     Compute min/max/avg for fields
+  query     Save and run named queries
+  serve     Serve the index over HTTP
+  export    Write documents to stdout as JSONL
   help      Print this message or the help of the given subcommand(s)
 
 Options:
@@ -101,6 +121,12 @@ func printCommandHelp(cmd string) {
 		printDiscoverHelp("")
 	case "stats":
 		printStatsHelp()
+	case "query":
+		printQueryHelp("")
+	case "serve":
+		printServeHelp()
+	case "export":
+		printExportHelp()
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
 		os.Exit(1)
@@ -109,14 +135,18 @@ func printCommandHelp(cmd string) {
 
 func printIndexHelp(subcmd string) {
 	if subcmd == "" {
-		fmt.Println(`Manage indexes: create, optimize, schema
+		fmt.Println(`Manage indexes: create, optimize, reindex, snapshot, migrate, stats, schema
 
 Usage: ministore index <COMMAND>
 
 Commands:
   create    Create index (--schema file)
   schema    Show current schema
-  optimize  Vacuum + rebuild FTS
+  optimize  Optimize FTS, refresh stats, checkpoint WAL
+  reindex   Rebuild index tables from stored documents
+  snapshot  Write a consistent copy of the index (--out)
+  migrate   Copy the index into a new one with another schema (--to-schema, --out)
+  stats     Show item count, rows per table and size on disk
 
 Options:
   -h, --help  Print help`)
@@ -134,24 +164,76 @@ Options:
       --schema <SCHEMA>        Schema JSON file
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+      --ts-config <NAME>       PostgreSQL text search config, e.g. english [default: simple]
   -h, --help                   Print help`)
 	case "schema":
-		fmt.Println(`Show current schema
+		fmt.Println(`Show current schema, or with --diff the changes a new schema would make
 
 Usage: ministore index schema [OPTIONS]
 
 Options:
   -i, --index <INDEX>          Path to index
+      --diff <SCHEMA>          Schema JSON file to compare against (nothing is applied)
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
   -h, --help                   Print help`)
 	case "optimize":
-		fmt.Println(`Vacuum + rebuild FTS
+		fmt.Println(`Optimize FTS, refresh stats, checkpoint WAL
 
 Usage: ministore index optimize [OPTIONS]
 
 Options:
   -i, --index <INDEX>          Path to index
+      --vacuum                 Also VACUUM (rewrites the whole database; slow on large indexes)
+      --merge <PAGES>          Only do one incremental FTS merge step of about PAGES pages
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+	case "reindex":
+		fmt.Println(`Rebuild index tables from stored documents
+
+Usage: ministore index reindex [OPTIONS]
+
+Options:
+  -i, --index <INDEX>          Path to index
+  -w, --where <WHERE>          Only reindex documents matching this query
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+	case "snapshot":
+		fmt.Println(`Write a consistent copy of the index while writes continue
+
+Usage: ministore index snapshot [OPTIONS]
+
+Options:
+  -i, --index <INDEX>          Path to index
+  -o, --out <OUT>              New SQLite file, or new PostgreSQL schema name
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+	case "migrate":
+		fmt.Println(`Copy the index into a new one with another schema
+
+Every document is re-indexed against the new schema; fields it no longer has
+are dropped. The source index is left unchanged.
+
+Usage: ministore index migrate [OPTIONS]
+
+Options:
+  -i, --index <INDEX>          Path to index
+      --to-schema <SCHEMA>     Schema JSON file for the new index
+  -o, --out <OUT>              New SQLite file, or new PostgreSQL schema name
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+	case "stats":
+		fmt.Println(`Show item count, rows per table and size on disk
+
+Usage: ministore index stats [OPTIONS]
+
+Options:
+  -i, --index <INDEX>          Path to index
+      --format <FORMAT>        Output: pretty|json [default: pretty]
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
   -h, --help                   Print help`)
@@ -168,6 +250,25 @@ Options:
   -p, --path <PATH>            Document path (for single doc mode)
       --set <SETS>             Set field: key=value (repeatable)
       --json                   Read JSONL from stdin (one JSON object per line)
+      --batch-size <N>         Documents per transaction with --json [default: 1000]
+      --validate               With --json, check every line against the schema and write nothing
+      --path-field <FIELD>     With --json, take each document's path from FIELD
+      --path-template <TMPL>   With --json, build paths from fields, e.g. "/users/{id}"
+      --auto-path <GEN>        With --json, name documents without a path: uuid|hash
+      --defer-doc-freq         With --json, recompute keyword doc_freq once per batch (faster bulk loads)
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+}
+
+func printExportHelp() {
+	fmt.Println(`Write documents to stdout as JSONL
+
+Usage: ministore export [OPTIONS] --index <INDEX>
+
+Options:
+  -i, --index <INDEX>          Path to index
+  -w, --where <WHERE>          Only export documents matching this query
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
   -h, --help                   Print help`)
@@ -194,6 +295,7 @@ Usage: ministore peek [OPTIONS]
 Options:
   -i, --index <INDEX>          Path to index
   -p, --path <PATH>            Document path
+      --exists                 Only print true or false; exit 1 if absent
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
   -h, --help                   Print help`)
@@ -208,6 +310,7 @@ Options:
   -i, --index <INDEX>          Path to index
   -p, --path <PATH>            Document path (single delete)
   -w, --where <WHERE>          Query for batch delete
+      --batch-size <N>         Items per transaction with --where, for large sweeps
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
   -h, --help                   Print help`)
@@ -224,10 +327,46 @@ Options:
       --limit <LIMIT>          Max results per page [default: 20]
       --after <AFTER>          Cursor for pagination
       --cursor <CURSOR>        Cursor mode: short|full [default: short]
-      --rank <RANK>            Ranking: default|recency|none|field:<name> [default: default]
+      --rank <RANK>            Ranking: default|recency[:asc]|none|field:<name>[:asc|desc],... [default: default]
+      --missing-last           With --rank field:..., keep items lacking the field, ranked last
       --show <SHOW>            Fields: "all" or "f1,f2"
-      --format <FORMAT>        Output: pretty|paths|json [default: pretty]
-      --explain                Show query plan
+      --nested                 Return dotted --show fields as nested objects, not "a.b" keys
+      --min-score <SCORE>      Drop text matches scoring below SCORE (raw backend score, default rank only)
+      --snapshot               Keep later pages (--after) free of items inserted after this page
+      --distinct-by <FIELD>    Return only the top-ranked item per value of FIELD
+      --suggest                With no results, suggest values completing an unknown keyword value
+      --estimate-total         Count all matches, for "20 of ~340 results" (one extra query per page)
+      --allow-unanchored-or    Accept OR with one anchored side; the other is only evaluated within an enclosing AND
+      --timeout <DURATION>     Cancel the search if it runs longer, e.g. 500ms or 5s
+      --format <FORMAT>        Output: pretty|paths|json|ndjson|csv|tsv [default: pretty]
+      --array-sep <SEP>        Separator joining array values in csv/tsv cells [default: ;]
+      --explain                Show query plan (with --format json: plan tree as "explain_plan")
+      --profile                Show row count and time of each plan step (implies --explain)
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+}
+
+func printServeHelp() {
+	fmt.Println(`Serve the index over HTTP
+
+Usage: ministore serve [OPTIONS]
+
+Endpoints:
+  GET  /healthz
+  POST /put                JSONL body
+  GET  /get?path=
+  POST /delete?path=       or ?where=<query>
+  POST /search             JSON body: {"query": ..., "limit": ..., "after": ..., "rank": {...}, "show": {...}}
+  GET  /discover/fields
+  GET  /discover/values?field=&where=&top=
+  GET  /discover/facets?fields=a,b&where=&top=
+  GET  /stats?field=&where=
+  GET  /histogram?field=&where=&width=   or &interval=day|week|month
+
+Options:
+  -i, --index <INDEX>          Path to index
+      --addr <ADDR>            Listen address [default: :8080]
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
   -h, --help                   Print help`)
@@ -242,6 +381,7 @@ Usage: ministore discover <COMMAND>
 Commands:
   fields    List all fields with stats
   values    List top values for a field
+  related   List values appearing most often alongside a value
 
 Options:
   -h, --help  Print help`)
@@ -270,6 +410,23 @@ Options:
       --field <FIELD>          Field name
       --top <TOP>              Number of values [default: 20]
   -w, --where <WHERE>          Filter query
+      --idf                    Show inverse document frequency of each value
+      --total                  Also count all distinct values ("20 of 340")
+      --count-only             Print only the number of distinct values
+      --format <FORMAT>        Output: pretty|json [default: pretty]
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+	case "related":
+		fmt.Println(`List values appearing most often alongside a value, by items holding both
+
+Usage: ministore discover related [OPTIONS]
+
+Options:
+  -i, --index <INDEX>          Path to index
+      --field <FIELD>          Keyword field name
+      --value <VALUE>          Value whose co-occurring values to list
+      --top <TOP>              Number of values [default: 20]
       --format <FORMAT>        Output: pretty|json [default: pretty]
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
@@ -282,16 +439,70 @@ func printStatsHelp() {
 
 Usage: ministore stats [OPTIONS]
 
+Without --field, prints the item count, rows per table and on-disk size.
+
 Options:
   -i, --index <INDEX>          Path to index
       --field <FIELD>          Field name
   -w, --where <WHERE>          Filter query
+      --bucket-width <WIDTH>   Histogram of a number field with buckets of WIDTH
+      --interval <INTERVAL>    Histogram of a date field: day|week|month
+      --percentiles <LIST>     Also compute percentiles, e.g. 90,95,99
       --format <FORMAT>        Output: pretty|json [default: pretty]
       --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
       --schema-name <NAME>     PostgreSQL schema name [default: ministore]
   -h, --help                   Print help`)
 }
 
+func printQueryHelp(subcmd string) {
+	if subcmd == "" {
+		fmt.Println(`Save and run named queries
+
+Usage: ministore query <COMMAND>
+
+Commands:
+  save      Save a named query with search options
+  run       Run a saved query
+
+Options:
+  -h, --help  Print help`)
+		return
+	}
+
+	switch subcmd {
+	case "save":
+		fmt.Println(`Save a named query with search options
+
+Usage: ministore query save [OPTIONS]
+
+Options:
+  -i, --index <INDEX>          Path to index
+      --name <NAME>            Saved query name
+  -w, --where <WHERE>          Query (e.g. "category:rust priority>5")
+      --limit <LIMIT>          Max results per page [default: 20]
+      --rank <RANK>            Ranking: default|recency[:asc]|none|field:<name>[:asc|desc],... [default: default]
+      --show <SHOW>            Fields: "all" or "f1,f2"
+      --nested                 Return dotted --show fields as nested objects, not "a.b" keys
+      --explain                Show query plan when run
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+	case "run":
+		fmt.Println(`Run a saved query
+
+Usage: ministore query run [OPTIONS]
+
+Options:
+  -i, --index <INDEX>          Path to index
+      --name <NAME>            Saved query name
+      --after <AFTER>          Cursor for pagination
+      --format <FORMAT>        Output: pretty|paths|json [default: pretty]
+      --backend <BACKEND>      Backend: sqlite|postgres [default: sqlite]
+      --schema-name <NAME>     PostgreSQL schema name [default: ministore]
+  -h, --help                   Print help`)
+	}
+}
+
 // Argument parsing helpers
 type args struct {
 	args   []string
@@ -318,7 +529,7 @@ func parseArgs(input []string) *args {
 
 		if strings.HasPrefix(arg, "--") {
 			key := strings.TrimPrefix(arg, "--")
-			if key == "json" || key == "explain" {
+			if key == "json" || key == "explain" || key == "profile" || key == "idf" || key == "snapshot" || key == "vacuum" || key == "validate" || key == "exists" || key == "nested" || key == "suggest" || key == "total" || key == "count-only" || key == "missing-last" || key == "defer-doc-freq" || key == "estimate-total" || key == "allow-unanchored-or" {
 				a.flags[key] = true
 				i++
 				continue
@@ -390,9 +601,17 @@ var cmdDescriptions = map[string]string{
 	"stats":           "Compute min/max/avg for fields",
 	"index create":    "Create index (--schema file)",
 	"index schema":    "Show current schema",
-	"index optimize":  "Vacuum + rebuild FTS",
+	"index optimize":  "Optimize FTS, refresh stats, checkpoint WAL",
+	"index reindex":   "Rebuild index tables from stored documents",
+	"index snapshot":  "Write a consistent copy of the index (--out)",
+	"index migrate":   "Copy the index into a new one with another schema (--to-schema, --out)",
+	"index stats":     "Show item count, rows per table and size on disk",
 	"discover fields": "List all fields with stats",
 	"discover values": "List top values for a field",
+	"query save":      "Save a named query with search options",
+	"serve":           "Serve the index over HTTP",
+	"export":          "Write documents to stdout as JSONL",
+	"query run":       "Run a saved query",
 }
 
 // checkRequired validates all required arguments and exits with clap-style error if any are missing
@@ -448,10 +667,33 @@ func createAdapter(a *args) storage.Adapter {
 		if schemaName == "" {
 			schemaName = "ministore"
 		}
-		return postgres.New(indexPath, schemaName)
-	default:
+		pg := postgres.New(indexPath, schemaName)
+		pg.TextSearchConfig = a.get("ts-config")
+		return pg
+	case "", "sqlite":
 		return sqlite.NewWithDriver(indexPath, sqliteDriverName)
+	default:
+		// Falling back to SQLite would create a stray index file named
+		// after whatever --index held
+		fmt.Fprintf(os.Stderr, "Error: unknown backend '%s' (supported: sqlite, postgres)\n", backend)
+		os.Exit(1)
+		return nil
+	}
+}
+
+// destAdapter returns the adapter for a new index named dst next to the one
+// a points at: another SQLite file, or another schema on the same PostgreSQL
+// server
+func destAdapter(a *args, dst string) storage.Adapter {
+	b := &args{values: maps.Clone(a.values), flags: a.flags}
+	switch a.get("backend") {
+	case "postgres", "pg":
+		b.values["schema-name"] = dst
+	default:
+		delete(b.values, "i")
+		b.values["index"] = dst
 	}
+	return createAdapter(b)
 }
 
 // Command handlers
@@ -482,13 +724,13 @@ func handleIndex(ctx context.Context, cmdArgs []string) {
 
 		schemaData, err := os.ReadFile(vals["schema"])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 
 		var schema ministore.Schema
 		if err := json.Unmarshal(schemaData, &schema); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 
@@ -496,7 +738,7 @@ func handleIndex(ctx context.Context, cmdArgs []string) {
 		adapter := createAdapter(a)
 		ix, err := ministore.Create(ctx, adapter, schema, ministore.DefaultIndexOptions())
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		defer ix.Close()
@@ -511,11 +753,16 @@ func handleIndex(ctx context.Context, cmdArgs []string) {
 		adapter := createAdapter(a)
 		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		defer ix.Close()
 
+		if diffPath := a.get("diff"); diffPath != "" {
+			printSchemaDiff(ctx, ix, diffPath)
+			return
+		}
+
 		schema := ix.Schema()
 		schemaJSON, _ := json.MarshalIndent(schema, "", "  ")
 		fmt.Println(string(schemaJSON))
@@ -527,17 +774,118 @@ func handleIndex(ctx context.Context, cmdArgs []string) {
 		adapter := createAdapter(a)
 		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		defer ix.Close()
 
-		if err := ix.Optimize(ctx); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if a.get("merge") != "" {
+			done, err := ix.OptimizeIncremental(ctx, a.getInt("merge"))
+			if err != nil {
+				printError(err)
+				os.Exit(1)
+			}
+			if done {
+				fmt.Println("FTS index fully merged")
+			} else {
+				fmt.Println("Merge step done; more work remains")
+			}
+			return
+		}
+
+		if err := ix.Optimize(ctx, ministore.OptimizeOptions{Vacuum: a.has("vacuum")}); err != nil {
+			printError(err)
 			os.Exit(1)
 		}
 		fmt.Println("Index optimized")
 
+	case "reindex":
+		a.checkRequired("index reindex",
+			requirementCheck{name: "index", keys: []string{"i", "index"}},
+		)
+		adapter := createAdapter(a)
+		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer ix.Close()
+
+		err = ix.ReindexWhere(ctx, a.get("w", "where"), func(done, total int) {
+			fmt.Fprintf(os.Stderr, "Reindexed %d/%d items\n", done, total)
+		})
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		fmt.Println("Index rebuilt")
+
+	case "snapshot":
+		vals := a.checkRequired("index snapshot",
+			requirementCheck{name: "index", keys: []string{"i", "index"}},
+			requirementCheck{name: "out", keys: []string{"o", "out"}},
+		)
+		adapter := createAdapter(a)
+		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer ix.Close()
+
+		if err := ix.Snapshot(ctx, vals["out"]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Snapshot written to %s\n", vals["out"])
+
+	case "migrate":
+		vals := a.checkRequired("index migrate",
+			requirementCheck{name: "index", keys: []string{"i", "index"}},
+			requirementCheck{name: "to-schema", keys: []string{"to-schema"}},
+			requirementCheck{name: "out", keys: []string{"o", "out"}},
+		)
+
+		schemaData, err := os.ReadFile(vals["to-schema"])
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		var schema ministore.Schema
+		if err := json.Unmarshal(schemaData, &schema); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		adapter := createAdapter(a)
+		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer ix.Close()
+
+		n, err := ix.MigrateRebuild(ctx, destAdapter(a, vals["out"]), schema)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Migrated %d items to %s\n", n, vals["out"])
+
+	case "stats":
+		a.checkRequired("index stats",
+			requirementCheck{name: "index", keys: []string{"i", "index"}},
+		)
+		adapter := createAdapter(a)
+		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer ix.Close()
+
+		printSizeStats(ctx, ix, a.get("format"))
+
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown index command: %s\n", subcmd)
 		printIndexHelp("")
@@ -557,34 +905,60 @@ func handlePut(ctx context.Context, cmdArgs []string) {
 		requirementCheck{name: "index", keys: []string{"i", "index"}},
 	)
 
+	opts := ministore.DefaultIndexOptions()
+	switch gen := a.get("auto-path"); gen {
+	case "":
+	case "uuid":
+		opts.AutoPath = ministore.UUIDPath
+	case "hash":
+		opts.AutoPath = ministore.ContentHashPath
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --auto-path must be uuid or hash, got %q\n", gen)
+		os.Exit(1)
+	}
+
 	adapter := createAdapter(a)
-	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+	ix, err := ministore.Open(ctx, adapter, opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 	defer ix.Close()
 
-	if a.has("json") {
-		scanner := bufio.NewScanner(os.Stdin)
-		batch := ministore.NewBatch()
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
-				continue
-			}
-			if err := batch.PutJSON([]byte(line)); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
-			}
+	importOpts := ministore.ImportOptions{
+		BatchSize:    a.getInt("batch-size"),
+		PathField:    a.get("path-field"),
+		PathTemplate: a.get("path-template"),
+		DeferDocFreq: a.has("defer-doc-freq"),
+		Progress: func(done int) {
+			fmt.Fprintf(os.Stderr, "Committed %d items\n", done)
+		},
+		OnAutoPath: func(line int, path string) {
+			fmt.Printf("line %d: %s\n", line, path)
+		},
+	}
+
+	if a.has("validate") {
+		if !a.has("json") {
+			fmt.Fprintln(os.Stderr, "Error: --validate requires --json")
+			os.Exit(1)
+		}
+		valid, invalid, err := validateJSONL(os.Stdin, ix.Schema(), importOpts, opts.AutoPath)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
 		}
-		if err := scanner.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Printf("Valid: %d, invalid: %d\n", valid, invalid)
+		if invalid > 0 {
 			os.Exit(1)
 		}
-		count, err := batch.Execute(ctx, ix)
+		return
+	}
+
+	if a.has("json") {
+		count, err := ix.Import(ctx, os.Stdin, importOpts)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		fmt.Printf("Imported %d items\n", count)
@@ -610,13 +984,109 @@ func handlePut(ctx context.Context, cmdArgs []string) {
 
 		docJSON, _ := json.Marshal(doc)
 		if err := ix.PutJSON(ctx, docJSON); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: %s\n", putErrorMessage(err))
+			printSQLStatement(err)
 			os.Exit(1)
 		}
 		fmt.Printf("Put %s\n", path)
 	}
 }
 
+// putErrorMessage describes a failed put, naming the field when the document
+// was rejected by the schema
+// validateJSONL checks each JSONL document read from r against schema,
+// printing every rejected line to stderr, and counts valid and invalid lines.
+// Paths are mapped by opts and named by autoPath as Import would. Blank lines are skipped; only a read failure stops it early.
+func validateJSONL(r io.Reader, schema ministore.Schema, opts ministore.ImportOptions, autoPath func(map[string]any) (string, error)) (valid, invalid int, err error) {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return valid, invalid, readErr
+		}
+		if doc := bytes.TrimSpace(b); len(doc) > 0 {
+			batch := ministore.NewBatch()
+			doc, lineErr := opts.MapPath(doc)
+			if lineErr == nil {
+				doc, _, lineErr = ministore.ApplyAutoPath(doc, autoPath)
+			}
+			if lineErr == nil {
+				lineErr = batch.PutJSON(doc)
+			}
+			if lineErr == nil {
+				if errs := batch.Validate(schema); len(errs) > 0 {
+					lineErr = errors.Unwrap(errs[0]) // drop the batch position
+				}
+			}
+			if lineErr != nil {
+				invalid++
+				fmt.Fprintf(os.Stderr, "line %d: %v\n", line, lineErr)
+			} else {
+				valid++
+			}
+		}
+		if readErr == io.EOF {
+			return valid, invalid, nil
+		}
+	}
+}
+
+// printError reports err on stderr, followed by the failing statement when
+// err came from the database, or a hint when the query cannot work on this
+// schema
+func printError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	printSQLStatement(err)
+	if ministore.IsKind(err, ministore.ErrNoTextFields) {
+		fmt.Fprintln(os.Stderr, "  hint: the schema has no text fields, so bare words match nothing;")
+		fmt.Fprintln(os.Stderr, "        query a field instead (status:open, price>=10), or add a text field with 'ministore index migrate'")
+	}
+}
+
+// printSQLStatement prints the SQL statement behind err, if any, on one line.
+// Bound argument values are never part of it.
+func printSQLStatement(err error) {
+	var se *storage.SQLError
+	if errors.As(err, &se) && se.SQL != "" {
+		fmt.Fprintf(os.Stderr, "  statement: %s (%d args)\n", se.Statement(), se.NArgs)
+	}
+}
+
+func putErrorMessage(err error) string {
+	var e *ministore.Error
+	if errors.As(err, &e) && e.Kind == ministore.ErrSchema && e.Field != "" {
+		return fmt.Sprintf("document rejected by schema at field '%s': %s", e.Field, e.Message)
+	}
+	return err.Error()
+}
+
+func handleExport(ctx context.Context, cmdArgs []string) {
+	a := parseArgs(cmdArgs)
+	if a.has("help") {
+		printExportHelp()
+		return
+	}
+
+	a.checkRequired("export",
+		requirementCheck{name: "index", keys: []string{"i", "index"}},
+	)
+
+	adapter := createAdapter(a)
+	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	defer ix.Close()
+
+	count, err := ix.Export(ctx, os.Stdout, ministore.ExportOptions{Where: a.get("w", "where")})
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d items\n", count)
+}
+
 func handleGet(ctx context.Context, cmdArgs []string) {
 	a := parseArgs(cmdArgs)
 	if a.has("help") {
@@ -633,7 +1103,7 @@ func handleGet(ctx context.Context, cmdArgs []string) {
 	adapter := createAdapter(a)
 	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 	defer ix.Close()
@@ -644,7 +1114,7 @@ func handleGet(ctx context.Context, cmdArgs []string) {
 			fmt.Fprintf(os.Stderr, "Error: item not found: %s\n", vals["path"])
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 
@@ -670,18 +1140,31 @@ func handlePeek(ctx context.Context, cmdArgs []string) {
 	adapter := createAdapter(a)
 	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 	defer ix.Close()
 
+	if a.has("exists") {
+		ok, err := ix.Exists(ctx, vals["path"])
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		fmt.Println(ok)
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
 	data, err := ix.Peek(ctx, vals["path"])
 	if err != nil {
 		if ministore.IsKind(err, ministore.ErrNotFound) {
 			fmt.Fprintf(os.Stderr, "Error: item not found: %s\n", vals["path"])
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 
@@ -709,7 +1192,7 @@ func handleDelete(ctx context.Context, cmdArgs []string) {
 	adapter := createAdapter(a)
 	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 	defer ix.Close()
@@ -731,7 +1214,7 @@ func handleDelete(ctx context.Context, cmdArgs []string) {
 	if path != "" {
 		deleted, err := ix.Delete(ctx, path)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		if deleted {
@@ -740,9 +1223,15 @@ func handleDelete(ctx context.Context, cmdArgs []string) {
 			fmt.Printf("Not found: %s\n", path)
 		}
 	} else {
-		count, err := ix.DeleteWhere(ctx, where)
+		var count int
+		var err error
+		if a.get("batch-size") != "" {
+			count, err = ix.DeleteWhereBatched(ctx, where, a.getInt("batch-size"))
+		} else {
+			count, err = ix.DeleteWhere(ctx, where)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		fmt.Printf("Deleted %d items\n", count)
@@ -763,81 +1252,309 @@ func handleSearch(ctx context.Context, cmdArgs []string) {
 
 	a.values["index"] = vals["index"]
 	adapter := createAdapter(a)
-	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+	ixOpts := ministore.DefaultIndexOptions()
+	ixOpts.AllowUnanchoredOrBranches = a.has("allow-unanchored-or")
+	ix, err := ministore.Open(ctx, adapter, ixOpts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 	defer ix.Close()
 
-	opts := ministore.SearchOptions{
-		Limit:   20,
-		After:   a.get("after"),
-		Explain: a.has("explain"),
+	opts := searchOptionsFromArgs(a)
+	format := a.get("format")
+	if (format == "csv" || format == "tsv") && opts.Show.Kind == ministore.ShowAll {
+		fmt.Fprintf(os.Stderr, "Error: --format %s needs --show f1,f2 to pick its columns\n", format)
+		os.Exit(1)
 	}
 
-	if limit := a.getInt("limit"); limit > 0 {
-		opts.Limit = limit
+	result, err := ix.Search(ctx, vals["where"], opts)
+	if err != nil {
+		printError(err)
+		os.Exit(1)
 	}
 
-	// Parse show
-	show := a.get("show")
-	switch show {
-	case "", "none":
-		opts.Show.Kind = ministore.ShowNone
-	case "all":
-		opts.Show.Kind = ministore.ShowAll
-	default:
-		opts.Show.Kind = ministore.ShowFields
-		opts.Show.Fields = strings.Split(show, ",")
-	}
+	switch format {
+	case "csv", "tsv":
+		comma := ','
+		if format == "tsv" {
+			comma = '\t'
+		}
+		sep := ";"
+		if v, ok := a.values["array-sep"]; ok {
+			sep = v
+		}
+		if err := printSearchTable(os.Stdout, result, opts.Show.Fields, comma, sep); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "ndjson":
+		if err := printSearchNDJSON(os.Stdout, result); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	default:
+		printSearchResult(result, opts.Explain, format)
+	}
+}
+
+// printSearchTable writes result as CSV (or TSV, with comma '\t'): a header
+// of path and fields, then one row per item. Array values are joined with
+// arraySep and objects are written as JSON. The page footer goes to stderr
+// so the output can be loaded as is.
+func printSearchTable(w io.Writer, result ministore.SearchResultPage, fields []string, comma rune, arraySep string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	if err := cw.Write(append([]string{"path"}, fields...)); err != nil {
+		return err
+	}
+	for _, item := range result.Items {
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.UseNumber()
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			return fmt.Errorf("decode result: %w", err)
+		}
+		row := []string{tableCell(obj["path"], arraySep)}
+		for _, f := range fields {
+			row = append(row, tableCell(lookupShown(obj, f), arraySep))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "--- %s", resultCount(result))
+	if result.HasMore {
+		fmt.Fprint(os.Stderr, ", more available")
+		if result.NextCursor != "" {
+			fmt.Fprintf(os.Stderr, " (cursor: %s)", result.NextCursor)
+		}
+	}
+	fmt.Fprintln(os.Stderr, " ---")
+	return nil
+}
+
+// printSearchNDJSON writes each result item, which always carries its path,
+// as one JSON line, flushing after every line so a reader downstream sees
+// results as they are written. The next cursor, if any, goes to stderr.
+func printSearchNDJSON(w io.Writer, result ministore.SearchResultPage) error {
+	bw := bufio.NewWriter(w)
+	for _, item := range result.Items {
+		if _, err := bw.Write(item); err != nil {
+			return err
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	if result.HasMore && result.NextCursor != "" {
+		fmt.Fprintf(os.Stderr, "next cursor: %s\n", result.NextCursor)
+	}
+	return nil
+}
+
+// lookupShown returns the value of a --show field in a result, whether it was
+// projected as a flat "a.b" key or, with --nested, as nested objects
+func lookupShown(obj map[string]any, field string) any {
+	if v, ok := obj[field]; ok {
+		return v
+	}
+	var cur any = obj
+	for _, part := range strings.Split(field, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	return cur
+}
+
+// tableCell formats a JSON value for one CSV cell; missing and null values
+// are empty
+func tableCell(v any, arraySep string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = tableCell(e, arraySep)
+		}
+		return strings.Join(parts, arraySep)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// printSchemaDiff prints what applying the schema in path would change. It
+// exits non-zero if the change needs MigrateRebuild.
+func printSchemaDiff(ctx context.Context, ix *ministore.Index, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	var schema ministore.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid schema JSON: %v\n", err)
+		os.Exit(1)
+	}
+
+	change, applyErr := ix.ApplySchema(ctx, schema, ministore.ApplySchemaOptions{DryRun: true})
+	diff := change.Diff
+	if diff.Empty() && applyErr == nil {
+		fmt.Println("No field changes")
+	}
+	for _, name := range diff.Added {
+		fmt.Printf("+ %s (%s)\n", name, schema.Fields[name].Type)
+	}
+	for _, name := range diff.Removed {
+		fmt.Printf("- %s (%s)\n", name, ix.Schema().Fields[name].Type)
+	}
+	for _, c := range diff.Changed {
+		oldJSON, _ := json.Marshal(c.Old)
+		newJSON, _ := json.Marshal(c.New)
+		fmt.Printf("~ %s %s -> %s\n", c.Field, oldJSON, newJSON)
+	}
+	if applyErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", applyErr)
+		os.Exit(1)
+	}
+	if len(change.DDL) > 0 {
+		fmt.Println("\nDDL:")
+		for _, stmt := range change.DDL {
+			fmt.Printf("  %s;\n", stmt)
+		}
+	}
+}
+
+// resultCount reads "N results", or "N of ~T results" when the page carries
+// a total estimate
+func resultCount(result ministore.SearchResultPage) string {
+	if result.EstimatedTotal != nil {
+		return fmt.Sprintf("%d of ~%d results", result.Returned, *result.EstimatedTotal)
+	}
+	return fmt.Sprintf("%d results", len(result.Items))
+}
+
+// searchOptionsFromArgs builds search options from --limit, --after, --show,
+// --nested, --rank, --missing-last, --min-score, --snapshot, --distinct-by, --suggest,
+// --estimate-total, --timeout, --explain and --profile.
+func searchOptionsFromArgs(a *args) ministore.SearchOptions {
+	opts := ministore.SearchOptions{
+		Limit:          a.getInt("limit"),
+		After:          a.get("after"),
+		Explain:        a.has("explain") || a.has("profile"),
+		Profile:        a.has("profile"),
+		Snapshot:       a.has("snapshot"),
+		DistinctBy:     a.get("distinct-by"),
+		SuggestOnEmpty: a.has("suggest"),
+		EstimateTotal:  a.has("estimate-total"),
+	}
+
+	if a.get("format") == "json" {
+		opts.ExplainFormat = ministore.ExplainFormatJSON
+	}
+	if v := a.get("min-score"); v != "" {
+		minScore, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --min-score %q\n", v)
+			os.Exit(1)
+		}
+		opts.MinScore = &minScore
+	}
+	if v := a.get("timeout"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --timeout %q\n", v)
+			os.Exit(1)
+		}
+		opts.Timeout = timeout
+	}
+
+	// Parse show
+	show := a.get("show")
+	switch show {
+	case "", "none":
+		opts.Show.Kind = ministore.ShowNone
+	case "all":
+		opts.Show.Kind = ministore.ShowAll
+	default:
+		opts.Show.Kind = ministore.ShowFields
+		opts.Show.Fields = strings.Split(show, ",")
+		opts.Show.Nested = a.has("nested")
+	}
 
 	// Parse rank
+	opts.Rank.MissingLast = a.has("missing-last")
 	rank := a.get("rank")
 	switch {
 	case rank == "" || rank == "default":
 		opts.Rank.Kind = ministore.RankDefault
-	case rank == "recency":
+	case rank == "recency" || rank == "recency:desc":
 		opts.Rank.Kind = ministore.RankRecency
+	case rank == "recency:asc":
+		opts.Rank.Kind = ministore.RankRecency
+		opts.Rank.Ascending = true
 	case rank == "none":
 		opts.Rank.Kind = ministore.RankNone
 	case strings.HasPrefix(rank, "field:"):
+		// field:priority[:asc|desc] or field:priority:desc,due:asc
 		opts.Rank.Kind = ministore.RankField
-		opts.Rank.Field = strings.TrimPrefix(rank, "field:")
+		specs := strings.Split(strings.TrimPrefix(rank, "field:"), ",")
+		if len(specs) == 1 {
+			name, dir, _ := strings.Cut(specs[0], ":")
+			opts.Rank.Field = name
+			opts.Rank.Ascending = strings.EqualFold(dir, "asc")
+			break
+		}
+		for _, spec := range specs {
+			name, dir, _ := strings.Cut(spec, ":")
+			opts.Rank.Keys = append(opts.Rank.Keys, ministore.SortKey{
+				Field: name,
+				Desc:  !strings.EqualFold(dir, "asc"),
+			})
+		}
 	}
 
-	result, err := ix.Search(ctx, vals["where"], opts)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
+	return opts
+}
 
-	format := a.get("format")
+func printSearchResult(result ministore.SearchResultPage, explain bool, format string) {
 	if format == "json" {
-		output := map[string]any{
-			"items":    make([]any, 0, len(result.Items)),
-			"has_more": result.HasMore,
-		}
-		if result.NextCursor != "" {
-			output["next_cursor"] = result.NextCursor
-		}
-		for _, item := range result.Items {
-			var obj any
-			if json.Unmarshal(item, &obj) == nil {
-				output["items"] = append(output["items"].([]any), obj)
-			}
-		}
-		jsonOut, _ := json.Marshal(output)
+		jsonOut, _ := json.Marshal(server.SearchResultJSON(result))
 		fmt.Println(string(jsonOut))
 		return
 	}
 
 	// Pretty format
-	if opts.Explain {
+	if explain {
 		fmt.Println("=== Query Plan ===")
 		for _, step := range result.ExplainSteps {
 			fmt.Printf("  %s\n", step)
 		}
+		if len(result.Profile) > 0 {
+			fmt.Println("\n=== Profile ===")
+			for _, p := range result.Profile {
+				fmt.Printf("  %-8s %8d rows %12s  %s\n", p.CTE, p.Rows, p.Elapsed, p.Step)
+			}
+		}
 		fmt.Println("\n=== SQL ===")
 		fmt.Println(result.ExplainSQL)
 		fmt.Println("\n=== Results ===")
@@ -853,7 +1570,14 @@ func handleSearch(ctx context.Context, cmdArgs []string) {
 		}
 	}
 
-	fmt.Printf("\n--- %d results", len(result.Items))
+	if len(result.Suggestions) > 0 {
+		fmt.Printf("Did you mean (%s):\n", result.SuggestField)
+		for _, s := range result.Suggestions {
+			fmt.Printf("  %s (%d)\n", s.Value, s.Count)
+		}
+	}
+
+	fmt.Printf("\n--- %s", resultCount(result))
 	if result.HasMore {
 		fmt.Print(", more available")
 		if result.NextCursor != "" {
@@ -891,14 +1615,14 @@ func handleDiscover(ctx context.Context, cmdArgs []string) {
 		adapter := createAdapter(a)
 		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		defer ix.Close()
 
 		fields, err := ix.DiscoverFields(ctx)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 
@@ -931,7 +1655,7 @@ func handleDiscover(ctx context.Context, cmdArgs []string) {
 		adapter := createAdapter(a)
 		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
 		defer ix.Close()
@@ -942,19 +1666,118 @@ func handleDiscover(ctx context.Context, cmdArgs []string) {
 			top = 20
 		}
 
-		values, err := ix.DiscoverValues(ctx, vals["field"], where, top)
+		if a.has("count-only") {
+			page, err := ix.DiscoverValuesTotal(ctx, vals["field"], where, 1)
+			if err != nil {
+				printError(err)
+				os.Exit(1)
+			}
+			if format == "json" {
+				jsonOut, _ := json.Marshal(map[string]uint64{"TotalDistinct": page.TotalDistinct})
+				fmt.Println(string(jsonOut))
+				return
+			}
+			fmt.Println(page.TotalDistinct)
+			return
+		}
+
+		var page ministore.ValuesPage
+		if a.has("total") {
+			page, err = ix.DiscoverValuesTotal(ctx, vals["field"], where, top)
+		} else {
+			page.Values, err = ix.DiscoverValues(ctx, vals["field"], where, top)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			printError(err)
 			os.Exit(1)
 		}
+		values := page.Values
+
+		if a.has("idf") {
+			// IDF comes from index-wide doc frequencies, even with --where
+			stats, err := ix.KeywordStats(ctx, vals["field"])
+			if err != nil {
+				printError(err)
+				os.Exit(1)
+			}
+			idf := make(map[string]float64, len(stats))
+			for _, s := range stats {
+				idf[s.Value] = s.IDF()
+			}
+
+			if format == "json" {
+				type valueIDF struct {
+					Value string
+					Count uint64
+					IDF   float64
+				}
+				out := make([]valueIDF, 0, len(values))
+				for _, v := range values {
+					out = append(out, valueIDF{Value: v.Value, Count: v.Count, IDF: idf[v.Value]})
+				}
+				jsonOut, _ := json.Marshal(out)
+				fmt.Println(string(jsonOut))
+				return
+			}
+
+			fmt.Printf("Top values for '%s':\n", vals["field"])
+			for _, v := range values {
+				fmt.Printf("  %s: %d (idf %.3f)\n", v.Value, v.Count, idf[v.Value])
+			}
+			return
+		}
 
 		if format == "json" {
-			jsonOut, _ := json.Marshal(values)
+			var jsonOut []byte
+			if a.has("total") {
+				jsonOut, _ = json.Marshal(page)
+			} else {
+				jsonOut, _ = json.Marshal(values)
+			}
 			fmt.Println(string(jsonOut))
 			return
 		}
 
-		fmt.Printf("Top values for '%s':\n", vals["field"])
+		if a.has("total") {
+			fmt.Printf("Top values for '%s' (%d of %d):\n", vals["field"], len(values), page.TotalDistinct)
+		} else {
+			fmt.Printf("Top values for '%s':\n", vals["field"])
+		}
+		for _, v := range values {
+			fmt.Printf("  %s: %d\n", v.Value, v.Count)
+		}
+
+	case "related":
+		vals := a.checkRequired("discover related",
+			requirementCheck{name: "index", keys: []string{"i", "index"}},
+			requirementCheck{name: "field", keys: []string{"field"}},
+			requirementCheck{name: "value", keys: []string{"value"}},
+		)
+		a.values["index"] = vals["index"]
+		adapter := createAdapter(a)
+		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer ix.Close()
+
+		top := a.getInt("top")
+		if top == 0 {
+			top = 20
+		}
+		values, err := ix.RelatedValues(ctx, vals["field"], vals["value"], top)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		if format == "json" {
+			jsonOut, _ := json.Marshal(values)
+			fmt.Println(string(jsonOut))
+			return
+		}
+		fmt.Printf("Values appearing with %s:%s:\n", vals["field"], vals["value"])
 		for _, v := range values {
 			fmt.Printf("  %s: %d\n", v.Value, v.Count)
 		}
@@ -975,44 +1798,88 @@ func handleStats(ctx context.Context, cmdArgs []string) {
 
 	vals := a.checkRequired("stats",
 		requirementCheck{name: "index", keys: []string{"i", "index"}},
-		requirementCheck{name: "field", keys: []string{"field"}},
+		requirementCheck{name: "field", keys: []string{"field"}, optional: true},
 	)
 
 	a.values["index"] = vals["index"]
 	adapter := createAdapter(a)
 	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(err)
 		os.Exit(1)
 	}
 	defer ix.Close()
 
 	where := a.get("w", "where")
-	stats, err := ix.Stats(ctx, vals["field"], where)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	format := a.get("format")
+
+	// Without a field, report how big the index is
+	if vals["field"] == "" {
+		printSizeStats(ctx, ix, format)
+		return
 	}
 
-	format := a.get("format")
-	if format == "json" {
-		output := map[string]any{
-			"field": stats.Field,
-			"count": stats.Count,
+	if interval := a.get("interval"); interval != "" {
+		buckets, err := ix.DateHistogram(ctx, vals["field"], where, ministore.DateInterval(interval))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
 		}
-		if stats.Min != nil {
-			output["min"] = *stats.Min
+		if format == "json" {
+			jsonOut, _ := json.Marshal(buckets)
+			fmt.Println(string(jsonOut))
+			return
 		}
-		if stats.Max != nil {
-			output["max"] = *stats.Max
+		fmt.Printf("Histogram for '%s' by %s:\n", vals["field"], interval)
+		for _, b := range buckets {
+			fmt.Printf("  %s: %d\n", b.Start.Format("2006-01-02"), b.Count)
+		}
+		return
+	}
+
+	if v := a.get("bucket-width"); v != "" {
+		width, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --bucket-width %q\n", v)
+			os.Exit(1)
 		}
-		if stats.Avg != nil {
-			output["avg"] = *stats.Avg
+		buckets, err := ix.Histogram(ctx, vals["field"], where, width)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
 		}
-		if stats.Median != nil {
-			output["median"] = *stats.Median
+		if format == "json" {
+			jsonOut, _ := json.Marshal(buckets)
+			fmt.Println(string(jsonOut))
+			return
 		}
-		jsonOut, _ := json.Marshal(output)
+		fmt.Printf("Histogram for '%s':\n", vals["field"])
+		for _, b := range buckets {
+			fmt.Printf("  [%g, %g): %d\n", b.Lo, b.Hi, b.Count)
+		}
+		return
+	}
+
+	var percentiles []float64
+	if v := a.get("percentiles"); v != "" {
+		for _, ps := range strings.Split(v, ",") {
+			p, err := strconv.ParseFloat(strings.TrimSpace(ps), 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid --percentiles value %q\n", ps)
+				os.Exit(1)
+			}
+			percentiles = append(percentiles, p)
+		}
+	}
+
+	stats, err := ix.Stats(ctx, vals["field"], where, percentiles...)
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+
+	if format == "json" {
+		jsonOut, _ := json.Marshal(server.StatsJSON(stats))
 		fmt.Println(string(jsonOut))
 		return
 	}
@@ -1031,4 +1898,167 @@ func handleStats(ctx context.Context, cmdArgs []string) {
 	if stats.Median != nil {
 		fmt.Printf("  Median: %.2f\n", *stats.Median)
 	}
+	for _, p := range percentiles {
+		if v, ok := stats.Percentiles[p]; ok {
+			fmt.Printf("  %s: %.2f\n", strings.ToUpper(server.PercentileKey(p)), v)
+		}
+	}
+}
+
+// printSizeStats prints the item count, the row count of each index table
+// and the on-disk sizes from Index.SizeStats
+func printSizeStats(ctx context.Context, ix *ministore.Index, format string) {
+	stats, err := ix.SizeStats(ctx)
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	if format == "json" {
+		jsonOut, _ := json.Marshal(map[string]any{
+			"items":         stats.Items,
+			"deleted_items": stats.DeletedItems,
+			"rows":          stats.Rows,
+			"bytes":         stats.Bytes,
+			"table_bytes":   stats.TableBytes,
+		})
+		fmt.Println(string(jsonOut))
+		return
+	}
+
+	fmt.Printf("Items: %d (%d deleted)\n", stats.Items, stats.DeletedItems)
+	fmt.Printf("Size: %d bytes\n", stats.Bytes)
+	tables := make([]string, 0, len(stats.Rows))
+	for t := range stats.Rows {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	fmt.Println("Tables:")
+	for _, t := range tables {
+		if size, ok := stats.TableBytes[t]; ok {
+			fmt.Printf("  %-14s %10d rows %12d bytes\n", t, stats.Rows[t], size)
+		} else {
+			fmt.Printf("  %-14s %10d rows\n", t, stats.Rows[t])
+		}
+	}
+}
+
+func handleQuery(ctx context.Context, cmdArgs []string) {
+	if len(cmdArgs) == 0 || cmdArgs[0] == "-h" || cmdArgs[0] == "--help" || cmdArgs[0] == "help" {
+		if len(cmdArgs) > 1 {
+			printQueryHelp(cmdArgs[1])
+		} else {
+			printQueryHelp("")
+		}
+		return
+	}
+
+	subcmd := cmdArgs[0]
+	a := parseArgs(cmdArgs[1:])
+
+	if a.has("help") {
+		printQueryHelp(subcmd)
+		return
+	}
+
+	switch subcmd {
+	case "save":
+		vals := a.checkRequired("query save",
+			requirementCheck{name: "index", keys: []string{"i", "index"}},
+			requirementCheck{name: "name", keys: []string{"name"}},
+			requirementCheck{name: "where", keys: []string{"w", "where"}},
+		)
+
+		a.values["index"] = vals["index"]
+		adapter := createAdapter(a)
+		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer ix.Close()
+
+		if err := ix.SaveQuery(ctx, vals["name"], vals["where"], searchOptionsFromArgs(a)); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Saved query: %s\n", vals["name"])
+
+	case "run":
+		vals := a.checkRequired("query run",
+			requirementCheck{name: "index", keys: []string{"i", "index"}},
+			requirementCheck{name: "name", keys: []string{"name"}},
+		)
+
+		a.values["index"] = vals["index"]
+		adapter := createAdapter(a)
+		ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer ix.Close()
+
+		result, err := ix.RunSavedQuery(ctx, vals["name"], a.get("after"))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		printSearchResult(result, result.ExplainSQL != "", a.get("format"))
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown query command: %s\n", subcmd)
+		printQueryHelp("")
+		os.Exit(1)
+	}
+}
+
+// serveShutdownTimeout bounds how long in-flight requests get to finish after
+// SIGINT/SIGTERM
+const serveShutdownTimeout = 10 * time.Second
+
+func handleServe(ctx context.Context, cmdArgs []string) {
+	a := parseArgs(cmdArgs)
+	if a.has("help") {
+		printServeHelp()
+		return
+	}
+
+	vals := a.checkRequired("serve",
+		requirementCheck{name: "index", keys: []string{"i", "index"}},
+	)
+	addr := a.get("addr")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	a.values["index"] = vals["index"]
+	adapter := createAdapter(a)
+	ix, err := ministore.Open(ctx, adapter, ministore.DefaultIndexOptions())
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	defer ix.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: addr, Handler: server.New(ix)}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	fmt.Fprintf(os.Stderr, "Serving %s on %s\n", vals["index"], addr)
+
+	select {
+	case err := <-errc:
+		printError(err)
+		ix.Close()
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: shutdown: %v\n", err)
+	}
 }
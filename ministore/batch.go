@@ -3,6 +3,9 @@ package ministore
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+
+	"github.com/ministore/ministore/ministore/ops"
 )
 
 type BatchOpKind int
@@ -13,32 +16,73 @@ const (
 )
 
 type BatchOp struct {
-	Kind BatchOpKind
-	Doc  []byte // for put
-	Path string // for delete
+	Kind    BatchOpKind
+	Doc     []byte         // for put
+	Decoded map[string]any // for Put: the document Doc encodes
+	Path    string         // for delete
 }
 
 type Batch struct {
 	ops []BatchOp
+
+	// DeferDocFreq skips the per-value doc_freq updates of each put and
+	// recomputes the doc_freq of every touched keyword value in one pass
+	// before commit, which is cheaper for bulk loads of many keywords
+	DeferDocFreq bool
 }
 
 func NewBatch() Batch {
 	return Batch{ops: make([]BatchOp, 0)}
 }
 
+// PutJSON adds a put of a JSON document. A document without a path is named
+// by IndexOptions.AutoPath when the batch executes, and rejected then if the
+// index has none.
 func (b *Batch) PutJSON(doc []byte) error {
 	var m map[string]any
 	if err := json.Unmarshal(doc, &m); err != nil {
 		return Wrap(ErrSchema, "document json", err)
 	}
-	p, ok := m["path"].(string)
-	if !ok || p == "" {
-		return New(ErrSchema, "document must contain non-empty 'path'")
+	if err := checkBatchPath(m); err != nil {
+		return err
 	}
 	b.ops = append(b.ops, BatchOp{Kind: batchPut, Doc: doc})
 	return nil
 }
 
+// Put adds a put of a document the caller already holds decoded. It is
+// marshalled once, for storage, and executing the batch extracts index
+// values from doc itself rather than decoding the JSON again. doc must not
+// change until the batch has executed. Values other than the ones
+// encoding/json decodes to (an int, a []string, ...) are accepted, and
+// indexed as their JSON encoding reads, at the cost of that decode. A
+// document without a path is named as for PutJSON; doc itself is not
+// modified.
+func (b *Batch) Put(doc map[string]any) error {
+	if err := checkBatchPath(doc); err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return Wrap(ErrSchema, "document json", err)
+	}
+	b.ops = append(b.ops, BatchOp{Kind: batchPut, Doc: data, Decoded: doc})
+	return nil
+}
+
+// checkBatchPath rejects a document whose path is set but not a non-empty
+// string. A missing path is left for AutoPath.
+func checkBatchPath(doc map[string]any) error {
+	p, ok := doc["path"]
+	if !ok {
+		return nil
+	}
+	if s, ok := p.(string); !ok || s == "" {
+		return New(ErrSchema, "document 'path' must be a non-empty string")
+	}
+	return nil
+}
+
 func (b *Batch) Delete(path string) error {
 	if path == "" {
 		return New(ErrSchema, "path cannot be empty")
@@ -59,3 +103,42 @@ func (b *Batch) Empty() bool {
 func (b *Batch) Execute(ctx context.Context, ix *Index) (int, error) {
 	return ix.Batch(ctx, *b)
 }
+
+// BatchError is a batch operation rejected by Validate
+type BatchError struct {
+	Op  int // position of the operation in the batch, from 0
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch op %d: %v", e.Op, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks every put in the batch against schema, with the same
+// extraction Execute runs, and writes nothing. It returns one *BatchError per
+// rejected document, or nil if all of them would be accepted. Validate does
+// not know the index's AutoPath, so documents without a path are rejected.
+func (b *Batch) Validate(schema Schema) []error {
+	s := schema.AsStorageSchema()
+	var errs []error
+	for i, op := range b.ops {
+		if op.Kind != batchPut {
+			continue
+		}
+		// Bare dates parse the same in any time zone, so UTC will do
+		var err error
+		if op.Decoded != nil {
+			_, err = ops.PreparePutDoc(s, op.Decoded, op.Doc, nil)
+		} else {
+			_, err = ops.PreparePut(s, op.Doc, nil)
+		}
+		if err != nil {
+			errs = append(errs, &BatchError{Op: i, Err: prepareError("prepare put", err)})
+		}
+	}
+	return errs
+}
@@ -7,4 +7,12 @@ const (
 	DefaultMinPrefixLen       = 2
 	DefaultMaxPrefixExpansion = 20000
 	DefaultCursorTTL          = time.Hour
+	DefaultSearchLimit        = 20 // results per page when SearchOptions.Limit is 0
+	DefaultMigrateBatchSize   = 500
+	DefaultImportBatchSize    = 1000
+	DefaultDeleteBatchSize    = 10000
+	DefaultMergePages         = 500 // FTS pages written per OptimizeIncremental call
+	GetManyChunkSize          = 500 // stays under SQLite's 999 bound-parameter limit
+	KeywordStatsPageSize      = 1000
+	MaxSuggestions            = 10 // values returned with SearchOptions.SuggestOnEmpty
 )
@@ -0,0 +1,278 @@
+package ministore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ministore/ministore/ministore/ops"
+)
+
+// DocStore keeps full documents outside the index. When IndexOptions.DocStore
+// is set, items.data_json only holds path and the indexed fields; Get, Peek
+// and search results with ShowAll read the full document back from the store.
+// Get must return an error wrapping fs.ErrNotExist for unknown paths, in which
+// case the stored projection is used instead. Delete is called once an item is
+// deleted for good (not soft-deleted) or moved to another path, and must
+// succeed for unknown paths.
+type DocStore interface {
+	Put(path string, doc []byte) error
+	Get(path string) ([]byte, error)
+	Delete(path string) error
+}
+
+// FileDocStore is a DocStore keeping one file per document under a directory.
+// File names are derived from a hash of the path, so any path is allowed.
+type FileDocStore struct {
+	dir string
+}
+
+// NewFileDocStore creates dir if needed and returns a store rooted there
+func NewFileDocStore(dir string) (*FileDocStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, Wrap(ErrIO, "create doc store", err)
+	}
+	return &FileDocStore{dir: dir}, nil
+}
+
+// Put writes doc atomically, replacing any previous version
+func (s *FileDocStore) Put(path string, doc []byte) error {
+	name := s.file(path)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(doc); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Get reads the document stored for path
+func (s *FileDocStore) Get(path string) ([]byte, error) {
+	return os.ReadFile(s.file(path))
+}
+
+// Delete removes the document stored for path, if any
+func (s *FileDocStore) Delete(path string) error {
+	err := os.Remove(s.file(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// file returns <dir>/<first two hex digits>/<sha256 of path>.json
+func (s *FileDocStore) file(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	h := hex.EncodeToString(sum[:])
+	return filepath.Join(s.dir, h[:2], h+".json")
+}
+
+// preparePut prepares docJSON for writing against schema. With CanonicalizeJSON it is
+// re-encoded in canonical form; with a DocStore only the projection of
+// indexed fields is kept in data_json; with CompressDocs it is stored
+// gzipped.
+func (ix *Index) preparePut(schema Schema, docJSON []byte) (*ops.PutPrepared, error) {
+	prep, err := ops.PreparePut(schema.AsStorageSchema(), docJSON, ix.opts.Location)
+	if err != nil {
+		return nil, err
+	}
+	return ix.encodePrepared(prep)
+}
+
+// preparePutDoc is preparePut for a document already decoded from docJSON
+func (ix *Index) preparePutDoc(schema Schema, doc map[string]any, docJSON []byte) (*ops.PutPrepared, error) {
+	prep, err := ops.PreparePutDoc(schema.AsStorageSchema(), doc, docJSON, ix.opts.Location)
+	if err != nil {
+		return nil, err
+	}
+	return ix.encodePrepared(prep)
+}
+
+// encodePrepared applies the index's storage options to prep.DataJSON
+func (ix *Index) encodePrepared(prep *ops.PutPrepared) (*ops.PutPrepared, error) {
+	var err error
+	prep.UpsertKey = ix.opts.UpsertKey
+	if ix.opts.CanonicalizeJSON {
+		if prep.DataJSON, err = ops.CanonicalizeDoc(prep.DataJSON); err != nil {
+			return nil, err
+		}
+	}
+	if ix.opts.DocStore != nil {
+		if prep.DataJSON, err = ops.ProjectDoc(prep); err != nil {
+			return nil, err
+		}
+	}
+	if ix.opts.CompressDocs {
+		if prep.DataJSON, err = ops.CompressDoc(prep.DataJSON); err != nil {
+			return nil, err
+		}
+		prep.DataEnc = ops.DocEncGzip
+	}
+	return prep, nil
+}
+
+// docWrites collects the DocStore writes of one transaction so that every
+// write path applies them the same way, through commitDocs and removeDocs. A
+// nil *docWrites, as returned by newDocWrites without a DocStore, collects
+// nothing.
+type docWrites struct {
+	puts    map[string][]byte
+	deletes map[string]bool
+}
+
+// newDocWrites returns a collector, or nil if the index has no DocStore
+func (ix *Index) newDocWrites() *docWrites {
+	if ix.opts.DocStore == nil {
+		return nil
+	}
+	return &docWrites{puts: make(map[string][]byte), deletes: make(map[string]bool)}
+}
+
+// put records docJSON as the new document for path
+func (w *docWrites) put(path string, docJSON []byte) {
+	if w == nil {
+		return
+	}
+	delete(w.deletes, path)
+	w.puts[path] = docJSON
+}
+
+// remove records that the document for path goes away
+func (w *docWrites) remove(path string) {
+	if w == nil {
+		return
+	}
+	delete(w.puts, path)
+	w.deletes[path] = true
+}
+
+// recordPut records the document written by an executed put, dropping the
+// one left behind when an upsert key moved the item from another path
+func (w *docWrites) recordPut(prep *ops.PutPrepared, docJSON []byte) {
+	if prep.MovedFrom != "" {
+		w.remove(prep.MovedFrom)
+	}
+	w.put(prep.Path, docJSON)
+}
+
+// get returns the document put for path earlier in the transaction
+func (w *docWrites) get(path string) ([]byte, bool) {
+	if w == nil {
+		return nil, false
+	}
+	doc, ok := w.puts[path]
+	return doc, ok
+}
+
+// commitDocs pushes the documents put in w to the DocStore and commits tx.
+// Store writes go first so a failed one aborts the transaction; if the
+// commit then fails, the previous documents are put back so the store never
+// runs ahead of the index.
+func (ix *Index) commitDocs(tx *sql.Tx, w *docWrites) error {
+	var undo map[string][]byte // path -> previous document, nil if none
+	if w != nil {
+		undo = make(map[string][]byte, len(w.puts))
+		for path, doc := range w.puts {
+			prev, err := ix.opts.DocStore.Get(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				ix.undoDocs(undo)
+				return Wrap(ErrIO, "load document", err)
+			}
+			undo[path] = prev
+			if err := ix.storeDoc(path, doc); err != nil {
+				ix.undoDocs(undo)
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		ix.undoDocs(undo)
+		return Wrap(ErrSQL, "commit", err)
+	}
+	return nil
+}
+
+// undoDocs restores the DocStore documents saved by commitDocs. It is best
+// effort: the transaction has already failed with a more useful error.
+func (ix *Index) undoDocs(undo map[string][]byte) {
+	for path, prev := range undo {
+		if prev == nil {
+			ix.opts.DocStore.Delete(path)
+		} else {
+			ix.opts.DocStore.Put(path, prev)
+		}
+	}
+}
+
+// removeDocs deletes the documents removed in w from the DocStore. It runs
+// after the commit, so a rolled back delete keeps its document; a failure
+// leaves an orphaned document behind and is reported as ErrIO.
+func (ix *Index) removeDocs(w *docWrites) error {
+	if w == nil {
+		return nil
+	}
+	for path := range w.deletes {
+		if err := ix.opts.DocStore.Delete(path); err != nil {
+			return Wrap(ErrIO, "delete stored document "+path, err)
+		}
+	}
+	return nil
+}
+
+// storeDoc pushes the full document to the DocStore, if any, in canonical
+// form with CanonicalizeJSON
+func (ix *Index) storeDoc(path string, docJSON []byte) error {
+	if ix.opts.DocStore == nil {
+		return nil
+	}
+	if ix.opts.CanonicalizeJSON {
+		var err error
+		if docJSON, err = ops.CanonicalizeDoc(docJSON); err != nil {
+			return Wrap(ErrSchema, "canonicalize document", err)
+		}
+	}
+	if err := ix.opts.DocStore.Put(path, docJSON); err != nil {
+		return Wrap(ErrIO, "store document", err)
+	}
+	return nil
+}
+
+// readDoc decodes a data_json value read with its data_enc and returns the
+// full document for path, as loadDoc does
+func (ix *Index) readDoc(path string, data []byte, enc sql.NullString) ([]byte, error) {
+	doc, err := ops.DecodeDoc(data, enc.String)
+	if err != nil {
+		return nil, Wrap(ErrIO, "read document "+path, err)
+	}
+	return ix.loadDoc(path, doc)
+}
+
+// loadDoc returns the full document for path, falling back to dataJSON when
+// there is no DocStore or it does not have the path
+func (ix *Index) loadDoc(path string, dataJSON []byte) ([]byte, error) {
+	if ix.opts.DocStore == nil {
+		return dataJSON, nil
+	}
+	doc, err := ix.opts.DocStore.Get(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return dataJSON, nil
+	}
+	if err != nil {
+		return nil, Wrap(ErrIO, "load document", err)
+	}
+	return doc, nil
+}
@@ -3,6 +3,9 @@ package ministore
 import (
 	"errors"
 	"fmt"
+
+	"github.com/ministore/ministore/ministore/ops"
+	"github.com/ministore/ministore/ministore/storage"
 )
 
 type ErrorKind string
@@ -18,6 +21,9 @@ const (
 	ErrCursor        ErrorKind = "cursor"
 	ErrNotFound      ErrorKind = "not_found"
 	ErrFeature       ErrorKind = "feature_missing"
+	ErrReadOnly      ErrorKind = "read_only"
+	ErrTimeout       ErrorKind = "timeout"
+	ErrNoTextFields  ErrorKind = "no_text_fields"
 )
 
 type Error struct {
@@ -45,6 +51,10 @@ func (e *Error) Unwrap() error {
 	return e.Cause
 }
 
+// SQLError is the cause of an ErrSQL error raised by a failing statement;
+// use errors.As to get the statement and its bound argument count
+type SQLError = storage.SQLError
+
 func Wrap(kind ErrorKind, msg string, cause error) *Error {
 	return &Error{Kind: kind, Message: msg, Cause: cause}
 }
@@ -81,6 +91,46 @@ func NotFoundError(path string) *Error {
 	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("item not found: %s", path)}
 }
 
+func ReadOnlyError(op string) *Error {
+	return &Error{Kind: ErrReadOnly, Message: fmt.Sprintf("%s: index is opened read-only", op)}
+}
+
+// noTextFieldsError reports a bare text query against a schema without text
+// fields, which has nothing to search. It is a rejected query of its own kind
+// so callers can point the user at field predicates instead.
+func noTextFieldsError(cause error) *Error {
+	return Wrap(ErrNoTextFields, "bare text search needs a text field and the schema has none; use keyword or number predicates such as status:open or price>=10", cause)
+}
+
+// prepareError wraps a PreparePut failure as ErrSchema, naming the offending
+// field when the document violated a strict or required constraint
+func prepareError(msg string, err error) *Error {
+	var fe *ops.FieldError
+	if errors.As(err, &fe) {
+		return &Error{Kind: ErrSchema, Message: fmt.Sprintf("%s: %s", msg, fe.Reason), Field: fe.Field}
+	}
+	return Wrap(ErrSchema, msg, err)
+}
+
+// guardError maps a failed query check: ErrQueryRejected when the check
+// rejected the query, ErrSQL when it could not run
+func guardError(msg string, err error) *Error {
+	if ops.IsRejection(err) {
+		return Wrap(ErrQueryRejected, msg, err)
+	}
+	return Wrap(ErrSQL, msg, err)
+}
+
+// putError maps a failed ops.ExecutePut. An upsert key conflict is a
+// *ops.FieldError and reported like a schema violation.
+func putError(err error) *Error {
+	var fe *ops.FieldError
+	if errors.As(err, &fe) {
+		return prepareError("execute put", err)
+	}
+	return Wrap(ErrSQL, "execute put", err)
+}
+
 func IsKind(err error, kind ErrorKind) bool {
 	var e *Error
 	if errors.As(err, &e) {
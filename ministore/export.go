@@ -0,0 +1,67 @@
+package ministore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/ministore/ministore/ministore/ops"
+	"github.com/ministore/ministore/ministore/query"
+)
+
+// ExportOptions configures Index.Export
+type ExportOptions struct {
+	// Where, if set, is a query restricting the export to matching items.
+	// Empty or "*" exports every item.
+	Where string
+}
+
+// Export writes every live document to w as JSONL, one compact document per
+// line with its path, in insertion (item_id) order. Rows are streamed in
+// pages of DefaultMigrateBatchSize rather than loaded at once. Soft-deleted
+// items are skipped. The output can be read back with Import. It returns the
+// number of documents written.
+func (ix *Index) Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	where := opts.Where
+	if query.IsMatchAll(where) {
+		where = ""
+	}
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, ix.currentSchema(), where)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	var line bytes.Buffer
+	n, err := ops.ScanLiveItems(ctx, ix.db, ix.adapter, whereSQL, whereArgs, DefaultMigrateBatchSize, func(_ int64, path string, dataJSON []byte) error {
+		doc, err := ix.loadDoc(path, dataJSON)
+		if err != nil {
+			return err
+		}
+		// Stored documents may span lines; JSONL needs one per line
+		line.Reset()
+		if err := json.Compact(&line, doc); err != nil {
+			return Wrap(ErrIO, "export "+path, err)
+		}
+		line.WriteByte('\n')
+		if _, err := bw.Write(line.Bytes()); err != nil {
+			return Wrap(ErrIO, "write export", err)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		// Document and write failures are already an *Error
+		var e *Error
+		if errors.As(err, &e) {
+			return n, err
+		}
+		return n, Wrap(ErrSQL, "export items", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return n, Wrap(ErrIO, "write export", err)
+	}
+	return n, nil
+}
@@ -1,6 +1,12 @@
 package ministore
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // unmarshalJSON is a helper to unmarshal JSON
 func unmarshalJSON(data []byte, v any) error {
@@ -11,3 +17,36 @@ func unmarshalJSON(data []byte, v any) error {
 func marshalJSON(v any) ([]byte, error) {
 	return json.Marshal(v)
 }
+
+// metaKeySchema is the meta key holding the schema JSON
+const metaKeySchema = "schema_json"
+
+// metaKeyLocation is the meta key holding the index time zone
+const metaKeyLocation = "location"
+
+// encodeLocation serializes a time zone for the meta table. Zones that
+// cannot be loaded by name (such as time.FixedZone) are stored as a fixed
+// UTC offset in seconds.
+func encodeLocation(loc *time.Location) string {
+	if loc == nil {
+		return "UTC"
+	}
+	if _, err := time.LoadLocation(loc.String()); err == nil {
+		return loc.String()
+	}
+	name, offset := time.Now().In(loc).Zone()
+	return fmt.Sprintf("fixed:%d:%s", offset, name)
+}
+
+// decodeLocation is the inverse of encodeLocation
+func decodeLocation(s string) (*time.Location, error) {
+	if rest, ok := strings.CutPrefix(s, "fixed:"); ok {
+		offStr, name, _ := strings.Cut(rest, ":")
+		offset, err := strconv.Atoi(offStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed zone %q: %w", s, err)
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	return time.LoadLocation(s)
+}
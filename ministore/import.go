@@ -0,0 +1,269 @@
+package ministore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ImportOptions configures Index.Import
+type ImportOptions struct {
+	// BatchSize is the number of documents committed per transaction
+	// [default: DefaultImportBatchSize]
+	BatchSize int
+	// Progress, if non-nil, is called after each commit with the number of
+	// documents committed so far
+	Progress func(done int)
+	// PathField, if set, names the field whose value becomes the document
+	// path, for sources keyed by something like "id"
+	PathField string
+	// PathTemplate, if set, builds the document path from fields, e.g.
+	// "/users/{id}". It takes precedence over PathField.
+	PathTemplate string
+	// OnAutoPath, if non-nil, is called with the line number and path of
+	// each document named by IndexOptions.AutoPath
+	OnAutoPath func(line int, path string)
+	// DeferDocFreq sets Batch.DeferDocFreq on every batch
+	DeferDocFreq bool
+}
+
+// MapPath sets the path of the JSON document doc from opts.PathTemplate or
+// opts.PathField. doc is returned as is when neither is set. A referenced
+// field that is missing, or is not a string or number, is an ErrSchema error
+// naming the field.
+func (opts ImportOptions) MapPath(doc []byte) ([]byte, error) {
+	if opts.PathTemplate == "" && opts.PathField == "" {
+		return doc, nil
+	}
+	tmpl := opts.PathTemplate
+	if tmpl == "" {
+		tmpl = "{" + opts.PathField + "}"
+	}
+	parts, err := parsePathTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, Wrap(ErrSchema, "invalid JSON document", err)
+	}
+
+	var path strings.Builder
+	for _, part := range parts {
+		if !part.field {
+			path.WriteString(part.text)
+			continue
+		}
+		switch v := fields[part.text].(type) {
+		case string:
+			path.WriteString(v)
+		case json.Number:
+			path.WriteString(v.String())
+		case nil:
+			return nil, &Error{Kind: ErrSchema, Message: "path field missing", Field: part.text}
+		default:
+			return nil, &Error{Kind: ErrSchema, Message: "path field must be a string or number", Field: part.text}
+		}
+	}
+	fields["path"] = path.String()
+	return marshalJSON(fields)
+}
+
+// autoPath names doc with IndexOptions.AutoPath; see ApplyAutoPath
+func (ix *Index) autoPath(doc []byte) ([]byte, string, error) {
+	return ApplyAutoPath(doc, ix.opts.AutoPath)
+}
+
+// ApplyAutoPath names the JSON document doc with gen when it has no path, as
+// puts do with IndexOptions.AutoPath, returning the named document and the
+// generated path. doc is returned as is, with an empty path, when it has a
+// path or gen is nil.
+func ApplyAutoPath(doc []byte, gen func(doc map[string]any) (string, error)) ([]byte, string, error) {
+	if gen == nil {
+		return doc, "", nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, "", Wrap(ErrSchema, "invalid JSON document", err)
+	}
+	if p, ok := fields["path"]; ok && p != nil {
+		return doc, "", nil
+	}
+	path, err := gen(fields)
+	if err != nil {
+		return nil, "", Wrap(ErrSchema, "auto path", err)
+	}
+	if path == "" {
+		return nil, "", SchemaError("auto path returned an empty path")
+	}
+	fields["path"] = path
+	named, err := marshalJSON(fields)
+	if err != nil {
+		return nil, "", Wrap(ErrSchema, "marshal document", err)
+	}
+	return named, path, nil
+}
+
+// UUIDPath is an IndexOptions.AutoPath naming each document with a random
+// (version 4) UUID
+func UUIDPath(map[string]any) (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// ContentHashPath is an IndexOptions.AutoPath naming each document by the
+// SHA-256 of its content, so putting the same record twice keeps one item.
+// Keys are hashed in sorted order, so their order in the input does not matter.
+func ContentHashPath(doc map[string]any) (string, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// pathPart is a literal run or a {field} reference of a path template
+type pathPart struct {
+	text  string
+	field bool
+}
+
+func parsePathTemplate(tmpl string) ([]pathPart, error) {
+	var parts []pathPart
+	for rest := tmpl; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			parts = append(parts, pathPart{text: rest})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, pathPart{text: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, SchemaError(fmt.Sprintf("path template %q: unclosed '{'", tmpl))
+		}
+		name := rest[open+1 : open+end]
+		if name == "" || strings.ContainsRune(name, '{') {
+			return nil, SchemaError(fmt.Sprintf("path template %q: bad field reference", tmpl))
+		}
+		parts = append(parts, pathPart{text: name, field: true})
+		rest = rest[open+end+1:]
+	}
+	return parts, nil
+}
+
+// Import puts the JSONL documents read from r, one per line, committing every
+// opts.BatchSize documents so a large load never holds one huge transaction.
+// Blank lines are skipped. With opts.PathField or opts.PathTemplate, each
+// document's path is mapped by ImportOptions.MapPath first; documents still
+// without a path are named by IndexOptions.AutoPath, if set. It returns the
+// number of documents committed; on
+// error, the documents of earlier batches stay committed and the error
+// message says how many there were.
+func (ix *Index) Import(ctx context.Context, r io.Reader, opts ImportOptions) (int, error) {
+	if err := ix.checkWritable("import"); err != nil {
+		return 0, err
+	}
+	size := opts.BatchSize
+	if size <= 0 {
+		size = DefaultImportBatchSize
+	}
+	if opts.PathTemplate != "" {
+		if _, err := parsePathTemplate(opts.PathTemplate); err != nil {
+			return 0, err
+		}
+	}
+
+	committed := 0
+	batch := NewBatch()
+	batch.DeferDocFreq = opts.DeferDocFreq
+	flush := func() error {
+		if batch.Empty() {
+			return nil
+		}
+		n, err := ix.Batch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		committed += n
+		batch = NewBatch()
+		batch.DeferDocFreq = opts.DeferDocFreq
+		if opts.Progress != nil {
+			opts.Progress(committed)
+		}
+		return nil
+	}
+
+	br := bufio.NewReader(r)
+	line := 0
+	for {
+		b, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return committed, importError(line+1, committed, Wrap(ErrIO, "read input", readErr))
+		}
+		if len(b) > 0 {
+			line++
+			if doc := bytes.TrimSpace(b); len(doc) > 0 {
+				doc, err := opts.MapPath(doc)
+				if err != nil {
+					return committed, importError(line, committed, err)
+				}
+				doc, path, err := ix.autoPath(doc)
+				if err != nil {
+					return committed, importError(line, committed, err)
+				}
+				if path != "" && opts.OnAutoPath != nil {
+					opts.OnAutoPath(line, path)
+				}
+				if err := batch.PutJSON(doc); err != nil {
+					return committed, importError(line, committed, err)
+				}
+				if batch.Len() >= size {
+					if err := flush(); err != nil {
+						return committed, importError(line, committed, err)
+					}
+				}
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return committed, importError(line, committed, err)
+	}
+	return committed, nil
+}
+
+// importError keeps the kind of err and adds where the import stopped. A
+// failed batch is rolled back whole, so line is the last one read rather than
+// the offending document.
+func importError(line, committed int, err error) error {
+	msg := fmt.Sprintf("import stopped at line %d, %d documents committed", line, committed)
+	var e *Error
+	if errors.As(err, &e) {
+		return Wrap(e.Kind, msg, err)
+	}
+	return Wrap(ErrIO, msg, err)
+}
@@ -3,7 +3,14 @@ package ministore
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ministore/ministore/ministore/ops"
 	"github.com/ministore/ministore/ministore/planner"
@@ -16,16 +23,26 @@ import (
 type Index struct {
 	adapter     storage.Adapter
 	db          *sql.DB
-	schema      Schema
+	schema      atomic.Pointer[Schema] // swapped by ApplySchema and Reload; see currentSchema
 	opts        IndexOptions
 	cursorStore ops.CursorStore
+	cache       *resultCache // nil unless opts.CacheSize > 0
 }
 
 // Create creates a new index with the given schema
 func Create(ctx context.Context, adapter storage.Adapter, schema Schema, opts IndexOptions) (*Index, error) {
+	if opts.ReadOnly {
+		return nil, ReadOnlyError("create")
+	}
 	if err := schema.Validate(); err != nil {
 		return nil, err
 	}
+	if err := validateUpsertKey(schema, opts.UpsertKey); err != nil {
+		return nil, err
+	}
+	if err := validateCompressDocs(adapter, opts); err != nil {
+		return nil, err
+	}
 
 	db, err := adapter.Connect(ctx)
 	if err != nil {
@@ -42,17 +59,31 @@ func Create(ctx context.Context, adapter storage.Adapter, schema Schema, opts In
 		return nil, Wrap(ErrSQL, "create index", err)
 	}
 
-	return &Index{
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if _, err := db.ExecContext(ctx, adapter.SQL().SetMeta, metaKeyLocation, encodeLocation(opts.Location)); err != nil {
+		db.Close()
+		return nil, Wrap(ErrSQL, "store index location", err)
+	}
+
+	ix := &Index{
 		adapter:     adapter,
 		db:          db,
-		schema:      schema,
 		opts:        opts,
-		cursorStore: ops.NewDBCursorStore(db, adapter.SQL(), opts.CursorTTL),
-	}, nil
+		cursorStore: ops.NewDBCursorStore(db, adapter.SQL(), opts.CursorTTL, opts.Now),
+		cache:       newIndexCache(opts),
+	}
+	ix.schema.Store(&schema)
+	return ix, nil
 }
 
 // Open opens an existing index
 func Open(ctx context.Context, adapter storage.Adapter, opts IndexOptions) (*Index, error) {
+	if err := validateCompressDocs(adapter, opts); err != nil {
+		return nil, err
+	}
+	adapter.SetReadOnly(opts.ReadOnly)
 	db, err := adapter.Connect(ctx)
 	if err != nil {
 		return nil, Wrap(ErrIO, "connect to database", err)
@@ -69,6 +100,10 @@ func Open(ctx context.Context, adapter storage.Adapter, opts IndexOptions) (*Ind
 		db.Close()
 		return nil, err
 	}
+	if err := validateUpsertKey(schema, opts.UpsertKey); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	// Verify FTS structure matches schema
 	if err := adapter.VerifyFTS(ctx, db, schema.AsStorageSchema()); err != nil {
@@ -76,13 +111,64 @@ func Open(ctx context.Context, adapter storage.Adapter, opts IndexOptions) (*Ind
 		return nil, Wrap(ErrSchema, "FTS verification failed", err)
 	}
 
-	return &Index{
+	// The time zone chosen at Create wins over opts.Location so stored dates
+	// keep their meaning; indexes from before it was recorded use UTC.
+	var locStr string
+	err = db.QueryRowContext(ctx, adapter.SQL().GetMeta, metaKeyLocation).Scan(&locStr)
+	switch {
+	case err == sql.ErrNoRows:
+		opts.Location = time.UTC
+	case err != nil:
+		db.Close()
+		return nil, Wrap(ErrSQL, "read index location", err)
+	default:
+		loc, err := decodeLocation(locStr)
+		if err != nil {
+			db.Close()
+			return nil, Wrap(ErrSchema, "index location", err)
+		}
+		opts.Location = loc
+	}
+
+	var cursorStore ops.CursorStore = ops.NewDBCursorStore(db, adapter.SQL(), opts.CursorTTL, opts.Now)
+	if opts.ReadOnly {
+		cursorStore = ops.NewReadOnlyDBCursorStore(db, adapter.SQL(), opts.Now)
+	}
+
+	ix := &Index{
 		adapter:     adapter,
 		db:          db,
-		schema:      schema,
 		opts:        opts,
-		cursorStore: ops.NewDBCursorStore(db, adapter.SQL(), opts.CursorTTL),
-	}, nil
+		cursorStore: cursorStore,
+		cache:       newIndexCache(opts),
+	}
+	ix.schema.Store(&schema)
+	return ix, nil
+}
+
+// validateUpsertKey checks that IndexOptions.UpsertKey, if set, names a
+// single-valued keyword field
+func validateUpsertKey(schema Schema, field string) error {
+	if field == "" {
+		return nil
+	}
+	spec, ok := schema.Fields[field]
+	if !ok {
+		return &Error{Kind: ErrSchema, Field: field, Message: fmt.Sprintf("upsert key %q is not in the schema", field)}
+	}
+	if spec.Type != FieldKeyword || spec.Multi {
+		return &Error{Kind: ErrSchema, Field: field, Message: fmt.Sprintf("upsert key %q must be a single-valued keyword field", field)}
+	}
+	return nil
+}
+
+// validateCompressDocs rejects IndexOptions.CompressDocs off SQLite, where
+// data_json is JSONB and must stay queryable
+func validateCompressDocs(adapter storage.Adapter, opts IndexOptions) error {
+	if opts.CompressDocs && adapter.Backend() != storage.BackendSQLite {
+		return New(ErrFeature, fmt.Sprintf("CompressDocs is not supported on %s", adapter.Backend()))
+	}
+	return nil
 }
 
 // Close closes the index
@@ -97,21 +183,51 @@ func (ix *Index) Close() error {
 
 // Schema returns the index schema
 func (ix *Index) Schema() Schema {
-	return ix.schema
+	return ix.currentSchema()
+}
+
+// currentSchema returns the schema in use. Reload and ApplySchema may swap it
+// while other calls run, so each call takes one snapshot and uses it
+// throughout.
+func (ix *Index) currentSchema() Schema {
+	return *ix.schema.Load()
+}
+
+// storageSchema is currentSchema for the storage and ops layers. A stored
+// schema is never modified, only replaced, so sharing it is safe.
+func (ix *Index) storageSchema() storage.Schema {
+	return ix.schema.Load().AsStorageSchema()
 }
 
 // PutJSON inserts or updates an item from JSON
 func (ix *Index) PutJSON(ctx context.Context, docJSON []byte) error {
+	_, err := ix.PutJSONPath(ctx, docJSON)
+	return err
+}
+
+// PutJSONPath is PutJSON returning the path the item was stored under, which
+// IndexOptions.AutoPath chose if the document had none
+func (ix *Index) PutJSONPath(ctx context.Context, docJSON []byte) (_ string, err error) {
+	var path string
+	defer ix.observePut(&path, time.Now(), &err)
+	if err := ix.checkWritable("put"); err != nil {
+		return "", err
+	}
+	if docJSON, _, err = ix.autoPath(docJSON); err != nil {
+		return "", err
+	}
 	// Prepare the put operation
-	prep, err := ops.PreparePut(ix.schema.AsStorageSchema(), docJSON)
+	schema := ix.currentSchema()
+	prep, err := ix.preparePut(schema, docJSON)
 	if err != nil {
-		return Wrap(ErrSchema, "prepare put", err)
+		return "", prepareError("prepare put", err)
 	}
+	path = prep.Path
 
 	// Execute in transaction
 	tx, err := ix.db.BeginTx(ctx, nil)
 	if err != nil {
-		return Wrap(ErrSQL, "begin transaction", err)
+		return "", Wrap(ErrSQL, "begin transaction", err)
 	}
 	defer tx.Rollback()
 
@@ -119,20 +235,74 @@ func (ix *Index) PutJSON(ctx context.Context, docJSON []byte) error {
 	fts := ix.adapter.FTS()
 	nowMS := ix.nowMS()
 
-	_, _, err = ops.ExecutePut(ctx, tx, sqlt, fts, ix.schema.AsStorageSchema(), prep, nowMS)
+	_, _, err = ops.ExecutePut(ctx, tx, sqlt, fts, schema.AsStorageSchema(), prep, nowMS)
 	if err != nil {
-		return Wrap(ErrSQL, "execute put", err)
+		return "", putError(err)
 	}
+	docs := ix.newDocWrites()
+	docs.recordPut(prep, docJSON)
 
-	if err := tx.Commit(); err != nil {
-		return Wrap(ErrSQL, "commit", err)
+	if err := ix.commitDocs(tx, docs); err != nil {
+		return "", err
 	}
+	ix.invalidateCache()
 
-	return nil
+	return path, ix.removeDocs(docs)
+}
+
+// PutIfUnchanged writes docJSON only if the stored item's updated time still
+// equals expectedUpdatedAtMS (from ItemView.Meta.UpdatedAtMS). It returns
+// false without writing when another writer got there first, including one
+// that deleted or soft-deleted the item. Pass 0 to insert a path that does
+// not exist yet.
+func (ix *Index) PutIfUnchanged(ctx context.Context, docJSON []byte, expectedUpdatedAtMS int64) (_ bool, err error) {
+	var path string
+	defer ix.observePut(&path, time.Now(), &err)
+	if err := ix.checkWritable("put"); err != nil {
+		return false, err
+	}
+	schema := ix.currentSchema()
+	prep, err := ix.preparePut(schema, docJSON)
+	if err != nil {
+		return false, prepareError("prepare put", err)
+	}
+	path = prep.Path
+
+	tx, err := ix.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, Wrap(ErrSQL, "begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	// The new version must differ from the expected one, or a writer in the
+	// same millisecond would leave the token unchanged
+	nowMS := ix.nowMS()
+	if nowMS <= expectedUpdatedAtMS {
+		nowMS = expectedUpdatedAtMS + 1
+	}
+
+	ok, err := ops.ExecutePutIfUnchanged(ctx, tx, ix.adapter.SQL(), ix.adapter.FTS(), schema.AsStorageSchema(), prep, nowMS, expectedUpdatedAtMS)
+	if err != nil {
+		return false, putError(err)
+	}
+	if !ok {
+		return false, nil
+	}
+	docs := ix.newDocWrites()
+	docs.recordPut(prep, docJSON)
+
+	if err := ix.commitDocs(tx, docs); err != nil {
+		return false, err
+	}
+	ix.invalidateCache()
+	return true, ix.removeDocs(docs)
 }
 
 // PutFields inserts or updates an item with field values
 func (ix *Index) PutFields(ctx context.Context, path string, fieldsJSON []byte) error {
+	if err := ix.checkWritable("put fields"); err != nil {
+		return err
+	}
 	// Build full document JSON with path
 	// This is a convenience method that wraps fields in a document
 	doc := make(map[string]interface{})
@@ -149,24 +319,100 @@ func (ix *Index) PutFields(ctx context.Context, path string, fieldsJSON []byte)
 	return ix.PutJSON(ctx, docJSON)
 }
 
-// Get retrieves an item by path
+// Update applies a shallow merge of patch to the stored document at path and
+// reindexes it in one transaction. A nil value in patch deletes that key.
+// created is preserved; returns ErrNotFound if path does not exist or is
+// soft-deleted.
+func (ix *Index) Update(ctx context.Context, path string, patch map[string]any) (err error) {
+	defer ix.observePut(&path, time.Now(), &err)
+	if err := ix.checkWritable("update"); err != nil {
+		return err
+	}
+	if p, ok := patch["path"]; ok && p != path {
+		return New(ErrSchema, "patch cannot change 'path'")
+	}
+
+	tx, err := ix.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Wrap(ErrSQL, "begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	sqlt := ix.adapter.SQL()
+	var dataJSON string
+	var dataEnc sql.NullString
+	err = tx.QueryRowContext(ctx, sqlt.LockItemByPath, path).Scan(&dataJSON, &dataEnc)
+	if err == sql.ErrNoRows {
+		return NotFoundError(path)
+	}
+	if err != nil {
+		return Wrap(ErrSQL, "get item", err)
+	}
+
+	stored, err := ix.readDoc(path, []byte(dataJSON), dataEnc)
+	if err != nil {
+		return err
+	}
+	doc := make(map[string]interface{})
+	if err := unmarshalJSON(stored, &doc); err != nil {
+		return Wrap(ErrSchema, "stored document", err)
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		doc[k] = v
+	}
+	doc["path"] = path
+
+	docJSON, err := marshalJSON(doc)
+	if err != nil {
+		return Wrap(ErrSchema, "marshal document", err)
+	}
+	schema := ix.currentSchema()
+	prep, err := ix.preparePut(schema, docJSON)
+	if err != nil {
+		return prepareError("prepare put", err)
+	}
+
+	_, _, err = ops.ExecutePut(ctx, tx, sqlt, ix.adapter.FTS(), schema.AsStorageSchema(), prep, ix.nowMS())
+	if err != nil {
+		return putError(err)
+	}
+	docs := ix.newDocWrites()
+	docs.recordPut(prep, docJSON)
+
+	if err := ix.commitDocs(tx, docs); err != nil {
+		return err
+	}
+	ix.invalidateCache()
+	return ix.removeDocs(docs)
+}
+
+// Get retrieves an item by path. Soft-deleted items are not found.
 func (ix *Index) Get(ctx context.Context, path string) (ItemView, error) {
 	sqlt := ix.adapter.SQL()
 	var itemID int64
 	var dataJSON string
+	var dataEnc sql.NullString
 	var createdAt, updatedAt int64
 
-	err := ix.db.QueryRowContext(ctx, sqlt.GetItemByPath, path).Scan(&itemID, &dataJSON, &createdAt, &updatedAt)
+	err := ix.db.QueryRowContext(ctx, sqlt.GetItemByPath, path).Scan(&itemID, &dataJSON, &dataEnc, &createdAt, &updatedAt)
 	if err == sql.ErrNoRows {
 		return ItemView{}, NotFoundError(path)
 	}
 	if err != nil {
 		return ItemView{}, Wrap(ErrSQL, "get item", err)
 	}
+	doc, err := ix.readDoc(path, []byte(dataJSON), dataEnc)
+	if err != nil {
+		return ItemView{}, err
+	}
 
 	return ItemView{
 		Path:    path,
-		DocJSON: []byte(dataJSON),
+		DocJSON: doc,
 		Meta: ItemMeta{
 			CreatedAtMS: createdAt,
 			UpdatedAtMS: updatedAt,
@@ -174,276 +420,1292 @@ func (ix *Index) Get(ctx context.Context, path string) (ItemView, error) {
 	}, nil
 }
 
-// Peek retrieves just the raw JSON for an item
-func (ix *Index) Peek(ctx context.Context, path string) ([]byte, error) {
-	view, err := ix.Get(ctx, path)
+// Exists reports whether Get would find path, without reading the document.
+// Soft-deleted items do not exist.
+func (ix *Index) Exists(ctx context.Context, path string) (bool, error) {
+	var one int
+	err := ix.db.QueryRowContext(ctx, ix.adapter.SQL().ItemExists, path).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		return nil, err
+		return false, Wrap(ErrSQL, "item exists", err)
 	}
-	return view.DocJSON, nil
+	return true, nil
 }
 
-// Delete removes an item by path
-func (ix *Index) Delete(ctx context.Context, path string) (bool, error) {
-	sqlt := ix.adapter.SQL()
-	fts := ix.adapter.FTS()
+// GetMany retrieves several items by path in as few queries as possible.
+// Paths that do not exist or are soft-deleted are absent from the returned
+// map.
+func (ix *Index) GetMany(ctx context.Context, paths []string) (map[string]ItemView, error) {
+	out := make(map[string]ItemView, len(paths))
 
-	return ops.DeleteByPath(ctx, ix.db, sqlt, fts, path)
-}
+	for start := 0; start < len(paths); start += GetManyChunkSize {
+		end := min(start+GetManyChunkSize, len(paths))
 
-// DeleteWhere deletes items matching a query
-func (ix *Index) DeleteWhere(ctx context.Context, queryStr string) (int, error) {
-	// Parse and compile query
-	expr, err := query.Parse(queryStr)
-	if err != nil {
-		return 0, Wrap(ErrQueryParse, "parse query", err)
-	}
+		b := sqlbuilder.New(ix.adapter.PlaceholderStyle())
+		phs := make([]string, 0, end-start)
+		for _, p := range paths[start:end] {
+			phs = append(phs, b.Arg(p))
+		}
+		q := fmt.Sprintf("SELECT id, path, data_json, data_enc, created_at, updated_at FROM items WHERE path IN (%s) AND deleted_at IS NULL", strings.Join(phs, ", "))
 
-	normalizedExpr, err := query.Normalize(expr, query.DefaultNormalizeOptions())
-	if err != nil {
-		return 0, Wrap(ErrQueryRejected, "normalize query", err)
+		rows, err := ix.db.QueryContext(ctx, q, b.Args()...)
+		if err != nil {
+			return nil, Wrap(ErrSQL, "get items", err)
+		}
+		for rows.Next() {
+			var itemID int64
+			var path, dataJSON string
+			var dataEnc sql.NullString
+			var createdAt, updatedAt int64
+			if err := rows.Scan(&itemID, &path, &dataJSON, &dataEnc, &createdAt, &updatedAt); err != nil {
+				rows.Close()
+				return nil, Wrap(ErrSQL, "scan item", err)
+			}
+			doc, err := ix.readDoc(path, []byte(dataJSON), dataEnc)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[path] = ItemView{
+				Path:    path,
+				DocJSON: doc,
+				Meta: ItemMeta{
+					CreatedAtMS: createdAt,
+					UpdatedAtMS: updatedAt,
+				},
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, Wrap(ErrSQL, "get items", err)
+		}
 	}
 
-	builder := sqlbuilder.New(ix.adapter.PlaceholderStyle())
-	compiled, err := planner.Compile(ix.adapter, ix.schema.AsStorageSchema(), builder, normalizedExpr, ix.nowMS())
+	return out, nil
+}
+
+// Peek retrieves just the raw JSON for an item
+func (ix *Index) Peek(ctx context.Context, path string) ([]byte, error) {
+	view, err := ix.Get(ctx, path)
 	if err != nil {
-		return 0, Wrap(ErrQueryRejected, "compile query", err)
+		return nil, err
 	}
+	return view.DocJSON, nil
+}
 
-	// Build CTE parts
-	var cteParts []string
-	for _, cte := range compiled.CTEs {
-		cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", cte.Name, cte.SQL))
+// Delete removes an item by path
+func (ix *Index) Delete(ctx context.Context, path string) (_ bool, err error) {
+	defer func(start time.Time) {
+		ix.observer().OnDelete(path, time.Since(start), err)
+	}(time.Now())
+	if err := ix.checkWritable("delete"); err != nil {
+		return false, err
 	}
-
 	sqlt := ix.adapter.SQL()
 	fts := ix.adapter.FTS()
 
-	return ops.DeleteWhere(ctx, ix.db, sqlt, fts, compiled.ResultCTE, cteParts, builder.Args())
+	defer ix.invalidateCache()
+	ok, err := ops.DeleteByPath(ctx, ix.db, sqlt, fts, path)
+	if err != nil || !ok {
+		return ok, err
+	}
+	docs := ix.newDocWrites()
+	docs.remove(path)
+	return true, ix.removeDocs(docs)
 }
 
-// Search executes a query and returns results
-func (ix *Index) Search(ctx context.Context, queryStr string, sopts SearchOptions) (SearchResultPage, error) {
-	// Clean up expired cursors (best effort)
-	if dbcs, ok := ix.cursorStore.(*ops.DBCursorStore); ok {
-		_ = dbcs.CleanupExpired(ctx)
+// DeleteMany removes the items at paths in one transaction, resolving item
+// ids in chunks of GetManyChunkSize. Paths that do not exist are skipped.
+// Returns the number of items deleted.
+func (ix *Index) DeleteMany(ctx context.Context, paths []string) (n int, err error) {
+	defer func(start time.Time) {
+		ix.observer().OnBatch(n, time.Since(start), err)
+	}(time.Now())
+	if err := ix.checkWritable("delete many"); err != nil {
+		return 0, err
 	}
-
-	// Convert ministore.SearchOptions to ops.SearchOptions
-	opsOpts := ops.SearchOptions{
-		Rank: planner.RankMode{
-			Kind:  toRankKind(sopts.Rank.Kind),
-			Field: sopts.Rank.Field,
-		},
-		Limit:      sopts.Limit,
-		After:      sopts.After,
-		CursorMode: ops.CursorMode(sopts.CursorMode),
-		Show: ops.OutputFieldSelector{
-			Kind:   toOutputFieldKind(sopts.Show.Kind),
-			Fields: sopts.Show.Fields,
-		},
-		Explain: sopts.Explain,
+	if len(paths) == 0 {
+		return 0, nil
 	}
 
-	result, err := ops.Search(
-		ctx,
-		ix.db,
-		ix.adapter,
-		ix.schema.AsStorageSchema(),
-		queryStr,
-		opsOpts,
-		ix.nowMS(),
-		ix.cursorStore,
-	)
+	tx, err := ix.db.BeginTx(ctx, nil)
 	if err != nil {
-		return SearchResultPage{}, Wrap(ErrSQL, "search", err)
+		return 0, Wrap(ErrSQL, "begin transaction", err)
 	}
+	defer tx.Rollback()
 
-	return SearchResultPage{
-		Items:        result.Items,
-		NextCursor:   result.NextCursor,
-		HasMore:      result.HasMore,
-		ExplainSQL:   result.ExplainSQL,
-		ExplainSteps: result.ExplainSteps,
-	}, nil
-}
-
-// DiscoverValues lists unique values for a field
-func (ix *Index) DiscoverValues(ctx context.Context, field string, where string, top int) ([]ValueCount, error) {
-	var whereSQL string
-	var whereArgs []any
+	var itemIDs []int64
+	seen := make(map[int64]bool, len(paths))
+	docs := ix.newDocWrites()
+	for start := 0; start < len(paths); start += GetManyChunkSize {
+		end := min(start+GetManyChunkSize, len(paths))
 
-	if where != "" {
-		// Compile the where query to a CTE
-		expr, err := query.Parse(where)
-		if err != nil {
-			return nil, Wrap(ErrQueryParse, "parse where", err)
+		b := sqlbuilder.New(ix.adapter.PlaceholderStyle())
+		phs := make([]string, 0, end-start)
+		for _, p := range paths[start:end] {
+			phs = append(phs, b.Arg(p))
 		}
+		q := fmt.Sprintf("SELECT id, path FROM items WHERE path IN (%s) AND deleted_at IS NULL", strings.Join(phs, ", "))
 
-		normalizedExpr, err := query.Normalize(expr, query.DefaultNormalizeOptions())
+		rows, err := tx.QueryContext(ctx, q, b.Args()...)
 		if err != nil {
-			return nil, Wrap(ErrQueryRejected, "normalize where", err)
+			return 0, Wrap(ErrSQL, "find items", err)
 		}
-
-		builder := sqlbuilder.New(ix.adapter.PlaceholderStyle())
-		compiled, err := planner.Compile(ix.adapter, ix.schema.AsStorageSchema(), builder, normalizedExpr, ix.nowMS())
+		for rows.Next() {
+			var id int64
+			var path string
+			if err := rows.Scan(&id, &path); err != nil {
+				rows.Close()
+				return 0, Wrap(ErrSQL, "scan item id", err)
+			}
+			if !seen[id] {
+				seen[id] = true
+				itemIDs = append(itemIDs, id)
+				docs.remove(path)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
 		if err != nil {
-			return nil, Wrap(ErrQueryRejected, "compile where", err)
+			return 0, Wrap(ErrSQL, "find items", err)
 		}
+	}
 
-		// Build CTEs
-		var cteParts []string
-		for _, cte := range compiled.CTEs {
-			cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", cte.Name, cte.SQL))
+	sqlt := ix.adapter.SQL()
+	fts := ix.adapter.FTS()
+	for _, id := range itemIDs {
+		if err := ops.DeleteByItemID(ctx, tx, sqlt, fts, id); err != nil {
+			return 0, Wrap(ErrSQL, "delete item", err)
 		}
+	}
 
-		if len(cteParts) > 0 {
-			whereSQL = "WITH " + joinComma(cteParts) + " SELECT item_id FROM " + compiled.ResultCTE
-		} else {
-			whereSQL = "SELECT item_id FROM " + compiled.ResultCTE
-		}
-		whereArgs = builder.Args()
+	if err := ix.commitDocs(tx, docs); err != nil {
+		return 0, err
 	}
+	ix.invalidateCache()
+	return len(itemIDs), ix.removeDocs(docs)
+}
 
-	results, err := ops.DiscoverValues(ctx, ix.db, ix.adapter, ix.schema.AsStorageSchema(), field, whereSQL, whereArgs, top)
+// SoftDelete removes the item at path from all index tables so it stops
+// matching searches, but keeps its document so Restore can bring it back.
+// Until then Get, Exists and GetMany treat it as missing.
+// Soft-deleting an already deleted item is a no-op. Returns ErrNotFound if
+// path does not exist.
+func (ix *Index) SoftDelete(ctx context.Context, path string) error {
+	if err := ix.checkWritable("soft delete"); err != nil {
+		return err
+	}
+	tx, err := ix.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, Wrap(ErrSQL, "discover values", err)
+		return Wrap(ErrSQL, "begin transaction", err)
 	}
+	defer tx.Rollback()
 
-	// Convert ops.ValueCount to ministore.ValueCount
-	var converted []ValueCount
-	for _, r := range results {
-		converted = append(converted, ValueCount{Value: r.Value, Count: r.Count})
+	sqlt := ix.adapter.SQL()
+	var itemID int64
+	var dataJSON string
+	var dataEnc sql.NullString
+	var deletedAt sql.NullInt64
+	err = tx.QueryRowContext(ctx, sqlt.GetItemStateByPath, path).Scan(&itemID, &dataJSON, &dataEnc, &deletedAt)
+	if err == sql.ErrNoRows {
+		return NotFoundError(path)
 	}
-	return converted, nil
-}
-
-// DiscoverFields returns an overview of all fields
-func (ix *Index) DiscoverFields(ctx context.Context) ([]FieldOverview, error) {
-	results, err := ops.DiscoverFields(ctx, ix.db, ix.adapter, ix.schema.AsStorageSchema())
 	if err != nil {
-		return nil, Wrap(ErrSQL, "discover fields", err)
+		return Wrap(ErrSQL, "get item", err)
+	}
+	if deletedAt.Valid {
+		return nil
 	}
 
-	// Convert ops.FieldOverview to ministore.FieldOverview
-	var converted []FieldOverview
-	for _, r := range results {
-		converted = append(converted, FieldOverview{
-			Field:    r.Field,
-			Type:     FieldType(r.Type),
-			Multi:    r.Multi,
-			DocCount: r.DocCount,
-			Unique:   r.Unique,
-			Weight:   r.Weight,
-			Examples: r.Examples,
-		})
+	if err := ops.SoftDeleteByItemID(ctx, tx, sqlt, ix.adapter.FTS(), itemID, ix.nowMS()); err != nil {
+		return Wrap(ErrSQL, "soft delete", err)
 	}
-	return converted, nil
+	if err := tx.Commit(); err != nil {
+		return Wrap(ErrSQL, "commit", err)
+	}
+	ix.invalidateCache()
+	return nil
 }
 
-// Stats computes statistics for a field
-func (ix *Index) Stats(ctx context.Context, field string, where string) (StatsResult, error) {
-	var whereSQL string
-	var whereArgs []any
-
-	if where != "" {
-		// Compile the where query to a CTE
-		expr, err := query.Parse(where)
-		if err != nil {
-			return StatsResult{}, Wrap(ErrQueryParse, "parse where", err)
-		}
-
-		normalizedExpr, err := query.Normalize(expr, query.DefaultNormalizeOptions())
-		if err != nil {
-			return StatsResult{}, Wrap(ErrQueryRejected, "normalize where", err)
-		}
-
-		builder := sqlbuilder.New(ix.adapter.PlaceholderStyle())
-		compiled, err := planner.Compile(ix.adapter, ix.schema.AsStorageSchema(), builder, normalizedExpr, ix.nowMS())
-		if err != nil {
-			return StatsResult{}, Wrap(ErrQueryRejected, "compile where", err)
-		}
-
-		// Build CTEs
-		var cteParts []string
-		for _, cte := range compiled.CTEs {
-			cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", cte.Name, cte.SQL))
-		}
-
-		if len(cteParts) > 0 {
-			whereSQL = "WITH " + joinComma(cteParts) + " SELECT item_id FROM " + compiled.ResultCTE
-		} else {
-			whereSQL = "SELECT item_id FROM " + compiled.ResultCTE
-		}
-		whereArgs = builder.Args()
+// Restore re-indexes a soft-deleted item from its stored document. Restoring
+// an item that is not deleted is a no-op. Returns ErrNotFound if path does
+// not exist, and ErrSchema if another item claimed its UpsertKey value while
+// it was deleted.
+func (ix *Index) Restore(ctx context.Context, path string) error {
+	if err := ix.checkWritable("restore"); err != nil {
+		return err
 	}
-
-	result, err := ops.Stats(ctx, ix.db, ix.adapter, ix.schema.AsStorageSchema(), field, whereSQL, whereArgs)
+	tx, err := ix.db.BeginTx(ctx, nil)
 	if err != nil {
-		return StatsResult{}, Wrap(ErrSQL, "stats", err)
+		return Wrap(ErrSQL, "begin transaction", err)
 	}
+	defer tx.Rollback()
 
-	return StatsResult{
-		Field:  result.Field,
-		Count:  result.Count,
-		Min:    result.Min,
-		Max:    result.Max,
+	sqlt := ix.adapter.SQL()
+	var itemID int64
+	var dataJSON string
+	var dataEnc sql.NullString
+	var deletedAt sql.NullInt64
+	err = tx.QueryRowContext(ctx, sqlt.GetItemStateByPath, path).Scan(&itemID, &dataJSON, &dataEnc, &deletedAt)
+	if err == sql.ErrNoRows {
+		return NotFoundError(path)
+	}
+	if err != nil {
+		return Wrap(ErrSQL, "get item", err)
+	}
+	if !deletedAt.Valid {
+		return nil
+	}
+
+	doc, err := ix.readDoc(path, []byte(dataJSON), dataEnc)
+	if err != nil {
+		return err
+	}
+	schema := ix.currentSchema()
+	prep, err := ix.preparePut(schema, doc)
+	if err != nil {
+		return prepareError("prepare put", err)
+	}
+	if err := ix.refreshProjection(ctx, tx, prep, itemID); err != nil {
+		return err
+	}
+	if err := ops.RestoreByItemID(ctx, tx, sqlt, ix.adapter.FTS(), schema.AsStorageSchema(), prep, itemID); err != nil {
+		var fe *ops.FieldError
+		if errors.As(err, &fe) {
+			return prepareError("restore", err)
+		}
+		return Wrap(ErrSQL, "restore", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Wrap(ErrSQL, "commit", err)
+	}
+	ix.invalidateCache()
+	return nil
+}
+
+// DeleteWhere deletes items matching a query
+func (ix *Index) DeleteWhere(ctx context.Context, queryStr string) (n int, err error) {
+	defer func(start time.Time) {
+		ix.observer().OnDeleteWhere(queryStr, n, time.Since(start), err)
+	}(time.Now())
+	if err := ix.checkWritable("delete where"); err != nil {
+		return 0, err
+	}
+	// An empty where means no filter elsewhere; here it must not mean everything
+	if queryStr == "" {
+		return 0, QueryParseError("delete where needs a query")
+	}
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, ix.currentSchema(), queryStr)
+	if err != nil {
+		return 0, err
+	}
+
+	defer ix.invalidateCache()
+	return ops.DeleteWhere(ctx, ix.db, ix.adapter.SQL(), ix.adapter.FTS(), whereSQL, whereArgs, ix.deletedPaths)
+}
+
+// deletedPaths removes the stored documents of items deleted for good, for
+// the committed callback of ops.DeleteWhere and ops.DeleteWhereBatched
+func (ix *Index) deletedPaths(paths []string) error {
+	docs := ix.newDocWrites()
+	for _, path := range paths {
+		docs.remove(path)
+	}
+	return ix.removeDocs(docs)
+}
+
+// DeleteWhereBatched deletes items matching a query like DeleteWhere, but in
+// transactions of at most batchSize items [default: DefaultDeleteBatchSize],
+// so retention sweeps over many items run in bounded memory. The query is
+// compiled once, so a relative date such as created>30d keeps one cutoff for
+// the whole sweep. It returns the number of items deleted; on error, the
+// batches already committed stay deleted and are counted.
+func (ix *Index) DeleteWhereBatched(ctx context.Context, queryStr string, batchSize int) (n int, err error) {
+	defer func(start time.Time) {
+		ix.observer().OnDeleteWhere(queryStr, n, time.Since(start), err)
+	}(time.Now())
+	if err := ix.checkWritable("delete where"); err != nil {
+		return 0, err
+	}
+	if queryStr == "" {
+		return 0, QueryParseError("delete where needs a query")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultDeleteBatchSize
+	}
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, ix.currentSchema(), queryStr)
+	if err != nil {
+		return 0, err
+	}
+
+	defer ix.invalidateCache()
+	deleted, err := ops.DeleteWhereBatched(ctx, ix.db, ix.adapter, whereSQL, whereArgs, batchSize, ix.deletedPaths)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			return deleted, err
+		}
+		return deleted, Wrap(ErrSQL, fmt.Sprintf("delete where stopped after %d items", deleted), err)
+	}
+	return deleted, nil
+}
+
+// Search executes a query and returns results
+func (ix *Index) Search(ctx context.Context, queryStr string, sopts SearchOptions) (SearchResultPage, error) {
+	start := time.Now()
+	page, cached, err := ix.cachedSearch(ctx, ix.currentSchema(), queryStr, sopts)
+	ix.observer().OnSearch(SearchEvent{
+		Query:    queryStr,
+		Duration: time.Since(start),
+		Rows:     len(page.Items),
+		FTS:      page.fts,
+		Cached:   cached,
+		Err:      err,
+	})
+	return page, err
+}
+
+// cachedSearch serves the search from the result cache when it can, and
+// reports whether it did
+func (ix *Index) cachedSearch(ctx context.Context, schema Schema, queryStr string, sopts SearchOptions) (SearchResultPage, bool, error) {
+	limit, err := ix.searchLimit(sopts.Limit)
+	if err != nil {
+		return SearchResultPage{}, false, err
+	}
+	sopts.Limit = limit
+
+	if ix.cache == nil || sopts.Profile {
+		page, err := ix.search(ctx, schema, queryStr, sopts)
+		return page, false, err
+	}
+	key, ok := ix.cache.key(schema, queryStr, sopts, ix.opts.Location)
+	if !ok {
+		page, err := ix.search(ctx, schema, queryStr, sopts)
+		return page, false, err
+	}
+	if page, ok := ix.cache.get(key, ix.opts.Now()); ok {
+		return page, true, nil
+	}
+	page, err := ix.search(ctx, schema, queryStr, sopts)
+	if err != nil {
+		return SearchResultPage{}, false, err
+	}
+	ix.cache.put(key, page, ix.opts.Now(), ix.opts.CursorTTL)
+	return page, false, nil
+}
+
+// searchLimit applies IndexOptions.DefaultLimit and MaxLimit to a requested
+// page size
+func (ix *Index) searchLimit(limit int) (int, error) {
+	if limit <= 0 {
+		limit = ix.opts.DefaultLimit
+		if limit <= 0 {
+			limit = DefaultSearchLimit
+		}
+	}
+	if ix.opts.MaxLimit > 0 && limit > ix.opts.MaxLimit {
+		return 0, New(ErrQueryRejected, fmt.Sprintf("limit %d exceeds the maximum of %d", limit, ix.opts.MaxLimit))
+	}
+	return limit, nil
+}
+
+// CacheStats returns search result cache counters; all zero when
+// IndexOptions.CacheSize is 0
+func (ix *Index) CacheStats() CacheStats {
+	if ix.cache == nil {
+		return CacheStats{}
+	}
+	return ix.cache.stats()
+}
+
+// invalidateCache drops cached search pages after a committed write
+func (ix *Index) invalidateCache() {
+	if ix.cache != nil {
+		ix.cache.invalidate()
+	}
+}
+
+func (ix *Index) search(ctx context.Context, schema Schema, queryStr string, sopts SearchOptions) (SearchResultPage, error) {
+	// Clean up expired cursors (best effort), outside the query's time budget
+	if dbcs, ok := ix.cursorStore.(*ops.DBCursorStore); ok {
+		_ = dbcs.CleanupExpired(ctx)
+	}
+
+	// Both SQLite drivers and pgx interrupt a running statement when its
+	// context is done, so the deadline also stops a query mid-scan
+	qctx := ctx
+	if sopts.Timeout > 0 {
+		var cancel context.CancelFunc
+		qctx, cancel = context.WithTimeout(ctx, sopts.Timeout)
+		defer cancel()
+	}
+
+	// Convert ministore.SearchOptions to ops.SearchOptions
+	opsOpts := ops.SearchOptions{
+		Rank: planner.RankMode{
+			Kind:        toRankKind(sopts.Rank.Kind),
+			Field:       sopts.Rank.Field,
+			Keys:        toSortKeys(sopts.Rank.Keys),
+			Ascending:   sopts.Rank.Ascending,
+			MissingLast: sopts.Rank.MissingLast,
+		},
+		Limit:      sopts.Limit,
+		After:      sopts.After,
+		CursorMode: ops.CursorMode(sopts.CursorMode),
+		Show: ops.OutputFieldSelector{
+			Kind:   toOutputFieldKind(sopts.Show.Kind),
+			Fields: sopts.Show.Fields,
+			Nested: sopts.Show.Nested,
+		},
+		Explain:        sopts.Explain,
+		Location:       ix.opts.Location,
+		IncludeDeleted: sopts.IncludeDeleted,
+		ExplainPlan:    sopts.Explain && sopts.ExplainFormat == ExplainFormatJSON,
+		Profile:        sopts.Explain && sopts.Profile,
+		MinScore:       sopts.MinScore,
+		Snapshot:       sopts.Snapshot,
+		DistinctBy:     sopts.DistinctBy,
+		MatchPositions: sopts.ReturnMatchPositions,
+		SuggestOnEmpty: sopts.SuggestOnEmpty,
+		EstimateTotal:  sopts.EstimateTotal,
+		MaxSuggestions: MaxSuggestions,
+	}
+	nopts := ix.normalizeOptions(schema)
+	opsOpts.Normalize = &nopts
+	if ix.opts.DocStore != nil {
+		opsOpts.LoadDoc = ix.loadDoc
+	}
+	if sopts.Highlight != nil {
+		opsOpts.Highlight = &storage.HighlightSpec{
+			Tokens: sopts.Highlight.Tokens,
+			Open:   sopts.Highlight.Open,
+			Close:  sopts.Highlight.Close,
+		}
+	}
+
+	result, err := ops.Search(
+		qctx,
+		ix.db,
+		ix.adapter,
+		schema.AsStorageSchema(),
+		queryStr,
+		opsOpts,
+		ix.nowMS(),
+		ix.cursorStore,
+	)
+	if err != nil {
+		// The driver's interrupt error does not always wrap the context's,
+		// so tell our own timeout apart from the caller's by the contexts
+		if qctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return SearchResultPage{}, Wrap(ErrTimeout, fmt.Sprintf("search exceeded timeout %s", sopts.Timeout), err)
+		}
+		if errors.Is(err, storage.ErrNoTextFields) {
+			return SearchResultPage{}, noTextFieldsError(err)
+		}
+		var rej *ops.RejectedError
+		if errors.As(err, &rej) {
+			kind := ErrQueryRejected
+			if rej.Parse {
+				kind = ErrQueryParse
+			}
+			return SearchResultPage{}, Wrap(kind, rej.Op, rej.Err)
+		}
+		return SearchResultPage{}, Wrap(ErrSQL, "search", err)
+	}
+
+	page := SearchResultPage{
+		Items:          result.Items,
+		NextCursor:     result.NextCursor,
+		HasMore:        result.HasMore,
+		ExplainSQL:     result.ExplainSQL,
+		ExplainSteps:   result.ExplainSteps,
+		Limit:          result.Limit,
+		Returned:       result.Returned,
+		EstimatedTotal: result.EstimatedTotal,
+		fts:            result.FTS,
+	}
+	if result.ExplainPlan != nil {
+		if page.ExplainJSON, err = json.Marshal(result.ExplainPlan); err != nil {
+			return SearchResultPage{}, Wrap(ErrSQL, "explain json", err)
+		}
+	}
+	for _, p := range result.Profile {
+		page.Profile = append(page.Profile, StepProfile{CTE: p.CTE, Step: p.Step, Rows: p.Rows, Elapsed: p.Elapsed})
+	}
+	page.SuggestField = result.SuggestField
+	for _, r := range result.Suggestions {
+		page.Suggestions = append(page.Suggestions, ValueCount{Value: r.Value, Count: r.Count})
+	}
+	return page, nil
+}
+
+// SaveQuery stores a named query and its search options in the index.
+// Saving under an existing name replaces it.
+func (ix *Index) SaveQuery(ctx context.Context, name, queryStr string, opts SearchOptions) error {
+	if err := ix.checkWritable("save query"); err != nil {
+		return err
+	}
+	if name == "" {
+		return QueryRejectedError("saved query name is required")
+	}
+
+	if !query.IsMatchAll(queryStr) {
+		expr, err := query.ParseInLocation(queryStr, ix.opts.Location)
+		if err != nil {
+			return Wrap(ErrQueryParse, "parse query", err)
+		}
+		if _, err := query.Normalize(expr, ix.normalizeOptions(ix.currentSchema())); err != nil {
+			return Wrap(ErrQueryRejected, "normalize query", err)
+		}
+	}
+
+	// Cursors are per-run; never persist one
+	opts.After = ""
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return Wrap(ErrQueryRejected, "saved query options json", err)
+	}
+
+	if _, err := ix.db.ExecContext(ctx, ix.adapter.SQL().UpsertSavedQuery, name, queryStr, string(optsJSON), ix.nowMS()); err != nil {
+		return Wrap(ErrSQL, "save query", err)
+	}
+	return nil
+}
+
+// RunSavedQuery runs a query stored with SaveQuery. after is a cursor from a
+// previous page, or "" for the first page.
+func (ix *Index) RunSavedQuery(ctx context.Context, name string, after string) (SearchResultPage, error) {
+	var queryStr, optsJSON string
+	err := ix.db.QueryRowContext(ctx, ix.adapter.SQL().GetSavedQuery, name).Scan(&queryStr, &optsJSON)
+	if err == sql.ErrNoRows {
+		return SearchResultPage{}, New(ErrNotFound, fmt.Sprintf("saved query not found: %s", name))
+	}
+	if err != nil {
+		return SearchResultPage{}, Wrap(ErrSQL, "get saved query", err)
+	}
+
+	var opts SearchOptions
+	if err := json.Unmarshal([]byte(optsJSON), &opts); err != nil {
+		return SearchResultPage{}, Wrap(ErrQueryRejected, "saved query options json", err)
+	}
+	opts.After = after
+
+	return ix.Search(ctx, queryStr, opts)
+}
+
+// DiscoverValues lists unique values for a field
+func (ix *Index) DiscoverValues(ctx context.Context, field string, where string, top int) ([]ValueCount, error) {
+	schema := ix.currentSchema()
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, schema, where)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := ops.DiscoverValues(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), field, whereSQL, whereArgs, top, false)
+	if err != nil {
+		return nil, Wrap(ErrSQL, "discover values", err)
+	}
+	return convertValueCounts(page.Values), nil
+}
+
+// DiscoverValuesTotal is DiscoverValues that also counts every distinct value
+// of field over the items matching where, for "20 of 340" facet pagination
+func (ix *Index) DiscoverValuesTotal(ctx context.Context, field string, where string, top int) (ValuesPage, error) {
+	schema := ix.currentSchema()
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, schema, where)
+	if err != nil {
+		return ValuesPage{}, err
+	}
+
+	page, err := ops.DiscoverValues(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), field, whereSQL, whereArgs, top, true)
+	if err != nil {
+		return ValuesPage{}, Wrap(ErrSQL, "discover values", err)
+	}
+	return ValuesPage{
+		Values:        convertValueCounts(page.Values),
+		TotalDistinct: page.TotalDistinct,
+		HasMore:       page.HasMore,
+	}, nil
+}
+
+// RelatedValues lists the values of a keyword field that appear most often
+// on the items holding field:value, with the number of such items, for
+// "also tagged" panels. value itself is left out.
+func (ix *Index) RelatedValues(ctx context.Context, field, value string, top int) ([]ValueCount, error) {
+	results, err := ops.RelatedValues(ctx, ix.db, ix.adapter, ix.storageSchema(), field, value, top)
+	if err != nil {
+		return nil, Wrap(ErrSQL, "related values", err)
+	}
+	return convertValueCounts(results), nil
+}
+
+// convertValueCounts converts ops.ValueCount to ministore.ValueCount
+func convertValueCounts(in []ops.ValueCount) []ValueCount {
+	var out []ValueCount
+	for _, r := range in {
+		out = append(out, ValueCount{Value: r.Value, Count: r.Count})
+	}
+	return out
+}
+
+// Facets returns the top topPerField values of each keyword field in fields,
+// counted over the items matching where (every item when where is empty).
+// The where clause is compiled and executed once for all fields. Values are
+// ordered by count desc, then value asc.
+func (ix *Index) Facets(ctx context.Context, where string, fields []string, topPerField int) (map[string][]ValueCount, error) {
+	schema := ix.currentSchema()
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, schema, where)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ops.Facets(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), fields, whereSQL, whereArgs, topPerField)
+	if err != nil {
+		return nil, Wrap(ErrSQL, "facets", err)
+	}
+
+	converted := make(map[string][]ValueCount, len(results))
+	for field, values := range results {
+		out := make([]ValueCount, 0, len(values))
+		for _, r := range values {
+			out = append(out, ValueCount{Value: r.Value, Count: r.Count})
+		}
+		converted[field] = out
+	}
+	return converted, nil
+}
+
+// Histogram counts the values of a number field matching where (every item
+// when where is empty) in buckets of bucketWidth aligned to multiples of it.
+// Buckets run from the smallest value's to the largest value's, including
+// empty buckets in between; an empty result means no values.
+func (ix *Index) Histogram(ctx context.Context, field string, where string, bucketWidth float64) ([]Bucket, error) {
+	schema := ix.currentSchema()
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, schema, where)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ops.Histogram(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), field, whereSQL, whereArgs, bucketWidth)
+	if err != nil {
+		return nil, Wrap(ErrSQL, "histogram", err)
+	}
+
+	buckets := make([]Bucket, 0, len(results))
+	for _, r := range results {
+		buckets = append(buckets, Bucket{Lo: r.Lo, Hi: r.Hi, Count: r.Count})
+	}
+	return buckets, nil
+}
+
+// DateHistogram is Histogram for a date field, or the implicit created and
+// updated fields, with calendar buckets computed in the index time zone
+func (ix *Index) DateHistogram(ctx context.Context, field string, where string, interval DateInterval) ([]DateBucket, error) {
+	schema := ix.currentSchema()
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, schema, where)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ops.DateHistogram(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), field, whereSQL, whereArgs, string(interval), ix.opts.Location)
+	if err != nil {
+		return nil, Wrap(ErrSQL, "date histogram", err)
+	}
+
+	buckets := make([]DateBucket, 0, len(results))
+	for _, r := range results {
+		buckets = append(buckets, DateBucket{
+			Start: time.UnixMilli(r.StartMS).In(ix.opts.Location),
+			End:   time.UnixMilli(r.EndMS).In(ix.opts.Location),
+			Count: r.Count,
+		})
+	}
+	return buckets, nil
+}
+
+// compileWhere compiles a where query to a SELECT of matching item_ids with
+// its arguments. An empty where yields an empty SQL string.
+func (ix *Index) compileWhere(ctx context.Context, schema Schema, where string) (string, []any, error) {
+	if where == "" {
+		return "", nil, nil
+	}
+
+	expr, err := query.ParseInLocation(where, ix.opts.Location)
+	if err != nil {
+		return "", nil, Wrap(ErrQueryParse, "parse where", err)
+	}
+
+	nopts := ix.normalizeOptions(schema)
+	normalizedExpr, err := query.Normalize(expr, nopts)
+	if err != nil {
+		return "", nil, Wrap(ErrQueryRejected, "normalize where", err)
+	}
+	normalizedExpr, err = ops.CheckPrefixExpansion(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), normalizedExpr, nopts.MaxPrefixExpansion, false)
+	if err != nil {
+		return "", nil, guardError("normalize where", err)
+	}
+	if err := ops.CheckLike(ctx, ix.db, ix.adapter, normalizedExpr); err != nil {
+		return "", nil, guardError("normalize where", err)
+	}
+	normalizedExpr, err = ops.ResolveFuzzy(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), normalizedExpr, nopts.MaxPrefixExpansion)
+	if err != nil {
+		return "", nil, guardError("resolve fuzzy", err)
+	}
+
+	builder := sqlbuilder.New(ix.adapter.PlaceholderStyle())
+	compiled, err := planner.Compile(ix.adapter, schema.AsStorageSchema(), builder, normalizedExpr, ix.nowMS(), ix.opts.Location)
+	if errors.Is(err, storage.ErrNoTextFields) {
+		return "", nil, noTextFieldsError(err)
+	}
+	if err != nil {
+		return "", nil, Wrap(ErrQueryRejected, "compile where", err)
+	}
+
+	var cteParts []string
+	for _, cte := range compiled.CTEs {
+		cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", cte.Name, cte.SQL))
+	}
+	if len(cteParts) > 0 {
+		return "WITH " + joinComma(cteParts) + " SELECT item_id FROM " + compiled.ResultCTE, builder.Args(), nil
+	}
+	return "SELECT item_id FROM " + compiled.ResultCTE, builder.Args(), nil
+}
+
+// sizeStatsTables are the tables SizeStats counts; search is added when the
+// schema has text fields
+var sizeStatsTables = []string{"items", "kw_dict", "kw_postings", "field_number", "field_date", "field_bool", "field_geo", "field_present"}
+
+// SizeStats reports the number of items, the row count of each index table
+// and the on-disk size of the index, to help with capacity planning. A
+// kw_postings count far above the item count points at a large multi-valued
+// keyword field.
+func (ix *Index) SizeStats(ctx context.Context) (SizeStats, error) {
+	tables := sizeStatsTables
+	if ix.adapter.FTS().HasFTS(ix.storageSchema()) {
+		tables = append(slices.Clone(tables), "search")
+	}
+
+	stats := SizeStats{Rows: make(map[string]int64, len(tables))}
+	for _, t := range tables {
+		n, err := ops.CountRows(ctx, ix.db, t)
+		if err != nil {
+			return SizeStats{}, Wrap(ErrSQL, "size stats", err)
+		}
+		stats.Rows[t] = n
+	}
+	live, err := ops.CountLiveItems(ctx, ix.db)
+	if err != nil {
+		return SizeStats{}, Wrap(ErrSQL, "size stats", err)
+	}
+	stats.Items = live
+	stats.DeletedItems = uint64(stats.Rows["items"]) - live
+
+	stats.Bytes, stats.TableBytes, err = ix.adapter.DiskSize(ctx, ix.db, tables)
+	if err != nil {
+		return SizeStats{}, Wrap(ErrSQL, "size stats", err)
+	}
+	return stats, nil
+}
+
+// KeywordStats returns every value of a keyword field with its document
+// frequency, ordered by value, plus the number of live items so callers can
+// compute IDF. Values are read in pages of KeywordStatsPageSize.
+func (ix *Index) KeywordStats(ctx context.Context, field string) ([]KeywordStat, error) {
+	total, err := ops.CountLiveItems(ctx, ix.db)
+	if err != nil {
+		return nil, Wrap(ErrSQL, "keyword stats", err)
+	}
+
+	schema := ix.storageSchema()
+	var stats []KeywordStat
+	after := ""
+	for {
+		page, err := ops.KeywordValuesPage(ctx, ix.db, ix.adapter, schema, field, after, KeywordStatsPageSize)
+		if err != nil {
+			return nil, Wrap(ErrSQL, "keyword stats", err)
+		}
+		for _, v := range page {
+			stats = append(stats, KeywordStat{Value: v.Value, DocFreq: v.Count, TotalItems: total})
+		}
+		if len(page) < KeywordStatsPageSize {
+			return stats, nil
+		}
+		after = page[len(page)-1].Value
+	}
+}
+
+// DiscoverFields returns an overview of all fields
+func (ix *Index) DiscoverFields(ctx context.Context) ([]FieldOverview, error) {
+	results, err := ops.DiscoverFields(ctx, ix.db, ix.adapter, ix.storageSchema())
+	if err != nil {
+		return nil, Wrap(ErrSQL, "discover fields", err)
+	}
+
+	// Convert ops.FieldOverview to ministore.FieldOverview
+	var converted []FieldOverview
+	for _, r := range results {
+		converted = append(converted, FieldOverview{
+			Field:    r.Field,
+			Type:     FieldType(r.Type),
+			Multi:    r.Multi,
+			DocCount: r.DocCount,
+			Unique:   r.Unique,
+			Weight:   r.Weight,
+			Examples: r.Examples,
+		})
+	}
+	return converted, nil
+}
+
+// Stats computes statistics for a field, plus the given percentiles (0-100,
+// e.g. 90, 95, 99) interpolated between the closest values
+func (ix *Index) Stats(ctx context.Context, field string, where string, percentiles ...float64) (StatsResult, error) {
+	schema := ix.currentSchema()
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, schema, where)
+	if err != nil {
+		return StatsResult{}, err
+	}
+
+	result, err := ops.Stats(ctx, ix.db, ix.adapter, schema.AsStorageSchema(), field, whereSQL, whereArgs, percentiles)
+	if err != nil {
+		return StatsResult{}, Wrap(ErrSQL, "stats", err)
+	}
+
+	return StatsResult{
+		Field:  result.Field,
+		Count:  result.Count,
+		Min:    result.Min,
+		Max:    result.Max,
 		Avg:    result.Avg,
 		Median: result.Median,
+
+		Percentiles: result.Percentiles,
 	}, nil
 }
 
-// Optimize optimizes the index (vacuum, FTS optimize, etc.)
-func (ix *Index) Optimize(ctx context.Context) error {
-	return ix.adapter.Optimize(ctx, ix.db)
+// Optimize compacts the full-text index, refreshes query planner statistics
+// and, on SQLite, checkpoints and truncates the WAL file. opts.Vacuum also
+// rewrites the whole database to reclaim space, which is slow on large
+// indexes.
+func (ix *Index) Optimize(ctx context.Context, opts OptimizeOptions) error {
+	if err := ix.checkWritable("optimize"); err != nil {
+		return err
+	}
+	if err := ix.adapter.Optimize(ctx, ix.db, ix.storageSchema(), storage.OptimizeOptions{Vacuum: opts.Vacuum}); err != nil {
+		return Wrap(ErrSQL, "optimize", err)
+	}
+	return nil
+}
+
+// OptimizeIncremental does one bounded step of full-text index compaction,
+// writing about pages pages (DefaultMergePages if pages <= 0), and reports
+// whether the index is fully merged. A background job can call it until done
+// instead of running Optimize, whose single full merge can hold the write
+// lock for a long time on a large index; the price is more calls and, until
+// done, a somewhat less compact index. Only SQLite has work to do; statistics
+// and the WAL are left to Optimize.
+func (ix *Index) OptimizeIncremental(ctx context.Context, pages int) (done bool, err error) {
+	if err := ix.checkWritable("optimize"); err != nil {
+		return false, err
+	}
+	if pages <= 0 {
+		pages = DefaultMergePages
+	}
+	done, err = ix.adapter.MergeFTS(ctx, ix.db, ix.storageSchema(), pages)
+	if err != nil {
+		return false, Wrap(ErrSQL, "optimize incremental", err)
+	}
+	return done, nil
+}
+
+// Snapshot writes a consistent copy of the index that Open accepts, without
+// blocking writers. On SQLite dst is the path of a new database file (VACUUM
+// INTO); on Postgres it is the name of a new schema in the same database.
+// Documents held in a DocStore are not copied.
+func (ix *Index) Snapshot(ctx context.Context, dst string) error {
+	if dst == "" {
+		return New(ErrIO, "snapshot destination is required")
+	}
+	if err := ix.adapter.Snapshot(ctx, ix.db, dst); err != nil {
+		return Wrap(ErrIO, "snapshot", err)
+	}
+	return nil
+}
+
+// Reindex rebuilds all index tables from the documents stored in items, in
+// one transaction. Use it to repair an index whose tables have drifted from
+// data_json; doc_freq counters are rebuilt from scratch. Soft-deleted items
+// stay unindexed. If progress is non-nil it is called after each batch of
+// DefaultMigrateBatchSize items with the number done and the total.
+func (ix *Index) Reindex(ctx context.Context, progress func(done, total int)) error {
+	return ix.reindex(ctx, "", progress)
 }
 
-// ApplySchema applies schema changes (additive only)
-func (ix *Index) ApplySchema(ctx context.Context, newSchema Schema) error {
+// ReindexWhere is Reindex limited to the live items matching where, e.g. after
+// fixing how some documents were indexed. Other items keep their index rows
+// and doc_freq counters are adjusted rather than rebuilt. Items are selected
+// through the current index, so a drifted index may miss some. An empty or
+// "*" where reindexes everything, like Reindex.
+func (ix *Index) ReindexWhere(ctx context.Context, where string, progress func(done, total int)) error {
+	if query.IsMatchAll(where) {
+		where = ""
+	}
+	return ix.reindex(ctx, where, progress)
+}
+
+func (ix *Index) reindex(ctx context.Context, where string, progress func(done, total int)) error {
+	if err := ix.checkWritable("reindex"); err != nil {
+		return err
+	}
+	schema := ix.currentSchema()
+	whereSQL, whereArgs, err := ix.compileWhere(ctx, schema, where)
+	if err != nil {
+		return err
+	}
+	total, err := ops.CountLiveItemsWhere(ctx, ix.db, whereSQL, whereArgs)
+	if err != nil {
+		return Wrap(ErrSQL, "reindex", err)
+	}
+
+	tx, err := ix.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Wrap(ErrSQL, "begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	sqlt := ix.adapter.SQL()
+	fts := ix.adapter.FTS()
+	storageSchema := schema.AsStorageSchema()
+
+	// A full reindex starts from empty tables; a scoped one replaces the rows
+	// of each item in place
+	if whereSQL == "" {
+		if err := ops.ClearIndexTables(ctx, tx, fts, storageSchema); err != nil {
+			return Wrap(ErrSQL, "clear index tables", err)
+		}
+	}
+
+	// Each page re-runs whereSQL, but only over ids past those already
+	// rewritten, so reindexing an item never changes what is selected
+	_, err = ops.ScanLiveItems(ctx, tx, ix.adapter, whereSQL, whereArgs, DefaultMigrateBatchSize, func(id int64, path string, dataJSON []byte) error {
+		doc, err := ix.loadDoc(path, dataJSON)
+		if err != nil {
+			return err
+		}
+		prep, err := ix.preparePut(schema, doc)
+		if err != nil {
+			return prepareError(fmt.Sprintf("prepare put for %s", path), err)
+		}
+		if err := ix.refreshProjection(ctx, tx, prep, id); err != nil {
+			return err
+		}
+		if err := ops.ReindexItem(ctx, tx, sqlt, fts, storageSchema, prep, id); err != nil {
+			return Wrap(ErrSQL, fmt.Sprintf("reindex %s", path), err)
+		}
+		return nil
+	}, func(done int) {
+		if progress != nil {
+			progress(done, int(total))
+		}
+	})
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			return err
+		}
+		return Wrap(ErrSQL, "list items", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Wrap(ErrSQL, "commit", err)
+	}
+	ix.invalidateCache()
+	return nil
+}
+
+// refreshProjection rewrites the data_json of an item whose full document is
+// in the DocStore, so it holds the fields the current schema indexes
+func (ix *Index) refreshProjection(ctx context.Context, tx *sql.Tx, prep *ops.PutPrepared, itemID int64) error {
+	if ix.opts.DocStore == nil {
+		return nil
+	}
+	if err := ops.SetItemData(ctx, tx, ix.adapter.SQL(), prep, itemID); err != nil {
+		return Wrap(ErrSQL, "update "+prep.Path, err)
+	}
+	return nil
+}
+
+// ApplySchemaOptions configures ApplySchema
+type ApplySchemaOptions struct {
+	// DryRun computes the change without touching the index
+	DryRun bool
+}
+
+// SchemaChange describes what ApplySchema did, or would do with DryRun
+type SchemaChange struct {
+	Diff SchemaDiff
+	DDL  []string // statements run against the index, in order
+}
+
+// ApplySchema applies additive schema changes: new fields and changes to
+// weights or required. Removing a field or changing its type, multi flag,
+// normalizer, case_fold or full_text flag needs MigrateRebuild and is
+// rejected with ErrSchema.
+func (ix *Index) ApplySchema(ctx context.Context, newSchema Schema, opts ApplySchemaOptions) (SchemaChange, error) {
+	if !opts.DryRun {
+		if err := ix.checkWritable("apply schema"); err != nil {
+			return SchemaChange{}, err
+		}
+	}
 	if err := newSchema.Validate(); err != nil {
+		return SchemaChange{}, err
+	}
+	oldSchema := ix.currentSchema()
+	oldTok, _ := storage.NormalizeFTSTokenizer(oldSchema.FTSTokenizer)
+	newTok, _ := storage.NormalizeFTSTokenizer(newSchema.FTSTokenizer)
+	if oldTok != newTok {
+		return SchemaChange{}, SchemaError("changing fts_tokenizer requires MigrateRebuild")
+	}
+
+	change := SchemaChange{Diff: oldSchema.Diff(newSchema)}
+	if len(change.Diff.Removed) > 0 {
+		return change, &Error{Kind: ErrSchema, Field: change.Diff.Removed[0],
+			Message: fmt.Sprintf("removing field %q requires MigrateRebuild", change.Diff.Removed[0])}
+	}
+	for _, c := range change.Diff.Changed {
+		if c.TypeChanged() {
+			return change, &Error{Kind: ErrSchema, Field: c.Field,
+				Message: fmt.Sprintf("changing field %q from %s to %s requires MigrateRebuild", c.Field, specType(c.Old), specType(c.New))}
+		}
+		if c.NormalizerChanged() {
+			return change, &Error{Kind: ErrSchema, Field: c.Field,
+				Message: fmt.Sprintf("changing the normalizer of field %q requires MigrateRebuild", c.Field)}
+		}
+		if c.CaseFoldChanged() {
+			return change, &Error{Kind: ErrSchema, Field: c.Field,
+				Message: fmt.Sprintf("changing case_fold on field %q requires MigrateRebuild", c.Field)}
+		}
+		if c.FullTextChanged() {
+			return change, &Error{Kind: ErrSchema, Field: c.Field,
+				Message: fmt.Sprintf("changing full_text on field %q requires MigrateRebuild", c.Field)}
+		}
+	}
+
+	oldStorage, newStorage := oldSchema.AsStorageSchema(), newSchema.AsStorageSchema()
+	ddl, err := ix.adapter.ApplySchemaDDL(oldStorage, newStorage)
+	if err != nil {
+		return change, Wrap(ErrSchema, "apply schema", err)
+	}
+	change.DDL = ddl
+	if opts.DryRun {
+		return change, nil
+	}
+
+	if err := ix.adapter.ApplySchemaAdditive(ctx, ix.db, oldStorage, newStorage); err != nil {
+		return change, Wrap(ErrSQL, "apply schema", err)
+	}
+	stored := newSchema.clone()
+	ix.schema.Store(&stored)
+	ix.invalidateCache()
+	return change, nil
+}
+
+// Reload re-reads the schema from the index and adopts it, so a long-lived
+// Index picks up schema changes applied by another process. The new schema
+// must pass the same checks as at Open, including FTS verification; on error
+// the current schema is kept. It is safe to call while other goroutines use
+// the Index: calls already running finish with the schema they started with.
+func (ix *Index) Reload(ctx context.Context) error {
+	var schemaJSON string
+	if err := ix.db.QueryRowContext(ctx, ix.adapter.SQL().GetMeta, metaKeySchema).Scan(&schemaJSON); err != nil {
+		return Wrap(ErrSQL, "read schema", err)
+	}
+	schema, err := SchemaFromJSON([]byte(schemaJSON))
+	if err != nil {
 		return err
 	}
-	if err := ix.adapter.ApplySchemaAdditive(ctx, ix.db, ix.schema.AsStorageSchema(), newSchema.AsStorageSchema()); err != nil {
-		return Wrap(ErrSQL, "apply schema", err)
+	if err := validateUpsertKey(schema, ix.opts.UpsertKey); err != nil {
+		return err
+	}
+	if err := ix.adapter.VerifyFTS(ctx, ix.db, schema.AsStorageSchema()); err != nil {
+		return Wrap(ErrSchema, "FTS verification failed", err)
 	}
-	ix.schema = newSchema
+	ix.schema.Store(&schema)
+	ix.invalidateCache()
 	return nil
 }
 
-// MigrateRebuild performs a full rebuild with a new schema
-func (ix *Index) MigrateRebuild(ctx context.Context, dst storage.Adapter, newSchema Schema) error {
-	// TODO: Implement migration logic
-	return New(ErrFeature, "MigrateRebuild not yet implemented")
+// specType formats a field's type for messages, e.g. "keyword" or "keyword[]"
+func specType(spec FieldSpec) string {
+	if spec.Multi {
+		return string(spec.Type) + "[]"
+	}
+	return string(spec.Type)
+}
+
+// MigrateRebuild performs a full rebuild with a new schema.
+// A fresh index is created on dst and every item is re-indexed against
+// newSchema in batches of DefaultMigrateBatchSize, one transaction per batch.
+// Fields no longer in the schema are dropped from the index; newly added
+// fields stay absent until the document is put again. Soft-deleted items are
+// copied and stay deleted. Items are upserted by path, so re-running after a
+// partial failure is safe.
+// Returns the number of migrated documents.
+func (ix *Index) MigrateRebuild(ctx context.Context, dst storage.Adapter, newSchema Schema) (int, error) {
+	dstIx, err := Create(ctx, dst, newSchema, ix.opts)
+	if err != nil {
+		return 0, err
+	}
+	defer dstIx.Close()
+
+	srcSQL := ix.adapter.SQL()
+	dstSQL := dst.SQL()
+	dstFTS := dst.FTS()
+	dstSchema := dstIx.storageSchema()
+
+	type row struct {
+		id        int64
+		path      string
+		dataJSON  string
+		dataEnc   sql.NullString
+		createdAt int64
+		updatedAt int64
+		deletedAt sql.NullInt64
+	}
+
+	migrated := 0
+	var lastID int64
+	for {
+		rows, err := ix.db.QueryContext(ctx, srcSQL.ListItemsAfterID, lastID, DefaultMigrateBatchSize)
+		if err != nil {
+			return migrated, Wrap(ErrSQL, "list items", err)
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.path, &r.dataJSON, &r.dataEnc, &r.createdAt, &r.updatedAt, &r.deletedAt); err != nil {
+				rows.Close()
+				return migrated, Wrap(ErrSQL, "scan item", err)
+			}
+			batch = append(batch, r)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return migrated, Wrap(ErrSQL, "iterate items", err)
+		}
+		rows.Close()
+
+		if len(batch) == 0 {
+			break
+		}
+
+		tx, err := dstIx.db.BeginTx(ctx, nil)
+		if err != nil {
+			return migrated, Wrap(ErrSQL, "begin transaction", err)
+		}
+		for _, r := range batch {
+			// The DocStore is shared with dstIx, so it already has the document
+			doc, err := ix.readDoc(r.path, []byte(r.dataJSON), r.dataEnc)
+			if err != nil {
+				tx.Rollback()
+				return migrated, err
+			}
+			prep, err := dstIx.preparePut(newSchema, doc)
+			if err != nil {
+				tx.Rollback()
+				return migrated, prepareError(fmt.Sprintf("prepare put for %s", r.path), err)
+			}
+			itemID, err := ops.ExecutePutWithTS(ctx, tx, dstSQL, dstFTS, dstSchema, prep, r.createdAt, r.updatedAt)
+			if err != nil {
+				tx.Rollback()
+				return migrated, Wrap(ErrSQL, fmt.Sprintf("execute put for %s", r.path), err)
+			}
+			if r.deletedAt.Valid {
+				if err := ops.SoftDeleteByItemID(ctx, tx, dstSQL, dstFTS, itemID, r.deletedAt.Int64); err != nil {
+					tx.Rollback()
+					return migrated, Wrap(ErrSQL, fmt.Sprintf("soft delete %s", r.path), err)
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return migrated, Wrap(ErrSQL, "commit", err)
+		}
+
+		migrated += len(batch)
+		lastID = batch[len(batch)-1].id
+	}
+
+	return migrated, nil
 }
 
 // Batch executes a batch of operations
-func (ix *Index) Batch(ctx context.Context, b Batch) (int, error) {
+func (ix *Index) Batch(ctx context.Context, b Batch) (n int, err error) {
+	n, _, err = ix.batch(ctx, b)
+	return n, err
+}
+
+// BatchPaths is Batch returning the path each put was stored under, in
+// order, including those IndexOptions.AutoPath chose
+func (ix *Index) BatchPaths(ctx context.Context, b Batch) ([]string, error) {
+	_, paths, err := ix.batch(ctx, b)
+	return paths, err
+}
+
+func (ix *Index) batch(ctx context.Context, b Batch) (n int, paths []string, err error) {
+	defer func(start time.Time) {
+		ix.observer().OnBatch(n, time.Since(start), err)
+	}(time.Now())
+	if err := ix.checkWritable("batch"); err != nil {
+		return 0, nil, err
+	}
 	if b.Empty() {
-		return 0, nil
+		return 0, nil, nil
 	}
 
 	tx, err := ix.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, Wrap(ErrSQL, "begin transaction", err)
+		return 0, nil, Wrap(ErrSQL, "begin transaction", err)
 	}
 	defer tx.Rollback()
 
 	sqlt := ix.adapter.SQL()
 	fts := ix.adapter.FTS()
 	nowMS := ix.nowMS()
+	schema := ix.currentSchema()
+
+	var touched map[int64]bool
+	if b.DeferDocFreq {
+		touched = make(map[int64]bool)
+	}
+	docs := ix.newDocWrites()
 
 	count := 0
 	for _, op := range b.ops {
 		switch op.Kind {
 		case batchPut:
-			prep, err := ops.PreparePut(ix.schema.AsStorageSchema(), op.Doc)
+			doc, decoded, err := ix.autoPathOp(op)
+			if err != nil {
+				return count, nil, err
+			}
+			var prep *ops.PutPrepared
+			if decoded != nil {
+				prep, err = ix.preparePutDoc(schema, decoded, doc)
+			} else {
+				prep, err = ix.preparePut(schema, doc)
+			}
 			if err != nil {
-				return count, Wrap(ErrSchema, "prepare put", err)
+				return count, nil, prepareError("prepare put", err)
 			}
-			_, _, err = ops.ExecutePut(ctx, tx, sqlt, fts, ix.schema.AsStorageSchema(), prep, nowMS)
+			prep.DocFreqTouched = touched
+			_, _, err = ops.ExecutePut(ctx, tx, sqlt, fts, schema.AsStorageSchema(), prep, nowMS)
 			if err != nil {
-				return count, Wrap(ErrSQL, "execute put", err)
+				return count, nil, putError(err)
 			}
+			docs.recordPut(prep, doc)
+			paths = append(paths, prep.Path)
 		case batchDelete:
 			// Find item ID
 			var itemID int64
@@ -454,19 +1716,42 @@ func (ix *Index) Batch(ctx context.Context, b Batch) (int, error) {
 				continue
 			}
 			if err != nil {
-				return count, Wrap(ErrSQL, "find item", err)
+				return count, nil, Wrap(ErrSQL, "find item", err)
 			}
 			if err := ops.DeleteByItemID(ctx, tx, sqlt, fts, itemID); err != nil {
-				return count, Wrap(ErrSQL, "delete item", err)
+				return count, nil, Wrap(ErrSQL, "delete item", err)
 			}
+			docs.remove(op.Path)
 		}
 		count++
 	}
 
-	if err := tx.Commit(); err != nil {
-		return count, Wrap(ErrSQL, "commit transaction", err)
+	if len(touched) > 0 {
+		if err := ops.RecomputeDocFreq(ctx, tx, ix.adapter.PlaceholderStyle(), touched); err != nil {
+			return count, nil, Wrap(ErrSQL, "recompute doc_freq", err)
+		}
+	}
+	if err := ix.commitDocs(tx, docs); err != nil {
+		return count, nil, err
+	}
+	ix.invalidateCache()
+	return count, paths, ix.removeDocs(docs)
+}
+
+// autoPathOp names a batched put without a path with IndexOptions.AutoPath.
+// A decoded document is copied before the path is added, so the caller's
+// map is left as it was.
+func (ix *Index) autoPathOp(op BatchOp) ([]byte, map[string]any, error) {
+	if _, named := op.Decoded["path"]; named {
+		return op.Doc, op.Decoded, nil
+	}
+	doc, path, err := ix.autoPath(op.Doc)
+	if err != nil || path == "" || op.Decoded == nil {
+		return doc, op.Decoded, err
 	}
-	return count, nil
+	decoded := maps.Clone(op.Decoded)
+	decoded["path"] = path
+	return doc, decoded, nil
 }
 
 // Adapter returns the underlying storage adapter
@@ -479,6 +1764,34 @@ func (ix *Index) DB() *sql.DB {
 	return ix.db
 }
 
+// checkWritable fails fast with ErrReadOnly when the index was opened
+// read-only, before any SQL runs
+func (ix *Index) checkWritable(op string) error {
+	if ix.opts.ReadOnly {
+		return ReadOnlyError(op)
+	}
+	return nil
+}
+
+// normalizeOptions returns the query guardrails with schema-aware checks
+// enabled and the limits from IndexOptions, each left at its default when
+// unset
+func (ix *Index) normalizeOptions(schema Schema) query.NormalizeOptions {
+	opts := ops.NormalizeOptionsFor(schema.AsStorageSchema())
+	if ix.opts.MinContainsLen > 0 {
+		opts.MinContainsLen = ix.opts.MinContainsLen
+	}
+	if ix.opts.MinPrefixLen > 0 {
+		opts.MinPrefixLen = ix.opts.MinPrefixLen
+	}
+	if ix.opts.MaxPrefixExpansion > 0 {
+		opts.MaxPrefixExpansion = ix.opts.MaxPrefixExpansion
+	}
+	opts.NoLike = ix.opts.CompressDocs
+	opts.RequireAllOrBranchesAnchored = !ix.opts.AllowUnanchoredOrBranches
+	return opts
+}
+
 // nowMS returns current time in milliseconds since epoch
 func (ix *Index) nowMS() int64 {
 	return ix.opts.Now().UnixMilli()
@@ -486,6 +1799,17 @@ func (ix *Index) nowMS() int64 {
 
 // Helper functions
 
+func toSortKeys(keys []SortKey) []planner.SortKey {
+	if len(keys) == 0 {
+		return nil
+	}
+	out := make([]planner.SortKey, len(keys))
+	for i, k := range keys {
+		out[i] = planner.SortKey{Field: k.Field, Desc: k.Desc}
+	}
+	return out
+}
+
 func toRankKind(k RankModeKind) planner.RankKind {
 	switch k {
 	case RankDefault:
@@ -1,10 +1,18 @@
 package ministore_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -253,6 +261,275 @@ func TestSearchFiltersAndPagination_Recency_SQLite(t *testing.T) {
 	}
 }
 
+func TestSearchSnapshotCursor_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	put := func(path string) {
+		if err := ix.PutJSON(ctx, []byte(fmt.Sprintf(`{"path":%q,"tags":["x"]}`, path))); err != nil {
+			t.Fatalf("PutJSON(%s): %v", path, err)
+		}
+	}
+	for _, p := range []string{"/1", "/2", "/3"} {
+		put(p)
+	}
+
+	// Pages in insertion order, so items added later sort last
+	pageAll := func(snapshot bool, mode ministore.CursorMode, insert string) []string {
+		opts := ministore.SearchOptions{
+			Limit:      2,
+			Rank:       ministore.RankMode{Kind: ministore.RankNone},
+			Show:       ministore.OutputFieldSelector{Kind: ministore.ShowNone},
+			CursorMode: mode,
+			Snapshot:   snapshot,
+		}
+		var paths []string
+		for {
+			page, err := ix.Search(ctx, "tags:x", opts)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			paths = append(paths, pathsFromItems(t, page.Items)...)
+			if opts.After == "" {
+				put(insert) // a write between the first and second page
+			}
+			if !page.HasMore {
+				return paths
+			}
+			opts.After = page.NextCursor
+		}
+	}
+
+	if got := strings.Join(pageAll(false, ministore.CursorShort, "/4"), ","); got != "/1,/2,/3,/4" {
+		t.Errorf("without snapshot = %s, want /1,/2,/3,/4", got)
+	}
+	if got := strings.Join(pageAll(true, ministore.CursorShort, "/5"), ","); got != "/1,/2,/3,/4" {
+		t.Errorf("snapshot short cursor = %s, want /1,/2,/3,/4", got)
+	}
+	if got := strings.Join(pageAll(true, ministore.CursorFull, "/6"), ","); got != "/1,/2,/3,/4,/5" {
+		t.Errorf("snapshot full cursor = %s, want /1,/2,/3,/4,/5", got)
+	}
+
+	// Deleting the newest item must not free its id for the next insert,
+	// or that insert would fall inside the snapshot
+	opts := ministore.SearchOptions{Limit: 2, Rank: ministore.RankMode{Kind: ministore.RankNone}, Show: ministore.OutputFieldSelector{Kind: ministore.ShowNone}, Snapshot: true}
+	page, err := ix.Search(ctx, "tags:x", opts)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if _, err := ix.Delete(ctx, "/6"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	put("/7")
+	var rest []string
+	for opts.After = page.NextCursor; opts.After != ""; opts.After = page.NextCursor {
+		if page, err = ix.Search(ctx, "tags:x", opts); err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		rest = append(rest, pathsFromItems(t, page.Items)...)
+	}
+	if got := strings.Join(rest, ","); got != "/3,/4,/5" {
+		t.Errorf("snapshot after deleting the newest item = %s, want /3,/4,/5", got)
+	}
+}
+
+func TestFTSScoreTiePagination_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	// Identical titles score the same; the few "rust rust" ones score higher
+	var want []string
+	for i := 0; i < 25; i++ {
+		title := "rust guide"
+		if i%10 == 5 {
+			title = "rust rust"
+		}
+		doc := fmt.Sprintf(`{"path":"/%02d","title":%q}`, i, title)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+		if i%10 != 5 {
+			want = append(want, fmt.Sprintf("/%02d", i))
+		}
+	}
+	want = append([]string{"/05", "/15"}, want...)
+
+	for _, mode := range []ministore.CursorMode{ministore.CursorFull, ministore.CursorShort} {
+		opts := ministore.SearchOptions{Limit: 3, CursorMode: mode}
+		var got []string
+		for {
+			page, err := ix.Search(ctx, "rust", opts)
+			if err != nil {
+				t.Fatalf("%s: Search: %v", mode, err)
+			}
+			got = append(got, pathsFromItems(t, page.Items)...)
+			if !page.HasMore {
+				break
+			}
+			opts.After = page.NextCursor
+		}
+		// Ties come back in item_id order, each exactly once
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s cursor: got %v, want %v", mode, got, want)
+		}
+	}
+}
+
+func TestSearchSuggestOnEmpty_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags":   {Type: ministore.FieldKeyword, Multi: true},
+			"status": {Type: ministore.FieldKeyword, CaseFold: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/1","tags":["rust","go"],"status":"Open"}`,
+		`{"path":"/2","tags":["rust"],"status":"Closed"}`,
+		`{"path":"/3","tags":["rust","rustlang"],"status":"Open"}`,
+		`{"path":"/4","tags":["ruby"],"status":"Closed"}`,
+		`{"path":"/5","tags":["ru_st"]}`,
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if _, err := ix.Delete(ctx, "/5"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		field string
+		want  string
+	}{
+		{"tags:rus", "tags", "rust:3,rustlang:1"},
+		{"tags:ruby AND status:op", "status", "Open:2"},
+		{"tags:ru", "tags", "rust:3,ruby:1,rustlang:1"},
+		{"tags:ruby AND tags:go", "", ""}, // known values, empty together
+		{"tags:rust", "", ""},             // has results
+		{"tags:ru_", "", ""},              // ru_st is deleted, and _ is no wildcard
+		{"tags:zzz", "", ""},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10, SuggestOnEmpty: true})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		var got []string
+		for _, s := range res.Suggestions {
+			got = append(got, fmt.Sprintf("%s:%d", s.Value, s.Count))
+		}
+		if res.SuggestField != tt.field || strings.Join(got, ",") != tt.want {
+			t.Errorf("Search(%q) suggestions = %q %v, want %q %s", tt.query, res.SuggestField, got, tt.field, tt.want)
+		}
+	}
+
+	res, err := ix.Search(ctx, "tags:rus", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if res.Suggestions != nil {
+		t.Errorf("Search without SuggestOnEmpty returned suggestions %v", res.Suggestions)
+	}
+}
+
+func TestSearchDistinctBy_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title":    {Type: ministore.FieldText},
+			"author":   {Type: ministore.FieldKeyword},
+			"priority": {Type: ministore.FieldNumber},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/a1","title":"go","author":"ann","priority":3}`,
+		`{"path":"/a2","title":"go","author":"ann","priority":9}`,
+		`{"path":"/b1","title":"go","author":"bob","priority":5}`,
+		`{"path":"/b2","title":"go","author":"bob","priority":1}`,
+		`{"path":"/c1","title":"go","author":"cy","priority":7}`,
+		`{"path":"/n1","title":"go","priority":8}`, // no author: left out
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	// One page at a time: each author's highest priority item, in rank order
+	opts := ministore.SearchOptions{
+		Limit:      1,
+		Rank:       ministore.RankMode{Kind: ministore.RankField, Field: "priority"},
+		Show:       ministore.OutputFieldSelector{Kind: ministore.ShowNone},
+		DistinctBy: "author",
+		Snapshot:   true,
+	}
+	var got []string
+	for {
+		page, err := ix.Search(ctx, "title:go", opts)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		got = append(got, pathsFromItems(t, page.Items)...)
+		if opts.After == "" {
+			// Would win bob's group, but the snapshot predates it
+			if err := ix.PutJSON(ctx, []byte(`{"path":"/b3","title":"go","author":"bob","priority":8}`)); err != nil {
+				t.Fatalf("PutJSON: %v", err)
+			}
+		}
+		if !page.HasMore {
+			break
+		}
+		opts.After = page.NextCursor
+	}
+	if strings.Join(got, ",") != "/a2,/c1,/b1" {
+		t.Errorf("distinct by author = %v, want /a2,/c1,/b1", got)
+	}
+
+	// Without a snapshot the new item wins bob's group
+	page, err := ix.Search(ctx, "title:go", ministore.SearchOptions{
+		Limit:      10,
+		Rank:       ministore.RankMode{Kind: ministore.RankField, Field: "priority"},
+		Show:       ministore.OutputFieldSelector{Kind: ministore.ShowNone},
+		DistinctBy: "author",
+	})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := strings.Join(pathsFromItems(t, page.Items), ","); got != "/a2,/b3,/c1" {
+		t.Errorf("distinct by author = %s, want /a2,/b3,/c1", got)
+	}
+
+	// Text relevance ranking collapses too
+	page, err = ix.Search(ctx, "title:go", ministore.SearchOptions{Limit: 10, DistinctBy: "author"})
+	if err != nil {
+		t.Fatalf("Search FTS: %v", err)
+	}
+	if len(page.Items) != 3 {
+		t.Errorf("distinct by author (FTS) = %v, want 3 items", pathsFromItems(t, page.Items))
+	}
+
+	if _, err := ix.Search(ctx, "title:go", ministore.SearchOptions{DistinctBy: "title"}); err == nil {
+		t.Error("DistinctBy on a text field: want error")
+	}
+}
+
 func TestDocFreqMaintenance_SQLite(t *testing.T) {
 	schema := ministore.Schema{
 		Fields: map[string]ministore.FieldSpec{
@@ -297,6 +574,75 @@ func TestDocFreqMaintenance_SQLite(t *testing.T) {
 	}
 }
 
+func TestDeleteWhereBatched_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for i := 0; i < 25; i++ {
+		tags := []string{"keep"}
+		if i%3 != 0 {
+			tags = []string{"old", "shared"} // 16 items
+		} else if i%2 == 0 {
+			tags = append(tags, "shared")
+		}
+		b, _ := json.Marshal(map[string]any{"path": fmt.Sprintf("/%02d", i), "tags": tags})
+		if err := ix.PutJSON(ctx, b); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	if _, err := ix.DeleteWhereBatched(ctx, "", 4); !ministore.IsKind(err, ministore.ErrQueryParse) {
+		t.Errorf("DeleteWhereBatched(\"\") err = %v, want ErrQueryParse", err)
+	}
+
+	// A batch size that divides the match count ends on an empty batch
+	n, err := ix.DeleteWhereBatched(ctx, "tags:old", 4)
+	if err != nil {
+		t.Fatalf("DeleteWhereBatched: %v", err)
+	}
+	if n != 16 {
+		t.Errorf("DeleteWhereBatched = %d, want 16", n)
+	}
+
+	db := ix.DB()
+	getFreq := func(val string) int64 {
+		var df int64
+		if err := db.QueryRowContext(ctx,
+			"SELECT doc_freq FROM kw_dict WHERE field = ? AND value = ?",
+			"tags", val,
+		).Scan(&df); err != nil {
+			t.Fatalf("scan doc_freq(%s): %v", val, err)
+		}
+		return df
+	}
+	for val, want := range map[string]int64{"old": 0, "shared": 5, "keep": 9} {
+		if df := getFreq(val); df != want {
+			t.Errorf("doc_freq(%s) = %d, want %d", val, df, want)
+		}
+	}
+
+	res, err := ix.Search(ctx, "tags:shared", ministore.SearchOptions{Limit: 100})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 5 {
+		t.Errorf("Search(tags:shared) = %d items after delete, want 5", len(res.Items))
+	}
+
+	// Default batch size, and nothing left to delete
+	if n, err := ix.DeleteWhereBatched(ctx, "tags:old", 0); err != nil || n != 0 {
+		t.Errorf("second DeleteWhereBatched = %d, %v; want 0, nil", n, err)
+	}
+	if n, err := ix.DeleteWhereBatched(ctx, "tags:keep", 0); err != nil || n != 9 {
+		t.Errorf("DeleteWhereBatched(tags:keep) = %d, %v; want 9, nil", n, err)
+	}
+}
+
 func TestDiscoverValuesAndStats_SQLite(t *testing.T) {
 	schema := ministore.Schema{
 		Fields: map[string]ministore.FieldSpec{
@@ -378,3 +724,5317 @@ func TestDiscoverValuesAndStats_SQLite(t *testing.T) {
 		t.Fatalf("median=%v want 3.5", stats2.Median)
 	}
 }
+
+func TestStatsPercentiles_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"latency": {Type: ministore.FieldNumber},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for i := 1; i <= 10; i++ {
+		doc := fmt.Sprintf(`{"path":"/%d","latency":%d}`, i, i*10)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	// Ranks are p*(n-1)/100 over 10,20,...,100: p90 is rank 8.1, 90 + 0.1*10
+	stats, err := ix.Stats(ctx, "latency", "", 0, 50, 90, 100)
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	want := map[float64]float64{0: 10, 50: 55, 90: 91, 100: 100}
+	for p, v := range want {
+		if got, ok := stats.Percentiles[p]; !ok || math.Abs(got-v) > 1e-9 {
+			t.Errorf("p%v = %v, want %v", p, got, v)
+		}
+	}
+	if stats.Median == nil || *stats.Median != stats.Percentiles[50] {
+		t.Errorf("median %v != p50 %v", stats.Median, stats.Percentiles[50])
+	}
+
+	// A single value is every percentile
+	stats, err = ix.Stats(ctx, "latency", "latency>=100", 1, 99)
+	if err != nil {
+		t.Fatalf("Stats filtered: %v", err)
+	}
+	if stats.Percentiles[1] != 100 || stats.Percentiles[99] != 100 {
+		t.Errorf("single value percentiles = %v", stats.Percentiles)
+	}
+
+	stats, err = ix.Stats(ctx, "created", "latency>50", 50)
+	if err != nil {
+		t.Fatalf("Stats created: %v", err)
+	}
+	if stats.Median == nil || stats.Percentiles[50] != *stats.Median {
+		t.Errorf("created p50 = %v, median %v", stats.Percentiles, stats.Median)
+	}
+
+	// Without requested percentiles the map stays nil
+	if stats, err = ix.Stats(ctx, "latency", ""); err != nil || stats.Percentiles != nil {
+		t.Errorf("no percentiles: %v, %v", stats.Percentiles, err)
+	}
+	if _, err := ix.Stats(ctx, "latency", "", 120); err == nil {
+		t.Error("percentile 120: want error")
+	}
+}
+
+func TestFacets_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags":     {Type: ministore.FieldKeyword, Multi: true},
+			"lang":     {Type: ministore.FieldKeyword},
+			"priority": {Type: ministore.FieldNumber},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	put := func(path string, tags []string, lang string, pr int) {
+		doc := map[string]any{"path": path, "tags": tags, "lang": lang, "priority": pr}
+		b, _ := json.Marshal(doc)
+		if err := ix.PutJSON(ctx, b); err != nil {
+			t.Fatalf("PutJSON(%s): %v", path, err)
+		}
+	}
+
+	put("/1", []string{"x", "z"}, "go", 1)
+	put("/2", []string{"x", "y"}, "rust", 2)
+	put("/3", []string{"y"}, "go", 3)
+	put("/4", []string{"x", "z"}, "go", 4)
+
+	facets, err := ix.Facets(ctx, "", []string{"tags", "lang"}, 2)
+	if err != nil {
+		t.Fatalf("Facets: %v", err)
+	}
+	// y and z tie at 2; value asc breaks the tie, and top 2 drops z
+	want := map[string][]ministore.ValueCount{
+		"tags": {{Value: "x", Count: 3}, {Value: "y", Count: 2}},
+		"lang": {{Value: "go", Count: 3}, {Value: "rust", Count: 1}},
+	}
+	if !reflect.DeepEqual(facets, want) {
+		t.Fatalf("Facets = %+v, want %+v", facets, want)
+	}
+
+	// Filtered: priority>=2 selects /2, /3, /4
+	facets, err = ix.Facets(ctx, "priority>=2", []string{"tags", "lang"}, 10)
+	if err != nil {
+		t.Fatalf("Facets filtered: %v", err)
+	}
+	want = map[string][]ministore.ValueCount{
+		"tags": {{Value: "x", Count: 2}, {Value: "y", Count: 2}, {Value: "z", Count: 1}},
+		"lang": {{Value: "go", Count: 2}, {Value: "rust", Count: 1}},
+	}
+	if !reflect.DeepEqual(facets, want) {
+		t.Fatalf("Facets filtered = %+v, want %+v", facets, want)
+	}
+
+	// A field with no matching values still has an (empty) entry
+	facets, err = ix.Facets(ctx, "tags:none", []string{"lang"}, 10)
+	if err != nil {
+		t.Fatalf("Facets empty: %v", err)
+	}
+	if v, ok := facets["lang"]; !ok || len(v) != 0 {
+		t.Fatalf("Facets empty = %+v", facets)
+	}
+
+	if _, err := ix.Facets(ctx, "", []string{"priority"}, 10); err == nil {
+		t.Fatal("Facets on number field: want error")
+	}
+}
+
+func TestHistogram_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"n":   {Type: ministore.FieldNumber},
+			"due": {Type: ministore.FieldDate},
+		},
+	}
+	ctx := context.Background()
+	opts := ministore.DefaultIndexOptions()
+	opts.Location = time.FixedZone("UTC+5", 5*3600)
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+
+	docs := []string{
+		`{"path":"/a","n":1,"due":"2025-01-01T10:00:00Z"}`,
+		`{"path":"/b","n":2.5,"due":"2025-01-01T20:00:00Z"}`, // Jan 2 in UTC+5
+		`{"path":"/c","n":3,"due":"2025-01-04T12:00:00Z"}`,
+		`{"path":"/d","n":9}`,
+		`{"path":"/e","n":-1}`,
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	buckets, err := ix.Histogram(ctx, "n", "", 2)
+	if err != nil {
+		t.Fatalf("Histogram: %v", err)
+	}
+	want := []ministore.Bucket{
+		{Lo: -2, Hi: 0, Count: 1},
+		{Lo: 0, Hi: 2, Count: 1},
+		{Lo: 2, Hi: 4, Count: 2},
+		{Lo: 4, Hi: 6, Count: 0},
+		{Lo: 6, Hi: 8, Count: 0},
+		{Lo: 8, Hi: 10, Count: 1},
+	}
+	if !reflect.DeepEqual(buckets, want) {
+		t.Fatalf("Histogram = %+v, want %+v", buckets, want)
+	}
+
+	buckets, err = ix.Histogram(ctx, "n", "n>2", 2)
+	if err != nil {
+		t.Fatalf("Histogram filtered: %v", err)
+	}
+	if !reflect.DeepEqual(buckets, want[2:]) {
+		t.Fatalf("Histogram filtered = %+v, want %+v", buckets, want[2:])
+	}
+
+	buckets, err = ix.Histogram(ctx, "n", "n>100", 2)
+	if err != nil || len(buckets) != 0 {
+		t.Fatalf("Histogram empty = %+v, %v", buckets, err)
+	}
+
+	// Day buckets start at midnight in the index time zone
+	days, err := ix.DateHistogram(ctx, "due", "", ministore.IntervalDay)
+	if err != nil {
+		t.Fatalf("DateHistogram: %v", err)
+	}
+	var got []string
+	for _, b := range days {
+		got = append(got, fmt.Sprintf("%s:%d", b.Start.Format("2006-01-02T15:04-07:00"), b.Count))
+	}
+	wantDays := "2025-01-01T00:00+05:00:1,2025-01-02T00:00+05:00:1,2025-01-03T00:00+05:00:0,2025-01-04T00:00+05:00:1"
+	if strings.Join(got, ",") != wantDays {
+		t.Fatalf("day buckets = %v, want %s", got, wantDays)
+	}
+
+	weeks, err := ix.DateHistogram(ctx, "due", "", ministore.IntervalWeek)
+	if err != nil {
+		t.Fatalf("DateHistogram week: %v", err)
+	}
+	if len(weeks) != 1 || weeks[0].Start.Format("2006-01-02") != "2024-12-30" || weeks[0].End.Format("2006-01-02") != "2025-01-06" || weeks[0].Count != 3 {
+		t.Fatalf("week buckets = %+v", weeks)
+	}
+
+	months, err := ix.DateHistogram(ctx, "created", "n>=0", ministore.IntervalMonth)
+	if err != nil {
+		t.Fatalf("DateHistogram created: %v", err)
+	}
+	var total uint64
+	for _, b := range months {
+		total += b.Count
+	}
+	if total != 4 {
+		t.Fatalf("created buckets = %+v, want 4 items", months)
+	}
+
+	if _, err := ix.DateHistogram(ctx, "due", "", "year"); err == nil {
+		t.Fatal("DateHistogram with unknown interval: want error")
+	}
+	if _, err := ix.Histogram(ctx, "due", "", 1); err == nil {
+		t.Fatal("Histogram on date field: want error")
+	}
+	if _, err := ix.Histogram(ctx, "n", "", 0); err == nil {
+		t.Fatal("Histogram with zero width: want error")
+	}
+}
+
+func TestOptimize_SQLite(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		schema ministore.Schema
+	}{
+		{"fts", ministore.Schema{Fields: map[string]ministore.FieldSpec{"title": {Type: ministore.FieldText}}}},
+		{"no fts", ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}}},
+	} {
+		ix, dbPath := newIndex(t, tt.schema)
+		ctx := context.Background()
+		for i := 0; i < 20; i++ {
+			doc := fmt.Sprintf(`{"path":"/%d","title":"hello %d","tags":"t%d"}`, i, i, i)
+			if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+				t.Fatalf("%s: PutJSON: %v", tt.name, err)
+			}
+		}
+
+		if err := ix.Optimize(ctx, ministore.OptimizeOptions{}); err != nil {
+			t.Fatalf("%s: Optimize: %v", tt.name, err)
+		}
+		// The checkpoint truncates the WAL file
+		if fi, err := os.Stat(dbPath + "-wal"); err == nil && fi.Size() != 0 {
+			t.Errorf("%s: WAL size after Optimize = %d, want 0", tt.name, fi.Size())
+		}
+
+		if err := ix.Optimize(ctx, ministore.OptimizeOptions{Vacuum: true}); err != nil {
+			t.Fatalf("%s: Optimize with vacuum: %v", tt.name, err)
+		}
+		res, err := ix.Search(ctx, "path:/1*", ministore.SearchOptions{Limit: 50})
+		if err != nil || len(res.Items) != 11 {
+			t.Errorf("%s: search after Optimize = %d items, %v", tt.name, len(res.Items), err)
+		}
+	}
+}
+
+func TestOptimizeIncremental_SQLite(t *testing.T) {
+	ix, _ := newIndex(t, ministore.Schema{Fields: map[string]ministore.FieldSpec{"title": {Type: ministore.FieldText}}})
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		doc := fmt.Sprintf(`{"path":"/%d","title":"hello %d"}`, i, i)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	steps := 0
+	for {
+		done, err := ix.OptimizeIncremental(ctx, 1)
+		if err != nil {
+			t.Fatalf("OptimizeIncremental: %v", err)
+		}
+		if done {
+			break
+		}
+		if steps++; steps > 1000 {
+			t.Fatalf("OptimizeIncremental never finished")
+		}
+	}
+	if steps == 0 {
+		t.Errorf("50 single-document commits left nothing to merge")
+	}
+	if done, err := ix.OptimizeIncremental(ctx, 0); err != nil || !done {
+		t.Errorf("OptimizeIncremental after finishing = %v, %v; want done", done, err)
+	}
+	res, err := ix.Search(ctx, "hello", ministore.SearchOptions{Limit: 100})
+	if err != nil || len(res.Items) != 50 {
+		t.Errorf("search after merging = %d items, %v", len(res.Items), err)
+	}
+
+	noFTS, _ := newIndex(t, ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}})
+	if done, err := noFTS.OptimizeIncremental(ctx, 10); err != nil || !done {
+		t.Errorf("OptimizeIncremental without FTS = %v, %v; want done", done, err)
+	}
+}
+
+func TestSnapshot_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+		"tags":  {Type: ministore.FieldKeyword},
+	}}
+	ix, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		doc := fmt.Sprintf(`{"path":"/%d","title":"hello %d","tags":"t"}`, i, i)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	dst := filepath.Join(filepath.Dir(dbPath), "snap.db")
+	if err := ix.Snapshot(ctx, dst); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := ix.Snapshot(ctx, dst); err == nil {
+		t.Errorf("Snapshot over an existing file was accepted")
+	}
+	// Later writes stay out of the copy
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/late","title":"hello late","tags":"t"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	snap, err := ministore.Open(ctx, sqlite.New(dst), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open snapshot: %v", err)
+	}
+	defer snap.Close()
+	for _, q := range []string{"hello", "tags:t"} {
+		res, err := snap.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil || len(res.Items) != 5 {
+			t.Errorf("snapshot Search(%s) = %d items, %v; want 5", q, len(res.Items), err)
+		}
+	}
+	// The copy is a working index of its own
+	if err := snap.PutJSON(ctx, []byte(`{"path":"/new","title":"hello new","tags":"t"}`)); err != nil {
+		t.Errorf("PutJSON into snapshot: %v", err)
+	}
+}
+
+func TestSQLErrorContext_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags":     {Type: ministore.FieldKeyword},
+		"priority": {Type: ministore.FieldNumber},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	if _, err := ix.DB().ExecContext(ctx, `CREATE TRIGGER fail_number BEFORE INSERT ON field_number
+		BEGIN SELECT RAISE(ABORT, 'no numbers'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+
+	err := ix.PutJSON(ctx, []byte(`{"path":"/a","tags":"secret-tag","priority":42}`))
+	if !ministore.IsKind(err, ministore.ErrSQL) {
+		t.Fatalf("PutJSON error = %v, want ErrSQL", err)
+	}
+	var se *ministore.SQLError
+	if !errors.As(err, &se) {
+		t.Fatalf("PutJSON error %v has no SQLError", err)
+	}
+	if se.Op != "insert number" || se.Field != "priority" || se.NArgs != 3 {
+		t.Errorf("SQLError = {Op:%q Field:%q NArgs:%d}, want insert number on priority with 3 args", se.Op, se.Field, se.NArgs)
+	}
+	if !strings.Contains(se.Statement(), "INSERT INTO field_number") {
+		t.Errorf("Statement() = %q, want the field_number insert", se.Statement())
+	}
+	if msg := err.Error(); strings.Contains(msg, "secret-tag") || strings.Contains(se.Statement(), "42") {
+		t.Errorf("error leaks document values: %q / %q", msg, se.Statement())
+	}
+}
+
+func TestSearchTimeout_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"title": {Type: ministore.FieldText}}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := ix.PutJSON(ctx, []byte(fmt.Sprintf(`{"path":"/%d","title":"hello %d"}`, i, i))); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, "title:hello", ministore.SearchOptions{Limit: 10, Timeout: time.Minute})
+	if err != nil || len(res.Items) != 5 {
+		t.Fatalf("Search with a generous timeout = %d items, %v", len(res.Items), err)
+	}
+
+	_, err = ix.Search(ctx, "title:hello", ministore.SearchOptions{Limit: 10, Timeout: time.Nanosecond})
+	if !ministore.IsKind(err, ministore.ErrTimeout) {
+		t.Errorf("Search past its timeout: err = %v, want ErrTimeout", err)
+	}
+
+	// Cancelling the caller's own context is not a timeout
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ix.Search(cctx, "title:hello", ministore.SearchOptions{Limit: 10, Timeout: time.Minute})
+	if err == nil || ministore.IsKind(err, ministore.ErrTimeout) {
+		t.Errorf("Search on a cancelled context: err = %v, want a non-timeout error", err)
+	}
+}
+
+func TestImport_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"n": {Type: ministore.FieldNumber}}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	input := "{\"path\":\"/1\",\"n\":1}\n\n{\"path\":\"/2\",\"n\":2}\n{\"path\":\"/3\",\"n\":3}\n" +
+		"{\"path\":\"/4\",\"n\":4}\n{\"path\":\"/5\",\"n\":5}" // no trailing newline
+	var progress []int
+	n, err := ix.Import(ctx, strings.NewReader(input), ministore.ImportOptions{
+		BatchSize: 2,
+		Progress:  func(done int) { progress = append(progress, done) },
+	})
+	if err != nil || n != 5 {
+		t.Fatalf("Import = %d, %v; want 5", n, err)
+	}
+	if !reflect.DeepEqual(progress, []int{2, 4, 5}) {
+		t.Errorf("progress = %v, want [2 4 5]", progress)
+	}
+
+	// The batch holding the bad document is rolled back; earlier ones stay
+	input = "{\"path\":\"/6\",\"n\":6}\n{\"path\":\"/7\",\"n\":7}\n{\"path\":\"/8\",\"n\":8}\n{\"path\":\"/9\",\"n\":\"x\"}\n"
+	n, err = ix.Import(ctx, strings.NewReader(input), ministore.ImportOptions{BatchSize: 2})
+	if n != 2 || !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Fatalf("Import with a bad document = %d, %v; want 2 and ErrSchema", n, err)
+	}
+	if !strings.Contains(err.Error(), "line 4, 2 documents committed") {
+		t.Errorf("error %q does not say where the import stopped", err)
+	}
+	res, err := ix.Search(ctx, "n>=6", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); len(got) != 2 {
+		t.Errorf("items after failed import = %v, want /6 and /7", got)
+	}
+}
+
+func TestImportPathMapping_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"n": {Type: ministore.FieldNumber}}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	input := "{\"id\":\"a\",\"n\":1}\n{\"id\":2,\"n\":2}\n"
+	if n, err := ix.Import(ctx, strings.NewReader(input), ministore.ImportOptions{PathField: "id"}); err != nil || n != 2 {
+		t.Fatalf("Import with PathField = %d, %v; want 2", n, err)
+	}
+	input = "{\"org\":\"acme\",\"id\":7,\"n\":3}\n"
+	if n, err := ix.Import(ctx, strings.NewReader(input), ministore.ImportOptions{PathTemplate: "/orgs/{org}/users/{id}"}); err != nil || n != 1 {
+		t.Fatalf("Import with PathTemplate = %d, %v; want 1", n, err)
+	}
+	for _, path := range []string{"a", "2", "/orgs/acme/users/7"} {
+		if ok, err := ix.Exists(ctx, path); err != nil || !ok {
+			t.Errorf("Exists(%q) = %v, %v; want true", path, ok, err)
+		}
+	}
+
+	// A line without the field names it and the line number
+	input = "{\"id\":\"b\",\"n\":4}\n{\"n\":5}\n"
+	_, err := ix.Import(ctx, strings.NewReader(input), ministore.ImportOptions{PathField: "id"})
+	var e *ministore.Error
+	if !errors.As(err, &e) || e.Kind != ministore.ErrSchema {
+		t.Fatalf("Import with a missing path field = %v; want ErrSchema", err)
+	}
+	if !strings.Contains(err.Error(), "line 2") || !strings.Contains(err.Error(), "field=id") {
+		t.Errorf("error %q does not name the line and field", err)
+	}
+
+	if _, err := ix.Import(ctx, strings.NewReader(""), ministore.ImportOptions{PathTemplate: "/users/{id"}); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("Import with an unclosed template = %v; want ErrSchema", err)
+	}
+}
+
+func TestAutoPath_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"n": {Type: ministore.FieldNumber}}}
+	opts := ministore.DefaultIndexOptions()
+	opts.AutoPath = ministore.ContentHashPath
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer ix.Close()
+
+	path, err := ix.PutJSONPath(ctx, []byte(`{"n":1,"note":"a"}`))
+	if err != nil || len(path) != 64 {
+		t.Fatalf("PutJSONPath without a path = %q, %v; want a content hash", path, err)
+	}
+	view, err := ix.Get(ctx, path)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", path, err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(view.DocJSON, &doc); err != nil || doc["path"] != path {
+		t.Errorf("stored document = %s, want path %q", view.DocJSON, path)
+	}
+	// The same content hashes to the same path, whatever the key order
+	if again, err := ix.PutJSONPath(ctx, []byte(`{"note":"a","n":1}`)); err != nil || again != path {
+		t.Errorf("PutJSONPath of the same content = %q, %v; want %q", again, err, path)
+	}
+	// A document with a path keeps it
+	if got, err := ix.PutJSONPath(ctx, []byte(`{"path":"/given","n":2}`)); err != nil || got != "/given" {
+		t.Errorf("PutJSONPath with a path = %q, %v; want /given", got, err)
+	}
+
+	var named []int
+	input := "{\"path\":\"/a\",\"n\":3}\n{\"n\":4}\n"
+	n, err := ix.Import(ctx, strings.NewReader(input), ministore.ImportOptions{
+		OnAutoPath: func(line int, path string) { named = append(named, line) },
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("Import = %d, %v; want 2", n, err)
+	}
+	if !reflect.DeepEqual(named, []int{2}) {
+		t.Errorf("auto-named lines = %v, want [2]", named)
+	}
+	res, err := ix.Search(ctx, "n>=1", ministore.SearchOptions{Limit: 10})
+	if err != nil || len(res.Items) != 4 {
+		t.Fatalf("Search = %d items, %v; want 4", len(res.Items), err)
+	}
+
+	// Batches and transactions name documents too
+	batch := ministore.NewBatch()
+	decoded := map[string]any{"n": 6}
+	if err := batch.PutJSON([]byte(`{"n":5}`)); err != nil {
+		t.Fatalf("Batch.PutJSON: %v", err)
+	}
+	if err := batch.Put(decoded); err != nil {
+		t.Fatalf("Batch.Put: %v", err)
+	}
+	if err := batch.PutJSON([]byte(`{"path":"/b","n":7}`)); err != nil {
+		t.Fatalf("Batch.PutJSON: %v", err)
+	}
+	paths, err := ix.BatchPaths(ctx, batch)
+	if err != nil || len(paths) != 3 || len(paths[0]) != 64 || len(paths[1]) != 64 || paths[2] != "/b" {
+		t.Fatalf("BatchPaths = %q, %v; want two content hashes and /b", paths, err)
+	}
+	if _, ok := decoded["path"]; ok {
+		t.Errorf("Batch.Put modified the caller's document: %v", decoded)
+	}
+	var txPath string
+	err = ix.WithTx(ctx, func(tx *ministore.IndexTx) error {
+		var err error
+		txPath, err = tx.PutPath(ctx, []byte(`{"n":8}`))
+		return err
+	})
+	if err != nil || len(txPath) != 64 {
+		t.Fatalf("IndexTx.PutPath = %q, %v; want a content hash", txPath, err)
+	}
+	if got, err := ix.Search(ctx, "n>=5", ministore.SearchOptions{Limit: 10}); err != nil || len(got.Items) != 4 {
+		t.Fatalf("Search after batch and tx = %d items, %v; want 4", len(got.Items), err)
+	}
+
+	opts.AutoPath = ministore.UUIDPath
+	ix3, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "uuid.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer ix3.Close()
+	a, errA := ix3.PutJSONPath(ctx, []byte(`{"n":1}`))
+	b, errB := ix3.PutJSONPath(ctx, []byte(`{"n":1}`))
+	if errA != nil || errB != nil || len(a) != 36 || a == b {
+		t.Errorf("UUIDPath puts = %q, %q (%v, %v); want two distinct UUIDs", a, b, errA, errB)
+	}
+}
+
+func TestExport_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"n":    {Type: ministore.FieldNumber},
+		"tags": {Type: ministore.FieldKeyword, Multi: true},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	input := "{\"path\":\"/b\",\"n\":1,\"tags\":[\"x\"]}\n{\"path\":\"/a\",\"n\":2}\n{\"path\":\"/c\",\"n\":3,\"tags\":[\"x\"]}\n"
+	if _, err := ix.Import(ctx, strings.NewReader(input), ministore.ImportOptions{}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if err := ix.PutJSON(ctx, []byte("{\"path\":\"/d\",\n  \"n\": 4}")); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if _, err := ix.Delete(ctx, "/c"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	var out bytes.Buffer
+	n, err := ix.Export(ctx, &out, ministore.ExportOptions{})
+	if err != nil || n != 3 {
+		t.Fatalf("Export = %d, %v; want 3", n, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	var paths []string
+	for _, line := range lines {
+		var doc struct{ Path string }
+		if err := json.Unmarshal([]byte(line), &doc); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		paths = append(paths, doc.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"/b", "/a", "/d"}) {
+		t.Errorf("exported paths = %v, want insertion order without the deleted item", paths)
+	}
+
+	out.Reset()
+	if n, err := ix.Export(ctx, &out, ministore.ExportOptions{Where: "tags:x"}); err != nil || n != 1 {
+		t.Fatalf("Export where = %d, %v; want 1", n, err)
+	}
+	if !strings.Contains(out.String(), "\"/b\"") {
+		t.Errorf("Export where wrote %q, want /b", out.String())
+	}
+
+	// An export reads back into an empty index unchanged
+	ix2, _ := newIndex(t, schema)
+	out.Reset()
+	if _, err := ix.Export(ctx, &out, ministore.ExportOptions{Where: "*"}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if n, err := ix2.Import(ctx, &out, ministore.ImportOptions{}); err != nil || n != 3 {
+		t.Fatalf("Import of export = %d, %v; want 3", n, err)
+	}
+}
+
+func TestBatchDeferDocFreq_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags": {Type: ministore.FieldKeyword, Multi: true},
+	}}
+	ctx := context.Background()
+
+	// The same writes with incremental and deferred doc_freq maintenance
+	// must leave the same counts
+	var stats [2][]ministore.KeywordStat
+	for i, deferred := range []bool{false, true} {
+		ix, _ := newIndex(t, schema)
+		if err := ix.PutJSON(ctx, []byte(`{"path":"/old","tags":["a","gone"]}`)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+		b := ministore.NewBatch()
+		b.DeferDocFreq = deferred
+		for _, doc := range []string{
+			`{"path":"/1","tags":["a","b"]}`,
+			`{"path":"/2","tags":["a","c"]}`,
+			`{"path":"/1","tags":["b","c"]}`,
+			`{"path":"/old","tags":["c"]}`,
+		} {
+			if err := b.PutJSON([]byte(doc)); err != nil {
+				t.Fatalf("PutJSON(%s): %v", doc, err)
+			}
+		}
+		if err := b.Delete("/2"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := ix.Batch(ctx, b); err != nil {
+			t.Fatalf("Batch(DeferDocFreq=%v): %v", deferred, err)
+		}
+		var err error
+		if stats[i], err = ix.KeywordStats(ctx, "tags"); err != nil {
+			t.Fatalf("KeywordStats: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(stats[0], stats[1]) {
+		t.Errorf("deferred doc_freq = %+v, want %+v", stats[1], stats[0])
+	}
+	want := map[string]uint64{"b": 1, "c": 2}
+	for _, st := range stats[1] {
+		if st.DocFreq != want[st.Value] {
+			t.Errorf("doc_freq of %q = %d, want %d", st.Value, st.DocFreq, want[st.Value])
+		}
+	}
+}
+
+func TestBatchValidate_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"n":    {Type: ministore.FieldNumber},
+		"tags": {Type: ministore.FieldKeyword},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	b := ministore.NewBatch()
+	for _, doc := range []string{
+		`{"path":"/ok","n":1}`,
+		`{"path":"/bad","n":"x"}`,
+		`{"path":"/also-ok","tags":["a"]}`,
+	} {
+		if err := b.PutJSON([]byte(doc)); err != nil {
+			t.Fatalf("PutJSON(%s): %v", doc, err)
+		}
+	}
+	if err := b.Delete("/gone"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	errs := b.Validate(ix.Schema())
+	if len(errs) != 1 {
+		t.Fatalf("Validate = %v, want one error", errs)
+	}
+	var be *ministore.BatchError
+	if !errors.As(errs[0], &be) || be.Op != 1 || !ministore.IsKind(errs[0], ministore.ErrSchema) {
+		t.Errorf("Validate error = %#v, want a schema BatchError for op 1", errs[0])
+	}
+
+	// Nothing was written
+	res, err := ix.Search(ctx, "path:/*", ministore.SearchOptions{Limit: 10})
+	if err != nil || len(res.Items) != 0 {
+		t.Errorf("search after Validate = %d items, %v; want none", len(res.Items), err)
+	}
+}
+
+func TestBatchPutDecoded_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"n":           {Type: ministore.FieldNumber},
+		"tags":        {Type: ministore.FieldKeyword, Multi: true},
+		"author.name": {Type: ministore.FieldKeyword},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	b := ministore.NewBatch()
+	docs := []map[string]any{
+		// As json.Unmarshal decodes it: the map is indexed directly
+		{"path": "/decoded", "n": 1.5, "tags": []any{"a", "b"}, "author": map[string]any{"name": "ann"}},
+		// Go types json.Unmarshal never yields: indexed from the JSON
+		{"path": "/typed", "n": 2, "tags": []string{"b", "c"}, "author": map[string]string{"name": "bob"}},
+	}
+	for _, doc := range docs {
+		if err := b.Put(doc); err != nil {
+			t.Fatalf("Put(%v): %v", doc, err)
+		}
+	}
+	if err := b.Put(map[string]any{"path": "", "n": 1}); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("Put with empty path error = %v, want %s", err, ministore.ErrSchema)
+	}
+	if errs := b.Validate(ix.Schema()); len(errs) != 0 {
+		t.Fatalf("Validate = %v", errs)
+	}
+	if _, err := ix.Batch(ctx, b); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{"tags:b", []string{"/decoded", "/typed"}},
+		{"n>1", []string{"/decoded", "/typed"}},
+		{"n:2", []string{"/typed"}},
+		{"author.name:ann", []string{"/decoded"}},
+		{"author.name:bob", []string{"/typed"}},
+	} {
+		res, err := ix.Search(ctx, tc.q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tc.q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Search(%q) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	item, err := ix.Get(ctx, "/typed")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	var stored map[string]any
+	if err := json.Unmarshal(item.DocJSON, &stored); err != nil || stored["n"] != 2.0 {
+		t.Errorf("Get /typed = %s (%v)", item.DocJSON, err)
+	}
+
+	// A decoded put is rejected like its JSON would be
+	bad := ministore.NewBatch()
+	if err := bad.Put(map[string]any{"path": "/bad", "n": "x"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if errs := bad.Validate(ix.Schema()); len(errs) != 1 {
+		t.Errorf("Validate = %v, want one error", errs)
+	}
+	if _, err := ix.Batch(ctx, bad); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("Batch error = %v, want %s", err, ministore.ErrSchema)
+	}
+}
+
+func TestMigrateRebuild_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title":    {Type: ministore.FieldKeyword},
+			"priority": {Type: ministore.FieldNumber},
+			"obsolete": {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","title":"Hello World","priority":1,"obsolete":"x"}`,
+		`{"path":"/b","title":"Goodbye World","priority":2,"obsolete":"y"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	before, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// title: keyword -> text, obsolete removed
+	newSchema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title":    {Type: ministore.FieldText},
+			"priority": {Type: ministore.FieldNumber},
+		},
+	}
+	dstPath := filepath.Join(t.TempDir(), "dst.db")
+	n, err := ix.MigrateRebuild(ctx, sqlite.New(dstPath), newSchema)
+	if err != nil {
+		t.Fatalf("MigrateRebuild: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("migrated=%d want 2", n)
+	}
+
+	// Re-running is idempotent on path
+	if n, err := ix.MigrateRebuild(ctx, sqlite.New(dstPath), newSchema); err != nil || n != 2 {
+		t.Fatalf("second MigrateRebuild: n=%d err=%v", n, err)
+	}
+
+	dst, err := ministore.Open(ctx, sqlite.New(dstPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open dst: %v", err)
+	}
+	defer dst.Close()
+
+	res, err := dst.Search(ctx, "title:hello", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	got := pathsFromItems(t, res.Items)
+	if len(got) != 1 || got[0] != "/a" {
+		t.Fatalf("got %v want [/a]", got)
+	}
+
+	if _, err := dst.Search(ctx, "obsolete:x", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Fatalf("expected error searching removed field")
+	}
+
+	after, err := dst.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get dst: %v", err)
+	}
+	if after.Meta != before.Meta {
+		t.Fatalf("timestamps changed: before=%+v after=%+v", before.Meta, after.Meta)
+	}
+}
+
+func TestKeywordCaseFold_SQLite(t *testing.T) {
+	ctx := context.Background()
+	for _, fold := range []bool{false, true} {
+		schema := ministore.Schema{
+			Fields: map[string]ministore.FieldSpec{
+				"tags": {Type: ministore.FieldKeyword, Multi: true, CaseFold: fold},
+			},
+		}
+		ix, _ := newIndex(t, schema)
+		if err := ix.PutJSON(ctx, []byte(`{"path":"/a","tags":["rust"]}`)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+
+		res, err := ix.Search(ctx, "tags:Rust", ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		got := pathsFromItems(t, res.Items)
+		if fold && (len(got) != 1 || got[0] != "/a") {
+			t.Fatalf("case_fold=true: got %v want [/a]", got)
+		}
+		if !fold && len(got) != 0 {
+			t.Fatalf("case_fold=false: got %v want []", got)
+		}
+	}
+}
+
+func TestKeywordEscapedWildcards_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/star","tags":["c*"]}`,
+		`{"path":"/plain","tags":["c","cpp"]}`,
+		`{"path":"/starx","tags":["c*xy"]}`,
+		`{"path":"/question","tags":["what?"]}`,
+		`{"path":"/underscore","tags":["a_b"]}`,
+		`{"path":"/letter","tags":["axb"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	for q, want := range map[string]string{
+		`tags:c\*`:    "/star",
+		`tags:"c\*"`:  "/star",
+		`tags:c\**`:   "/star,/starx",
+		`tags:c\*??`:  "/starx",
+		`tags:what\?`: "/question",
+		`tags:a_*`:    "/underscore",
+		`tags:*\*xy*`: "/starx",
+	} {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("Search(%s) = %v, want %s", q, got, want)
+		}
+	}
+}
+
+func TestFullTextKeyword_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"status": {Type: ministore.FieldKeyword, FullText: true},
+			"labels": {Type: ministore.FieldKeyword, Multi: true, FullText: true},
+			"title":  {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","status":"open case","title":"first"}`,
+		`{"path":"/b","status":"open","labels":["needs review"]}`,
+		`{"path":"/c","status":"closed case","labels":["bug"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	for q, want := range map[string]string{
+		`status:"open case"`:       "/a",
+		`status:"open"`:            "/b",
+		`status:open`:              "/a,/b",
+		`status:case`:              "/a,/c",
+		`status:"case"`:            "",
+		`status:"open*"`:           "/a,/b",
+		`labels:review`:            "/b",
+		`labels:"needs review"`:    "/b",
+		`labels:in(bug)`:           "/c",
+		`review`:                   "/b",
+		`fields(status):closed`:    "/c",
+		`status:(closed OR first)`: "/c",
+	} {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("Search(%s) = %v, want %s", q, got, want)
+		}
+	}
+
+	// Updates replace the full-text entry along with the keyword values
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","status":"resolved"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	res, err := ix.Search(ctx, "status:case", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); len(got) != 1 || got[0] != "/c" {
+		t.Errorf("after update: got %v, want [/c]", got)
+	}
+
+	// Turning full_text off is not additive
+	changed := ministore.Schema{Fields: map[string]ministore.FieldSpec{}}
+	for name, spec := range schema.Fields {
+		changed.Fields[name] = spec
+	}
+	changed.Fields["status"] = ministore.FieldSpec{Type: ministore.FieldKeyword}
+	if _, err := ix.ApplySchema(ctx, changed, ministore.ApplySchemaOptions{}); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("ApplySchema toggling full_text: got %v, want ErrSchema", err)
+	}
+
+	for _, spec := range []ministore.FieldSpec{
+		{Type: ministore.FieldText, FullText: true},
+		{Type: ministore.FieldNumber, FullText: true},
+	} {
+		bad := ministore.Schema{Fields: map[string]ministore.FieldSpec{"f": spec}}
+		if err := bad.Validate(); !ministore.IsKind(err, ministore.ErrSchema) {
+			t.Errorf("Validate(%+v): got %v, want ErrSchema", spec, err)
+		}
+	}
+	w := 2.0
+	weighted := ministore.Schema{Fields: map[string]ministore.FieldSpec{"f": {Type: ministore.FieldKeyword, FullText: true, Weight: &w}}}
+	if err := weighted.Validate(); err != nil {
+		t.Errorf("weight on a full_text keyword field: %v", err)
+	}
+}
+
+func TestMultiFieldText_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title":   {Type: ministore.FieldText},
+			"summary": {Type: ministore.FieldText},
+			"body":    {Type: ministore.FieldText},
+			"tags":    {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/title","title":"rust guide","body":"intro"}`,
+		`{"path":"/summary","title":"guide","summary":"about rust"}`,
+		`{"path":"/body","title":"guide","body":"rust everywhere"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, "fields(title,summary):rust", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	got := pathsFromItems(t, res.Items)
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "/summary" || got[1] != "/title" {
+		t.Fatalf("got %v want [/summary /title]", got)
+	}
+
+	if _, err := ix.Search(ctx, "fields(title,tags):rust", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Fatalf("expected error for non-text field in fields(...)")
+	}
+}
+
+func TestFuzzyKeyword_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/rust","tags":["rust","rusty"]}`,
+		`{"path":"/rest","tags":["rest"]}`,
+		`{"path":"/go","tags":["golang"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, "tags~rusr", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	got := pathsFromItems(t, res.Items)
+	if len(got) != 1 || got[0] != "/rust" {
+		t.Fatalf("got %v want [/rust]", got)
+	}
+
+	res, err = ix.Search(ctx, "tags~python", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); len(got) != 0 {
+		t.Fatalf("got %v want []", got)
+	}
+}
+
+func TestFuzzyKeywordBounds_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags": {Type: ministore.FieldKeyword, Multi: true, CaseFold: true},
+	}}
+	opts := ministore.DefaultIndexOptions()
+	opts.MaxPrefixExpansion = 2
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+
+	for _, doc := range []string{
+		`{"path":"/1","tags":["Rust"]}`,
+		`{"path":"/2","tags":["rusty"]}`,
+		`{"path":"/3","tags":["rustacean","bust"]}`,
+		`{"path":"/4","tags":["gust"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	// rustacean is outside the length window; the folded Rust still matches
+	res, err := ix.Search(ctx, "tags~rusti", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	got := pathsFromItems(t, res.Items)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"/1", "/2"}) {
+		t.Errorf("tags~rusti = %v, want [/1 /2]", got)
+	}
+
+	// rust, bust and gust are all one edit from must: more than the limit
+	if _, err := ix.Search(ctx, "tags~must", ministore.SearchOptions{Limit: 10}); err == nil || !strings.Contains(err.Error(), "matches more than 2 values") {
+		t.Errorf("tags~must err = %v, want candidate limit error", err)
+	}
+}
+
+func TestGetMany_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	var want []string
+	for i := 0; i < ministore.GetManyChunkSize+10; i++ {
+		p := fmt.Sprintf("/doc/%d", i)
+		if err := ix.PutJSON(ctx, []byte(fmt.Sprintf(`{"path":%q,"tags":["t"]}`, p))); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+		want = append(want, p)
+	}
+
+	got, err := ix.GetMany(ctx, append(want, "/missing"))
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d items want %d", len(got), len(want))
+	}
+	if _, ok := got["/missing"]; ok {
+		t.Fatalf("missing path should be absent from result")
+	}
+	if v := got["/doc/3"]; v.Path != "/doc/3" || len(v.DocJSON) == 0 {
+		t.Fatalf("unexpected item view: %+v", v)
+	}
+}
+
+func TestSavedQuery_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","tags":["rust"]}`,
+		`{"path":"/b","tags":["rust"]}`,
+		`{"path":"/c","tags":["go"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	opts := ministore.SearchOptions{Limit: 1, Rank: ministore.RankMode{Kind: ministore.RankNone}}
+	if err := ix.SaveQuery(ctx, "rusty", "tags:rust", opts); err != nil {
+		t.Fatalf("SaveQuery: %v", err)
+	}
+
+	page, err := ix.RunSavedQuery(ctx, "rusty", "")
+	if err != nil {
+		t.Fatalf("RunSavedQuery: %v", err)
+	}
+	got := pathsFromItems(t, page.Items)
+	if !page.HasMore || page.NextCursor == "" {
+		t.Fatalf("expected a second page, got %+v", page)
+	}
+	page, err = ix.RunSavedQuery(ctx, "rusty", page.NextCursor)
+	if err != nil {
+		t.Fatalf("RunSavedQuery page 2: %v", err)
+	}
+	got = append(got, pathsFromItems(t, page.Items)...)
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "/a" || got[1] != "/b" {
+		t.Fatalf("got %v want [/a /b]", got)
+	}
+
+	if _, err := ix.RunSavedQuery(ctx, "nope", ""); !ministore.IsKind(err, ministore.ErrNotFound) {
+		t.Fatalf("expected not_found, got %v", err)
+	}
+	if err := ix.SaveQuery(ctx, "bad", "NOT tags:rust", opts); err == nil {
+		t.Fatalf("expected SaveQuery to reject unanchored query")
+	}
+}
+
+func TestBracketRanges_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"priority": {Type: ministore.FieldNumber},
+			"due":      {Type: ministore.FieldDate},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/1","priority":1,"due":"2024-01-01"}`,
+		`{"path":"/2","priority":2,"due":"2024-01-15"}`,
+		`{"path":"/3","priority":3,"due":"2024-02-01"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"priority:1..3", []string{"/1", "/2", "/3"}},
+		{"priority:[1,3]", []string{"/1", "/2", "/3"}},
+		{"priority:[1,3)", []string{"/1", "/2"}},
+		{"priority:(1,3]", []string{"/2", "/3"}},
+		{"priority:(1,3)", []string{"/2"}},
+		{"due:[2024-01-01,2024-02-01]", []string{"/1", "/2", "/3"}},
+		{"due:[2024-01-01,2024-02-01)", []string{"/1", "/2"}},
+		{"due:(2024-01-01,2024-02-01]", []string{"/2", "/3"}},
+		{"due:(2024-01-01,2024-02-01)", []string{"/2"}},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: Search: %v", tt.query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestInSet_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","tags":["rust"]}`,
+		`{"path":"/b","tags":["go","rust"]}`,
+		`{"path":"/c","tags":["a,b"]}`,
+		`{"path":"/d","tags":["python"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, `tags:in(rust,go,"a,b")`, ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	got := pathsFromItems(t, res.Items)
+	sort.Strings(got)
+	if strings.Join(got, ",") != "/a,/b,/c" {
+		t.Fatalf("got %v want [/a /b /c]", got)
+	}
+
+	for q, want := range map[string]string{
+		"tags:any(rust,go)":           "/a,/b",
+		"tags:all(rust,go)":           "/b",
+		"tags:all(rust)":              "/a,/b",
+		"tags:all(rust,go,python)":    "",
+		"tags:all(go) OR tags:python": "/b,/d",
+	} {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("Search(%s) = %v, want %s", q, got, want)
+		}
+	}
+}
+
+func TestAnyKeyword_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags":     {Type: ministore.FieldKeyword, Multi: true},
+			"category": {Type: ministore.FieldKeyword, CaseFold: true},
+			"title":    {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","tags":["urgent","bug"]}`,
+		`{"path":"/b","category":"Urgent"}`,
+		`{"path":"/c","title":"urgent fix","tags":["bug"]}`,
+		`{"path":"/d","tags":["urgent"],"category":"urgent"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	// Text fields are not searched; /d matches once despite two fields
+	for q, want := range map[string]string{
+		"*:urgent":                     "/a,/b,/d",
+		"*:URGENT":                     "/b,/d",
+		"*:urgent AND tags:bug":        "/a",
+		"*:bug OR *:urgent":            "/a,/b,/c,/d",
+		"*:urgent AND NOT tags:urgent": "/b",
+		"*:missing":                    "",
+	} {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("Search(%s) = %v, want %s", q, got, want)
+		}
+	}
+
+	if _, err := ix.Search(ctx, "*:urg*", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Error("expected an error for a wildcard across keyword fields")
+	}
+}
+
+func TestSearchHighlights_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"body":  {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","title":"intro","body":"learning rust the hard way"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	res, err := ix.Search(ctx, "rust", ministore.SearchOptions{
+		Limit:     10,
+		Highlight: &ministore.HighlightSpec{Tokens: 5, Open: "[", Close: "]"},
+	})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(res.Items))
+	}
+	var out struct {
+		Highlights map[string]string `json:"highlights"`
+	}
+	if err := json.Unmarshal(res.Items[0], &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !strings.Contains(out.Highlights["body"], "[rust]") {
+		t.Fatalf("expected highlighted body, got %v", out.Highlights)
+	}
+	if _, ok := out.Highlights["title"]; ok {
+		t.Fatalf("title did not match and should not be highlighted: %v", out.Highlights)
+	}
+
+	// A marker left unset takes its default on its own
+	for _, tc := range []struct {
+		spec ministore.HighlightSpec
+		want string
+	}{
+		{ministore.HighlightSpec{Open: "<em>"}, "<em>rust</b>"},
+		{ministore.HighlightSpec{Close: "</em>"}, "<b>rust</em>"},
+	} {
+		spec := tc.spec
+		res, err := ix.Search(ctx, "rust", ministore.SearchOptions{Limit: 10, Highlight: &spec})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if err := json.Unmarshal(res.Items[0], &out); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if !strings.Contains(out.Highlights["body"], tc.want) {
+			t.Errorf("highlight %+v = %q, want %q", tc.spec, out.Highlights["body"], tc.want)
+		}
+	}
+}
+
+func TestCursorTTLUsesIndexClock_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}}
+	now := time.Unix(1700000000, 0)
+	opts := ministore.DefaultIndexOptions()
+	opts.Now = func() time.Time { return now }
+	opts.CursorTTL = time.Minute
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+	for _, p := range []string{"/a", "/b", "/c"} {
+		if err := ix.PutJSON(ctx, []byte(`{"path":"`+p+`","tags":"x"}`)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	sopts := ministore.SearchOptions{Limit: 1, CursorMode: ministore.CursorShort}
+	page, err := ix.Search(ctx, "tags:x", sopts)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if !strings.HasPrefix(page.NextCursor, "c:") {
+		t.Fatalf("expected a short cursor, got %q", page.NextCursor)
+	}
+
+	// Still valid one millisecond before the TTL
+	now = now.Add(time.Minute - time.Millisecond)
+	sopts.After = page.NextCursor
+	page, err = ix.Search(ctx, "tags:x", sopts)
+	if err != nil {
+		t.Fatalf("Search before TTL: %v", err)
+	}
+
+	// The cursor from that page expires at exactly its TTL
+	now = now.Add(time.Minute)
+	sopts.After = page.NextCursor
+	if _, err := ix.Search(ctx, "tags:x", sopts); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("Search at TTL: err = %v, want cursor expired", err)
+	}
+}
+
+func TestSearchMatchPositions_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"body":  {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	body := "Rust is fun; learning rust the hard way"
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","title":"intro","body":"`+body+`"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	// Positions alone, and alongside snippets
+	for _, hl := range []*ministore.HighlightSpec{nil, {Tokens: 5, Open: "[", Close: "]"}} {
+		res, err := ix.Search(ctx, "rust", ministore.SearchOptions{Limit: 10, Highlight: hl, ReturnMatchPositions: true})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(res.Items) != 1 {
+			t.Fatalf("expected 1 item, got %d", len(res.Items))
+		}
+		var out struct {
+			Highlights     map[string]string `json:"highlights"`
+			MatchPositions map[string][]int  `json:"match_positions"`
+		}
+		if err := json.Unmarshal(res.Items[0], &out); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		got := out.MatchPositions["body"]
+		if fmt.Sprint(got) != "[0 4 22 26]" {
+			t.Fatalf("body positions = %v, want [0 4 22 26]", got)
+		}
+		for i := 0; i < len(got); i += 2 {
+			if term := body[got[i]:got[i+1]]; !strings.EqualFold(term, "rust") {
+				t.Errorf("offsets %d..%d cover %q", got[i], got[i+1], term)
+			}
+		}
+		if _, ok := out.MatchPositions["title"]; ok {
+			t.Errorf("title did not match: %v", out.MatchPositions)
+		}
+		if (hl != nil) != strings.Contains(out.Highlights["body"], "[rust]") {
+			t.Errorf("highlights = %v with spec %v", out.Highlights, hl)
+		}
+	}
+
+	res, err := ix.Search(ctx, "rust", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if strings.Contains(string(res.Items[0]), "match_positions") {
+		t.Errorf("match positions returned unrequested: %s", res.Items[0])
+	}
+}
+
+func TestCalendarWindows_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"due":      {Type: ministore.FieldDate},
+			"priority": {Type: ministore.FieldNumber},
+		},
+	}
+	opts := ministore.DefaultIndexOptions()
+	// Tuesday 2023-11-14 22:13:20 UTC
+	opts.Now = func() time.Time { return time.Unix(1700000000, 0) }
+	ix, err := ministore.Create(context.Background(), sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/today","due":"2023-11-14","priority":1}`,
+		`{"path":"/yesterday","due":"2023-11-13","priority":1}`,
+		`{"path":"/lastweek","due":"2023-11-12","priority":1}`,
+		`{"path":"/lastmonth","due":"2023-10-31","priority":1}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"due:today", "/today"},
+		{"due:yesterday", "/yesterday"},
+		{"due:thisweek", "/today,/yesterday"},
+		{"due:thismonth", "/lastweek,/today,/yesterday"},
+		{"created:today", "/lastmonth,/lastweek,/today,/yesterday"},
+		{"created:yesterday", ""},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: Search: %v", tt.query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v want %s", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := ix.Search(ctx, "priority:today", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Fatalf("expected calendar window on number field to be rejected")
+	}
+}
+
+func TestIndexLocation_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"due": {Type: ministore.FieldDate},
+		},
+	}
+	ctx := context.Background()
+	docs := []string{
+		`{"path":"/bare","due":"2025-01-02"}`,
+		`{"path":"/utc","due":"2025-01-02T00:00:00Z"}`,
+	}
+
+	tests := []struct {
+		name string
+		loc  *time.Location
+		want string
+	}{
+		// Bare date and explicit UTC midnight are the same instant
+		{"utc", time.UTC, "/bare,/utc"},
+		// Bare date is local midnight (19:00 UTC on Jan 1); the RFC3339 value keeps its offset
+		{"plus5", time.FixedZone("UTC+5", 5*3600), "/bare"},
+	}
+	for _, tt := range tests {
+		dbPath := filepath.Join(t.TempDir(), "test.db")
+		opts := ministore.DefaultIndexOptions()
+		opts.Location = tt.loc
+		ix, err := ministore.Create(ctx, sqlite.New(dbPath), schema, opts)
+		if err != nil {
+			t.Fatalf("%s: Create: %v", tt.name, err)
+		}
+		for _, doc := range docs {
+			if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+				t.Fatalf("%s: PutJSON: %v", tt.name, err)
+			}
+		}
+		_ = ix.Close()
+
+		// Reopen without a location: the persisted one must be used
+		ix, err = ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+		if err != nil {
+			t.Fatalf("%s: Open: %v", tt.name, err)
+		}
+		res, err := ix.Search(ctx, "due:2025-01-02", ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: Search: %v", tt.name, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v want %s", tt.name, got, tt.want)
+		}
+
+		res, err = ix.Search(ctx, "due:[2025-01-02,2025-01-03)", ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: Search range: %v", tt.name, err)
+		}
+		if got := pathsFromItems(t, res.Items); len(got) != 2 {
+			t.Errorf("%s: range got %v want both items", tt.name, got)
+		}
+		_ = ix.Close()
+	}
+}
+
+func TestNestedFieldPaths_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"author.name":    {Type: ministore.FieldKeyword},
+			"reviewers.name": {Type: ministore.FieldKeyword, Multi: true},
+			"meta.stats.n":   {Type: ministore.FieldNumber},
+			"body":           {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/a","author":{"name":"ann"},"reviewers":[{"name":"bob"},{"name":"cy"}],"meta":{"stats":{"n":3}},"body":"hello"}`,
+		`{"path":"/b","author":{"name":"bob"},"reviewers":[{"name":"ann"}],"meta":{"stats":{"n":7}}}`,
+		`{"path":"/c","author":"flat"}`,
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"author.name:ann", "/a"},
+		{"reviewers.name:bob", "/a"},
+		{"reviewers.name:cy", "/a"},
+		{"meta.stats.n>5", "/b"},
+		{"has:author.name", "/a,/b"},
+		{"meta.stats.n:*", "/a,/b"},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v want %s", tt.query, got, tt.want)
+		}
+	}
+
+	// Several nested values under a non-multi field are rejected
+	err := ix.PutJSON(ctx, []byte(`{"path":"/d","author":[{"name":"x"},{"name":"y"}]}`))
+	if err == nil {
+		t.Fatal("expected error for multiple values in non-multi nested field")
+	}
+}
+
+func TestNestedFieldPathValidation(t *testing.T) {
+	bad := []map[string]ministore.FieldSpec{
+		{"author.": {Type: ministore.FieldKeyword}},
+		{"a..b": {Type: ministore.FieldKeyword}},
+		{"path.x": {Type: ministore.FieldKeyword}},
+		{"author.bio": {Type: ministore.FieldText}},
+		{"author": {Type: ministore.FieldKeyword}, "author.name": {Type: ministore.FieldKeyword}},
+	}
+	for _, fields := range bad {
+		if err := (ministore.Schema{Fields: fields}).Validate(); err == nil {
+			t.Errorf("expected validation error for %v", fields)
+		}
+	}
+	ok := ministore.Schema{Fields: map[string]ministore.FieldSpec{"a.b.c": {Type: ministore.FieldDate}}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestShowNestedFields_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"author.name":    {Type: ministore.FieldKeyword},
+			"reviewers.name": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	doc := `{"path":"/a","title":"t","author":{"name":"ann","email":"ann@x"},"reviewers":[{"name":"bob","n":1},{"name":"cy"},{"n":2}]}`
+	if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	tests := []struct {
+		fields []string
+		nested bool
+		want   string
+	}{
+		{[]string{"author.name", "missing.x"}, false, `{"author.name":"ann","path":"/a"}`},
+		{[]string{"reviewers.name"}, false, `{"path":"/a","reviewers.name":["bob","cy"]}`},
+		// author.email is not in the schema, so the full document is read
+		{[]string{"title", "author.email"}, false, `{"author.email":"ann@x","path":"/a","title":"t"}`},
+		{[]string{"author.name", "author.email", "missing.x"}, true, `{"author":{"email":"ann@x","name":"ann"},"path":"/a"}`},
+		{[]string{"reviewers.name"}, true, `{"path":"/a","reviewers":[{"name":"bob"},{"name":"cy"}]}`},
+		{[]string{"title", "author"}, true, `{"author":{"email":"ann@x","name":"ann"},"path":"/a","title":"t"}`},
+	}
+	for _, tt := range tests {
+		opts := ministore.SearchOptions{Limit: 10}
+		opts.Show = ministore.OutputFieldSelector{Kind: ministore.ShowFields, Fields: tt.fields, Nested: tt.nested}
+		res, err := ix.Search(ctx, "author.name:ann", opts)
+		if err != nil {
+			t.Fatalf("Search(show %v): %v", tt.fields, err)
+		}
+		if len(res.Items) != 1 {
+			t.Fatalf("Search(show %v) = %d items, want 1", tt.fields, len(res.Items))
+		}
+		if got := string(res.Items[0]); got != tt.want {
+			t.Errorf("show %v nested=%v = %s, want %s", tt.fields, tt.nested, got, tt.want)
+		}
+	}
+}
+
+func TestUpdate_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"status": {Type: ministore.FieldKeyword},
+			"n":      {Type: ministore.FieldNumber},
+			"title":  {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","status":"open","n":1,"title":"hello world"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	before, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if err := ix.Update(ctx, "/a", map[string]any{"status": "closed", "n": nil}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	after, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if after.Meta.CreatedAtMS != before.Meta.CreatedAtMS {
+		t.Errorf("created changed: %d -> %d", before.Meta.CreatedAtMS, after.Meta.CreatedAtMS)
+	}
+	if after.Meta.UpdatedAtMS <= before.Meta.UpdatedAtMS {
+		t.Errorf("updated not bumped: %d -> %d", before.Meta.UpdatedAtMS, after.Meta.UpdatedAtMS)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(after.DocJSON, &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if doc["status"] != "closed" || doc["title"] != "hello world" {
+		t.Errorf("unexpected doc: %v", doc)
+	}
+	if _, ok := doc["n"]; ok {
+		t.Errorf("expected n to be deleted: %v", doc)
+	}
+
+	for query, want := range map[string]int{
+		"status:closed": 1,
+		"status:open":   0,
+		"has:n":         0,
+		"title:hello":   1,
+	} {
+		res, err := ix.Search(ctx, query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if len(res.Items) != want {
+			t.Errorf("%s: got %d items want %d", query, len(res.Items), want)
+		}
+	}
+
+	err = ix.Update(ctx, "/missing", map[string]any{"status": "x"})
+	if !ministore.IsKind(err, ministore.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPutIfUnchanged_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"status": {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","status":"new"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	// Two writers read the same version
+	readA, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	readB, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	ok, err := ix.PutIfUnchanged(ctx, []byte(`{"path":"/a","status":"by-a"}`), readA.Meta.UpdatedAtMS)
+	if err != nil || !ok {
+		t.Fatalf("writer A: ok=%v err=%v", ok, err)
+	}
+	ok, err = ix.PutIfUnchanged(ctx, []byte(`{"path":"/a","status":"by-b"}`), readB.Meta.UpdatedAtMS)
+	if err != nil {
+		t.Fatalf("writer B: %v", err)
+	}
+	if ok {
+		t.Fatal("writer B should have lost the race")
+	}
+
+	res, err := ix.Search(ctx, "status:by-a", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 1 {
+		t.Errorf("expected writer A's document to be indexed, got %d items", len(res.Items))
+	}
+	res, err = ix.Search(ctx, "status:by-b", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 0 {
+		t.Errorf("writer B's document must not be indexed, got %d items", len(res.Items))
+	}
+
+	// Writer B retries with the fresh token
+	fresh, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	ok, err = ix.PutIfUnchanged(ctx, []byte(`{"path":"/a","status":"by-b"}`), fresh.Meta.UpdatedAtMS)
+	if err != nil || !ok {
+		t.Fatalf("writer B retry: ok=%v err=%v", ok, err)
+	}
+	after, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if after.Meta.UpdatedAtMS == fresh.Meta.UpdatedAtMS {
+		t.Error("updated time should change on a successful conditional put")
+	}
+}
+
+func TestPutIfUnchangedAfterDelete_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"status": {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/hard","status":"new"}`,
+		`{"path":"/soft","status":"new"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	hard, err := ix.Get(ctx, "/hard")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	soft, err := ix.Get(ctx, "/soft")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// Another writer deletes each item after it was read
+	if _, err := ix.Delete(ctx, "/hard"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := ix.SoftDelete(ctx, "/soft"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+
+	ok, err := ix.PutIfUnchanged(ctx, []byte(`{"path":"/hard","status":"stale"}`), hard.Meta.UpdatedAtMS)
+	if err != nil {
+		t.Fatalf("PutIfUnchanged(/hard): %v", err)
+	}
+	if ok {
+		t.Error("conditional put must not re-insert a deleted item")
+	}
+	if exists, err := ix.Exists(ctx, "/hard"); err != nil || exists {
+		t.Errorf("Exists(/hard) = %v, %v; want false", exists, err)
+	}
+
+	ok, err = ix.PutIfUnchanged(ctx, []byte(`{"path":"/soft","status":"stale"}`), soft.Meta.UpdatedAtMS)
+	if err != nil {
+		t.Fatalf("PutIfUnchanged(/soft): %v", err)
+	}
+	if ok {
+		t.Error("conditional put must not resurrect a soft-deleted item")
+	}
+	if exists, err := ix.Exists(ctx, "/soft"); err != nil || exists {
+		t.Errorf("Exists(/soft) = %v, %v; want false", exists, err)
+	}
+	res, err := ix.Search(ctx, "status:stale", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 0 {
+		t.Errorf("stale documents must not be indexed, got %d items", len(res.Items))
+	}
+	if err := ix.Restore(ctx, "/soft"); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	// A zero token still inserts a path that does not exist
+	ok, err = ix.PutIfUnchanged(ctx, []byte(`{"path":"/new","status":"new"}`), 0)
+	if err != nil || !ok {
+		t.Fatalf("PutIfUnchanged(/new, 0): ok=%v err=%v", ok, err)
+	}
+}
+
+func TestSoftDeleteRestore_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"status": {Type: ministore.FieldKeyword},
+			"title":  {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/docs/a","status":"open","title":"alpha"}`,
+		`{"path":"/docs/b","status":"open","title":"beta"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	search := func(q string, includeDeleted bool) string {
+		t.Helper()
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10, IncludeDeleted: includeDeleted})
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+
+	if err := ix.SoftDelete(ctx, "/docs/a"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	if err := ix.SoftDelete(ctx, "/docs/a"); err != nil {
+		t.Fatalf("SoftDelete twice: %v", err)
+	}
+
+	if got := search("status:open", false); got != "/docs/b" {
+		t.Errorf("status:open: got %s", got)
+	}
+	if got := search("alpha", false); got != "" {
+		t.Errorf("alpha: got %s", got)
+	}
+	if got := search("path:/docs/*", false); got != "/docs/b" {
+		t.Errorf("path glob: got %s", got)
+	}
+	if got := search("path:/docs/*", true); got != "/docs/a,/docs/b" {
+		t.Errorf("path glob with deleted: got %s", got)
+	}
+
+	// Reads treat the item as gone; IncludeDeleted above still finds it
+	if _, err := ix.Get(ctx, "/docs/a"); !ministore.IsKind(err, ministore.ErrNotFound) {
+		t.Errorf("Get soft-deleted: expected ErrNotFound, got %v", err)
+	}
+	if ok, err := ix.Exists(ctx, "/docs/a"); err != nil || ok {
+		t.Errorf("Exists soft-deleted = %v, %v", ok, err)
+	}
+	if got, err := ix.GetMany(ctx, []string{"/docs/a", "/docs/b"}); err != nil || len(got) != 1 {
+		t.Errorf("GetMany with soft-deleted = %d items, %v", len(got), err)
+	}
+
+	values, err := ix.DiscoverValues(ctx, "status", "", 10)
+	if err != nil {
+		t.Fatalf("DiscoverValues: %v", err)
+	}
+	if len(values) != 1 || values[0].Count != 1 {
+		t.Errorf("doc_freq not maintained: %+v", values)
+	}
+
+	if err := ix.Restore(ctx, "/docs/a"); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got := search("status:open", false); got != "/docs/a,/docs/b" {
+		t.Errorf("after restore: got %s", got)
+	}
+	if got := search("alpha", false); got != "/docs/a" {
+		t.Errorf("alpha after restore: got %s", got)
+	}
+
+	// A put over a tombstone brings the item back
+	if err := ix.SoftDelete(ctx, "/docs/b"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/docs/b","status":"closed"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if got := search("status:closed", false); got != "/docs/b" {
+		t.Errorf("put over tombstone: got %s", got)
+	}
+
+	if err := ix.SoftDelete(ctx, "/missing"); !ministore.IsKind(err, ministore.ErrNotFound) {
+		t.Errorf("SoftDelete missing: expected ErrNotFound, got %v", err)
+	}
+	if err := ix.Restore(ctx, "/missing"); !ministore.IsKind(err, ministore.ErrNotFound) {
+		t.Errorf("Restore missing: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSoftDeletedSkippedByWhereOps_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"status": {Type: ministore.FieldKeyword}}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/docs/a","status":"open"}`,
+		`{"path":"/docs/b","status":"open"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if err := ix.SoftDelete(ctx, "/docs/a"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+
+	// Items-column aggregates see live items only
+	for _, where := range []string{"", "path:/docs/*"} {
+		st, err := ix.Stats(ctx, "created", where, 50)
+		if err != nil || st.Count != 1 {
+			t.Errorf("Stats(created, %q) count = %d, %v; want 1", where, st.Count, err)
+		}
+		buckets, err := ix.DateHistogram(ctx, "updated", where, ministore.IntervalDay)
+		if err != nil {
+			t.Fatalf("DateHistogram(updated, %q): %v", where, err)
+		}
+		var total uint64
+		for _, b := range buckets {
+			total += b.Count
+		}
+		if total != 1 {
+			t.Errorf("DateHistogram(updated, %q) total = %d, want 1", where, total)
+		}
+	}
+
+	// DeleteWhere leaves the tombstone for Restore
+	n, err := ix.DeleteWhere(ctx, "path:/docs/*")
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteWhere = %d, %v; want 1", n, err)
+	}
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/docs/c","status":"open"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if n, err := ix.DeleteWhereBatched(ctx, "path:/docs/*", 1); err != nil || n != 1 {
+		t.Fatalf("DeleteWhereBatched = %d, %v; want 1", n, err)
+	}
+	if err := ix.Restore(ctx, "/docs/a"); err != nil {
+		t.Fatalf("Restore after DeleteWhere: %v", err)
+	}
+	if ok, err := ix.Exists(ctx, "/docs/a"); err != nil || !ok {
+		t.Errorf("Exists after Restore = %v, %v", ok, err)
+	}
+}
+
+func TestRestoreUpsertKeyConflict_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"sku": {Type: ministore.FieldKeyword}}}
+	opts := ministore.DefaultIndexOptions()
+	opts.UpsertKey = "sku"
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/old","sku":"A1"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if err := ix.SoftDelete(ctx, "/old"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	// The deleted item no longer holds A1, so this is a new item
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/new","sku":"A1"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	err = ix.Restore(ctx, "/old")
+	if !ministore.IsKind(err, ministore.ErrSchema) || !strings.Contains(err.Error(), "claimed by /new") {
+		t.Fatalf("Restore = %v, want upsert key conflict", err)
+	}
+	res, err := ix.Search(ctx, "sku:A1", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, []string{"/new"}) {
+		t.Errorf("sku:A1 = %v, want [/new]", got)
+	}
+
+	if _, err := ix.Delete(ctx, "/new"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := ix.Restore(ctx, "/old"); err != nil {
+		t.Fatalf("Restore after the key was freed: %v", err)
+	}
+}
+
+func TestSoftDeleteUpgradesOldIndex_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"status": {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","status":"open"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	// Simulate an index created before items.deleted_at existed
+	if _, err := ix.DB().ExecContext(ctx, "ALTER TABLE items DROP COLUMN deleted_at"); err != nil {
+		t.Fatalf("drop column: %v", err)
+	}
+	_ = ix.Close()
+
+	ix, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer ix.Close()
+
+	if err := ix.SoftDelete(ctx, "/a"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	res, err := ix.Search(ctx, "path:/a", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 0 {
+		t.Errorf("expected soft-deleted item to be hidden, got %d items", len(res.Items))
+	}
+}
+
+func TestSearchPathExact_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	for _, doc := range []string{
+		`{"path":"/docs/a","tags":"x"}`,
+		`{"path":"/docs/ab","tags":"x"}`,
+		`{"path":"/docs/a[1]","tags":"y"}`,
+		`{"path":"/docs/A","tags":"y"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	for q, want := range map[string]string{
+		"path:/docs/a":                  "/docs/a",
+		`path:"/docs/a[1]"`:             "/docs/a[1]", // GLOB would read [1] as a character class
+		"path:/docs/a OR tags:y":        "/docs/A,/docs/a,/docs/a[1]",
+		"path:/docs/a? OR path:/docs/A": "/docs/A,/docs/ab",
+	} {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10, Explain: true})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("Search(%s) = %v, want %s", q, got, want)
+		}
+		if q == "path:/docs/a" && !strings.Contains(res.ExplainSQL, "WHERE path = ") {
+			t.Errorf("exact path did not compile to equality: %s", res.ExplainSQL)
+		}
+	}
+}
+
+func TestUpsertKey_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"external_id": {Type: ministore.FieldKeyword},
+		"tags":        {Type: ministore.FieldKeyword, Multi: true},
+	}}
+	opts := ministore.DefaultIndexOptions()
+	opts.Now = monotonicNow(time.Unix(1700000000, 0))
+
+	opts.UpsertKey = "tags"
+	if _, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "multi.db")), schema, opts); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Fatalf("Create with a multi-valued upsert key: err = %v, want ErrSchema", err)
+	}
+
+	opts.UpsertKey = "external_id"
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer ix.Close()
+
+	put := func(doc string) error { return ix.PutJSON(ctx, []byte(doc)) }
+	if err := put(`{"path":"/a","external_id":"e1","tags":"old"}`); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	before, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// Same key at a new path moves the item
+	if err := put(`{"path":"/b","external_id":"e1","tags":"new"}`); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if ok, _ := ix.Exists(ctx, "/a"); ok {
+		t.Errorf("/a still exists after its key moved to /b")
+	}
+	after, err := ix.Get(ctx, "/b")
+	if err != nil {
+		t.Fatalf("Get(/b): %v", err)
+	}
+	if after.Meta.CreatedAtMS != before.Meta.CreatedAtMS {
+		t.Errorf("created = %d, want %d preserved", after.Meta.CreatedAtMS, before.Meta.CreatedAtMS)
+	}
+	res, err := ix.Search(ctx, "tags:new OR tags:old", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); strings.Join(got, ",") != "/b" {
+		t.Errorf("Search = %v, want [/b]", got)
+	}
+
+	// A path held by an item with another key cannot take this one
+	if err := put(`{"path":"/c","external_id":"e2"}`); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if err := put(`{"path":"/c","external_id":"e1"}`); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("put onto another item's path: err = %v, want ErrSchema", err)
+	}
+	if err := put(`{"path":"/d","tags":"x"}`); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("put without the upsert key: err = %v, want ErrSchema", err)
+	}
+
+	// Within one batch the later document wins
+	b := ministore.NewBatch()
+	for _, doc := range []string{`{"path":"/x","external_id":"e3"}`, `{"path":"/y","external_id":"e3"}`} {
+		if err := b.PutJSON([]byte(doc)); err != nil {
+			t.Fatalf("Batch.PutJSON: %v", err)
+		}
+	}
+	if _, err := ix.Batch(ctx, b); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	res, err = ix.Search(ctx, "external_id:e3", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); strings.Join(got, ",") != "/y" {
+		t.Errorf("batch with a repeated key = %v, want [/y]", got)
+	}
+}
+
+func TestExists_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	for _, p := range []string{"/a", "/b", "/c"} {
+		if err := ix.PutJSON(ctx, []byte(`{"path":"`+p+`","tags":"x"}`)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if _, err := ix.Delete(ctx, "/b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := ix.SoftDelete(ctx, "/c"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+
+	// Exists agrees with Get, which still finds soft-deleted items
+	for _, p := range []string{"/a", "/b", "/c", "/missing"} {
+		got, err := ix.Exists(ctx, p)
+		if err != nil {
+			t.Fatalf("Exists(%s): %v", p, err)
+		}
+		_, getErr := ix.Get(ctx, p)
+		if want := getErr == nil; got != want {
+			t.Errorf("Exists(%s) = %v, Get error = %v", p, got, getErr)
+		}
+	}
+	if ok, _ := ix.Exists(ctx, "/a"); !ok {
+		t.Errorf("Exists(/a) = false")
+	}
+}
+
+func TestSearchMatchAll_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	for _, p := range []string{"/a", "/b", "/c", "/d"} {
+		if err := ix.PutJSON(ctx, []byte(`{"path":"`+p+`","tags":"x"}`)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if _, err := ix.Delete(ctx, "/b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	for _, q := range []string{"*", "", "  * "} {
+		opts := ministore.SearchOptions{Limit: 2, Rank: ministore.RankMode{Kind: ministore.RankRecency}}
+		var got []string
+		for {
+			res, err := ix.Search(ctx, q, opts)
+			if err != nil {
+				t.Fatalf("Search(%q): %v", q, err)
+			}
+			got = append(got, pathsFromItems(t, res.Items)...)
+			if !res.HasMore {
+				break
+			}
+			opts.After = res.NextCursor
+		}
+		if strings.Join(got, ",") != "/d,/c,/a" {
+			t.Errorf("Search(%q) = %v, want /d,/c,/a", q, got)
+		}
+	}
+
+	res, err := ix.Search(ctx, "*", ministore.SearchOptions{Limit: 10, Rank: ministore.RankMode{Kind: ministore.RankNone}})
+	if err != nil || len(res.Items) != 3 {
+		t.Errorf("Search(*) with RankNone = %d items, %v", len(res.Items), err)
+	}
+
+	// Only the whole query is the sentinel
+	for _, q := range []string{"* AND tags:x", "tags:x OR *", "NOT *"} {
+		if _, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10}); err == nil {
+			t.Errorf("Search(%q) was accepted", q)
+		}
+	}
+	if _, err := ix.DeleteWhere(ctx, "*"); err == nil {
+		t.Errorf("DeleteWhere(*) was accepted")
+	}
+}
+
+func TestSearchNotEqual_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"priority": {Type: ministore.FieldNumber, Multi: true},
+		"due":      {Type: ministore.FieldDate},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	for _, doc := range []string{
+		`{"path":"/five","priority":5,"due":"2024-01-01"}`,
+		`{"path":"/six","priority":6,"due":"2024-01-02"}`,
+		`{"path":"/both","priority":[5,6]}`,
+		`{"path":"/none"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	search := func(q string) string {
+		t.Helper()
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10, Explain: true})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+
+	// Any value other than 5 matches, so /both does; items without the
+	// field do not
+	if got := search("priority!=5"); got != "/both,/six" {
+		t.Errorf("priority!=5 = %s, want /both,/six", got)
+	}
+	// NOT excludes every item holding 5, including those without the field
+	if got := search("path:/* AND NOT priority:5"); got != "/none,/six" {
+		t.Errorf("NOT priority:5 = %s, want /none,/six", got)
+	}
+	if got := search("due!=2024-01-01"); got != "/six" {
+		t.Errorf("due!=2024-01-01 = %s, want /six", got)
+	}
+}
+
+func TestKeywordStats_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","tags":["common","rare"]}`,
+		`{"path":"/b","tags":["common"]}`,
+		`{"path":"/c","tags":["common","gone"]}`,
+		`{"path":"/d"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	// "gone" keeps its kw_dict row with doc_freq 0
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/c","tags":["common"]}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	stats, err := ix.KeywordStats(ctx, "tags")
+	if err != nil {
+		t.Fatalf("KeywordStats: %v", err)
+	}
+	want := []ministore.KeywordStat{
+		{Value: "common", DocFreq: 3, TotalItems: 4},
+		{Value: "rare", DocFreq: 1, TotalItems: 4},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %+v want %+v", stats, want)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("stat %d: got %+v want %+v", i, stats[i], want[i])
+		}
+	}
+	if idf := stats[1].IDF(); idf < 1.38 || idf > 1.39 { // ln(4)
+		t.Errorf("rare IDF = %f", idf)
+	}
+	if stats[0].IDF() >= stats[1].IDF() {
+		t.Error("common value should have lower IDF than rare value")
+	}
+
+	if _, err := ix.KeywordStats(ctx, "missing"); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestReindex_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+			"n":     {Type: ministore.FieldNumber},
+			"title": {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		doc := fmt.Sprintf(`{"path":"/d%d","tags":["all","t%d"],"n":%d,"title":"doc number %d"}`, i, i, i, i)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if err := ix.SoftDelete(ctx, "/d4"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+
+	// Simulate drift: a manual edit to data_json and lost index rows
+	db := ix.DB()
+	if _, err := db.ExecContext(ctx, `UPDATE items SET data_json = '{"path":"/d0","tags":["all","edited"],"n":100,"title":"edited by hand"}' WHERE path = '/d0'`); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM field_number"); err != nil {
+		t.Fatalf("drift: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE kw_dict SET doc_freq = 42"); err != nil {
+		t.Fatalf("drift: %v", err)
+	}
+
+	var calls, lastDone, lastTotal int
+	err := ix.Reindex(ctx, func(done, total int) {
+		calls++
+		lastDone, lastTotal = done, total
+	})
+	if err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	if calls == 0 || lastDone != 4 || lastTotal != 4 {
+		t.Errorf("progress: calls=%d done=%d total=%d", calls, lastDone, lastTotal)
+	}
+
+	for query, want := range map[string]string{
+		"tags:edited": "/d0",
+		"tags:t0":     "",
+		"n>=100":      "/d0",
+		"n:2":         "/d2",
+		"hand":        "/d0",
+		"tags:t4":     "",
+	} {
+		res, err := ix.Search(ctx, query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if got := strings.Join(pathsFromItems(t, res.Items), ","); got != want {
+			t.Errorf("%s: got %q want %q", query, got, want)
+		}
+	}
+
+	stats, err := ix.KeywordStats(ctx, "tags")
+	if err != nil {
+		t.Fatalf("KeywordStats: %v", err)
+	}
+	freq := map[string]uint64{}
+	for _, s := range stats {
+		freq[s.Value] = s.DocFreq
+	}
+	if freq["all"] != 4 || freq["edited"] != 1 || freq["t0"] != 0 || freq["t4"] != 0 {
+		t.Errorf("doc_freq not rebuilt: %v", freq)
+	}
+
+	// Soft-deleted items can still be restored afterwards
+	if err := ix.Restore(ctx, "/d4"); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	res, err := ix.Search(ctx, "tags:t4", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 1 {
+		t.Errorf("restored item not found after reindex")
+	}
+}
+
+func TestReindexWhere_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags": {Type: ministore.FieldKeyword, Multi: true},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		doc := fmt.Sprintf(`{"path":"/d%d","tags":["all","t%d"]}`, i, i)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	// Edit two documents by hand; only the one matching the where is fixed
+	db := ix.DB()
+	for _, path := range []string{"/d0", "/d1"} {
+		if _, err := db.ExecContext(ctx, `UPDATE items SET data_json = ? WHERE path = ?`,
+			fmt.Sprintf(`{"path":%q,"tags":["all","edited"]}`, path), path); err != nil {
+			t.Fatalf("edit: %v", err)
+		}
+	}
+
+	var lastDone, lastTotal int
+	err := ix.ReindexWhere(ctx, "tags:t0", func(done, total int) { lastDone, lastTotal = done, total })
+	if err != nil {
+		t.Fatalf("ReindexWhere: %v", err)
+	}
+	if lastDone != 1 || lastTotal != 1 {
+		t.Errorf("progress: done=%d total=%d, want 1/1", lastDone, lastTotal)
+	}
+
+	for query, want := range map[string]string{
+		"tags:edited": "/d0",
+		"tags:t0":     "",
+		"tags:t1":     "/d1",
+		"tags:all":    "/d0,/d1,/d2",
+	} {
+		res, err := ix.Search(ctx, query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s: got %v want %q", query, got, want)
+		}
+	}
+
+	stats, err := ix.KeywordStats(ctx, "tags")
+	if err != nil {
+		t.Fatalf("KeywordStats: %v", err)
+	}
+	freq := map[string]uint64{}
+	for _, s := range stats {
+		freq[s.Value] = s.DocFreq
+	}
+	if freq["all"] != 3 || freq["edited"] != 1 || freq["t0"] != 0 || freq["t1"] != 1 {
+		t.Errorf("doc_freq after scoped reindex: %v", freq)
+	}
+}
+
+func TestRankMultipleSortKeys_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"kind":     {Type: ministore.FieldKeyword},
+			"priority": {Type: ministore.FieldNumber},
+			"due":      {Type: ministore.FieldDate},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","kind":"task","priority":1,"due":"2025-01-05"}`,
+		`{"path":"/b","kind":"task","priority":3,"due":"2025-01-09"}`,
+		`{"path":"/c","kind":"task","priority":3,"due":"2025-01-02"}`,
+		`{"path":"/d","kind":"task","priority":2,"due":"2025-01-01"}`,
+		`{"path":"/e","kind":"task","priority":3,"due":"2025-01-02"}`,
+		`{"path":"/f","kind":"task","priority":5}`,
+		`{"path":"/g","kind":"note","priority":9,"due":"2025-01-01"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	rank := ministore.RankMode{
+		Kind: ministore.RankField,
+		Keys: []ministore.SortKey{
+			{Field: "priority", Desc: true},
+			{Field: "due", Desc: false},
+		},
+	}
+	// /c and /e tie on both keys; the later put (/e) wins on updated DESC.
+	// /f has no due date and is left out.
+	want := []string{"/e", "/c", "/b", "/d", "/a"}
+
+	res, err := ix.Search(ctx, "kind:task", ministore.SearchOptions{Rank: rank, Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := strings.Join(pathsFromItems(t, res.Items), ","); got != strings.Join(want, ",") {
+		t.Fatalf("got %s want %s", got, strings.Join(want, ","))
+	}
+
+	for _, mode := range []ministore.CursorMode{ministore.CursorFull, ministore.CursorShort} {
+		opts := ministore.SearchOptions{Rank: rank, Limit: 2, CursorMode: mode}
+		var got []string
+		for page := 0; page < 10; page++ {
+			res, err := ix.Search(ctx, "kind:task", opts)
+			if err != nil {
+				t.Fatalf("%s page %d: %v", mode, page, err)
+			}
+			got = append(got, pathsFromItems(t, res.Items)...)
+			if !res.HasMore {
+				break
+			}
+			opts.After = res.NextCursor
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s paginated: got %v want %v", mode, got, want)
+		}
+	}
+
+	// Field alone still means a single descending key
+	opts := ministore.SearchOptions{
+		Rank:  ministore.RankMode{Kind: ministore.RankField, Field: "priority"},
+		Limit: 3,
+	}
+	var got []string
+	for {
+		res, err := ix.Search(ctx, "kind:task", opts)
+		if err != nil {
+			t.Fatalf("single key: %v", err)
+		}
+		got = append(got, pathsFromItems(t, res.Items)...)
+		if !res.HasMore {
+			break
+		}
+		opts.After = res.NextCursor
+	}
+	if strings.Join(got, ",") != "/f,/e,/c,/b,/d,/a" {
+		t.Errorf("single key: got %v", got)
+	}
+}
+
+func TestRankAscending_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"kind":     {Type: ministore.FieldKeyword},
+			"priority": {Type: ministore.FieldNumber},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	// Put order fixes updated: /a oldest, /e newest. /b and /d tie on priority.
+	for _, doc := range []string{
+		`{"path":"/a","kind":"t","priority":3}`,
+		`{"path":"/b","kind":"t","priority":1}`,
+		`{"path":"/c","kind":"t","priority":2}`,
+		`{"path":"/d","kind":"t","priority":1}`,
+		`{"path":"/e","kind":"t","priority":5}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	paginate := func(rank ministore.RankMode, limit int) string {
+		t.Helper()
+		opts := ministore.SearchOptions{Rank: rank, Limit: limit, CursorMode: ministore.CursorFull}
+		var got []string
+		for page := 0; page < 10; page++ {
+			res, err := ix.Search(ctx, "kind:t", opts)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			got = append(got, pathsFromItems(t, res.Items)...)
+			if !res.HasMore {
+				break
+			}
+			opts.After = res.NextCursor
+		}
+		return strings.Join(got, ",")
+	}
+
+	tests := []struct {
+		name string
+		rank ministore.RankMode
+		want string
+	}{
+		{"recency desc", ministore.RankMode{Kind: ministore.RankRecency}, "/e,/d,/c,/b,/a"},
+		{"recency asc", ministore.RankMode{Kind: ministore.RankRecency, Ascending: true}, "/a,/b,/c,/d,/e"},
+		{"field desc", ministore.RankMode{Kind: ministore.RankField, Field: "priority"}, "/e,/a,/c,/d,/b"},
+		// Ties on priority flip too: older /b before /d
+		{"field asc", ministore.RankMode{Kind: ministore.RankField, Field: "priority", Ascending: true}, "/b,/d,/c,/a,/e"},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{10, 1, 2} {
+			if got := paginate(tt.rank, limit); got != tt.want {
+				t.Errorf("%s (limit %d): got %s want %s", tt.name, limit, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFTSTokenizer_SQLite(t *testing.T) {
+	ctx := context.Background()
+	fields := map[string]ministore.FieldSpec{
+		"body": {Type: ministore.FieldText},
+	}
+	doc := `{"path":"/a","body":"she was running home"}`
+
+	plain, _ := newIndex(t, ministore.Schema{Fields: fields})
+	stemmed, dbPath := newIndex(t, ministore.Schema{Fields: fields, FTSTokenizer: "porter  unicode61 remove_diacritics 2"})
+	for _, ix := range []*ministore.Index{plain, stemmed} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := plain.Search(ctx, "run", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search plain: %v", err)
+	}
+	if len(res.Items) != 0 {
+		t.Errorf("unicode61 should not stem: got %v", pathsFromItems(t, res.Items))
+	}
+	res, err = stemmed.Search(ctx, "run", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search stemmed: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); strings.Join(got, ",") != "/a" {
+		t.Errorf("porter should stem running: got %v", got)
+	}
+
+	// Changing the tokenizer in place is refused
+	_, err = stemmed.ApplySchema(ctx, ministore.Schema{Fields: fields}, ministore.ApplySchemaOptions{})
+	if !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("ApplySchema tokenizer change: expected schema error, got %v", err)
+	}
+
+	// The tokenizer survives reopen
+	_ = stemmed.Close()
+	reopened, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reopened.Close()
+	if tok := reopened.Schema().FTSTokenizer; tok != "porter  unicode61 remove_diacritics 2" {
+		t.Errorf("reopened tokenizer = %q", tok)
+	}
+
+	for _, bad := range []string{
+		"unicode61') ; DROP TABLE items; --",
+		"icu",
+		"unicode61 remove_diacritics 3",
+		"unicode61 tokenchars",
+		"trigram case_sensitive 1 case_sensitive 0",
+	} {
+		s := ministore.Schema{Fields: fields, FTSTokenizer: bad}
+		if err := s.Validate(); !ministore.IsKind(err, ministore.ErrSchema) {
+			t.Errorf("Validate(%q): expected schema error, got %v", bad, err)
+		}
+	}
+}
+
+func TestSchemaAliases_SQLite(t *testing.T) {
+	fields := map[string]ministore.FieldSpec{
+		"priority_level": {Type: ministore.FieldNumber},
+		"tag_list":       {Type: ministore.FieldKeyword, Multi: true},
+		"body":           {Type: ministore.FieldText},
+	}
+	schema := ministore.Schema{Fields: fields, Aliases: map[string]string{
+		"priority": "priority_level",
+		"tags":     "tag_list",
+		"content":  "body",
+	}}
+	ix, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+	for _, doc := range []string{
+		`{"path":"/a","priority_level":5,"tag_list":["go","rust"],"body":"hello world"}`,
+		`{"path":"/b","priority_level":1,"tag_list":["go"],"body":"goodbye world"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	_ = ix.Close()
+
+	// Aliases survive a reopen
+	ix, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer ix.Close()
+
+	for q, want := range map[string]string{
+		"priority>3":                     "/a",
+		"priority_level>3":               "/a",
+		"tags:all(go,rust)":              "/a",
+		"content:goodbye":                "/b",
+		"tags:go AND NOT priority:5":     "/b",
+		"has:priority AND priority:1..9": "/a,/b",
+	} {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%s): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != want {
+			t.Errorf("Search(%s) = %v, want %s", q, got, want)
+		}
+	}
+
+	for _, aliases := range []map[string]string{
+		{"prio": "missing"},
+		{"body": "priority_level"},
+		{"path": "tag_list"},
+		{"bad name": "body"},
+	} {
+		s := ministore.Schema{Fields: fields, Aliases: aliases}
+		if err := s.Validate(); !ministore.IsKind(err, ministore.ErrSchema) {
+			t.Errorf("Validate(aliases %v): expected schema error, got %v", aliases, err)
+		}
+	}
+}
+
+func TestNormalizeGuardrailOptions_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword, Multi: true}}}
+	open := func(minPrefix, maxExpansion int) *ministore.Index {
+		t.Helper()
+		opts := ministore.DefaultIndexOptions()
+		opts.MinPrefixLen = minPrefix
+		opts.MaxPrefixExpansion = maxExpansion
+		ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		t.Cleanup(func() { _ = ix.Close() })
+		for _, doc := range []string{
+			`{"path":"/1","tags":["go1","rust"]}`,
+			`{"path":"/2","tags":["go2"]}`,
+			`{"path":"/3","tags":["go3"]}`,
+		} {
+			if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+				t.Fatalf("PutJSON: %v", err)
+			}
+		}
+		return ix
+	}
+	count := func(ix *ministore.Index, q string) (int, error) {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		return len(res.Items), err
+	}
+
+	strict := open(4, 0)
+	if _, err := count(strict, "tags:rus*"); !ministore.IsKind(err, ministore.ErrQueryRejected) {
+		t.Errorf("MinPrefixLen 4: tags:rus* err = %v, want ErrQueryRejected", err)
+	}
+	if _, err := count(strict, "tags:(rust"); !ministore.IsKind(err, ministore.ErrQueryParse) {
+		t.Errorf("unbalanced parenthesis: err = %v, want ErrQueryParse", err)
+	}
+	if n, err := count(strict, "tags:rust*"); err != nil || n != 1 {
+		t.Errorf("MinPrefixLen 4: tags:rust* = %d, %v", n, err)
+	}
+
+	loose := open(1, 0)
+	if n, err := count(loose, "tags:r*"); err != nil || n != 1 {
+		t.Errorf("MinPrefixLen 1: tags:r* = %d, %v", n, err)
+	}
+
+	capped := open(0, 2)
+	if _, err := count(capped, "tags:go*"); !ministore.IsKind(err, ministore.ErrQueryRejected) || !strings.Contains(err.Error(), "matches more than 2 values") {
+		t.Errorf("MaxPrefixExpansion 2: tags:go* err = %v, want ErrQueryRejected citing the limit", err)
+	}
+	if n, err := count(capped, "tags:go1* OR tags:go2*"); err != nil || n != 2 {
+		t.Errorf("MaxPrefixExpansion 2: narrow prefixes = %d, %v", n, err)
+	}
+
+	// Explain shows how far each wildcard expanded; globs are counted
+	// but never rejected
+	res, err := capped.Search(ctx, "tags:go1* OR tags:go?", ministore.SearchOptions{Limit: 10, Explain: true})
+	if err != nil {
+		t.Fatalf("explain search: %v", err)
+	}
+	for _, want := range []string{"KEYWORD PREFIX tags:go1* expands to 1 values", "KEYWORD GLOB tags:go? expands to more than 2 values"} {
+		found := false
+		for _, step := range res.ExplainSteps {
+			found = found || step == want
+		}
+		if !found {
+			t.Errorf("explain steps %q lack %q", res.ExplainSteps, want)
+		}
+	}
+	if _, err := capped.DeleteWhere(ctx, "tags:go*"); !ministore.IsKind(err, ministore.ErrQueryRejected) {
+		t.Errorf("DeleteWhere over the expansion limit: err = %v, want ErrQueryRejected", err)
+	}
+	if n, _ := count(capped, "tags:rust OR tags:go1"); n != 1 {
+		t.Errorf("rejected DeleteWhere deleted items")
+	}
+}
+
+func TestTextPrefixSearch_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"body":  {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/a","title":"hello world","body":"nothing"}`,
+		`{"path":"/b","title":"help wanted","body":"nothing"}`,
+		`{"path":"/c","title":"shell script","body":"helium"}`,
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"title:hel*", "/a,/b"},
+		{"hel*", "/a,/b,/c"},
+		{"fields(body):hel*", "/c"},
+		{"title:hel", ""},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v want %s", tt.query, got, tt.want)
+		}
+	}
+
+	for _, q := range []string{"title:h*", "h*"} {
+		if _, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10}); err == nil {
+			t.Errorf("%s: expected short prefix to be rejected", q)
+		}
+	}
+}
+
+func TestTextTermGroup_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"body":  {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/a","title":"learning rust","body":"x"}`,
+		`{"path":"/b","title":"golang tips","body":"x"}`,
+		`{"path":"/c","title":"python","body":"rust and golang"}`,
+		`{"path":"/d","title":"error handling in go","body":"x"}`,
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"title:(rust OR golang)", "/a,/b"},
+		{`title:(python | "error handling")`, "/c,/d"},
+		{"title:(rust OR golang) !body:golang", "/a,/b"},
+		{"body:(rust) & title:python", "/c"},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: got %v want %s", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := ix.Search(ctx, "tags:(a OR b)", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Error("expected term group on keyword field to be rejected")
+	}
+}
+
+func TestReadOnlyOpen_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, dbPath := newIndex(t, schema)
+	for _, doc := range []string{
+		`{"path":"/a","title":"hello","tags":["x"]}`,
+		`{"path":"/b","title":"hello again","tags":["x"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	_ = ix.Close()
+
+	opts := ministore.DefaultIndexOptions()
+	opts.ReadOnly = true
+	if _, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "ro.db")), schema, opts); !ministore.IsKind(err, ministore.ErrReadOnly) {
+		t.Errorf("Create read-only: expected ErrReadOnly, got %v", err)
+	}
+
+	ro, err := ministore.Open(ctx, sqlite.New(dbPath), opts)
+	if err != nil {
+		t.Fatalf("Open read-only: %v", err)
+	}
+	defer ro.Close()
+
+	// Reads work, and short cursors degrade to full tokens
+	page, err := ro.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 1, CursorMode: ministore.CursorShort})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if !page.HasMore || page.NextCursor == "" || strings.HasPrefix(page.NextCursor, "c:") {
+		t.Fatalf("expected a full cursor, got %q (has more %v)", page.NextCursor, page.HasMore)
+	}
+	page, err = ro.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 1, After: page.NextCursor})
+	if err != nil {
+		t.Fatalf("Search page 2: %v", err)
+	}
+	if len(page.Items) != 1 {
+		t.Errorf("page 2: got %d items", len(page.Items))
+	}
+
+	writes := map[string]func() error{
+		"PutJSON":     func() error { return ro.PutJSON(ctx, []byte(`{"path":"/c","title":"x"}`)) },
+		"Delete":      func() error { _, err := ro.Delete(ctx, "/a"); return err },
+		"Optimize":    func() error { return ro.Optimize(ctx, ministore.OptimizeOptions{}) },
+		"ApplySchema": func() error { _, err := ro.ApplySchema(ctx, schema, ministore.ApplySchemaOptions{}); return err },
+		"SaveQuery":   func() error { return ro.SaveQuery(ctx, "q", "tags:x", ministore.SearchOptions{}) },
+	}
+	for name, write := range writes {
+		if err := write(); !ministore.IsKind(err, ministore.ErrReadOnly) {
+			t.Errorf("%s: expected ErrReadOnly, got %v", name, err)
+		}
+	}
+
+	// The connection itself refuses writes too
+	if _, err := ro.DB().ExecContext(ctx, "DELETE FROM items"); err == nil {
+		t.Error("expected raw write on read-only connection to fail")
+	}
+}
+
+func TestConcurrentWrites_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+			"n":     {Type: ministore.FieldNumber},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	const writers, perWriter = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				doc := fmt.Sprintf(`{"path":"/%d/%d","title":"doc","tags":["t%d"],"n":%d}`, w, i, w, i)
+				if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent PutJSON: %v", err)
+	}
+
+	// Nested reads must not need a second pooled connection
+	fields, err := ix.DiscoverFields(ctx)
+	if err != nil {
+		t.Fatalf("DiscoverFields: %v", err)
+	}
+	if len(fields) != 3 {
+		t.Errorf("DiscoverFields: got %d fields", len(fields))
+	}
+	res, err := ix.Search(ctx, "doc", ministore.SearchOptions{Limit: writers * perWriter})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != writers*perWriter {
+		t.Errorf("got %d items, want %d", len(res.Items), writers*perWriter)
+	}
+}
+
+func TestMultiValueNumberDateDistinct_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"n":    {Type: ministore.FieldNumber, Multi: true},
+			"when": {Type: ministore.FieldDate, Multi: true},
+			"tags": {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/a","n":[1,5,7,50],"when":["2024-01-02","2024-01-03","2025-06-01"],"tags":["x"]}`,
+		`{"path":"/b","n":[100],"when":["2023-01-01"],"tags":["x"]}`,
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	for _, q := range []string{
+		"n:1..10",
+		"n>0",
+		"when:2024-01-01..2024-12-31",
+		"when>2024-01-01",
+		"n:1..10 OR tags:x",
+		"tags:x !n:2..3",
+	} {
+		for _, rank := range []ministore.RankMode{{Kind: ministore.RankDefault}, {Kind: ministore.RankField, Field: "n"}} {
+			res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10, Rank: rank})
+			if err != nil {
+				t.Fatalf("%s: %v", q, err)
+			}
+			got := pathsFromItems(t, res.Items)
+			seen := map[string]bool{}
+			for _, p := range got {
+				if seen[p] {
+					t.Errorf("%s (rank %v): %s returned more than once: %v", q, rank.Kind, p, got)
+				}
+				seen[p] = true
+			}
+			if !seen["/a"] {
+				t.Errorf("%s (rank %v): /a missing: %v", q, rank.Kind, got)
+			}
+		}
+	}
+}
+
+func TestDeleteMany_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	// Enough items that the path list spans more than one IN chunk
+	const n = ministore.GetManyChunkSize + 10
+	b := ministore.NewBatch()
+	for i := 0; i < n; i++ {
+		tag := "keep"
+		if i%2 == 0 {
+			tag = "drop"
+		}
+		if err := b.PutJSON([]byte(fmt.Sprintf(`{"path":"/%d","title":"doc","tags":[%q]}`, i, tag))); err != nil {
+			t.Fatalf("batch PutJSON: %v", err)
+		}
+	}
+	if _, err := ix.Batch(ctx, b); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	var paths []string
+	for i := 0; i < n; i += 2 {
+		paths = append(paths, fmt.Sprintf("/%d", i))
+	}
+	// Missing and repeated paths are not counted
+	paths = append(paths, "/missing", "/0")
+
+	deleted, err := ix.DeleteMany(ctx, paths)
+	if err != nil {
+		t.Fatalf("DeleteMany: %v", err)
+	}
+	if deleted != (n+1)/2 {
+		t.Errorf("deleted = %d, want %d", deleted, (n+1)/2)
+	}
+
+	got, err := ix.GetMany(ctx, []string{"/0", "/1", fmt.Sprintf("/%d", n-1)})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if _, ok := got["/0"]; ok || len(got) != 2 {
+		t.Errorf("unexpected remaining items: %v", got)
+	}
+
+	stats, err := ix.KeywordStats(ctx, "tags")
+	if err != nil {
+		t.Fatalf("KeywordStats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Value != "keep" || stats[0].DocFreq != uint64(n/2) {
+		t.Errorf("doc_freq not maintained: %+v", stats)
+	}
+
+	res, err := ix.Search(ctx, "tags:drop", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 0 {
+		t.Errorf("deleted items still match: %v", pathsFromItems(t, res.Items))
+	}
+
+	if deleted, err := ix.DeleteMany(ctx, nil); err != nil || deleted != 0 {
+		t.Errorf("DeleteMany(nil) = %d, %v", deleted, err)
+	}
+}
+
+func TestStrictAndRequiredFields_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title":       {Type: ministore.FieldText, Required: true},
+			"tags":        {Type: ministore.FieldKeyword, Multi: true},
+			"author.name": {Type: ministore.FieldKeyword},
+		},
+		Strict: true,
+	}
+	ix, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","title":"ok","tags":["x"],"author":{"name":"ann"}}`)); err != nil {
+		t.Fatalf("PutJSON valid: %v", err)
+	}
+
+	cases := map[string]struct {
+		doc   string
+		field string
+	}{
+		"unknown top-level": {`{"path":"/b","title":"t","tgas":["x"]}`, "tgas"},
+		"unknown nested":    {`{"path":"/b","title":"t","author":{"email":"a@b"}}`, "author.email"},
+		"missing required":  {`{"path":"/b","tags":["x"]}`, "title"},
+		"null required":     {`{"path":"/b","title":null}`, "title"},
+	}
+	for name, c := range cases {
+		err := ix.PutJSON(ctx, []byte(c.doc))
+		var e *ministore.Error
+		if !errors.As(err, &e) || e.Kind != ministore.ErrSchema || e.Field != c.field {
+			t.Errorf("%s: expected schema error on %q, got %v", name, c.field, err)
+		}
+	}
+
+	// Batches stop at the offending document
+	b := ministore.NewBatch()
+	_ = b.PutJSON([]byte(`{"path":"/c","title":"t"}`))
+	_ = b.PutJSON([]byte(`{"path":"/d","title":"t","extra":1}`))
+	if _, err := ix.Batch(ctx, b); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("Batch: expected schema error, got %v", err)
+	}
+	if _, err := ix.Get(ctx, "/c"); !ministore.IsKind(err, ministore.ErrNotFound) {
+		t.Errorf("Batch should have rolled back, got %v", err)
+	}
+
+	// Constraints survive reopen
+	_ = ix.Close()
+	reopened, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reopened.Close()
+	if err := reopened.PutJSON(ctx, []byte(`{"path":"/e","title":"t","extra":1}`)); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("reopened strict: expected schema error, got %v", err)
+	}
+
+	// Non-strict schemas keep skipping unknown fields
+	lax, _ := newIndex(t, ministore.Schema{Fields: map[string]ministore.FieldSpec{"title": {Type: ministore.FieldText}}})
+	if err := lax.PutJSON(ctx, []byte(`{"path":"/a","titel":"typo"}`)); err != nil {
+		t.Errorf("non-strict PutJSON: %v", err)
+	}
+}
+
+func TestWithTx_SQLite(t *testing.T) {
+	store, err := ministore.NewFileDocStore(filepath.Join(t.TempDir(), "docs"))
+	if err != nil {
+		t.Fatalf("NewFileDocStore: %v", err)
+	}
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"status": {Type: ministore.FieldKeyword},
+			"n":      {Type: ministore.FieldNumber},
+		},
+	}
+	opts := ministore.DefaultIndexOptions()
+	opts.DocStore = store
+	ix, err := ministore.Create(context.Background(), sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer ix.Close()
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","status":"open","n":1,"note":"kept"}`,
+		`{"path":"/b","status":"open","n":5}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	search := func(q string) []string {
+		t.Helper()
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		return got
+	}
+	search("status:open") // cached, must be invalidated by the commit
+
+	// Read-modify-write plus a delete, committed together
+	err = ix.WithTx(ctx, func(tx *ministore.IndexTx) error {
+		view, err := tx.Get(ctx, "/a")
+		if err != nil {
+			return err
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(view.DocJSON, &doc); err != nil {
+			return err
+		}
+		doc["n"] = doc["n"].(float64) + 1
+		doc["status"] = "done"
+		b, _ := json.Marshal(doc)
+		if err := tx.Put(ctx, b); err != nil {
+			return err
+		}
+		if ok, err := tx.Delete(ctx, "/b"); err != nil || !ok {
+			return fmt.Errorf("delete /b: ok=%v err=%v", ok, err)
+		}
+		if _, err := tx.Get(ctx, "/b"); !ministore.IsKind(err, ministore.ErrNotFound) {
+			return fmt.Errorf("Get(/b) after delete: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if got := search("status:open"); len(got) != 0 {
+		t.Errorf("status:open after commit = %v, want none", got)
+	}
+	if got := search("n:2 AND status:done"); strings.Join(got, ",") != "/a" {
+		t.Errorf("n:2 AND status:done = %v, want /a", got)
+	}
+	view, err := ix.Get(ctx, "/a")
+	if err != nil || !strings.Contains(string(view.DocJSON), `"note":"kept"`) {
+		t.Errorf("Get(/a) = %s, %v; want the full document", view.DocJSON, err)
+	}
+
+	// A callback error rolls everything back, including DocStore writes
+	abort := errors.New("abort")
+	err = ix.WithTx(ctx, func(tx *ministore.IndexTx) error {
+		if err := tx.Put(ctx, []byte(`{"path":"/a","status":"lost","n":9,"note":"lost"}`)); err != nil {
+			return err
+		}
+		if err := tx.Put(ctx, []byte(`{"path":"/c","status":"lost"}`)); err != nil {
+			return err
+		}
+		view, err := tx.Get(ctx, "/a")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(view.DocJSON), `"note":"lost"`) {
+			return fmt.Errorf("Get(/a) in tx = %s, want its own write", view.DocJSON)
+		}
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Fatalf("WithTx err = %v, want the callback's error", err)
+	}
+	if got := search("status:lost"); len(got) != 0 {
+		t.Errorf("status:lost after rollback = %v, want none", got)
+	}
+	if _, err := ix.Get(ctx, "/c"); !ministore.IsKind(err, ministore.ErrNotFound) {
+		t.Errorf("Get(/c) after rollback: %v, want ErrNotFound", err)
+	}
+	view, err = ix.Get(ctx, "/a")
+	if err != nil || !strings.Contains(string(view.DocJSON), `"note":"kept"`) {
+		t.Errorf("Get(/a) after rollback = %s, %v; want the committed document", view.DocJSON, err)
+	}
+}
+
+// recordingObserver keeps the searches and one line per other callback
+type recordingObserver struct {
+	ministore.NopObserver
+	mu       sync.Mutex
+	searches []ministore.SearchEvent
+	calls    []string
+}
+
+func (o *recordingObserver) OnSearch(e ministore.SearchEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.searches = append(o.searches, e)
+}
+
+func (o *recordingObserver) OnPut(path string, _ time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.calls = append(o.calls, fmt.Sprintf("put %s %v", path, err != nil))
+}
+
+func (o *recordingObserver) OnDeleteWhere(query string, n int, _ time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.calls = append(o.calls, fmt.Sprintf("delete where %s %d %v", query, n, err != nil))
+}
+
+func TestObserver_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	rec := &recordingObserver{}
+	metrics := ministore.NewMetricsObserver()
+	for _, obs := range []ministore.Observer{rec, metrics} {
+		opts := ministore.DefaultIndexOptions()
+		opts.Observer = obs
+		opts.CacheSize = 10
+		ix, err := ministore.Create(context.Background(), sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		defer ix.Close()
+		ctx := context.Background()
+
+		if err := ix.PutJSON(ctx, []byte(`{"path":"/a","title":"hello world","tags":["x"]}`)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+		if err := ix.PutJSON(ctx, []byte(`{"path":"/b","tags":"x","unknown":`)); err == nil {
+			t.Fatal("PutJSON of invalid JSON succeeded")
+		}
+		for _, q := range []string{"hello", "tags:x", "tags:x"} {
+			if _, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10}); err != nil {
+				t.Fatalf("Search(%q): %v", q, err)
+			}
+		}
+		if _, err := ix.Search(ctx, "tags:(", ministore.SearchOptions{Limit: 10}); err == nil {
+			t.Fatal("Search of a bad query succeeded")
+		}
+		if _, err := ix.DeleteWhere(ctx, "tags:x"); err != nil {
+			t.Fatalf("DeleteWhere: %v", err)
+		}
+	}
+
+	if got, want := strings.Join(rec.calls, "; "), "put /a false; put  true; delete where tags:x 1 false"; got != want {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+	want := []ministore.SearchEvent{
+		{Query: "hello", Rows: 1, FTS: true},
+		{Query: "tags:x", Rows: 1},
+		{Query: "tags:x", Rows: 1, Cached: true},
+	}
+	if len(rec.searches) != 4 {
+		t.Fatalf("got %d search events, want 4", len(rec.searches))
+	}
+	for i, w := range want {
+		e := rec.searches[i]
+		if e.Query != w.Query || e.Rows != w.Rows || e.FTS != w.FTS || e.Cached != w.Cached || e.Err != nil {
+			t.Errorf("search event %d = %+v, want %+v", i, e, w)
+		}
+	}
+	if rec.searches[3].Err == nil {
+		t.Error("failed search reported no error")
+	}
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		`ministore_operations_total{op="put",status="ok"} 1`,
+		`ministore_operations_total{op="put",status="error"} 1`,
+		`ministore_operations_total{op="search",status="ok"} 3`,
+		`ministore_operations_total{op="search",status="error"} 1`,
+		`ministore_operation_items_total{op="delete_where",status="ok"} 1`,
+		`ministore_operation_duration_seconds_count{op="search"} 4`,
+		`ministore_searches_total{path="fts",cached="false"} 1`,
+		`ministore_searches_total{path="filter",cached="false"} 1`,
+		`ministore_searches_total{path="filter",cached="true"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics output lacks %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestFileDocStore_SQLite(t *testing.T) {
+	store, err := ministore.NewFileDocStore(filepath.Join(t.TempDir(), "docs"))
+	if err != nil {
+		t.Fatalf("NewFileDocStore: %v", err)
+	}
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title":       {Type: ministore.FieldText},
+			"author.name": {Type: ministore.FieldKeyword},
+		},
+	}
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	opts := ministore.DefaultIndexOptions()
+	opts.DocStore = store
+	ix, err := ministore.Create(context.Background(), sqlite.New(dbPath), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer ix.Close()
+	ctx := context.Background()
+
+	doc := `{"path":"/a","title":"hello","author":{"name":"ann","bio":"long"},"body":"big blob"}`
+	if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	var dataJSON string
+	if err := ix.DB().QueryRowContext(ctx, "SELECT data_json FROM items WHERE path = '/a'").Scan(&dataJSON); err != nil {
+		t.Fatalf("read data_json: %v", err)
+	}
+	if strings.Contains(dataJSON, "big blob") || !strings.Contains(dataJSON, "hello") || !strings.Contains(dataJSON, "ann") {
+		t.Errorf("data_json should hold only indexed fields: %s", dataJSON)
+	}
+
+	item, err := ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(item.DocJSON) != doc {
+		t.Errorf("Get = %s, want full document", item.DocJSON)
+	}
+
+	res, err := ix.Search(ctx, "author.name:ann", ministore.SearchOptions{Limit: 10, Show: ministore.OutputFieldSelector{Kind: ministore.ShowAll}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 1 || !strings.Contains(string(res.Items[0]), "big blob") {
+		t.Errorf("ShowAll should read from the doc store: %s", res.Items)
+	}
+	res, err = ix.Search(ctx, "title:hello", ministore.SearchOptions{Limit: 10, Show: ministore.OutputFieldSelector{Kind: ministore.ShowFields, Fields: []string{"body"}}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Items) != 1 || !strings.Contains(string(res.Items[0]), "big blob") {
+		t.Errorf("ShowFields on an unindexed key should read from the doc store: %s", res.Items)
+	}
+
+	// Update merges into the full document, not the projection
+	if err := ix.Update(ctx, "/a", map[string]any{"title": "bye"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	item, err = ix.Get(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !strings.Contains(string(item.DocJSON), "big blob") || !strings.Contains(string(item.DocJSON), "bye") {
+		t.Errorf("Update lost unindexed keys: %s", item.DocJSON)
+	}
+
+	// Items written before the store was configured fall back to data_json
+	if _, err := ix.DB().ExecContext(ctx, `INSERT INTO items(path, data_json, created_at, updated_at) VALUES ('/old', '{"path":"/old","title":"x"}', 1, 1)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	item, err = ix.Get(ctx, "/old")
+	if err != nil {
+		t.Fatalf("Get /old: %v", err)
+	}
+	if string(item.DocJSON) != `{"path":"/old","title":"x"}` {
+		t.Errorf("Get /old = %s", item.DocJSON)
+	}
+}
+
+func TestFileDocStoreLifecycle_SQLite(t *testing.T) {
+	store, err := ministore.NewFileDocStore(filepath.Join(t.TempDir(), "docs"))
+	if err != nil {
+		t.Fatalf("NewFileDocStore: %v", err)
+	}
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"sku":   {Type: ministore.FieldKeyword},
+		},
+	}
+	opts := ministore.DefaultIndexOptions()
+	opts.DocStore = store
+	opts.UpsertKey = "sku"
+	ix, err := ministore.Create(context.Background(), sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer ix.Close()
+	ctx := context.Background()
+
+	stored := func(path string) bool {
+		t.Helper()
+		_, err := store.Get(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("store.Get(%s): %v", path, err)
+		}
+		return err == nil
+	}
+
+	for _, doc := range []string{
+		`{"path":"/a","title":"alpha","sku":"a1","color":"red"}`,
+		`{"path":"/b","title":"beta","sku":"b1","color":"blue"}`,
+		`{"path":"/c","title":"gamma","sku":"c1","color":"red"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	// A field added later is indexed from the full documents in the store
+	added := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+		"sku":   {Type: ministore.FieldKeyword},
+		"color": {Type: ministore.FieldKeyword},
+	}}
+	if _, err := ix.ApplySchema(ctx, added, ministore.ApplySchemaOptions{}); err != nil {
+		t.Fatalf("ApplySchema: %v", err)
+	}
+	if err := ix.SoftDelete(ctx, "/c"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	if err := ix.Reindex(ctx, nil); err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	if err := ix.Restore(ctx, "/c"); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	res, err := ix.Search(ctx, "color:red", ministore.SearchOptions{Limit: 10, Show: ministore.OutputFieldSelector{Kind: ministore.ShowFields, Fields: []string{"color"}}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	got := pathsFromItems(t, res.Items)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"/a", "/c"}) {
+		t.Errorf("color:red = %v, want [/a /c]", got)
+	}
+	for _, item := range res.Items {
+		if !strings.Contains(string(item), "red") {
+			t.Errorf("projection lacks the new field: %s", item)
+		}
+	}
+
+	// Moving an item by its upsert key moves its stored document
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a2","title":"alpha","sku":"a1"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if stored("/a") || !stored("/a2") {
+		t.Error("upsert key move left the old document behind")
+	}
+
+	// Hard deletes remove the stored document; soft deletes keep it
+	if err := ix.SoftDelete(ctx, "/c"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	if !stored("/c") {
+		t.Error("SoftDelete removed the stored document")
+	}
+	if _, err := ix.Delete(ctx, "/a2"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if n, err := ix.DeleteWhere(ctx, "sku:b1"); err != nil || n != 1 {
+		t.Fatalf("DeleteWhere = %d, %v", n, err)
+	}
+	if stored("/a2") || stored("/b") {
+		t.Error("hard deletes left stored documents behind")
+	}
+
+	// A failed batch writes nothing to the store
+	b := ministore.NewBatch()
+	b.PutJSON([]byte(`{"path":"/d","title":"delta","sku":"d1"}`))
+	b.PutJSON([]byte(`{"path":"/e","title":7}`))
+	if _, err := ix.Batch(ctx, b); err == nil {
+		t.Fatal("Batch with an invalid document should fail")
+	}
+	if stored("/d") {
+		t.Error("failed batch wrote to the store")
+	}
+}
+
+func TestSearchResultCache_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	opts := ministore.DefaultIndexOptions()
+	opts.Now = monotonicNow(time.Unix(1700000000, 0))
+	opts.CacheSize = 2
+	ix, err := ministore.Create(context.Background(), sqlite.New(dbPath), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer ix.Close()
+	ctx := context.Background()
+
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","title":"hello","tags":["x"]}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	search := func(q string) []string {
+		t.Helper()
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search %q: %v", q, err)
+		}
+		return pathsFromItems(t, res.Items)
+	}
+
+	search("tags:x")
+	search("tags:x")
+	// Spacing and parentheses do not change the key
+	search("( tags:x )")
+	if st := ix.CacheStats(); st.Hits != 2 || st.Misses != 1 || st.Entries != 1 {
+		t.Errorf("after repeats: %+v", st)
+	}
+
+	// Writes invalidate cached pages
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/b","title":"bye","tags":["x"]}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if got := search("tags:x"); len(got) != 2 {
+		t.Errorf("stale page after put: %v", got)
+	}
+	if _, err := ix.Delete(ctx, "/a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := search("tags:x"); len(got) != 1 {
+		t.Errorf("stale page after delete: %v", got)
+	}
+	if st := ix.CacheStats(); st.Hits != 2 || st.Misses != 3 {
+		t.Errorf("after writes: %+v", st)
+	}
+
+	// Least recently used pages are evicted
+	search("title:bye")
+	search("tags:y")
+	if st := ix.CacheStats(); st.Entries != 2 {
+		t.Errorf("entries = %d, want 2", st.Entries)
+	}
+
+	// Disabled cache reports nothing
+	plain, _ := newIndex(t, schema)
+	if _, err := plain.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 10}); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if st := plain.CacheStats(); st != (ministore.CacheStats{}) {
+		t.Errorf("disabled cache stats = %+v", st)
+	}
+}
+
+func TestExplainPlanJSON_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","title":"hello world","tags":["x"]}`,
+		`{"path":"/b","title":"hello again","tags":["y"]}`,
+		`{"path":"/c","title":"goodbye","tags":["x"]}`,
+		`{"path":"/d","title":"other","tags":["z"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, "tags:x AND title:hello", ministore.SearchOptions{
+		Limit:         10,
+		Explain:       true,
+		ExplainFormat: ministore.ExplainFormatJSON,
+	})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+
+	type node struct {
+		Op          string  `json:"op"`
+		CTE         string  `json:"cte"`
+		Field       string  `json:"field"`
+		Pattern     string  `json:"pattern"`
+		Selectivity float64 `json:"selectivity"`
+		Children    []node  `json:"children"`
+	}
+	var plan node
+	if err := json.Unmarshal(res.ExplainJSON, &plan); err != nil {
+		t.Fatalf("unmarshal plan %s: %v", res.ExplainJSON, err)
+	}
+	if plan.Op != "Intersect" || len(plan.Children) != 2 {
+		t.Fatalf("root = %+v", plan)
+	}
+	if plan.Selectivity != 0.25 {
+		t.Errorf("root selectivity = %v, want 0.25", plan.Selectivity)
+	}
+	byOp := map[string]node{}
+	for _, c := range plan.Children {
+		byOp[c.Op] = c
+	}
+	if kw := byOp["KeywordScan"]; kw.Field != "tags" || kw.Pattern != "x" || kw.Selectivity != 0.5 {
+		t.Errorf("keyword node = %+v", kw)
+	}
+	if fts := byOp["FTSMatch"]; fts.Field != "title" || fts.Selectivity != 0.5 {
+		t.Errorf("fts node = %+v", fts)
+	}
+
+	// Text explain leaves the JSON plan empty
+	res, err = ix.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 10, Explain: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if res.ExplainJSON != nil || res.ExplainSQL == "" {
+		t.Errorf("text explain: json=%s sql=%q", res.ExplainJSON, res.ExplainSQL)
+	}
+}
+
+func TestSearchProfile_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","title":"hello world","tags":["x"]}`,
+		`{"path":"/b","title":"hello again","tags":["y"]}`,
+		`{"path":"/c","title":"goodbye","tags":["x"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, "tags:x AND title:hello", ministore.SearchOptions{Limit: 10, Explain: true, Profile: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Profile) != 3 {
+		t.Fatalf("profile = %+v", res.Profile)
+	}
+	want := []struct {
+		step string
+		rows uint64
+	}{{"KEYWORD tags:x", 2}, {"FTS", 2}, {"INTERSECT", 1}}
+	for i, w := range want {
+		p := res.Profile[i]
+		if !strings.HasPrefix(p.Step, w.step) || p.Rows != w.rows || p.Elapsed <= 0 {
+			t.Errorf("profile[%d] = %+v, want step %q rows %d", i, p, w.step, w.rows)
+		}
+	}
+
+	// Profile needs Explain
+	res, err = ix.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 10, Profile: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if res.Profile != nil {
+		t.Errorf("profile without explain = %+v", res.Profile)
+	}
+}
+
+func TestNearText_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"content": {Type: ministore.FieldText},
+			"tags":    {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/close","content":"request error after a timeout"}`,
+		`{"path":"/reversed","content":"timeout then error"}`,
+		`{"path":"/far","content":"error in the handler, which retried four more times before the timeout"}`,
+		`{"path":"/one","content":"error only"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, "content:near(error,timeout,3)", ministore.SearchOptions{Limit: 10, Rank: ministore.RankMode{Kind: ministore.RankNone}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	got := pathsFromItems(t, res.Items)
+	sort.Strings(got)
+	if strings.Join(got, ",") != "/close,/reversed" {
+		t.Errorf("near = %v", got)
+	}
+
+	if _, err := ix.Search(ctx, "tags:near(error,timeout,3)", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Errorf("near on keyword field: expected error")
+	}
+}
+
+func TestMinScore_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"body": {Type: ministore.FieldText},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/strong","body":"rust rust rust"}`,
+		`{"path":"/weak","body":"a long note about many things where rust appears once among lots of other words entirely"}`,
+	}
+	// Filler keeps the term rare enough for bm25 to give it weight
+	for i := 0; i < 6; i++ {
+		docs = append(docs, fmt.Sprintf(`{"path":"/filler/%d","body":"nothing here"}`, i))
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	search := func(minScore *float64, rank ministore.RankModeKind) []string {
+		t.Helper()
+		res, err := ix.Search(ctx, "body:rust", ministore.SearchOptions{
+			Limit:    10,
+			Rank:     ministore.RankMode{Kind: rank},
+			MinScore: minScore,
+		})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		return pathsFromItems(t, res.Items)
+	}
+
+	if got := search(nil, ministore.RankDefault); len(got) != 2 {
+		t.Errorf("no threshold: %v", got)
+	}
+	threshold := 1.0
+	if got := search(&threshold, ministore.RankDefault); len(got) != 1 || got[0] != "/strong" {
+		t.Errorf("threshold %v: %v", threshold, got)
+	}
+	// Ignored when results are not ranked by score
+	if got := search(&threshold, ministore.RankRecency); len(got) != 2 {
+		t.Errorf("recency with threshold: %v", got)
+	}
+}
+
+func TestApplySchemaDiffAndDryRun_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	withBody := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword, Multi: true, Required: true},
+			"body":  {Type: ministore.FieldKeyword},
+		},
+	}
+	diff := schema.Diff(withBody)
+	if strings.Join(diff.Added, ",") != "body" || len(diff.Removed) != 0 || len(diff.Changed) != 1 || diff.Changed[0].TypeChanged() {
+		t.Errorf("diff = %+v", diff)
+	}
+	if !schema.Diff(schema).Empty() {
+		t.Errorf("self diff not empty")
+	}
+
+	// A dry run reports the DDL and leaves the index alone
+	change, err := ix.ApplySchema(ctx, withBody, ministore.ApplySchemaOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("ApplySchema dry run: %v", err)
+	}
+	if len(change.DDL) != 0 || change.Diff.Empty() {
+		t.Errorf("dry run = %+v", change)
+	}
+	if ix.Schema().HasField("body") {
+		t.Errorf("dry run changed the schema")
+	}
+
+	// FTS5 tables cannot gain columns in place, so the table is copied
+	withText := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title":   {Type: ministore.FieldText},
+		"tags":    {Type: ministore.FieldKeyword, Multi: true},
+		"summary": {Type: ministore.FieldText},
+	}}
+	change, err = ix.ApplySchema(ctx, withText, ministore.ApplySchemaOptions{DryRun: true})
+	if err != nil || len(change.DDL) == 0 || !strings.Contains(change.DDL[0], "fts5(title, summary") {
+		t.Errorf("add text field: got %+v, %v", change, err)
+	}
+
+	if _, err := ix.ApplySchema(ctx, withBody, ministore.ApplySchemaOptions{}); err != nil {
+		t.Fatalf("ApplySchema: %v", err)
+	}
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/a","title":"t","tags":["x"],"body":"hello"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/b","title":"t"}`)); !ministore.IsKind(err, ministore.ErrSchema) {
+		t.Errorf("required tags not enforced after apply: %v", err)
+	}
+	res, err := ix.Search(ctx, "body:hello", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); len(got) != 1 {
+		t.Errorf("search new field: %v", got)
+	}
+
+	// Removals and type changes need MigrateRebuild
+	removed := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+		"tags":  {Type: ministore.FieldKeyword, Multi: true},
+	}}
+	retyped := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+		"tags":  {Type: ministore.FieldKeyword},
+		"body":  {Type: ministore.FieldKeyword},
+	}}
+	folded := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+		"tags":  {Type: ministore.FieldKeyword, Multi: true, Required: true},
+		"body":  {Type: ministore.FieldKeyword, CaseFold: true},
+	}}
+	for name, s := range map[string]ministore.Schema{"body": removed, "tags": retyped} {
+		_, err := ix.ApplySchema(ctx, s, ministore.ApplySchemaOptions{DryRun: true})
+		var e *ministore.Error
+		if !errors.As(err, &e) || e.Kind != ministore.ErrSchema || e.Field != name || !strings.Contains(e.Message, "MigrateRebuild") {
+			t.Errorf("change to %s: got %v", name, err)
+		}
+	}
+	// Existing values have no folded form, so case_fold needs a rebuild too
+	var e *ministore.Error
+	if _, err := ix.ApplySchema(ctx, folded, ministore.ApplySchemaOptions{}); !errors.As(err, &e) || e.Kind != ministore.ErrSchema || e.Field != "body" || !strings.Contains(e.Message, "MigrateRebuild") {
+		t.Errorf("case_fold change: got %v", err)
+	}
+	res, err = ix.Search(ctx, "body:HELLO", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); len(got) != 0 {
+		t.Errorf("rejected case_fold change took effect: %v", got)
+	}
+}
+
+func TestAddTextFieldKeepsFTSWeights_SQLite(t *testing.T) {
+	one, ten := 1.0, 10.0
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText, Weight: &one},
+	}}
+	ix, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{`{"path":"/t","title":"apple"}`, `{"path":"/b","title":"pear"}`} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	// body sorts before title but is appended after it in the FTS table
+	withBody := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText, Weight: &one},
+		"body":  {Type: ministore.FieldText, Weight: &ten},
+	}}
+	if _, err := ix.ApplySchema(ctx, withBody, ministore.ApplySchemaOptions{}); err != nil {
+		t.Fatalf("ApplySchema: %v", err)
+	}
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/b","title":"pear","body":"apple"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	check := func(ix *ministore.Index) {
+		t.Helper()
+		res, err := ix.Search(ctx, "title:apple", ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, []string{"/t"}) {
+			t.Errorf("title:apple = %v, want existing text kept", got)
+		}
+		// The heavier body match ranks first only if weights follow the columns
+		res, err = ix.Search(ctx, "apple", ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, []string{"/b", "/t"}) {
+			t.Errorf("apple = %v, want [/b /t]", got)
+		}
+		res, err = ix.Search(ctx, "body:apple", ministore.SearchOptions{
+			Limit:     10,
+			Highlight: &ministore.HighlightSpec{Tokens: 5, Open: "[", Close: "]"},
+		})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		var out struct {
+			Highlights map[string]string `json:"highlights"`
+		}
+		if len(res.Items) != 1 || json.Unmarshal(res.Items[0], &out) != nil || out.Highlights["body"] != "[apple]" {
+			t.Errorf("body highlight = %v", out.Highlights)
+		}
+	}
+	check(ix)
+
+	// A reopened index reads the column order back from the table
+	ix.Close()
+	ix, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer ix.Close()
+	check(ix)
+}
+
+func TestSizeStats_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+		"tags":  {Type: ministore.FieldKeyword, Multi: true},
+		"n":     {Type: ministore.FieldNumber},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","title":"one","tags":["x","y","z"],"n":1}`,
+		`{"path":"/b","title":"two","tags":["x"]}`,
+		`{"path":"/c","title":"three"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if err := ix.SoftDelete(ctx, "/c"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+
+	stats, err := ix.SizeStats(ctx)
+	if err != nil {
+		t.Fatalf("SizeStats: %v", err)
+	}
+	if stats.Items != 2 || stats.DeletedItems != 1 {
+		t.Errorf("items = %d, deleted = %d; want 2 and 1", stats.Items, stats.DeletedItems)
+	}
+	for table, want := range map[string]int64{"items": 3, "kw_dict": 3, "kw_postings": 4, "field_number": 1, "search": 2} {
+		if got := stats.Rows[table]; got != want {
+			t.Errorf("rows[%s] = %d, want %d", table, got, want)
+		}
+	}
+	if stats.Bytes <= 0 {
+		t.Errorf("bytes = %d, want the file size", stats.Bytes)
+	}
+	if stats.TableBytes["kw_postings"] <= 0 || stats.TableBytes["search"] <= 0 {
+		t.Errorf("table bytes = %v", stats.TableBytes)
+	}
+}
+
+func TestKeywordNormalizer_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"lang":  {Type: ministore.FieldKeyword, Normalizer: ministore.NormalizerLowercase},
+			"topic": {Type: ministore.FieldKeyword, Multi: true, Normalizer: ministore.NormalizerSlug},
+		},
+	}
+	ix, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","lang":"JavaScript","topic":["Machine Learning"]}`,
+		`{"path":"/b","lang":"javascript","topic":["machine-learning","Web Dev!"]}`,
+		`{"path":"/c","lang":"Go","topic":["web dev"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	values, err := ix.DiscoverValues(ctx, "lang", "", 10)
+	if err != nil {
+		t.Fatalf("DiscoverValues: %v", err)
+	}
+	if len(values) != 2 || values[0].Value != "javascript" || values[0].Count != 2 {
+		t.Errorf("lang values = %+v", values)
+	}
+
+	// Query terms are normalized the same way, wildcards included
+	for q, want := range map[string]int{
+		"lang:JAVASCRIPT":                  2,
+		"lang:Java*":                       2,
+		"lang:in(GO, Rust)":                1,
+		`topic:"Machine Learning"`:         2,
+		`topic:"web dev"`:                  2,
+		`topic:"Web*"`:                     2,
+		`topic:in("Machine  Learning", x)`: 2,
+	} {
+		res, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search %q: %v", q, err)
+		}
+		if got := pathsFromItems(t, res.Items); len(got) != want {
+			t.Errorf("%s: got %v, want %d items", q, got, want)
+		}
+	}
+
+	// The normalizer is persisted with the schema
+	_ = ix.Close()
+	reopened, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reopened.Close()
+	if spec, _ := reopened.Schema().Get("topic"); spec.Normalizer != ministore.NormalizerSlug {
+		t.Errorf("reopened normalizer = %q", spec.Normalizer)
+	}
+	res, err := reopened.Search(ctx, `topic:"WEB DEV"`, ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); len(got) != 2 {
+		t.Errorf("after reopen: %v", got)
+	}
+
+	// Unknown normalizers and non-keyword fields are rejected
+	for _, spec := range []ministore.FieldSpec{
+		{Type: ministore.FieldKeyword, Normalizer: "upper"},
+		{Type: ministore.FieldText, Normalizer: ministore.NormalizerLowercase},
+	} {
+		bad := ministore.Schema{Fields: map[string]ministore.FieldSpec{"f": spec}}
+		if err := bad.Validate(); !ministore.IsKind(err, ministore.ErrSchema) {
+			t.Errorf("Validate(%+v) = %v", spec, err)
+		}
+	}
+}
+
+func TestGeoWithin_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"location": {Type: ministore.FieldGeo},
+			"stops":    {Type: ministore.FieldGeo, Multi: true},
+			"kind":     {Type: ministore.FieldKeyword},
+		},
+		Strict: true,
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	docs := []string{
+		`{"path":"/paris","kind":"city","location":{"lat":48.8566,"lon":2.3522}}`,
+		`{"path":"/versailles","kind":"town","location":"48.8049, 2.1204"}`,
+		`{"path":"/london","kind":"city","location":{"lat":51.5074,"lon":-0.1278}}`,
+		`{"path":"/suva","kind":"city","location":{"lat":-18.1416,"lon":178.4419}}`,
+		`{"path":"/taveuni","kind":"island","location":{"lat":-16.85,"lon":-179.97}}`,
+		`{"path":"/route","stops":[{"lat":51.5074,"lon":-0.1278},"50.85,4.35"]}`,
+		`{"path":"/arctic","location":{"lat":89.5,"lon":120}}`,
+	}
+	for _, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON(%s): %v", doc, err)
+		}
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"location:within(48.8566,2.3522,5)", []string{"/paris"}},
+		{"location:within(48.8566,2.3522,20)", []string{"/paris", "/versailles"}},
+		{"location:within(48.8566,2.3522,400)", []string{"/london", "/paris", "/versailles"}},
+		{"location:within(48.8566,2.3522,400) AND kind:city", []string{"/london", "/paris"}},
+		{"location:within(48.8566,2.3522,400) AND NOT kind:city", []string{"/versailles"}},
+		// The circle crosses the antimeridian
+		{"location:within(-17.5,179.9,200)", []string{"/suva", "/taveuni"}},
+		// and reaches the pole
+		{"location:within(89,0,300)", []string{"/arctic"}},
+		{"stops:within(50.85,4.35,10)", []string{"/route"}},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	// Deleting an item removes its points
+	if _, err := ix.Delete(ctx, "/versailles"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	res, err := ix.Search(ctx, "location:within(48.8566,2.3522,20)", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search after delete: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, []string{"/paris"}) {
+		t.Errorf("after delete: %v", got)
+	}
+
+	for _, doc := range []string{
+		`{"path":"/bad","location":{"lat":91,"lon":0}}`,
+		`{"path":"/bad","location":"48.8"}`,
+		`{"path":"/bad","location":{"lat":"x","lon":0}}`,
+		`{"path":"/bad","location":["1,2","3,4"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err == nil {
+			t.Errorf("PutJSON(%s): expected error", doc)
+		}
+	}
+	if _, err := ix.Search(ctx, "kind:within(1,2,3)", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Error("within on a keyword field: expected error")
+	}
+}
+
+func TestCompressDocs_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags":  {Type: ministore.FieldKeyword},
+			"title": {Type: ministore.FieldText},
+		},
+	}
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	opts := ministore.DefaultIndexOptions()
+	opts.Now = monotonicNow(time.Unix(1700000000, 0))
+
+	// A plain row written before compression was turned on
+	ix, err := ministore.Create(ctx, sqlite.New(dbPath), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/plain","tags":"x","title":"plain doc"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	opts.CompressDocs = true
+	ix, err = ministore.Open(ctx, sqlite.New(dbPath), opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+	body := strings.Repeat("lorem ipsum dolor sit amet ", 50)
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/packed","tags":"x","title":"packed doc","body":"`+body+`"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	var enc string
+	var stored int
+	if err := ix.DB().QueryRowContext(ctx, "SELECT data_enc, length(data_json) FROM items WHERE path = '/packed'").Scan(&enc, &stored); err != nil {
+		t.Fatalf("read row: %v", err)
+	}
+	if enc != "gzip" || stored >= len(body) {
+		t.Fatalf("stored %d bytes with data_enc %q, want a gzip row under %d bytes", stored, enc, len(body))
+	}
+
+	for _, p := range []string{"/plain", "/packed"} {
+		item, err := ix.Get(ctx, p)
+		if err != nil {
+			t.Fatalf("Get %s: %v", p, err)
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(item.DocJSON, &doc); err != nil || doc["path"] != p {
+			t.Fatalf("Get %s = %s (%v)", p, item.DocJSON, err)
+		}
+	}
+	if item, _ := ix.Get(ctx, "/packed"); !strings.Contains(string(item.DocJSON), body) {
+		t.Fatalf("Get lost the body: %s", item.DocJSON)
+	}
+
+	for _, show := range []ministore.OutputFieldSelector{
+		{Kind: ministore.ShowAll},
+		{Kind: ministore.ShowFields, Fields: []string{"title"}},
+		{Kind: ministore.ShowNone},
+	} {
+		res, err := ix.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 10, Show: show})
+		if err != nil {
+			t.Fatalf("Search show=%s: %v", show.Kind, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, []string{"/packed", "/plain"}) {
+			t.Fatalf("Search show=%s paths = %v", show.Kind, got)
+		}
+		for _, it := range res.Items {
+			if show.Kind == ministore.ShowFields && !strings.Contains(string(it), `"title"`) {
+				t.Errorf("show=fields result lacks title: %s", it)
+			}
+		}
+	}
+
+	// Rewriting the plain row compresses it
+	if err := ix.Update(ctx, "/plain", map[string]any{"tags": "y"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := ix.DB().QueryRowContext(ctx, "SELECT data_enc FROM items WHERE path = '/plain'").Scan(&enc); err != nil || enc != "gzip" {
+		t.Fatalf("updated row data_enc = %q (%v), want gzip", enc, err)
+	}
+	if item, err := ix.Get(ctx, "/plain"); err != nil || !strings.Contains(string(item.DocJSON), `"title":"plain doc"`) {
+		t.Fatalf("Get after Update = %s (%v)", item.DocJSON, err)
+	}
+
+	if err := ix.Reindex(ctx, nil); err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	if res, err := ix.Search(ctx, "tags:y", ministore.SearchOptions{Limit: 10}); err != nil || len(res.Items) != 1 {
+		t.Fatalf("Search after Reindex: %v items, err %v", res, err)
+	}
+
+	var out bytes.Buffer
+	if n, err := ix.Export(ctx, &out, ministore.ExportOptions{}); err != nil || n != 2 {
+		t.Fatalf("Export = %d, %v", n, err)
+	}
+	if !strings.Contains(out.String(), body) {
+		t.Fatalf("export lost the compressed body")
+	}
+
+	// Compressed bodies cannot be matched in SQL
+	if _, err := ix.Search(ctx, `title:like("plain%")`, ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Fatalf("like(...) on a compressed index: expected an error")
+	}
+
+	// Turning compression off leaves the gzip rows, so like(...) stays
+	// rejected rather than silently missing them until they are rewritten
+	if err := ix.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	opts.CompressDocs = false
+	ix, err = ministore.Open(ctx, sqlite.New(dbPath), opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+	if _, err := ix.Search(ctx, `tags:y AND title:like("plain%")`, ministore.SearchOptions{Limit: 10}); err == nil || !strings.Contains(err.Error(), "stored documents are compressed") {
+		t.Fatalf("like(...) with compressed rows left: err = %v", err)
+	}
+	if _, err := ix.DiscoverValues(ctx, "tags", `tags:y AND title:like("plain%")`, 10); !ministore.IsKind(err, ministore.ErrQueryRejected) {
+		t.Fatalf("DiscoverValues like(...) with compressed rows left: err = %v", err)
+	}
+	for _, p := range []string{"/plain", "/packed"} {
+		if err := ix.Update(ctx, p, map[string]any{"tags": "y"}); err != nil {
+			t.Fatalf("Update %s: %v", p, err)
+		}
+	}
+	res, err := ix.Search(ctx, `tags:y AND title:like("plain%")`, ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("like(...) after rewriting: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, []string{"/plain"}) {
+		t.Fatalf("like(...) after rewriting = %v, want [/plain]", got)
+	}
+}
+
+// BenchmarkCompressDocs_SQLite reports stored bytes per document and put
+// cost with and without CompressDocs
+func BenchmarkCompressDocs_SQLite(b *testing.B) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}}
+	body := strings.Repeat("the quick brown fox jumps over the lazy dog ", 40)
+	for _, compress := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compress=%v", compress), func(b *testing.B) {
+			ctx := context.Background()
+			opts := ministore.DefaultIndexOptions()
+			opts.CompressDocs = compress
+			ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(b.TempDir(), "bench.db")), schema, opts)
+			if err != nil {
+				b.Fatalf("Create: %v", err)
+			}
+			defer ix.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				doc := fmt.Sprintf(`{"path":"/d/%d","tags":"t%d","body":%q}`, i, i%10, body)
+				if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+					b.Fatalf("PutJSON: %v", err)
+				}
+			}
+			b.StopTimer()
+
+			var total int64
+			if err := ix.DB().QueryRowContext(ctx, "SELECT COALESCE(SUM(length(data_json)), 0) FROM items").Scan(&total); err != nil {
+				b.Fatalf("sum: %v", err)
+			}
+			b.ReportMetric(float64(total)/float64(b.N), "stored-bytes/doc")
+		})
+	}
+}
+
+func TestDiscoverValuesTotal_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"tags":     {Type: ministore.FieldKeyword, Multi: true},
+			"priority": {Type: ministore.FieldNumber},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		doc := fmt.Sprintf(`{"path":"/%d","tags":["common","t%d"],"priority":%d}`, i, i, i)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	// A value whose only item is gone is not counted
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/gone","tags":["stale"]}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+	if _, err := ix.Delete(ctx, "/gone"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	tests := []struct {
+		where   string
+		top     int
+		n       int
+		total   uint64
+		hasMore bool
+	}{
+		{"", 2, 2, 6, true},
+		{"", 10, 6, 6, false},
+		{"priority>=3", 2, 2, 3, true},
+		{"priority>=3", 3, 3, 3, false},
+		{"priority>100", 5, 0, 0, false},
+	}
+	for _, tt := range tests {
+		page, err := ix.DiscoverValuesTotal(ctx, "tags", tt.where, tt.top)
+		if err != nil {
+			t.Fatalf("DiscoverValuesTotal(%q, %d): %v", tt.where, tt.top, err)
+		}
+		if len(page.Values) != tt.n || page.TotalDistinct != tt.total || page.HasMore != tt.hasMore {
+			t.Errorf("DiscoverValuesTotal(%q, %d) = %d values, total %d, more %v; want %d, %d, %v",
+				tt.where, tt.top, len(page.Values), page.TotalDistinct, page.HasMore, tt.n, tt.total, tt.hasMore)
+		}
+		if tt.n > 0 && page.Values[0].Value != "common" {
+			t.Errorf("DiscoverValuesTotal(%q) top value = %+v, want common", tt.where, page.Values[0])
+		}
+	}
+}
+
+func TestSchemaWithFieldAndMerge(t *testing.T) {
+	base := ministore.Schema{Fields: map[string]ministore.FieldSpec{"title": {Type: ministore.FieldText}}}
+
+	extended := base.WithField("tags", ministore.FieldSpec{Type: ministore.FieldKeyword, Multi: true})
+	if _, ok := base.Fields["tags"]; ok {
+		t.Fatalf("WithField modified its receiver")
+	}
+	if !extended.HasField("tags") || !extended.HasField("title") {
+		t.Fatalf("WithField fields = %v", extended.Fields)
+	}
+
+	other := ministore.Schema{
+		Fields:  map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword, Multi: true}, "views": {Type: ministore.FieldNumber}},
+		Aliases: map[string]string{"hits": "views"},
+		Strict:  true,
+	}
+	merged, err := extended.Merge(other)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	var names []string
+	for name := range merged.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"tags", "title", "views"}) || !merged.Strict || merged.Aliases["hits"] != "views" {
+		t.Fatalf("Merge = %+v", merged)
+	}
+	if len(extended.Fields) != 2 || extended.Aliases != nil {
+		t.Fatalf("Merge modified its receiver: %+v", extended)
+	}
+
+	conflicts := []ministore.Schema{
+		{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}},
+		{Fields: map[string]ministore.FieldSpec{"x": {Type: ministore.FieldBool}}, Aliases: map[string]string{"hits": "x"}},
+		{Fields: map[string]ministore.FieldSpec{"x": {Type: ministore.FieldBool}}, FTSTokenizer: "trigram"},
+		{Fields: map[string]ministore.FieldSpec{"bad name": {Type: ministore.FieldBool}}},
+	}
+	merged.FTSTokenizer = "porter unicode61"
+	for i, c := range conflicts {
+		_, err := merged.Merge(c)
+		var merr *ministore.Error
+		if !errors.As(err, &merr) || merr.Kind != ministore.ErrSchema {
+			t.Errorf("conflict %d: err = %v, want ErrSchema", i, err)
+		}
+	}
+}
+
+func TestRankMissingLast_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"kind":     {Type: ministore.FieldKeyword},
+			"priority": {Type: ministore.FieldNumber},
+			"due":      {Type: ministore.FieldDate},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","kind":"task","priority":1,"due":"2025-01-05"}`,
+		`{"path":"/b","kind":"task","priority":3}`,
+		`{"path":"/c","kind":"task","due":"2025-01-02"}`,
+		`{"path":"/d","kind":"task","priority":2,"due":"2025-01-01"}`,
+		`{"path":"/e","kind":"task"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		rank ministore.RankMode
+		want string
+	}{
+		{"dropped by default", ministore.RankMode{Kind: ministore.RankField, Field: "priority"}, "/b,/d,/a"},
+		// Missing items tie on the sentinel and fall back to updated DESC
+		{"desc", ministore.RankMode{Kind: ministore.RankField, Field: "priority", MissingLast: true}, "/b,/d,/a,/e,/c"},
+		{"asc", ministore.RankMode{Kind: ministore.RankField, Field: "priority", Ascending: true, MissingLast: true}, "/a,/d,/b,/c,/e"},
+		{"two keys", ministore.RankMode{Kind: ministore.RankField, MissingLast: true, Keys: []ministore.SortKey{
+			{Field: "priority", Desc: true},
+			{Field: "due"},
+		}}, "/b,/d,/a,/c,/e"},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, "kind:task", ministore.SearchOptions{Rank: tt.rank, Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := strings.Join(pathsFromItems(t, res.Items), ","); got != tt.want {
+			t.Errorf("%s: got %s want %s", tt.name, got, tt.want)
+		}
+
+		// Cursors carry the sentinel across pages
+		for _, mode := range []ministore.CursorMode{ministore.CursorFull, ministore.CursorShort} {
+			opts := ministore.SearchOptions{Rank: tt.rank, Limit: 1, CursorMode: mode}
+			var got []string
+			for page := 0; page < 10; page++ {
+				res, err := ix.Search(ctx, "kind:task", opts)
+				if err != nil {
+					t.Fatalf("%s %s page %d: %v", tt.name, mode, page, err)
+				}
+				got = append(got, pathsFromItems(t, res.Items)...)
+				if !res.HasMore {
+					break
+				}
+				opts.After = res.NextCursor
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("%s %s paginated: got %v want %s", tt.name, mode, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestHasImplicitAndTextFields_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title": {Type: ministore.FieldText},
+			"tags":  {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","title":"hello","tags":"x"}`,
+		`{"path":"/b","title":""}`,
+		`{"path":"/c","tags":"y"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"has:path", []string{"/a", "/b", "/c"}},
+		{"has:created", []string{"/a", "/b", "/c"}},
+		{"updated:*", []string{"/a", "/b", "/c"}},
+		{"has:path AND tags:y", []string{"/c"}},
+		// An empty title is not content
+		{"has:title", []string{"/a"}},
+		{"has:path AND NOT has:title", []string{"/b", "/c"}},
+		{"has:tags", []string{"/a", "/c"}},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := ix.Search(ctx, "has:nope", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Errorf("has:nope: expected an unknown field error")
+	}
+}
+
+func TestSearchLimitPolicy_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{"tags": {Type: ministore.FieldKeyword}}}
+	opts := ministore.DefaultIndexOptions()
+	opts.DefaultLimit = 3
+	opts.MaxLimit = 5
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+	for i := 0; i < 8; i++ {
+		if err := ix.PutJSON(ctx, []byte(fmt.Sprintf(`{"path":"/%d","tags":"x"}`, i))); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	res, err := ix.Search(ctx, "tags:x", ministore.SearchOptions{})
+	if err != nil || len(res.Items) != 3 || !res.HasMore {
+		t.Fatalf("default limit: %d items, more %v, err %v; want 3 with more", len(res.Items), res.HasMore, err)
+	}
+	if res, err := ix.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 5}); err != nil || len(res.Items) != 5 {
+		t.Fatalf("limit at the maximum: %d items, err %v", len(res.Items), err)
+	}
+
+	_, err = ix.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 6})
+	var merr *ministore.Error
+	if !errors.As(err, &merr) || merr.Kind != ministore.ErrQueryRejected {
+		t.Fatalf("limit over the maximum: err = %v, want ErrQueryRejected", err)
+	}
+}
+
+func TestLikePattern_SQLite(t *testing.T) {
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"ref":  {Type: ministore.FieldText},
+			"tags": {Type: ministore.FieldKeyword},
+		},
+	}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/a","ref":"INV-001-2024","tags":"x"}`,
+		`{"path":"/b","ref":"inv-002-2023","tags":"x"}`,
+		`{"path":"/c","ref":"INV-10%-2024","tags":"y"}`,
+		`{"path":"/d","tags":"x"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`ref:like("INV-%-2024")`, []string{"/a", "/c"}},
+		{`ref:like("inv-00_-%")`, []string{"/a", "/b"}}, // case-insensitive
+		{`ref:like("INV-10\\%%")`, []string{"/c"}},
+		{`tags:x AND ref:like("%2023")`, []string{"/b"}},
+		{`tags:x AND NOT ref:like("%2023")`, []string{"/a", "/d"}},
+	}
+	for _, tt := range tests {
+		res, err := ix.Search(ctx, tt.query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, q := range []string{`ref:like("%2024")`, `tags:like("x%")`} {
+		if _, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10}); err == nil {
+			t.Errorf("Search(%q): expected an error", q)
+		}
+	}
+}
+
+func TestTextBoost_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title":  {Type: ministore.FieldText},
+		"body":   {Type: ministore.FieldText},
+		"status": {Type: ministore.FieldKeyword},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/title","title":"rust","body":"other words here","status":"open"}`,
+		`{"path":"/body","title":"other","body":"rust","status":"open"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{"title:rust^10 OR body:rust^0.1", []string{"/title", "/body"}},
+		{"title:rust^0.1 OR body:rust^10", []string{"/body", "/title"}},
+		// A boost on a predicate that does not score changes nothing
+		{"body:rust^10 AND status:open^0.1", []string{"/body"}},
+	} {
+		res, err := ix.Search(ctx, tc.q, ministore.SearchOptions{Limit: 10, Explain: true})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tc.q, err)
+		}
+		if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Search(%q) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	res, err := ix.Search(ctx, "title:rust^2", ministore.SearchOptions{Limit: 10, Explain: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if !reflect.DeepEqual(res.ExplainSteps, []string{"FTS rust^2"}) {
+		t.Errorf("explain steps = %q, want [FTS rust^2]", res.ExplainSteps)
+	}
+}
+
+func TestReload_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+	}}
+	writer, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+
+	reader, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reader.Close()
+
+	// Another handle adds a keyword and a text field
+	wider := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+		"body":  {Type: ministore.FieldText},
+		"tags":  {Type: ministore.FieldKeyword},
+	}}
+	if _, err := writer.ApplySchema(ctx, wider, ministore.ApplySchemaOptions{}); err != nil {
+		t.Fatalf("ApplySchema: %v", err)
+	}
+	if err := writer.PutJSON(ctx, []byte(`{"path":"/1","title":"hello","body":"world","tags":"x"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	if _, err := reader.Search(ctx, "tags:x", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Errorf("search on a field the reader does not know yet succeeded")
+	}
+	if err := reader.Reload(ctx); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, ok := reader.Schema().Fields["body"]; !ok {
+		t.Errorf("reloaded schema = %v, want body", reader.Schema().Fields)
+	}
+	for _, q := range []string{"tags:x", "body:world", "hello"} {
+		res, err := reader.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q) after Reload: %v", q, err)
+		}
+		if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, []string{"/1"}) {
+			t.Errorf("Search(%q) after Reload = %v, want [/1]", q, got)
+		}
+	}
+}
+
+// Run with -race: Reload swaps the schema under searches in flight
+func TestReloadConcurrentSearch_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"title": {Type: ministore.FieldText},
+	}}
+	writer, dbPath := newIndex(t, schema)
+	ctx := context.Background()
+	if err := writer.PutJSON(ctx, []byte(`{"path":"/1","title":"hello"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	reader, err := ministore.Open(ctx, sqlite.New(dbPath), ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reader.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				res, err := reader.Search(ctx, "hello", ministore.SearchOptions{Limit: 10})
+				if err == nil && len(res.Items) != 1 {
+					err = fmt.Errorf("got %d items, want 1", len(res.Items))
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	for range 25 {
+		if err := reader.Reload(ctx); err != nil {
+			t.Errorf("Reload: %v", err)
+			break
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Search during Reload: %v", err)
+	}
+}
+
+func TestAllowUnanchoredOrBranches_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags":   {Type: ministore.FieldKeyword, Multi: true},
+		"status": {Type: ministore.FieldKeyword},
+	}}
+	ctx := context.Background()
+	ix, dbPath := newIndex(t, schema)
+	for _, doc := range []string{
+		`{"path":"/rust","tags":["rust"],"status":"open"}`,
+		`{"path":"/plain","tags":["go"],"status":"open"}`,
+		`{"path":"/archived","tags":["archived"],"status":"open"}`,
+		`{"path":"/closed","tags":["go"],"status":"closed"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if _, err := ix.Search(ctx, "tags:rust OR NOT tags:archived", ministore.SearchOptions{Limit: 10}); !ministore.IsKind(err, ministore.ErrQueryRejected) || !strings.Contains(err.Error(), "AllowUnanchoredOrBranches") {
+		t.Fatalf("default options: err = %v, want a rejection naming the option", err)
+	}
+
+	opts := ministore.DefaultIndexOptions()
+	opts.AllowUnanchoredOrBranches = true
+	loose, err := ministore.Open(ctx, sqlite.New(dbPath), opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer loose.Close()
+	for query, want := range map[string][]string{
+		"status:open AND (tags:rust OR NOT tags:archived)": {"/plain", "/rust"},
+		"tags:rust OR NOT tags:archived":                   {"/rust"},
+		"NOT tags:archived OR tags:rust":                   {"/rust"},
+		"(NOT tags:rust OR tags:go) AND status:closed":     {"/closed"},
+	} {
+		res, err := loose.Search(ctx, query, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", query, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Search(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestPartialDates_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"due": {Type: ministore.FieldDate},
+		"ref": {Type: ministore.FieldKeyword},
+	}}
+	ix, _ := newIndex(t, schema) // created in November 2023
+	ctx := context.Background()
+
+	for _, doc := range []string{
+		`{"path":"/mar","due":"2024-03-05","ref":"2024-03"}`,
+		`{"path":"/apr","due":"2024-04-01"}`,
+		`{"path":"/next","due":"2025-01-01"}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{"due:2024", []string{"/apr", "/mar"}},
+		{"due:2024-03", []string{"/mar"}},
+		{"due:2024-03-05", []string{"/mar"}},
+		{"due:2023", nil},
+		{"created:2023", []string{"/apr", "/mar", "/next"}},
+		{"created:2023-11", []string{"/apr", "/mar", "/next"}},
+		{"created:2024", nil},
+		// Keyword fields keep matching the literal value
+		{"ref:2024-03", []string{"/mar"}},
+	} {
+		res, err := ix.Search(ctx, tc.q, ministore.SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tc.q, err)
+		}
+		got := pathsFromItems(t, res.Items)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Search(%q) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	if _, err := ix.Search(ctx, "due:2024-13", ministore.SearchOptions{Limit: 10}); err == nil {
+		t.Errorf("due:2024-13 was accepted")
+	}
+}
+
+func TestNoTextFieldsError_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"status": {Type: ministore.FieldKeyword},
+		"price":  {Type: ministore.FieldNumber},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/1","status":"open","price":5}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	for _, q := range []string{"hello", "status:open AND hello"} {
+		_, err := ix.Search(ctx, q, ministore.SearchOptions{Limit: 10})
+		if !ministore.IsKind(err, ministore.ErrNoTextFields) {
+			t.Errorf("Search(%q) error = %v, want kind %s", q, err, ministore.ErrNoTextFields)
+		}
+	}
+	if _, err := ix.Facets(ctx, "hello", []string{"status"}, 10); !ministore.IsKind(err, ministore.ErrNoTextFields) {
+		t.Errorf("Facets error = %v, want kind %s", err, ministore.ErrNoTextFields)
+	}
+
+	res, err := ix.Search(ctx, "status:open", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search(status:open): %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); !reflect.DeepEqual(got, []string{"/1"}) {
+		t.Errorf("Search(status:open) = %v, want [/1]", got)
+	}
+}
+
+func TestSearchPageMetadata_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags": {Type: ministore.FieldKeyword},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		doc := fmt.Sprintf(`{"path":"/%d","tags":"a"}`, i)
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+	if err := ix.PutJSON(ctx, []byte(`{"path":"/b","tags":"b"}`)); err != nil {
+		t.Fatalf("PutJSON: %v", err)
+	}
+
+	// Without EstimateTotal a partial page has no total
+	page, err := ix.Search(ctx, "tags:a", ministore.SearchOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if page.Limit != 2 || page.Returned != 2 || page.EstimatedTotal != nil {
+		t.Errorf("page = limit %d, returned %d, total %v; want 2, 2, nil", page.Limit, page.Returned, page.EstimatedTotal)
+	}
+
+	// A first page holding every result knows its total for free
+	page, err = ix.Search(ctx, "tags:b", ministore.SearchOptions{})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if page.Limit != ministore.DefaultSearchLimit || page.Returned != 1 || page.EstimatedTotal == nil || *page.EstimatedTotal != 1 {
+		t.Errorf("page = limit %d, returned %d, total %v; want %d, 1, 1", page.Limit, page.Returned, page.EstimatedTotal, ministore.DefaultSearchLimit)
+	}
+
+	// With EstimateTotal every page counts the whole result
+	opts := ministore.SearchOptions{Limit: 2, EstimateTotal: true}
+	var returned []int
+	for {
+		page, err := ix.Search(ctx, "tags:a", opts)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if page.EstimatedTotal == nil || *page.EstimatedTotal != 5 {
+			t.Fatalf("EstimatedTotal = %v, want 5", page.EstimatedTotal)
+		}
+		returned = append(returned, page.Returned)
+		if !page.HasMore {
+			break
+		}
+		opts.After = page.NextCursor
+	}
+	if !reflect.DeepEqual(returned, []int{2, 2, 1}) {
+		t.Errorf("returned per page = %v, want [2 2 1]", returned)
+	}
+
+	if _, err := ix.Delete(ctx, "/0"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	page, err = ix.Search(ctx, "tags:a", ministore.SearchOptions{Limit: 2, EstimateTotal: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if page.EstimatedTotal == nil || *page.EstimatedTotal != 4 {
+		t.Errorf("EstimatedTotal after delete = %v, want 4", page.EstimatedTotal)
+	}
+}
+
+func TestRelatedValues_SQLite(t *testing.T) {
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags":   {Type: ministore.FieldKeyword, Multi: true, CaseFold: true},
+		"status": {Type: ministore.FieldKeyword},
+	}}
+	ix, _ := newIndex(t, schema)
+	ctx := context.Background()
+	for _, doc := range []string{
+		`{"path":"/1","tags":["rust","cli","async"],"status":"open"}`,
+		`{"path":"/2","tags":["Rust","cli"]}`,
+		`{"path":"/3","tags":["rust","web"]}`,
+		`{"path":"/4","tags":["go","cli"]}`,
+	} {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON: %v", err)
+		}
+	}
+
+	got, err := ix.RelatedValues(ctx, "tags", "RUST", 10)
+	if err != nil {
+		t.Fatalf("RelatedValues: %v", err)
+	}
+	want := []ministore.ValueCount{{Value: "cli", Count: 2}, {Value: "async", Count: 1}, {Value: "web", Count: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RelatedValues(tags, RUST) = %v, want %v", got, want)
+	}
+
+	if got, err = ix.RelatedValues(ctx, "tags", "rust", 1); err != nil || len(got) != 1 || got[0].Value != "cli" {
+		t.Errorf("RelatedValues top 1 = %v, %v; want [cli]", got, err)
+	}
+	if got, err = ix.RelatedValues(ctx, "tags", "unknown", 10); err != nil || len(got) != 0 {
+		t.Errorf("RelatedValues(unknown) = %v, %v; want none", got, err)
+	}
+	if _, err := ix.RelatedValues(ctx, "nope", "rust", 10); err == nil {
+		t.Errorf("RelatedValues on an unknown field succeeded")
+	}
+}
+
+func TestCanonicalizeJSON_SQLite(t *testing.T) {
+	ctx := context.Background()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags": {Type: ministore.FieldKeyword, Multi: true},
+	}}
+	opts := ministore.DefaultIndexOptions()
+	opts.Now = monotonicNow(time.Unix(1700000000, 0))
+	opts.CanonicalizeJSON = true
+	ix, err := ministore.Create(ctx, sqlite.New(filepath.Join(t.TempDir(), "test.db")), schema, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+
+	docs := map[string]string{
+		"/a": `{"path":"/a","tags":["x","y"],"meta":{"b":2,"a":1.50},"n":10,"big":12345678901234567890,"s":"<&>"}`,
+		"/b": "{\n  \"s\": \"<&>\",\n  \"big\": 12345678901234567890,\n  \"n\": 10,\n  \"meta\": {\"a\": 1.50, \"b\": 2},\n  \"tags\": [\"x\", \"y\"],\n  \"path\": \"/b\"\n}",
+	}
+	stored := map[string]string{}
+	for path, doc := range docs {
+		if err := ix.PutJSON(ctx, []byte(doc)); err != nil {
+			t.Fatalf("PutJSON(%s): %v", path, err)
+		}
+		var data string
+		if err := ix.DB().QueryRowContext(ctx, "SELECT data_json FROM items WHERE path = ?", path).Scan(&data); err != nil {
+			t.Fatalf("read row: %v", err)
+		}
+		stored[path] = strings.Replace(data, `"path":"`+path+`"`, `"path":"?"`, 1)
+	}
+
+	want := `{"big":12345678901234567890,"meta":{"a":1.50,"b":2},"n":10,"path":"?","s":"<&>","tags":["x","y"]}`
+	for path, got := range stored {
+		if got != want {
+			t.Errorf("stored %s = %s, want %s", path, got, want)
+		}
+	}
+
+	item, err := ix.Get(ctx, "/b")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !strings.HasPrefix(string(item.DocJSON), `{"big":12345678901234567890,`) {
+		t.Errorf("Get /b = %s, want canonical bytes", item.DocJSON)
+	}
+	res, err := ix.Search(ctx, "tags:y", ministore.SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := pathsFromItems(t, res.Items); len(got) != 2 {
+		t.Errorf("Search(tags:y) = %v, want both items", got)
+	}
+}
@@ -0,0 +1,234 @@
+package ministore
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Observer receives a callback after each instrumented Index operation, for
+// counting and timing them. Callbacks run synchronously on the calling
+// goroutine, so they must be quick and safe for concurrent use. Embed
+// NopObserver to implement only some of them.
+type Observer interface {
+	// OnSearch is called after Search and RunSavedQuery
+	OnSearch(e SearchEvent)
+	// OnPut is called after PutJSON, PutFields, PutIfUnchanged and Update;
+	// path is empty if the document was rejected before its path was read
+	OnPut(path string, d time.Duration, err error)
+	// OnDelete is called after Delete
+	OnDelete(path string, d time.Duration, err error)
+	// OnDeleteWhere is called after DeleteWhere and DeleteWhereBatched with
+	// the number of items deleted
+	OnDeleteWhere(query string, n int, d time.Duration, err error)
+	// OnBatch is called after Batch (and so for each Import batch) and
+	// DeleteMany with the number of operations applied
+	OnBatch(n int, d time.Duration, err error)
+}
+
+// SearchEvent describes one completed search
+type SearchEvent struct {
+	Query    string
+	Duration time.Duration
+	Rows     int  // items on the returned page
+	FTS      bool // the query has text predicates, run through full-text search
+	Cached   bool // served from the result cache
+	Err      error
+}
+
+// NopObserver ignores every callback. It is used when IndexOptions.Observer
+// is nil.
+type NopObserver struct{}
+
+func (NopObserver) OnSearch(SearchEvent)                            {}
+func (NopObserver) OnPut(string, time.Duration, error)              {}
+func (NopObserver) OnDelete(string, time.Duration, error)           {}
+func (NopObserver) OnDeleteWhere(string, int, time.Duration, error) {}
+func (NopObserver) OnBatch(int, time.Duration, error)               {}
+
+// MetricsObserver counts and times operations in memory and writes them in
+// the Prometheus text exposition format, so an HTTP handler can serve them
+// without a client library:
+//
+//	m := ministore.NewMetricsObserver()
+//	opts.Observer = m
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//		m.WritePrometheus(w)
+//	})
+type MetricsObserver struct {
+	mu       sync.Mutex
+	ops      map[metricKey]*opStat
+	searches map[searchKey]uint64
+}
+
+type metricKey struct {
+	op     string
+	failed bool
+}
+
+type opStat struct {
+	count uint64
+	sum   time.Duration
+	items uint64
+}
+
+type searchKey struct {
+	fts, cached bool
+}
+
+var _ Observer = (*MetricsObserver)(nil)
+
+// NewMetricsObserver returns an empty MetricsObserver
+func NewMetricsObserver() *MetricsObserver {
+	return &MetricsObserver{
+		ops:      make(map[metricKey]*opStat),
+		searches: make(map[searchKey]uint64),
+	}
+}
+
+func (m *MetricsObserver) record(op string, d time.Duration, items int, err error) {
+	key := metricKey{op: op, failed: err != nil}
+	s := m.ops[key]
+	if s == nil {
+		s = &opStat{}
+		m.ops[key] = s
+	}
+	s.count++
+	s.sum += d
+	if items > 0 {
+		s.items += uint64(items)
+	}
+}
+
+func (m *MetricsObserver) OnSearch(e SearchEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("search", e.Duration, e.Rows, e.Err)
+	if e.Err == nil {
+		m.searches[searchKey{fts: e.FTS, cached: e.Cached}]++
+	}
+}
+
+func (m *MetricsObserver) OnPut(_ string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("put", d, 1, err)
+}
+
+func (m *MetricsObserver) OnDelete(_ string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("delete", d, 1, err)
+}
+
+func (m *MetricsObserver) OnDeleteWhere(_ string, n int, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("delete_where", d, n, err)
+}
+
+func (m *MetricsObserver) OnBatch(n int, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("batch", d, n, err)
+}
+
+// WritePrometheus writes the counters in the Prometheus text format:
+// ministore_operations_total and ministore_operation_items_total by op and
+// status, ministore_operation_duration_seconds (sum and count) by op, and
+// ministore_searches_total by query path (fts or filter) and cache use
+func (m *MetricsObserver) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	keys := make([]metricKey, 0, len(m.ops))
+	stats := make(map[metricKey]opStat, len(m.ops))
+	for k, s := range m.ops {
+		keys = append(keys, k)
+		stats[k] = *s
+	}
+	searches := make(map[searchKey]uint64, len(m.searches))
+	for k, n := range m.searches {
+		searches[k] = n
+	}
+	m.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return !keys[i].failed && keys[j].failed
+	})
+	status := func(k metricKey) string {
+		if k.failed {
+			return "error"
+		}
+		return "ok"
+	}
+
+	var out []string
+	out = append(out,
+		"# HELP ministore_operations_total Index operations by kind and outcome.",
+		"# TYPE ministore_operations_total counter")
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf(`ministore_operations_total{op=%q,status=%q} %d`, k.op, status(k), stats[k].count))
+	}
+	out = append(out,
+		"# HELP ministore_operation_items_total Items returned, written or deleted by operations.",
+		"# TYPE ministore_operation_items_total counter")
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf(`ministore_operation_items_total{op=%q,status=%q} %d`, k.op, status(k), stats[k].items))
+	}
+
+	// The duration summary is per op, over both outcomes
+	out = append(out,
+		"# HELP ministore_operation_duration_seconds Time spent in index operations.",
+		"# TYPE ministore_operation_duration_seconds summary")
+	for i := 0; i < len(keys); {
+		op := keys[i].op
+		var count uint64
+		var sum time.Duration
+		for ; i < len(keys) && keys[i].op == op; i++ {
+			count += stats[keys[i]].count
+			sum += stats[keys[i]].sum
+		}
+		out = append(out,
+			fmt.Sprintf(`ministore_operation_duration_seconds_sum{op=%q} %s`, op, strconv.FormatFloat(sum.Seconds(), 'g', -1, 64)),
+			fmt.Sprintf(`ministore_operation_duration_seconds_count{op=%q} %d`, op, count))
+	}
+
+	out = append(out,
+		"# HELP ministore_searches_total Successful searches by query path and cache use.",
+		"# TYPE ministore_searches_total counter")
+	for _, fts := range []bool{true, false} {
+		for _, cached := range []bool{false, true} {
+			path := "filter"
+			if fts {
+				path = "fts"
+			}
+			out = append(out, fmt.Sprintf(`ministore_searches_total{path=%q,cached="%t"} %d`, path, cached, searches[searchKey{fts: fts, cached: cached}]))
+		}
+	}
+
+	for _, line := range out {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observer returns IndexOptions.Observer, or a NopObserver
+func (ix *Index) observer() Observer {
+	if ix.opts.Observer == nil {
+		return NopObserver{}
+	}
+	return ix.opts.Observer
+}
+
+// observePut reports a put; it is deferred with pointers so that it sees
+// the path once the document is parsed, and the final error
+func (ix *Index) observePut(path *string, start time.Time, err *error) {
+	ix.observer().OnPut(*path, time.Since(start), *err)
+}
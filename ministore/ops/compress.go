@@ -0,0 +1,103 @@
+package ops
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/ministore/ministore/ministore/query"
+	"github.com/ministore/ministore/ministore/storage"
+)
+
+// DocEncGzip is the items.data_enc marker of a gzip-compressed data_json.
+// A NULL data_enc is plain JSON, so compressed and uncompressed rows can
+// live side by side.
+const DocEncGzip = "gzip"
+
+// CompressDoc gzips a document for storage in data_json
+func CompressDoc(doc []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(doc); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeDoc returns the JSON document held in data_json, given its data_enc
+// ("" for plain JSON)
+func DecodeDoc(data []byte, enc string) ([]byte, error) {
+	switch enc {
+	case "":
+		return data, nil
+	case DocEncGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("decompress document: %w", err)
+		}
+		doc, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("decompress document: %w", err)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown document encoding %q", enc)
+	}
+}
+
+// CheckLike rejects like(...) predicates in expr with a *LikeError while any
+// stored document is compressed. like matches data_json in SQL, which cannot read a gzip
+// row, so it would silently miss them; rows written before CompressDocs was
+// turned off keep their encoding until rewritten. Only SQLite compresses.
+func CheckLike(ctx context.Context, db *sql.DB, adapter storage.Adapter, expr query.Expr) error {
+	field, ok := firstLikeField(expr)
+	if !ok || adapter.Backend() != storage.BackendSQLite {
+		return nil
+	}
+	var one int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM items WHERE data_enc IS NOT NULL LIMIT 1").Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check compressed documents: %w", err)
+	}
+	return &LikeError{Field: field}
+}
+
+// LikeError rejects a like(...) predicate the index cannot evaluate
+type LikeError struct {
+	Field string
+}
+
+func (e *LikeError) Error() string {
+	return fmt.Sprintf("%s:like(...) is not supported on this index: stored documents are compressed", e.Field)
+}
+
+func firstLikeField(expr query.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case query.And:
+		if f, ok := firstLikeField(e.Left); ok {
+			return f, true
+		}
+		return firstLikeField(e.Right)
+	case query.Or:
+		if f, ok := firstLikeField(e.Left); ok {
+			return f, true
+		}
+		return firstLikeField(e.Right)
+	case query.Not:
+		return firstLikeField(e.Inner)
+	case query.Pred:
+		if p, ok := e.Predicate.(query.LikePattern); ok {
+			return p.Field, true
+		}
+	}
+	return "", false
+}
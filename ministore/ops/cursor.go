@@ -18,17 +18,39 @@ const shortCursorPrefix = "c:"
 
 // DBCursorStore implements CursorStore backed by database
 type DBCursorStore struct {
-	db   *sql.DB
-	sqlt storage.SQL
-	ttl  time.Duration
+	db       *sql.DB
+	sqlt     storage.SQL
+	ttl      time.Duration
+	now      func() time.Time
+	readOnly bool
 }
 
-// NewDBCursorStore creates a new database-backed cursor store
-func NewDBCursorStore(db *sql.DB, sqlt storage.SQL, ttl time.Duration) *DBCursorStore {
+// NewDBCursorStore creates a new database-backed cursor store. Cursor
+// creation and expiry are timed with now; nil means time.Now.
+func NewDBCursorStore(db *sql.DB, sqlt storage.SQL, ttl time.Duration, now func() time.Time) *DBCursorStore {
+	if now == nil {
+		now = time.Now
+	}
 	return &DBCursorStore{
 		db:   db,
 		sqlt: sqlt,
 		ttl:  ttl,
+		now:  now,
+	}
+}
+
+// NewReadOnlyDBCursorStore creates a cursor store that resolves existing
+// short cursors but never writes: Store always returns full tokens and
+// CleanupExpired does nothing
+func NewReadOnlyDBCursorStore(db *sql.DB, sqlt storage.SQL, now func() time.Time) *DBCursorStore {
+	if now == nil {
+		now = time.Now
+	}
+	return &DBCursorStore{
+		db:       db,
+		sqlt:     sqlt,
+		now:      now,
+		readOnly: true,
 	}
 }
 
@@ -46,7 +68,7 @@ func (s *DBCursorStore) Resolve(ctx context.Context, token string) (*CursorPaylo
 
 // Store stores a cursor payload and returns a token
 func (s *DBCursorStore) Store(ctx context.Context, payload CursorPayload, mode CursorMode) (string, error) {
-	if mode == CursorShort {
+	if mode == CursorShort && !s.readOnly {
 		return s.storeShort(ctx, payload)
 	}
 	return s.storeFull(payload)
@@ -54,7 +76,10 @@ func (s *DBCursorStore) Store(ctx context.Context, payload CursorPayload, mode C
 
 // CleanupExpired removes expired cursors
 func (s *DBCursorStore) CleanupExpired(ctx context.Context) error {
-	nowMS := time.Now().UnixMilli()
+	if s.readOnly {
+		return nil
+	}
+	nowMS := s.now().UnixMilli()
 	_, err := s.db.ExecContext(ctx, s.sqlt.CleanupExpiredCursors, nowMS)
 	return err
 }
@@ -70,8 +95,8 @@ func (s *DBCursorStore) resolveShort(ctx context.Context, handle string) (*Curso
 		return nil, fmt.Errorf("query cursor: %w", err)
 	}
 
-	// Check expiration
-	if time.Now().UnixMilli() > expiresAt {
+	// Check expiration; a cursor is valid for less than its TTL
+	if s.now().UnixMilli() >= expiresAt {
 		return nil, fmt.Errorf("cursor expired")
 	}
 
@@ -111,7 +136,7 @@ func (s *DBCursorStore) storeShort(ctx context.Context, payload CursorPayload) (
 		return "", fmt.Errorf("marshal payload: %w", err)
 	}
 
-	nowMS := time.Now().UnixMilli()
+	nowMS := s.now().UnixMilli()
 	expiresAtMS := nowMS + s.ttl.Milliseconds()
 
 	_, err = s.db.ExecContext(ctx, s.sqlt.PutCursor, handle, string(payloadJSON), nowMS, expiresAtMS)
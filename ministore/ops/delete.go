@@ -10,16 +10,58 @@ import (
 
 // DeleteByItemID deletes an item and all its index entries by item ID
 func DeleteByItemID(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, itemID int64) error {
+	if err := deleteIndexEntries(ctx, tx, sqlt, fts, itemID); err != nil {
+		return err
+	}
+
+	// 5. Delete items row
+	if _, err := tx.ExecContext(ctx, sqlt.DeleteItemsByID, itemID); err != nil {
+		return storage.WrapSQL("delete item", sqlt.DeleteItemsByID, 1, err)
+	}
+
+	return nil
+}
+
+// SoftDeleteByItemID removes all index entries for an item and marks it
+// deleted, keeping the items row (and its data_json) for RestoreByItemID
+func SoftDeleteByItemID(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, itemID int64, nowMS int64) error {
+	if err := deleteIndexEntries(ctx, tx, sqlt, fts, itemID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlt.SoftDeleteItem, itemID, nowMS); err != nil {
+		return storage.WrapSQL("mark item deleted", sqlt.SoftDeleteItem, 2, err)
+	}
+	return nil
+}
+
+// RestoreByItemID re-indexes a soft-deleted item from prep (built from its
+// stored data_json) and clears its deleted mark. It fails with a *FieldError
+// when another item took the upsert key while this one was deleted.
+func RestoreByItemID(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, schema storage.Schema, prep *PutPrepared, itemID int64) error {
+	if err := checkUpsertKeyFree(ctx, tx, sqlt, prep); err != nil {
+		return err
+	}
+	if err := writeIndexRows(ctx, tx, sqlt, fts, schema, prep, itemID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlt.RestoreItem, itemID); err != nil {
+		return storage.WrapSQL("restore item", sqlt.RestoreItem, 1, err)
+	}
+	return nil
+}
+
+// deleteIndexEntries removes every index row for an item, maintaining doc_freq
+func deleteIndexEntries(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, itemID int64) error {
 	// 1. Load value_ids from postings for doc_freq maintenance
 	valueIDs, err := loadOldValueIDs(ctx, tx, sqlt, itemID)
 	if err != nil {
-		return fmt.Errorf("load value_ids: %w", err)
+		return err
 	}
 
 	// 2. Decrement doc_freq for each value_id (clamped at 0 for safety)
 	for valueID := range valueIDs {
 		if _, err := tx.ExecContext(ctx, sqlt.DecrementDocFreq, valueID); err != nil {
-			return fmt.Errorf("decrement doc_freq: %w", err)
+			return storage.WrapSQL("decrement doc_freq", sqlt.DecrementDocFreq, 1, err)
 		}
 	}
 
@@ -32,12 +74,13 @@ func DeleteByItemID(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts stora
 		{sqlt.DeleteNumberByItem, "numbers"},
 		{sqlt.DeleteDateByItem, "dates"},
 		{sqlt.DeleteBoolByItem, "bools"},
+		{sqlt.DeleteGeoByItem, "geo points"},
 		{sqlt.DeletePresentByItem, "present"},
 	}
 
 	for _, q := range queries {
 		if _, err := tx.ExecContext(ctx, q.sql, itemID); err != nil {
-			return fmt.Errorf("delete %s: %w", q.name, err)
+			return storage.WrapSQL("delete "+q.name, q.sql, 1, err)
 		}
 	}
 
@@ -46,11 +89,6 @@ func DeleteByItemID(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts stora
 		return fmt.Errorf("delete FTS: %w", err)
 	}
 
-	// 5. Delete items row
-	if _, err := tx.ExecContext(ctx, sqlt.DeleteItemsByID, itemID); err != nil {
-		return fmt.Errorf("delete item: %w", err)
-	}
-
 	return nil
 }
 
@@ -64,7 +102,7 @@ func DeleteByPath(ctx context.Context, db *sql.DB, sqlt storage.SQL, fts storage
 		return false, nil
 	}
 	if err != nil {
-		return false, fmt.Errorf("find item: %w", err)
+		return false, storage.WrapSQL("find item", sqlt.FindItemIDByPath, 1, err)
 	}
 
 	// Delete in transaction
@@ -85,66 +123,121 @@ func DeleteByPath(ctx context.Context, db *sql.DB, sqlt storage.SQL, fts storage
 	return true, nil
 }
 
-// DeleteWhere deletes all items matching a compiled query
-// Returns the number of items deleted
-func DeleteWhere(ctx context.Context, db *sql.DB, sqlt storage.SQL, fts storage.FTS, resultCTE string, cteParts []string, args []any) (int, error) {
-	// Build the query to get item_ids
-	var withClause string
-	if len(cteParts) > 0 {
-		withClause = "WITH " + joinComma(cteParts) + " "
-	}
-	selectSQL := fmt.Sprintf("%sSELECT item_id FROM %s", withClause, resultCTE)
-
+// DeleteWhere deletes all live items selected by selectSQL, a compiled query
+// returning item_ids; soft-deleted items are kept for Restore. If committed is non-nil it is called with the paths of
+// the deleted items once the deletion has committed. Returns the number of
+// items deleted
+func DeleteWhere(ctx context.Context, db *sql.DB, sqlt storage.SQL, fts storage.FTS, selectSQL string, args []any, committed func(paths []string) error) (int, error) {
 	// Execute query to get all matching item_ids
-	rows, err := db.QueryContext(ctx, selectSQL, args...)
+	stmt := fmt.Sprintf("SELECT id, path FROM items WHERE deleted_at IS NULL AND id IN (%s)", selectSQL)
+	rows, err := db.QueryContext(ctx, stmt, args...)
 	if err != nil {
-		return 0, fmt.Errorf("execute query: %w", err)
+		return 0, storage.WrapSQL("execute query", stmt, len(args), err)
+	}
+	itemIDs, paths, err := scanItemIDs(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(itemIDs) == 0 {
+		return 0, nil
 	}
-	defer rows.Close()
 
+	// Delete each item in a transaction
+	if err := deleteItemIDs(ctx, db, sqlt, fts, itemIDs); err != nil {
+		return 0, err
+	}
+	if committed != nil {
+		if err := committed(paths); err != nil {
+			return len(itemIDs), err
+		}
+	}
+
+	return len(itemIDs), nil
+}
+
+// DeleteWhereBatched deletes the items selected by selectSQL like DeleteWhere,
+// but reads and deletes at most batchSize item_ids per transaction, keyed by
+// item_id, so a large sweep never holds every id in memory. committed, if
+// non-nil, is called after each batch commits. It returns the number of items
+// deleted; on error, the batches already committed stay deleted and are
+// counted.
+func DeleteWhereBatched(ctx context.Context, db *sql.DB, adapter storage.Adapter, selectSQL string, args []any, batchSize int, committed func(paths []string) error) (int, error) {
+	sqlt, fts := adapter.SQL(), adapter.FTS()
+	style := adapter.PlaceholderStyle()
+	base := len(args)
+	stmt := fmt.Sprintf(`SELECT id, path FROM items
+WHERE deleted_at IS NULL AND id IN (%s) AND id > %s
+ORDER BY id LIMIT %s`, selectSQL, ph(style, base+1), ph(style, base+2))
+
+	n := 0
+	var lastID int64
+	for {
+		pageArgs := append(append([]any(nil), args...), lastID, batchSize)
+		rows, err := db.QueryContext(ctx, stmt, pageArgs...)
+		if err != nil {
+			return n, storage.WrapSQL("execute query", stmt, len(pageArgs), err)
+		}
+		itemIDs, paths, err := scanItemIDs(rows)
+		if err != nil {
+			return n, err
+		}
+		if len(itemIDs) == 0 {
+			return n, nil
+		}
+
+		if err := deleteItemIDs(ctx, db, sqlt, fts, itemIDs); err != nil {
+			return n, err
+		}
+		n += len(itemIDs)
+		if committed != nil {
+			if err := committed(paths); err != nil {
+				return n, err
+			}
+		}
+		if len(itemIDs) < batchSize {
+			return n, nil
+		}
+		lastID = itemIDs[len(itemIDs)-1]
+	}
+}
+
+// scanItemIDs reads (id, path) rows and closes them
+func scanItemIDs(rows *sql.Rows) ([]int64, []string, error) {
+	defer rows.Close()
 	var itemIDs []int64
+	var paths []string
 	for rows.Next() {
 		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return 0, fmt.Errorf("scan item_id: %w", err)
+		var path string
+		if err := rows.Scan(&id, &path); err != nil {
+			return nil, nil, fmt.Errorf("scan item_id: %w", err)
 		}
 		itemIDs = append(itemIDs, id)
+		paths = append(paths, path)
 	}
 	if err := rows.Err(); err != nil {
-		return 0, fmt.Errorf("iterate rows: %w", err)
-	}
-
-	if len(itemIDs) == 0 {
-		return 0, nil
+		return nil, nil, fmt.Errorf("iterate rows: %w", err)
 	}
+	return itemIDs, paths, nil
+}
 
-	// Delete each item in a transaction
+// deleteItemIDs deletes itemIDs in one transaction
+func deleteItemIDs(ctx context.Context, db *sql.DB, sqlt storage.SQL, fts storage.FTS, itemIDs []int64) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("begin transaction: %w", err)
+		return fmt.Errorf("begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	for _, itemID := range itemIDs {
 		if err := DeleteByItemID(ctx, tx, sqlt, fts, itemID); err != nil {
-			return 0, fmt.Errorf("delete item %d: %w", itemID, err)
+			return fmt.Errorf("delete item %d: %w", itemID, err)
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("commit: %w", err)
-	}
-
-	return len(itemIDs), nil
-}
-
-func joinComma(parts []string) string {
-	result := ""
-	for i, p := range parts {
-		if i > 0 {
-			result += ", "
-		}
-		result += p
+		return fmt.Errorf("commit: %w", err)
 	}
-	return result
+	return nil
 }
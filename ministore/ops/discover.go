@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
+	"github.com/ministore/ministore/ministore/query"
 	"github.com/ministore/ministore/ministore/storage"
 	"github.com/ministore/ministore/ministore/storage/sqlbuilder"
 )
@@ -15,6 +17,15 @@ type ValueCount struct {
 	Count uint64
 }
 
+// ValuesPage is the top values of a field. With a total requested,
+// TotalDistinct counts every distinct value over the same items and HasMore
+// reports whether Values leaves some out.
+type ValuesPage struct {
+	Values        []ValueCount
+	TotalDistinct uint64
+	HasMore       bool
+}
+
 // FieldOverview provides information about a field
 type FieldOverview struct {
 	Field    string
@@ -33,15 +44,17 @@ func ph(style sqlbuilder.PlaceholderStyle, n int) string {
 	return "?"
 }
 
-// DiscoverValues returns top keyword values for a field
-func DiscoverValues(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field string, whereSQL string, whereArgs []any, top int) ([]ValueCount, error) {
+// DiscoverValues returns top keyword values for a field. withTotal also
+// counts the distinct values, which costs a second query when the top list
+// is full.
+func DiscoverValues(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field string, whereSQL string, whereArgs []any, top int, withTotal bool) (ValuesPage, error) {
 	// Validate field exists and is keyword
 	spec, ok := schema.Get(field)
 	if !ok {
-		return nil, fmt.Errorf("unknown field: %s", field)
+		return ValuesPage{}, fmt.Errorf("unknown field: %s", field)
 	}
 	if spec.Type != storage.FieldType("keyword") {
-		return nil, fmt.Errorf("field %s is not a keyword field (type: %s)", field, spec.Type)
+		return ValuesPage{}, fmt.Errorf("field %s is not a keyword field (type: %s)", field, spec.Type)
 	}
 
 	if top <= 0 {
@@ -58,7 +71,7 @@ func DiscoverValues(ctx context.Context, db *sql.DB, adapter storage.Adapter, sc
 		querySQL = fmt.Sprintf(`
 			SELECT d.value, d.doc_freq
 			FROM kw_dict d
-			WHERE d.field = %s
+			WHERE d.field = %s AND d.doc_freq > 0
 			ORDER BY d.doc_freq DESC, d.value ASC
 			LIMIT %s
 		`, ph(style, 1), ph(style, 2))
@@ -82,7 +95,198 @@ func DiscoverValues(ctx context.Context, db *sql.DB, adapter storage.Adapter, sc
 
 	rows, err := db.QueryContext(ctx, querySQL, args...)
 	if err != nil {
-		return nil, fmt.Errorf("query values: %w", err)
+		return ValuesPage{}, fmt.Errorf("query values: %w", err)
+	}
+	defer rows.Close()
+
+	var page ValuesPage
+	for rows.Next() {
+		var vc ValueCount
+		if err := rows.Scan(&vc.Value, &vc.Count); err != nil {
+			return ValuesPage{}, fmt.Errorf("scan value: %w", err)
+		}
+		page.Values = append(page.Values, vc)
+	}
+	if err := rows.Err(); err != nil {
+		return ValuesPage{}, err
+	}
+	rows.Close()
+
+	if !withTotal {
+		return page, nil
+	}
+	if len(page.Values) < top {
+		page.TotalDistinct = uint64(len(page.Values))
+		return page, nil
+	}
+	total, err := countDistinctValues(ctx, db, style, field, whereSQL, whereArgs)
+	if err != nil {
+		return ValuesPage{}, err
+	}
+	page.TotalDistinct = total
+	page.HasMore = total > uint64(len(page.Values))
+	return page, nil
+}
+
+// countDistinctValues counts the distinct values of field held by the items
+// selected by whereSQL, or by any live item when whereSQL is empty
+func countDistinctValues(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, field string, whereSQL string, whereArgs []any) (uint64, error) {
+	var q string
+	var args []any
+	if whereSQL == "" {
+		q = fmt.Sprintf(`SELECT COUNT(*) FROM kw_dict WHERE field = %s AND doc_freq > 0`, ph(style, 1))
+		args = []any{field}
+	} else {
+		q = fmt.Sprintf(`
+			WITH filtered AS (%s)
+			SELECT COUNT(DISTINCT d.id)
+			FROM kw_dict d
+			JOIN kw_postings p ON p.value_id = d.id
+			JOIN filtered f ON f.item_id = p.item_id
+			WHERE d.field = %s
+		`, whereSQL, ph(style, len(whereArgs)+1))
+		args = append(append([]any{}, whereArgs...), field)
+	}
+	var n int64
+	if err := db.QueryRowContext(ctx, q, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count values: %w", err)
+	}
+	return uint64(n), nil
+}
+
+// Facets returns the top keyword values of each field, counted over the items
+// selected by whereSQL (every item when whereSQL is empty). The filter runs
+// once and all fields are aggregated in a single statement. Values are ordered
+// by count desc, then value asc; every requested field has an entry, empty if
+// no item has a value for it.
+func Facets(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, fields []string, whereSQL string, whereArgs []any, topPerField int) (map[string][]ValueCount, error) {
+	result := make(map[string][]ValueCount, len(fields))
+	var unique []string
+	for _, field := range fields {
+		spec, ok := schema.Get(field)
+		if !ok {
+			return nil, fmt.Errorf("unknown field: %s", field)
+		}
+		if spec.Type != storage.FieldType("keyword") {
+			return nil, fmt.Errorf("field %s is not a keyword field (type: %s)", field, spec.Type)
+		}
+		if _, seen := result[field]; !seen {
+			result[field] = []ValueCount{}
+			unique = append(unique, field)
+		}
+	}
+	if len(unique) == 0 {
+		return result, nil
+	}
+
+	if topPerField <= 0 {
+		topPerField = 20
+	}
+
+	style := adapter.PlaceholderStyle()
+	args := append([]any{}, whereArgs...)
+	fieldPHs := make([]string, len(unique))
+	for i, field := range unique {
+		args = append(args, field)
+		fieldPHs[i] = ph(style, len(args))
+	}
+	args = append(args, topPerField)
+	topPH := ph(style, len(args))
+
+	var counts string
+	if whereSQL == "" {
+		counts = fmt.Sprintf(`
+			WITH counts AS (
+				SELECT d.field, d.value, d.doc_freq AS cnt
+				FROM kw_dict d
+				WHERE d.field IN (%s) AND d.doc_freq > 0
+			)`, strings.Join(fieldPHs, ", "))
+	} else {
+		counts = fmt.Sprintf(`
+			WITH filtered AS (%s),
+			counts AS (
+				SELECT d.field, d.value, COUNT(DISTINCT p.item_id) AS cnt
+				FROM kw_dict d
+				JOIN kw_postings p ON p.value_id = d.id
+				JOIN filtered f ON f.item_id = p.item_id
+				WHERE d.field IN (%s)
+				GROUP BY d.field, d.value
+			)`, whereSQL, strings.Join(fieldPHs, ", "))
+	}
+	querySQL := counts + fmt.Sprintf(`,
+		ranked AS (
+			SELECT field, value, cnt,
+				ROW_NUMBER() OVER (PARTITION BY field ORDER BY cnt DESC, value ASC) AS rn
+			FROM counts
+		)
+		SELECT field, value, cnt
+		FROM ranked
+		WHERE rn <= %s
+		ORDER BY field ASC, cnt DESC, value ASC
+	`, topPH)
+
+	rows, err := db.QueryContext(ctx, querySQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query facets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var field string
+		var vc ValueCount
+		if err := rows.Scan(&field, &vc.Value, &vc.Count); err != nil {
+			return nil, fmt.Errorf("scan facet: %w", err)
+		}
+		result[field] = append(result[field], vc)
+	}
+	return result, rows.Err()
+}
+
+// RelatedValues returns the top values of a keyword field that co-occur with
+// value on the same items, by the number of items holding both: for tags,
+// "items tagged rust are also tagged cli, async, ...". value itself is left
+// out, and is matched the way an exact keyword predicate is, so case_fold
+// fields find every casing of it. Values are ordered by count desc, then
+// value asc.
+func RelatedValues(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field, value string, top int) ([]ValueCount, error) {
+	spec, ok := schema.Get(field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field: %s", field)
+	}
+	if spec.Type != storage.FieldType("keyword") {
+		return nil, fmt.Errorf("field %s is not a keyword field (type: %s)", field, spec.Type)
+	}
+	if top <= 0 {
+		top = 20
+	}
+
+	valueCol := "value"
+	value = storage.NormalizeKeyword(spec.Normalizer, value)
+	if spec.CaseFold {
+		valueCol = "value_folded"
+		value = storage.FoldKeyword(value)
+	}
+
+	// Self-join kw_postings on item_id: the items holding value, then every
+	// other value of the field on those items
+	style := adapter.PlaceholderStyle()
+	q := fmt.Sprintf(`
+		SELECT d2.value, COUNT(DISTINCT p2.item_id) AS cnt
+		FROM kw_dict d1
+		JOIN kw_postings p1 ON p1.value_id = d1.id
+		JOIN kw_postings p2 ON p2.item_id = p1.item_id
+		JOIN kw_dict d2 ON d2.id = p2.value_id
+		WHERE d1.field = %s AND d1.%s = %s
+			AND d2.field = %s AND d2.%s <> %s
+		GROUP BY d2.value
+		ORDER BY cnt DESC, d2.value ASC
+		LIMIT %s
+	`, ph(style, 1), valueCol, ph(style, 2), ph(style, 3), valueCol, ph(style, 4), ph(style, 5))
+	args := []any{field, value, field, value, top}
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, storage.WrapFieldSQL("query related values", field, q, len(args), err)
 	}
 	defer rows.Close()
 
@@ -94,10 +298,75 @@ func DiscoverValues(ctx context.Context, db *sql.DB, adapter storage.Adapter, sc
 		}
 		result = append(result, vc)
 	}
+	return result, rows.Err()
+}
 
+// KeywordValuesPage returns up to limit values of a keyword field with their
+// doc_freq, ordered by value and starting after afterValue ("" for the first
+// page). Values no longer used by any item are skipped.
+func KeywordValuesPage(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field string, afterValue string, limit int) ([]ValueCount, error) {
+	spec, ok := schema.Get(field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field: %s", field)
+	}
+	if spec.Type != storage.FieldType("keyword") {
+		return nil, fmt.Errorf("field %s is not a keyword field (type: %s)", field, spec.Type)
+	}
+
+	style := adapter.PlaceholderStyle()
+	querySQL := fmt.Sprintf(`
+		SELECT value, doc_freq
+		FROM kw_dict
+		WHERE field = %s AND value > %s AND doc_freq > 0
+		ORDER BY value ASC
+		LIMIT %s
+	`, ph(style, 1), ph(style, 2), ph(style, 3))
+
+	rows, err := db.QueryContext(ctx, querySQL, field, afterValue, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query values: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ValueCount
+	for rows.Next() {
+		var vc ValueCount
+		if err := rows.Scan(&vc.Value, &vc.Count); err != nil {
+			return nil, fmt.Errorf("scan value: %w", err)
+		}
+		result = append(result, vc)
+	}
 	return result, rows.Err()
 }
 
+// CountLiveItems returns the number of items that are not soft-deleted
+func CountLiveItems(ctx context.Context, db *sql.DB) (uint64, error) {
+	var n uint64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items WHERE deleted_at IS NULL").Scan(&n); err != nil {
+		return 0, fmt.Errorf("count items: %w", err)
+	}
+	return n, nil
+}
+
+// CountRows returns the number of rows in table
+func CountRows(ctx context.Context, db *sql.DB, table string) (int64, error) {
+	var n int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count %s: %w", table, err)
+	}
+	return n, nil
+}
+
+// MaxItemID returns the highest item id, deleted items included, or 0 for an
+// empty index
+func MaxItemID(ctx context.Context, db *sql.DB) (int64, error) {
+	var id int64
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM items").Scan(&id); err != nil {
+		return 0, fmt.Errorf("max item id: %w", err)
+	}
+	return id, nil
+}
+
 // DiscoverFields returns an overview of all schema fields
 func DiscoverFields(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema) ([]FieldOverview, error) {
 	style := adapter.PlaceholderStyle()
@@ -108,6 +377,9 @@ func DiscoverFields(ctx context.Context, db *sql.DB, adapter storage.Adapter, sc
 	// Get text fields
 	for _, tf := range schema.TextFieldsInOrder() {
 		spec, _ := schema.Get(tf.Name)
+		if spec.Type != storage.FieldType("text") {
+			continue // full_text keyword fields are listed as keywords
+		}
 
 		// Count documents with this field
 		var docCount uint64
@@ -139,24 +411,36 @@ func DiscoverFields(ctx context.Context, db *sql.DB, adapter storage.Adapter, sc
 			UNION SELECT field FROM field_number
 			UNION SELECT field FROM field_date
 			UNION SELECT field FROM field_bool
+			UNION SELECT field FROM field_geo
 		)
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("list fields: %w", err)
 	}
-	defer rows.Close()
-
-	seenFields := make(map[string]bool)
-	for _, tf := range schema.TextFieldsInOrder() {
-		seenFields[tf.Name] = true
-	}
-
+	// Read the names up front so the per-field queries below don't need a
+	// second connection while rows holds one
+	var fieldNames []string
 	for rows.Next() {
 		var fieldName string
 		if err := rows.Scan(&fieldName); err != nil {
+			rows.Close()
 			return nil, fmt.Errorf("scan field: %w", err)
 		}
+		fieldNames = append(fieldNames, fieldName)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list fields: %w", err)
+	}
+
+	seenFields := make(map[string]bool)
+	for _, tf := range schema.TextFieldsInOrder() {
+		if spec, _ := schema.Get(tf.Name); spec.Type == storage.FieldType("text") {
+			seenFields[tf.Name] = true
+		}
+	}
 
+	for _, fieldName := range fieldNames {
 		if seenFields[fieldName] {
 			continue
 		}
@@ -254,10 +538,116 @@ func DiscoverFields(ctx context.Context, db *sql.DB, adapter storage.Adapter, sc
 				fieldName,
 			).Scan(&falseCount)
 			overview.Examples = append(overview.Examples, fmt.Sprintf("true: %d, false: %d", trueCount, falseCount))
+
+		case storage.FieldType("geo"):
+			// Bounding box of the stored points
+			var minLat, maxLat, minLon, maxLon sql.NullFloat64
+			db.QueryRowContext(ctx,
+				fmt.Sprintf("SELECT MIN(lat), MAX(lat), MIN(lon), MAX(lon) FROM field_geo WHERE field = %s", p1),
+				fieldName,
+			).Scan(&minLat, &maxLat, &minLon, &maxLon)
+			if minLat.Valid {
+				overview.Examples = append(overview.Examples,
+					fmt.Sprintf("lat: %g..%g", minLat.Float64, maxLat.Float64),
+					fmt.Sprintf("lon: %g..%g", minLon.Float64, maxLon.Float64))
+			}
 		}
 
 		result = append(result, overview)
 	}
 
-	return result, rows.Err()
+	return result, nil
+}
+
+// SuggestValues finds the first exact keyword predicate in expr, outside any
+// NOT, whose value no live item holds and that other values start with. It
+// returns its field and up to top of those values, most frequent first, then
+// by value. It returns no field when every exact value is known or none has
+// completions.
+func SuggestValues(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, expr query.Expr, top int) (string, []ValueCount, error) {
+	if top <= 0 {
+		top = 10
+	}
+	style := adapter.PlaceholderStyle()
+	for _, kw := range exactKeywords(schema, expr, nil) {
+		spec, _ := schema.Get(kw.Field)
+		valueCol := "value"
+		value := storage.NormalizeKeyword(spec.Normalizer, kw.Pattern)
+		if spec.CaseFold {
+			valueCol = "value_folded"
+			value = storage.FoldKeyword(value)
+		}
+		if value == "" {
+			continue
+		}
+
+		q := fmt.Sprintf(`SELECT COUNT(*) FROM kw_dict WHERE field = %s AND %s = %s AND doc_freq > 0`,
+			ph(style, 1), valueCol, ph(style, 2))
+		var known int
+		if err := db.QueryRowContext(ctx, q, kw.Field, value).Scan(&known); err != nil {
+			return "", nil, storage.WrapFieldSQL("look up keyword value", kw.Field, q, 2, err)
+		}
+		if known > 0 {
+			continue
+		}
+
+		// Escape the value's own wildcards so only the trailing * is one
+		var prefix strings.Builder
+		for _, r := range value {
+			if r == '*' || r == '?' || r == '\\' {
+				prefix.WriteByte('\\')
+			}
+			prefix.WriteRune(r)
+		}
+		prefix.WriteByte('*')
+		q = fmt.Sprintf(`SELECT value, doc_freq FROM kw_dict
+WHERE field = %s AND %s LIKE %s ESCAPE '\' AND doc_freq > 0
+ORDER BY doc_freq DESC, value ASC
+LIMIT %s`, ph(style, 1), valueCol, ph(style, 2), ph(style, 3))
+		rows, err := db.QueryContext(ctx, q, kw.Field, storage.KeywordPatternLike(prefix.String()), top)
+		if err != nil {
+			return "", nil, storage.WrapFieldSQL("list suggestions", kw.Field, q, 3, err)
+		}
+		var result []ValueCount
+		for rows.Next() {
+			var vc ValueCount
+			if err := rows.Scan(&vc.Value, &vc.Count); err != nil {
+				rows.Close()
+				return "", nil, fmt.Errorf("scan value: %w", err)
+			}
+			result = append(result, vc)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return "", nil, fmt.Errorf("iterate values: %w", err)
+		}
+		if len(result) > 0 {
+			return kw.Field, result, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// exactKeywords appends to out the exact keyword predicates on keyword
+// fields in expr, in query order, leaving out negated ones and unquoted
+// values on full_text fields, which are text searches
+func exactKeywords(schema storage.Schema, expr query.Expr, out []query.Keyword) []query.Keyword {
+	switch e := expr.(type) {
+	case query.And:
+		return exactKeywords(schema, e.Right, exactKeywords(schema, e.Left, out))
+	case query.Or:
+		return exactKeywords(schema, e.Right, exactKeywords(schema, e.Left, out))
+	case query.Pred:
+		kw, ok := e.Predicate.(query.Keyword)
+		if !ok || kw.Kind != query.KeywordExact {
+			return out
+		}
+		spec, ok := schema.Get(kw.Field)
+		if !ok || spec.Type != storage.FieldType("keyword") || (spec.FullText && !kw.Quoted) {
+			return out
+		}
+		return append(out, kw)
+	}
+	return out
 }
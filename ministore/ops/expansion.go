@@ -0,0 +1,119 @@
+package ops
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ministore/ministore/ministore/query"
+	"github.com/ministore/ministore/ministore/storage"
+)
+
+// CheckPrefixExpansion rejects expr with an *ExpansionError if a keyword
+// prefix predicate in it (tags:ru*) matches more than max distinct values in
+// kw_dict. Counting stops at max+1, so a broad prefix costs no more than a
+// narrow one to reject. A max of 0 or less disables the check.
+//
+// It returns expr with each counted Keyword's Expansion set, for explain.
+// With explain, glob and contains patterns (tags:r*st, tags:*ust*) are
+// counted too, under the same cap, though never rejected.
+func CheckPrefixExpansion(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, expr query.Expr, max int, explain bool) (query.Expr, error) {
+	if max <= 0 {
+		return expr, nil
+	}
+	switch e := expr.(type) {
+	case query.And:
+		left, err := CheckPrefixExpansion(ctx, db, adapter, schema, e.Left, max, explain)
+		if err != nil {
+			return nil, err
+		}
+		right, err := CheckPrefixExpansion(ctx, db, adapter, schema, e.Right, max, explain)
+		if err != nil {
+			return nil, err
+		}
+		return query.And{Left: left, Right: right}, nil
+	case query.Or:
+		left, err := CheckPrefixExpansion(ctx, db, adapter, schema, e.Left, max, explain)
+		if err != nil {
+			return nil, err
+		}
+		right, err := CheckPrefixExpansion(ctx, db, adapter, schema, e.Right, max, explain)
+		if err != nil {
+			return nil, err
+		}
+		return query.Or{Left: left, Right: right, RestrictToAnchored: e.RestrictToAnchored}, nil
+	case query.Not:
+		inner, err := CheckPrefixExpansion(ctx, db, adapter, schema, e.Inner, max, explain)
+		if err != nil {
+			return nil, err
+		}
+		return query.Not{Inner: inner}, nil
+	case query.Pred:
+		kw, ok := e.Predicate.(query.Keyword)
+		if !ok || kw.Kind == query.KeywordExact || (kw.Kind != query.KeywordPrefix && !explain) {
+			return expr, nil
+		}
+		spec, ok := schema.Get(kw.Field)
+		if !ok || spec.Type != storage.FieldType("keyword") {
+			return expr, nil // text prefixes go to FTS; the planner reports the rest
+		}
+		n, err := countKeywordExpansion(ctx, db, adapter, spec, kw, max+1)
+		if err != nil {
+			return nil, err
+		}
+		if n > max && kw.Kind == query.KeywordPrefix {
+			return nil, &ExpansionError{Pattern: kw.Field + ":" + kw.Pattern, Limit: max}
+		}
+		kw.Expansion, kw.ExpansionLimit = n, max
+		return query.Pred{Predicate: kw}, nil
+	default:
+		return expr, nil
+	}
+}
+
+// ExpansionError rejects a keyword prefix or fuzzy term matching more
+// kw_dict values than the MaxPrefixExpansion limit
+type ExpansionError struct {
+	Pattern string // as written, field included: tags:go* or tags~must
+	Fuzzy   bool
+	Limit   int
+}
+
+func (e *ExpansionError) Error() string {
+	if e.Fuzzy {
+		return fmt.Sprintf("fuzzy term '%s' matches more than %d values; use a longer term", e.Pattern, e.Limit)
+	}
+	return fmt.Sprintf("prefix pattern '%s' matches more than %d values; use a longer prefix", e.Pattern, e.Limit)
+}
+
+// countKeywordExpansion counts the live kw_dict values p matches, stopping at
+// limit unless it is 0
+func countKeywordExpansion(ctx context.Context, db *sql.DB, adapter storage.Adapter, spec storage.FieldSpec, p query.Keyword, limit int) (int, error) {
+	// Match the way the planner compiles the pattern
+	valueCol := "value"
+	pattern := storage.NormalizeKeywordPattern(spec.Normalizer, p.Pattern)
+	if spec.CaseFold {
+		valueCol = "value_folded"
+		pattern = storage.FoldKeyword(pattern)
+	}
+	match := "LIKE %s ESCAPE '\\'"
+	arg := storage.KeywordPatternLike(pattern)
+	if p.Kind == query.KeywordGlob && adapter.Backend() == storage.BackendSQLite {
+		match, arg = "GLOB %s", storage.KeywordPatternGlob(pattern)
+	}
+
+	style := adapter.PlaceholderStyle()
+	args := []any{p.Field, arg}
+	inner := fmt.Sprintf("SELECT 1 FROM kw_dict WHERE field = %s AND %s "+match+" AND doc_freq > 0", ph(style, 1), valueCol, ph(style, 2))
+	if limit > 0 {
+		inner += " LIMIT " + ph(style, 3)
+		args = append(args, limit)
+	}
+	q := fmt.Sprintf("SELECT COUNT(*) FROM (\n  %s\n) AS expansion", inner)
+
+	var n int
+	if err := db.QueryRowContext(ctx, q, args...).Scan(&n); err != nil {
+		return 0, storage.WrapFieldSQL("count keyword expansion", p.Field, q, len(args), err)
+	}
+	return n, nil
+}
@@ -0,0 +1,119 @@
+package ops
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ministore/ministore/ministore/planner"
+	"github.com/ministore/ministore/ministore/storage"
+)
+
+// CTEProfile is the measured cost of one compiled CTE
+type CTEProfile struct {
+	CTE     string
+	Step    string // explain step that produced the CTE
+	Rows    uint64
+	Elapsed time.Duration // includes the CTEs it reads from
+}
+
+// MeasurePlan fills in the selectivity of every node in compiled.Plan by
+// counting the live items each CTE yields. args are the arguments of the
+// compiled CTEs, i.e. the builder's arguments right after planner.Compile.
+// It runs one query per node, so it is only used for explain output.
+func MeasurePlan(ctx context.Context, db *sql.DB, compiled *planner.CompileOutput, args []any) error {
+	if compiled.Plan == nil {
+		return nil
+	}
+	total, err := CountLiveItems(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	with := withClause(compiled)
+
+	measured := make(map[string]*float64)
+	var walkErr error
+	compiled.Plan.Walk(func(n *planner.PlanNode) {
+		if walkErr != nil {
+			return
+		}
+		if s, ok := measured[n.CTE]; ok {
+			n.Selectivity = s
+			return
+		}
+		q := fmt.Sprintf("%s SELECT COUNT(DISTINCT c.item_id) FROM %s c JOIN items i ON i.id = c.item_id WHERE i.deleted_at IS NULL", with, n.CTE)
+		var count uint64
+		if err := db.QueryRowContext(ctx, q, args...).Scan(&count); err != nil {
+			walkErr = fmt.Errorf("measure %s: %w", n.CTE, err)
+			return
+		}
+		s := 0.0
+		if total > 0 {
+			s = float64(count) / float64(total)
+		}
+		n.Selectivity = &s
+		measured[n.CTE] = &s
+	})
+	return walkErr
+}
+
+// ProfileCTEs runs every CTE of compiled on its own and reports its row
+// count and elapsed time, in compile order. SQLite counts the rows and
+// times the query on the client; Postgres reports both from EXPLAIN
+// ANALYZE. Each CTE is measured together with the CTEs it reads from, so a
+// set operation's time includes its inputs.
+func ProfileCTEs(ctx context.Context, db *sql.DB, backend storage.Backend, compiled *planner.CompileOutput, args []any) ([]CTEProfile, error) {
+	with := withClause(compiled)
+	profiles := make([]CTEProfile, 0, len(compiled.CTEs))
+	for _, cte := range compiled.CTEs {
+		p := CTEProfile{CTE: cte.Name, Step: cte.Step}
+		var err error
+		if backend == storage.BackendPostgres {
+			p.Rows, p.Elapsed, err = explainAnalyze(ctx, db, fmt.Sprintf("%s SELECT item_id FROM %s", with, cte.Name), args)
+		} else {
+			start := time.Now()
+			err = db.QueryRowContext(ctx, fmt.Sprintf("%s SELECT COUNT(*) FROM %s", with, cte.Name), args...).Scan(&p.Rows)
+			p.Elapsed = time.Since(start)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", cte.Name, err)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// explainAnalyze runs q under EXPLAIN ANALYZE and returns the rows its top
+// plan node produced and the server-side execution time
+func explainAnalyze(ctx context.Context, db *sql.DB, q string, args []any) (uint64, time.Duration, error) {
+	var raw []byte
+	if err := db.QueryRowContext(ctx, "EXPLAIN (ANALYZE, FORMAT JSON) "+q, args...).Scan(&raw); err != nil {
+		return 0, 0, err
+	}
+	var out []struct {
+		Plan struct {
+			ActualRows float64 `json:"Actual Rows"`
+		} `json:"Plan"`
+		ExecutionTime float64 `json:"Execution Time"` // milliseconds
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return 0, 0, fmt.Errorf("parse explain output: %w", err)
+	}
+	if len(out) == 0 {
+		return 0, 0, fmt.Errorf("empty explain output")
+	}
+	return uint64(out[0].Plan.ActualRows), time.Duration(out[0].ExecutionTime * float64(time.Millisecond)), nil
+}
+
+// withClause returns the WITH clause declaring every CTE of compiled
+func withClause(compiled *planner.CompileOutput) string {
+	cteParts := make([]string, 0, len(compiled.CTEs))
+	for _, cte := range compiled.CTEs {
+		cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", cte.Name, cte.SQL))
+	}
+	return "WITH " + strings.Join(cteParts, ", ")
+}
@@ -0,0 +1,171 @@
+package ops
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ministore/ministore/ministore/query"
+	"github.com/ministore/ministore/ministore/storage"
+	"github.com/ministore/ministore/ministore/storage/sqlbuilder"
+)
+
+// MaxNativeFuzzyLen is the longest value, in characters, that backends
+// computing edit distances natively accept (fuzzystrmatch's limit). Longer
+// fuzzy terms are resolved client-side.
+const MaxNativeFuzzyLen = 255
+
+// ResolveFuzzy fills in the candidate kw_dict ids for every FuzzyKeyword
+// predicate in expr that the backend cannot match natively. A predicate
+// matching more than max values is rejected with an *ExpansionError, like a
+// broad prefix.
+func ResolveFuzzy(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, expr query.Expr, max int) (query.Expr, error) {
+	caps := adapter.Capabilities()
+	if !caps.FuzzyKeyword {
+		return expr, nil
+	}
+	r := fuzzyResolver{db: db, style: adapter.PlaceholderStyle(), schema: schema, native: caps.EditDistance, max: max}
+	return r.resolve(ctx, expr)
+}
+
+type fuzzyResolver struct {
+	db     *sql.DB
+	style  sqlbuilder.PlaceholderStyle
+	schema storage.Schema
+	native bool // backend has EditDistance
+	max    int
+}
+
+func (r fuzzyResolver) resolve(ctx context.Context, expr query.Expr) (query.Expr, error) {
+	switch e := expr.(type) {
+	case query.And:
+		left, err := r.resolve(ctx, e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.resolve(ctx, e.Right)
+		if err != nil {
+			return nil, err
+		}
+		return query.And{Left: left, Right: right}, nil
+
+	case query.Or:
+		left, err := r.resolve(ctx, e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.resolve(ctx, e.Right)
+		if err != nil {
+			return nil, err
+		}
+		return query.Or{Left: left, Right: right, RestrictToAnchored: e.RestrictToAnchored}, nil
+
+	case query.Not:
+		inner, err := r.resolve(ctx, e.Inner)
+		if err != nil {
+			return nil, err
+		}
+		return query.Not{Inner: inner}, nil
+
+	case query.Pred:
+		fk, ok := e.Predicate.(query.FuzzyKeyword)
+		if !ok {
+			return e, nil
+		}
+		if r.native && len([]rune(fk.Term))+fk.MaxDistance <= MaxNativeFuzzyLen {
+			return e, nil
+		}
+		ids, err := r.candidates(ctx, fk)
+		if err != nil {
+			return nil, err
+		}
+		fk.ValueIDs = ids
+		fk.Resolved = true
+		return query.Pred{Predicate: fk}, nil
+
+	default:
+		return expr, nil
+	}
+}
+
+// candidates scans the field's dictionary values whose length is within the
+// predicate's edit distance of the term's, and keeps those within the edit
+// distance itself.
+func (r fuzzyResolver) candidates(ctx context.Context, p query.FuzzyKeyword) ([]int64, error) {
+	spec, ok := r.schema.Get(p.Field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field: %s", p.Field)
+	}
+	if spec.Type != storage.FieldType("keyword") {
+		return nil, fmt.Errorf("field %s type %s cannot be used with fuzzy predicate", p.Field, spec.Type)
+	}
+
+	term := storage.NormalizeKeyword(spec.Normalizer, p.Term)
+	col := "value"
+	if spec.CaseFold {
+		term = storage.FoldKeyword(term)
+		col = "COALESCE(value_folded, value)"
+	}
+	n := len([]rune(term))
+	stmt := fmt.Sprintf("SELECT id, %s FROM kw_dict WHERE field = %s AND doc_freq > 0 AND length(%s) BETWEEN %s AND %s",
+		col, ph(r.style, 1), col, ph(r.style, 2), ph(r.style, 3))
+	args := []any{p.Field, n - p.MaxDistance, n + p.MaxDistance}
+
+	rows, err := r.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, storage.WrapFieldSQL("list fuzzy candidates", p.Field, stmt, len(args), err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, fmt.Errorf("scan kw_dict: %w", err)
+		}
+		if levenshtein(term, value, p.MaxDistance) > p.MaxDistance {
+			continue
+		}
+		if r.max > 0 && len(ids) == r.max {
+			return nil, &ExpansionError{Pattern: p.Field + "~" + p.Term, Fuzzy: true, Limit: r.max}
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate kw_dict: %w", err)
+	}
+	return ids, nil
+}
+
+// levenshtein returns the edit distance between a and b, or max+1 as soon
+// as it is known to exceed max.
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
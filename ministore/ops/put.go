@@ -1,34 +1,112 @@
 package ops
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ministore/ministore/ministore/storage"
+	"github.com/ministore/ministore/ministore/storage/sqlbuilder"
 )
 
 // PutPrepared holds the prepared data for a put operation
 type PutPrepared struct {
 	Path          string
 	DataJSON      []byte
-	TextCols      map[string]*string   // nil means absent
-	KeywordFields map[string][]string  // field -> values
-	NumberFields  map[string][]float64 // field -> values
-	DateFieldsMS  map[string][]int64   // field -> epoch ms values
-	BoolFields    map[string]bool      // field -> value
-	PresentFields []string             // fields that are present
+	DataEnc       string                        // data_enc of DataJSON; "" for plain JSON
+	TextCols      map[string]*string            // nil means absent
+	KeywordFields map[string][]string           // field -> values
+	KeywordFolded map[string][]string           // field -> case-folded values (CaseFold fields only)
+	NumberFields  map[string][]float64          // field -> values
+	DateFieldsMS  map[string][]int64            // field -> epoch ms values
+	BoolFields    map[string]bool               // field -> value
+	GeoFields     map[string][]storage.GeoPoint // field -> points
+	PresentFields []string                      // fields that are present
+
+	// UpsertKey, if set, is a single-valued keyword field identifying the
+	// item: a put whose key value is held by an item at another path moves
+	// that item to Path instead of inserting a second one
+	UpsertKey string
+
+	// MovedFrom is set by ExecutePut to the path the upsert key moved the
+	// item from, if it did
+	MovedFrom string
+
+	// DocFreqTouched, if non-nil, defers doc_freq maintenance: the kw_dict
+	// ids whose doc_freq would change are added to it instead of updated
+	// one row at a time, and the caller must RecomputeDocFreq before commit
+	DocFreqTouched map[int64]bool
 }
 
-// PreparePut validates and extracts fields from a document for indexing
-func PreparePut(schema storage.Schema, docJSON []byte) (*PutPrepared, error) {
+// FieldError reports a document that violates the schema at a specific field
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field '%s': %s", e.Field, e.Reason)
+}
+
+// PreparePut validates and extracts fields from a document for indexing.
+// Bare dates are read as midnight in loc (nil means UTC).
+func PreparePut(schema storage.Schema, docJSON []byte, loc *time.Location) (*PutPrepared, error) {
 	var doc map[string]interface{}
 	if err := json.Unmarshal(docJSON, &doc); err != nil {
 		return nil, fmt.Errorf("invalid JSON document: %w", err)
 	}
+	return prepareDecoded(schema, doc, docJSON, loc)
+}
+
+// PreparePutDoc is PreparePut for a document the caller already holds
+// decoded, with docJSON its encoding, and skips decoding docJSON again. doc
+// is only used as is when it holds nothing but the types encoding/json
+// decodes to (string, float64, bool, nil, []any and map[string]any); a doc
+// with an int or a []string, say, is read back from docJSON instead, so
+// both paths extract the same values.
+func PreparePutDoc(schema storage.Schema, doc map[string]any, docJSON []byte, loc *time.Location) (*PutPrepared, error) {
+	if !isDecodedJSON(doc) {
+		return PreparePut(schema, docJSON, loc)
+	}
+	return prepareDecoded(schema, doc, docJSON, loc)
+}
+
+// isDecodedJSON reports whether v is made only of the types json.Unmarshal
+// produces for an interface{} destination
+func isDecodedJSON(v any) bool {
+	switch v := v.(type) {
+	case nil, string, float64, bool:
+		return true
+	case []any:
+		for _, item := range v {
+			if !isDecodedJSON(item) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		for _, child := range v {
+			if !isDecodedJSON(child) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// prepareDecoded extracts the index values of doc, decoded from docJSON
+func prepareDecoded(schema storage.Schema, doc map[string]interface{}, docJSON []byte, loc *time.Location) (*PutPrepared, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
 
 	// Extract path
 	pathVal, ok := doc["path"]
@@ -45,17 +123,31 @@ func PreparePut(schema storage.Schema, docJSON []byte) (*PutPrepared, error) {
 		DataJSON:      docJSON,
 		TextCols:      make(map[string]*string),
 		KeywordFields: make(map[string][]string),
+		KeywordFolded: make(map[string][]string),
 		NumberFields:  make(map[string][]float64),
 		DateFieldsMS:  make(map[string][]int64),
 		BoolFields:    make(map[string]bool),
+		GeoFields:     make(map[string][]storage.GeoPoint),
+	}
+
+	// Dotted schema fields (author.name) address nested values
+	doc = flattenDoc(doc)
+
+	if schema.Strict() {
+		if field := unknownField(schema, doc); field != "" {
+			return nil, &FieldError{Field: field, Reason: "not in schema"}
+		}
 	}
 
 	// Process each field in the schema
 	for _, tf := range schema.TextFieldsInOrder() {
 		fieldName := tf.Name
+		prep.TextCols[fieldName] = nil
+		if spec, _ := schema.Get(fieldName); spec.Type != storage.FieldType("text") {
+			continue // full_text keyword fields are filled from their values below
+		}
 		val, exists := doc[fieldName]
 		if !exists || val == nil {
-			prep.TextCols[fieldName] = nil
 			continue
 		}
 		str, ok := val.(string)
@@ -77,7 +169,7 @@ func PreparePut(schema storage.Schema, docJSON []byte) (*PutPrepared, error) {
 
 		spec, ok := schema.Get(fieldName)
 		if !ok {
-			// Unknown field - skip (not in schema)
+			// Unknown field - skip (strict schemas rejected it above)
 			continue
 		}
 
@@ -87,13 +179,24 @@ func PreparePut(schema storage.Schema, docJSON []byte) (*PutPrepared, error) {
 			continue
 
 		case storage.FieldType("keyword"):
-			values, err := extractKeywordValues(fieldVal, spec.Multi)
+			values, err := extractKeywordValues(fieldVal, spec.Multi, spec.Normalizer)
 			if err != nil {
 				return nil, fmt.Errorf("field '%s': %w", fieldName, err)
 			}
 			if len(values) > 0 {
 				prep.KeywordFields[fieldName] = values
 				prep.PresentFields = append(prep.PresentFields, fieldName)
+				if spec.CaseFold {
+					folded := make([]string, len(values))
+					for i, v := range values {
+						folded[i] = storage.FoldKeyword(v)
+					}
+					prep.KeywordFolded[fieldName] = folded
+				}
+				if spec.FullText {
+					text := strings.Join(values, "\n")
+					prep.TextCols[fieldName] = &text
+				}
 			}
 
 		case storage.FieldType("number"):
@@ -107,7 +210,7 @@ func PreparePut(schema storage.Schema, docJSON []byte) (*PutPrepared, error) {
 			}
 
 		case storage.FieldType("date"):
-			values, err := extractDateValues(fieldVal, spec.Multi)
+			values, err := extractDateValues(fieldVal, spec.Multi, loc)
 			if err != nil {
 				return nil, fmt.Errorf("field '%s': %w", fieldName, err)
 			}
@@ -123,116 +226,473 @@ func PreparePut(schema storage.Schema, docJSON []byte) (*PutPrepared, error) {
 			}
 			prep.BoolFields[fieldName] = val
 			prep.PresentFields = append(prep.PresentFields, fieldName)
+
+		case storage.FieldType("geo"):
+			values, err := extractGeoValues(fieldVal, spec.Multi)
+			if err != nil {
+				return nil, fmt.Errorf("field '%s': %w", fieldName, err)
+			}
+			if len(values) > 0 {
+				prep.GeoFields[fieldName] = values
+				prep.PresentFields = append(prep.PresentFields, fieldName)
+			}
+		}
+	}
+
+	if required := schema.RequiredFields(); len(required) > 0 {
+		present := make(map[string]bool, len(prep.PresentFields))
+		for _, f := range prep.PresentFields {
+			present[f] = true
+		}
+		for _, field := range required {
+			if !present[field] {
+				return nil, &FieldError{Field: field, Reason: "required field is missing"}
+			}
 		}
 	}
 
 	return prep, nil
 }
 
+// ProjectDoc returns the document reduced to path and the top-level keys
+// holding indexed values; nested fields keep their whole top-level object
+func ProjectDoc(prep *PutPrepared) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(prep.DataJSON, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+	out := map[string]json.RawMessage{"path": doc["path"]}
+	for _, field := range prep.PresentFields {
+		root, _, _ := strings.Cut(field, ".")
+		out[root] = doc[root]
+	}
+	return json.Marshal(out)
+}
+
+// CanonicalizeDoc re-encodes a JSON document with object keys sorted at every
+// level and no insignificant whitespace, so equal documents encode to equal
+// bytes. Numbers are kept as written (1 stays 1, 1.50 stays 1.50), and <, >
+// and & are not escaped.
+func CanonicalizeDoc(docJSON []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(docJSON))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// unknownField returns the first key of the flattened doc, in sorted order,
+// that is not a schema field, or "" if all keys are known. Objects are not
+// checked themselves since flattenDoc already yields their children, and
+// the lat/lon keys of geo points are part of their field.
+func unknownField(schema storage.Schema, doc map[string]interface{}) string {
+	keys := make([]string, 0, len(doc))
+	for k, v := range doc {
+		if k == "path" || schema.HasField(k) || isObjectValue(v) || underGeoField(schema, k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	return keys[0]
+}
+
+// underGeoField reports whether the flattened key is nested in a geo field
+func underGeoField(schema storage.Schema, key string) bool {
+	for i := range key {
+		if key[i] != '.' {
+			continue
+		}
+		if spec, ok := schema.Get(key[:i]); ok && spec.Type == storage.FieldType("geo") {
+			return true
+		}
+	}
+	return false
+}
+
+// isObjectValue reports whether v is an object or an array of objects
+func isObjectValue(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		if len(t) == 0 {
+			return false
+		}
+		for _, item := range t {
+			if _, ok := item.(map[string]interface{}); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// flattenDoc returns doc with an extra dotted key for every value nested
+// under an object, e.g. {"author":{"name":"x"}} also yields "author.name".
+// Objects inside arrays are walked too, so [{"name":"a"},{"name":"b"}] under
+// "authors" yields "authors.name" = ["a","b"]. Top-level keys are unchanged.
+func flattenDoc(doc map[string]interface{}) map[string]interface{} {
+	nested := make(map[string][]interface{})
+
+	var collect func(path string, val interface{})
+	// descend records the children of an object, or of each object in an array
+	descend := func(path string, val interface{}) {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				collect(path+"."+k, child)
+			}
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					for k, child := range m {
+						collect(path+"."+k, child)
+					}
+				}
+			}
+		}
+	}
+	collect = func(path string, val interface{}) {
+		switch v := val.(type) {
+		case nil:
+		case map[string]interface{}:
+			descend(path, v)
+		case []interface{}:
+			for _, item := range v {
+				switch item.(type) {
+				case nil:
+				case map[string]interface{}:
+					descend(path, item)
+				default:
+					nested[path] = append(nested[path], item)
+				}
+			}
+		default:
+			nested[path] = append(nested[path], v)
+		}
+	}
+
+	for k, v := range doc {
+		descend(k, v)
+	}
+	if len(nested) == 0 {
+		return doc
+	}
+
+	flat := make(map[string]interface{}, len(doc)+len(nested))
+	for k, v := range doc {
+		flat[k] = v
+	}
+	for k, vals := range nested {
+		if len(vals) == 1 {
+			flat[k] = vals[0]
+		} else {
+			flat[k] = vals
+		}
+	}
+	return flat
+}
+
 // ExecutePut executes a prepared put operation within a transaction
 func ExecutePut(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, schema storage.Schema, prep *PutPrepared, nowMS int64) (itemID int64, createdAtMS int64, err error) {
+	if err := claimUpsertKey(ctx, tx, sqlt, prep); err != nil {
+		return 0, 0, err
+	}
+
 	// 1. Upsert items row
-	itemID, createdAtMS, err = upsertItem(ctx, tx, sqlt, prep.Path, prep.DataJSON, nowMS)
+	itemID, createdAtMS, err = upsertItem(ctx, tx, sqlt, prep, nowMS)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := writeIndexRows(ctx, tx, sqlt, fts, schema, prep, itemID); err != nil {
+		return 0, 0, err
+	}
+	return itemID, createdAtMS, nil
+}
+
+// ExecutePutIfUnchanged is like ExecutePut but only overwrites an existing
+// live item whose updated_at equals expectedUpdatedAtMS. ok is false (and
+// nothing is written) when the stored version differs, the item is
+// soft-deleted, or it is missing and expectedUpdatedAtMS is not 0.
+func ExecutePutIfUnchanged(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, schema storage.Schema, prep *PutPrepared, nowMS, expectedUpdatedAtMS int64) (ok bool, err error) {
+	if err := claimUpsertKey(ctx, tx, sqlt, prep); err != nil {
+		return false, err
+	}
+	q, args := sqlt.UpsertItemIfUpdatedAt.Build(prep.Path, prep.DataJSON, prep.DataEnc, nowMS, expectedUpdatedAtMS)
+	var itemID, createdAtMS int64
+	err = tx.QueryRowContext(ctx, q, args...).Scan(&itemID, &createdAtMS)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		return 0, 0, fmt.Errorf("upsert item: %w", err)
+		return false, storage.WrapSQL("upsert item", q, len(args), err)
+	}
+
+	if err := writeIndexRows(ctx, tx, sqlt, fts, schema, prep, itemID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ExecutePutWithTS is like ExecutePut but stores the given timestamps verbatim
+// instead of stamping the item with the current time. Used when copying items
+// between indexes so created/updated survive the move.
+func ExecutePutWithTS(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, schema storage.Schema, prep *PutPrepared, createdAtMS, updatedAtMS int64) (int64, error) {
+	q, args := sqlt.UpsertItemWithTS.Build(prep.Path, prep.DataJSON, prep.DataEnc, createdAtMS, updatedAtMS, false)
+	var itemID, storedCreatedAt int64
+	if err := tx.QueryRowContext(ctx, q, args...).Scan(&itemID, &storedCreatedAt); err != nil {
+		return 0, storage.WrapSQL("upsert item", q, len(args), err)
+	}
+
+	if err := writeIndexRows(ctx, tx, sqlt, fts, schema, prep, itemID); err != nil {
+		return 0, err
+	}
+	return itemID, nil
+}
+
+// recomputeDocFreqChunk bounds the kw_dict ids bound in one RecomputeDocFreq
+// statement, well under SQLite's host parameter limit
+const recomputeDocFreqChunk = 500
+
+// RecomputeDocFreq sets the doc_freq of each kw_dict id in valueIDs to its
+// number of postings, in one UPDATE per recomputeDocFreqChunk ids. It settles
+// the ids collected in PutPrepared.DocFreqTouched.
+func RecomputeDocFreq(ctx context.Context, tx *sql.Tx, style sqlbuilder.PlaceholderStyle, valueIDs map[int64]bool) error {
+	ids := make([]int64, 0, len(valueIDs))
+	for id := range valueIDs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), recomputeDocFreqChunk)]
+		ids = ids[len(chunk):]
+		marks := make([]string, len(chunk))
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			marks[i] = ph(style, i+1)
+			args[i] = id
+		}
+		stmt := `UPDATE kw_dict SET doc_freq = (SELECT COUNT(*) FROM kw_postings WHERE value_id = kw_dict.id)
+WHERE id IN (` + strings.Join(marks, ", ") + `)`
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return storage.WrapSQL("recompute doc_freq", stmt, len(args), err)
+		}
 	}
+	return nil
+}
 
-	// 2. Load old keyword value_ids for doc_freq maintenance
+// writeIndexRows replaces all index rows for itemID with those described by prep
+func writeIndexRows(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, schema storage.Schema, prep *PutPrepared, itemID int64) error {
+	// 1. Load old keyword value_ids for doc_freq maintenance
 	oldValueIDs, err := loadOldValueIDs(ctx, tx, sqlt, itemID)
 	if err != nil {
-		return 0, 0, fmt.Errorf("load old value_ids: %w", err)
+		return err
 	}
 
-	// 3. Delete old index rows
+	// 2. Delete old index rows
 	if err := deleteOldIndexRows(ctx, tx, sqlt, fts, itemID); err != nil {
-		return 0, 0, fmt.Errorf("delete old index rows: %w", err)
+		return err
 	}
 
-	// 4. Insert field_present rows
+	// 3. Insert field_present rows
 	for _, field := range prep.PresentFields {
 		if _, err := tx.ExecContext(ctx, sqlt.InsertFieldPresent, itemID, field); err != nil {
-			return 0, 0, fmt.Errorf("insert field_present: %w", err)
+			return storage.WrapFieldSQL("insert field_present", field, sqlt.InsertFieldPresent, 2, err)
 		}
 	}
 
-	// 5. Insert keywords with doc_freq maintenance
+	// 4. Insert keywords with doc_freq maintenance
 	newValueIDs := make(map[int64]bool)
 	for field, values := range prep.KeywordFields {
-		for _, value := range values {
-			valueID, err := insertKeyword(ctx, tx, sqlt, field, value)
+		folded := prep.KeywordFolded[field]
+		for i, value := range values {
+			var valueFolded *string
+			if folded != nil {
+				valueFolded = &folded[i]
+			}
+			valueID, err := insertKeyword(ctx, tx, sqlt, field, value, valueFolded)
 			if err != nil {
-				return 0, 0, fmt.Errorf("insert keyword: %w", err)
+				return err
 			}
 			newValueIDs[valueID] = true
 
 			// Insert posting
 			if _, err := tx.ExecContext(ctx, sqlt.InsertOrIgnoreKwPosting, field, valueID, itemID); err != nil {
-				return 0, 0, fmt.Errorf("insert posting: %w", err)
+				return storage.WrapFieldSQL("insert posting", field, sqlt.InsertOrIgnoreKwPosting, 3, err)
 			}
 
 			// Increment doc_freq only if this value_id was not previously associated
-			if !oldValueIDs[valueID] {
+			switch {
+			case oldValueIDs[valueID]:
+			case prep.DocFreqTouched != nil:
+				prep.DocFreqTouched[valueID] = true
+			default:
 				if _, err := tx.ExecContext(ctx, sqlt.IncrementDocFreq, valueID); err != nil {
-					return 0, 0, fmt.Errorf("increment doc_freq: %w", err)
+					return storage.WrapFieldSQL("increment doc_freq", field, sqlt.IncrementDocFreq, 1, err)
 				}
 			}
 		}
 	}
 
-	// 6. Decrement doc_freq for removed value_ids
+	// 5. Decrement doc_freq for removed value_ids
 	for valueID := range oldValueIDs {
-		if !newValueIDs[valueID] {
+		switch {
+		case newValueIDs[valueID]:
+		case prep.DocFreqTouched != nil:
+			prep.DocFreqTouched[valueID] = true
+		default:
 			if _, err := tx.ExecContext(ctx, sqlt.DecrementDocFreq, valueID); err != nil {
-				return 0, 0, fmt.Errorf("decrement doc_freq: %w", err)
+				return storage.WrapSQL("decrement doc_freq", sqlt.DecrementDocFreq, 1, err)
 			}
 		}
 	}
 
-	// 7. Insert numbers
+	// 6. Insert numbers
 	for field, values := range prep.NumberFields {
 		for _, val := range values {
 			if _, err := tx.ExecContext(ctx, sqlt.InsertFieldNumber, itemID, field, val); err != nil {
-				return 0, 0, fmt.Errorf("insert number: %w", err)
+				return storage.WrapFieldSQL("insert number", field, sqlt.InsertFieldNumber, 3, err)
 			}
 		}
 	}
 
-	// 8. Insert dates
+	// 7. Insert dates
 	for field, values := range prep.DateFieldsMS {
 		for _, val := range values {
 			if _, err := tx.ExecContext(ctx, sqlt.InsertFieldDate, itemID, field, val); err != nil {
-				return 0, 0, fmt.Errorf("insert date: %w", err)
+				return storage.WrapFieldSQL("insert date", field, sqlt.InsertFieldDate, 3, err)
 			}
 		}
 	}
 
-	// 9. Insert bools
+	// 8. Insert bools
 	for field, val := range prep.BoolFields {
 		intVal := 0
 		if val {
 			intVal = 1
 		}
 		if _, err := tx.ExecContext(ctx, sqlt.InsertFieldBool, itemID, field, intVal); err != nil {
-			return 0, 0, fmt.Errorf("insert bool: %w", err)
+			return storage.WrapFieldSQL("insert bool", field, sqlt.InsertFieldBool, 3, err)
+		}
+	}
+
+	// 9. Insert geo points
+	for field, points := range prep.GeoFields {
+		for _, pt := range points {
+			x, y, z := pt.UnitVector()
+			if _, err := tx.ExecContext(ctx, sqlt.InsertFieldGeo, itemID, field, pt.Lat, pt.Lon, x, y, z); err != nil {
+				return storage.WrapFieldSQL("insert geo point", field, sqlt.InsertFieldGeo, 7, err)
+			}
 		}
 	}
 
 	// 10. Upsert FTS row
 	if fts.HasFTS(schema) {
 		if err := fts.UpsertRow(ctx, tx, itemID, schema, prep.TextCols); err != nil {
-			return 0, 0, fmt.Errorf("upsert FTS: %w", err)
+			return fmt.Errorf("upsert FTS: %w", err)
 		}
 	}
 
-	return itemID, createdAtMS, nil
+	return nil
+}
+
+// claimUpsertKey moves the item holding prep's upsert key value to
+// prep.Path, so the upsert that follows updates it and keeps its created
+// time. It fails with a *FieldError when the key is missing or Path already
+// belongs to a different item.
+func claimUpsertKey(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, prep *PutPrepared) error {
+	if prep.UpsertKey == "" {
+		return nil
+	}
+	ids, oldPath, err := upsertKeyHolders(ctx, tx, sqlt, prep)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	value := prep.KeywordFields[prep.UpsertKey][0]
+	if len(ids) > 1 {
+		return &FieldError{Field: prep.UpsertKey, Reason: fmt.Sprintf("upsert key '%s' is held by %d items", value, len(ids))}
+	}
+
+	var otherID, createdAt int64
+	err = tx.QueryRowContext(ctx, sqlt.FindItemIDByPath, prep.Path).Scan(&otherID, &createdAt)
+	if err == nil {
+		return &FieldError{Field: prep.UpsertKey, Reason: fmt.Sprintf("upsert key '%s' belongs to %s, but %s is another item", value, oldPath, prep.Path)}
+	}
+	if err != sql.ErrNoRows {
+		return storage.WrapSQL("find item", sqlt.FindItemIDByPath, 1, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlt.RenameItem, ids[0], prep.Path); err != nil {
+		return storage.WrapSQL("rename item", sqlt.RenameItem, 2, err)
+	}
+	prep.MovedFrom = oldPath
+	return nil
 }
 
-func upsertItem(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, path string, dataJSON []byte, nowMS int64) (itemID int64, createdAtMS int64, err error) {
-	sql, args := sqlt.UpsertItem.Build(path, dataJSON, nowMS, nowMS, false)
+// checkUpsertKeyFree fails with a *FieldError when an item other than
+// prep.Path holds prep's upsert key value. Restore uses it instead of
+// claimUpsertKey: the restored item keeps its path, so a key claimed by
+// another item while it was deleted cannot be moved back.
+func checkUpsertKeyFree(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, prep *PutPrepared) error {
+	if prep.UpsertKey == "" {
+		return nil
+	}
+	ids, oldPath, err := upsertKeyHolders(ctx, tx, sqlt, prep)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	return &FieldError{Field: prep.UpsertKey, Reason: fmt.Sprintf("upsert key '%s' was claimed by %s while %s was deleted", prep.KeywordFields[prep.UpsertKey][0], oldPath, prep.Path)}
+}
+
+// upsertKeyHolders returns the ids of the live items other than prep.Path
+// holding prep's upsert key value, and the path of the last one
+func upsertKeyHolders(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, prep *PutPrepared) ([]int64, string, error) {
+	values := prep.KeywordFields[prep.UpsertKey]
+	if len(values) == 0 {
+		return nil, "", &FieldError{Field: prep.UpsertKey, Reason: "upsert key is missing"}
+	}
+
+	rows, err := tx.QueryContext(ctx, sqlt.FindItemsByKeyword, prep.UpsertKey, values[0], prep.Path)
+	if err != nil {
+		return nil, "", storage.WrapFieldSQL("find item by upsert key", prep.UpsertKey, sqlt.FindItemsByKeyword, 3, err)
+	}
+	defer rows.Close()
+	var ids []int64
+	var path string
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id, &path); err != nil {
+			return nil, "", storage.WrapFieldSQL("scan item by upsert key", prep.UpsertKey, sqlt.FindItemsByKeyword, 3, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", storage.WrapFieldSQL("find item by upsert key", prep.UpsertKey, sqlt.FindItemsByKeyword, 3, err)
+	}
+	return ids, path, nil
+}
+
+func upsertItem(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, prep *PutPrepared, nowMS int64) (itemID int64, createdAtMS int64, err error) {
+	sql, args := sqlt.UpsertItem.Build(prep.Path, prep.DataJSON, prep.DataEnc, nowMS, nowMS, false)
 
 	// SQLite template uses RETURNING id, created_at, so we must Scan.
 	if err := tx.QueryRowContext(ctx, sql, args...).Scan(&itemID, &createdAtMS); err != nil {
-		return 0, 0, err
+		return 0, 0, storage.WrapSQL("upsert item", sql, len(args), err)
 	}
 	return itemID, createdAtMS, nil
 }
@@ -241,18 +701,18 @@ func loadOldValueIDs(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, itemID i
 	result := make(map[int64]bool)
 	rows, err := tx.QueryContext(ctx, sqlt.GetValueIDsByItem, itemID)
 	if err != nil {
-		return nil, err
+		return nil, storage.WrapSQL("load old value_ids", sqlt.GetValueIDsByItem, 1, err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var valueID int64
 		if err := rows.Scan(&valueID); err != nil {
-			return nil, err
+			return nil, storage.WrapSQL("load old value_ids", sqlt.GetValueIDsByItem, 1, err)
 		}
 		result[valueID] = true
 	}
-	return result, rows.Err()
+	return result, storage.WrapSQL("load old value_ids", sqlt.GetValueIDsByItem, 1, rows.Err())
 }
 
 func deleteOldIndexRows(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, itemID int64) error {
@@ -262,40 +722,53 @@ func deleteOldIndexRows(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts s
 		sqlt.DeleteNumberByItem,
 		sqlt.DeleteDateByItem,
 		sqlt.DeleteBoolByItem,
+		sqlt.DeleteGeoByItem,
 		sqlt.DeletePresentByItem,
 	}
 
 	for _, q := range queries {
 		if _, err := tx.ExecContext(ctx, q, itemID); err != nil {
-			return err
+			return storage.WrapSQL("delete old index rows", q, 1, err)
 		}
 	}
 
 	// Delete FTS row (handled specially by FTS driver)
 	if err := fts.DeleteRow(ctx, tx, itemID); err != nil {
-		return err
+		return fmt.Errorf("delete old FTS row: %w", err)
 	}
 
 	return nil
 }
 
-func insertKeyword(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, field, value string) (int64, error) {
+func insertKeyword(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, field, value string, valueFolded *string) (int64, error) {
 	// Insert or ignore into dict
-	if _, err := tx.ExecContext(ctx, sqlt.InsertOrIgnoreKwDict, field, value); err != nil {
-		return 0, err
+	if _, err := tx.ExecContext(ctx, sqlt.InsertOrIgnoreKwDict, field, value, valueFolded); err != nil {
+		return 0, storage.WrapFieldSQL("insert keyword", field, sqlt.InsertOrIgnoreKwDict, 3, err)
 	}
 
 	// Get dict ID
 	var valueID int64
 	err := tx.QueryRowContext(ctx, sqlt.GetKwDictID, field, value).Scan(&valueID)
 	if err != nil {
-		return 0, err
+		return 0, storage.WrapFieldSQL("get keyword id", field, sqlt.GetKwDictID, 2, err)
 	}
 	return valueID, nil
 }
 
-// extractKeywordValues extracts keyword values from a JSON value
-func extractKeywordValues(val interface{}, multi bool) ([]string, error) {
+// extractKeywordValues extracts keyword values from a JSON value and applies
+// the field's normalizer to each
+func extractKeywordValues(val interface{}, multi bool, normalizer string) ([]string, error) {
+	values, err := rawKeywordValues(val, multi)
+	if err != nil || normalizer == "" {
+		return values, err
+	}
+	for i, v := range values {
+		values[i] = storage.NormalizeKeyword(normalizer, v)
+	}
+	return values, nil
+}
+
+func rawKeywordValues(val interface{}, multi bool) ([]string, error) {
 	switch v := val.(type) {
 	case string:
 		return []string{v}, nil
@@ -363,10 +836,10 @@ func extractNumberValues(val interface{}, multi bool) ([]float64, error) {
 }
 
 // extractDateValues extracts date values as epoch milliseconds
-func extractDateValues(val interface{}, multi bool) ([]int64, error) {
+func extractDateValues(val interface{}, multi bool, loc *time.Location) ([]int64, error) {
 	parseDate := func(s string) (int64, error) {
-		// Try YYYY-MM-DD
-		if t, err := time.Parse("2006-01-02", s); err == nil {
+		// Try YYYY-MM-DD (midnight in loc)
+		if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
 			return t.UnixMilli(), nil
 		}
 		// Try RFC3339
@@ -424,6 +897,62 @@ func extractBoolValue(val interface{}) (bool, error) {
 	}
 }
 
+// extractGeoValues extracts points given as {"lat": 48.85, "lon": 2.35} or
+// "48.85,2.35". Repeated points are stored once.
+func extractGeoValues(val interface{}, multi bool) ([]storage.GeoPoint, error) {
+	items := []interface{}{val}
+	if arr, ok := val.([]interface{}); ok {
+		if !multi && len(arr) > 1 {
+			return nil, fmt.Errorf("array not allowed for non-multi field")
+		}
+		items = arr
+	}
+
+	var result []storage.GeoPoint
+	seen := make(map[storage.GeoPoint]bool)
+	for _, item := range items {
+		pt, err := parseGeoPoint(item)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[pt] {
+			seen[pt] = true
+			result = append(result, pt)
+		}
+	}
+	return result, nil
+}
+
+func parseGeoPoint(val interface{}) (storage.GeoPoint, error) {
+	var pt storage.GeoPoint
+	switch v := val.(type) {
+	case map[string]interface{}:
+		lat, latOK := v["lat"].(float64)
+		lon, lonOK := v["lon"].(float64)
+		if !latOK || !lonOK {
+			return pt, fmt.Errorf("geo point must have numeric 'lat' and 'lon'")
+		}
+		pt = storage.GeoPoint{Lat: lat, Lon: lon}
+	case string:
+		latStr, lonStr, ok := strings.Cut(v, ",")
+		lat, latErr := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+		lon, lonErr := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+		if !ok || latErr != nil || lonErr != nil {
+			return pt, fmt.Errorf("cannot parse '%s' as a geo point (want \"lat,lon\")", v)
+		}
+		pt = storage.GeoPoint{Lat: lat, Lon: lon}
+	default:
+		return pt, fmt.Errorf("invalid geo value type: %T", val)
+	}
+	if pt.Lat < -90 || pt.Lat > 90 {
+		return pt, fmt.Errorf("latitude %v out of range [-90, 90]", pt.Lat)
+	}
+	if pt.Lon < -180 || pt.Lon > 180 {
+		return pt, fmt.Errorf("longitude %v out of range [-180, 180]", pt.Lon)
+	}
+	return pt, nil
+}
+
 // NowMS returns current time in milliseconds since epoch
 func NowMS() int64 {
 	return time.Now().UnixMilli()
@@ -0,0 +1,53 @@
+package ops
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ministore/ministore/ministore/storage"
+)
+
+// ClearIndexTables deletes every row derived from documents: postings, the
+// keyword dictionary (and with it all doc_freq counters), typed field values,
+// presence rows and the FTS table. The items table is left untouched.
+func ClearIndexTables(ctx context.Context, tx *sql.Tx, fts storage.FTS, schema storage.Schema) error {
+	tables := []string{
+		"kw_postings",
+		"kw_dict",
+		"field_number",
+		"field_date",
+		"field_bool",
+		"field_geo",
+		"field_present",
+	}
+	for _, table := range tables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return fmt.Errorf("clear %s: %w", table, err)
+		}
+	}
+	if err := fts.DeleteAll(ctx, tx, schema); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReindexItem writes the index rows for an existing item without touching its
+// items row, so data_json and timestamps are preserved
+func ReindexItem(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, fts storage.FTS, schema storage.Schema, prep *PutPrepared, itemID int64) error {
+	return writeIndexRows(ctx, tx, sqlt, fts, schema, prep, itemID)
+}
+
+// SetItemData replaces an item's data_json and data_enc with prep's, keeping
+// its timestamps, e.g. to refresh the projection kept with a DocStore after
+// the schema gained fields
+func SetItemData(ctx context.Context, tx *sql.Tx, sqlt storage.SQL, prep *PutPrepared, itemID int64) error {
+	var data, enc any = string(prep.DataJSON), nil
+	if prep.DataEnc != "" {
+		data, enc = prep.DataJSON, prep.DataEnc
+	}
+	if _, err := tx.ExecContext(ctx, sqlt.SetItemData, itemID, data, enc); err != nil {
+		return storage.WrapSQL("set item data", sqlt.SetItemData, 3, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package ops
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ministore/ministore/ministore/storage"
+)
+
+// Querier is the part of *sql.DB and *sql.Tx that ScanLiveItems reads through
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ScanLiveItems calls fn with the id, path and document of every item that is
+// not soft-deleted, in item_id order, restricted to the items selected by
+// whereSQL when it is not empty. Items are read in pages of pageSize keyed by
+// item_id and fn runs once a page is read, so it may write through the same
+// transaction. If pageDone is non-nil it is called after each non-empty page
+// with the number of items done so far. It returns the number of items passed
+// to fn; an error from fn stops the scan and is returned as is.
+func ScanLiveItems(ctx context.Context, q Querier, adapter storage.Adapter, whereSQL string, whereArgs []any, pageSize int, fn func(id int64, path string, dataJSON []byte) error, pageDone func(done int)) (int, error) {
+	style := adapter.PlaceholderStyle()
+	base := len(whereArgs)
+	filter := ""
+	if whereSQL != "" {
+		filter = fmt.Sprintf("AND id IN (%s)", whereSQL)
+	}
+	stmt := fmt.Sprintf(`SELECT id, path, data_json, data_enc FROM items
+WHERE deleted_at IS NULL %s AND id > %s
+ORDER BY id LIMIT %s`, filter, ph(style, base+1), ph(style, base+2))
+
+	type row struct {
+		id       int64
+		path     string
+		dataJSON []byte
+		dataEnc  sql.NullString
+	}
+
+	n := 0
+	var lastID int64
+	for {
+		args := append(append([]any(nil), whereArgs...), lastID, pageSize)
+		rows, err := q.QueryContext(ctx, stmt, args...)
+		if err != nil {
+			return n, storage.WrapSQL("list items", stmt, len(args), err)
+		}
+		var page []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.path, &r.dataJSON, &r.dataEnc); err != nil {
+				rows.Close()
+				return n, fmt.Errorf("scan item: %w", err)
+			}
+			page = append(page, r)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return n, fmt.Errorf("iterate items: %w", err)
+		}
+		rows.Close()
+
+		for _, r := range page {
+			doc, err := DecodeDoc(r.dataJSON, r.dataEnc.String)
+			if err != nil {
+				return n, fmt.Errorf("item %s: %w", r.path, err)
+			}
+			if err := fn(r.id, r.path, doc); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if pageDone != nil && len(page) > 0 {
+			pageDone(n)
+		}
+		if len(page) < pageSize {
+			return n, nil
+		}
+		lastID = page[len(page)-1].id
+	}
+}
+
+// CountLiveItemsWhere returns the number of items that are not soft-deleted
+// and are selected by whereSQL
+func CountLiveItemsWhere(ctx context.Context, db *sql.DB, whereSQL string, whereArgs []any) (uint64, error) {
+	if whereSQL == "" {
+		return CountLiveItems(ctx, db)
+	}
+	stmt := fmt.Sprintf("SELECT COUNT(*) FROM items WHERE deleted_at IS NULL AND id IN (%s)", whereSQL)
+	var n uint64
+	if err := db.QueryRowContext(ctx, stmt, whereArgs...).Scan(&n); err != nil {
+		return 0, storage.WrapSQL("count items", stmt, len(whereArgs), err)
+	}
+	return n, nil
+}
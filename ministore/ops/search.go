@@ -4,7 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ministore/ministore/ministore/planner"
 	"github.com/ministore/ministore/ministore/query"
@@ -15,11 +18,58 @@ import (
 // SearchOptions configures a search operation
 type SearchOptions struct {
 	Rank       planner.RankMode
-	Limit      int
+	Limit      int    // page size; callers apply defaults, so it must be positive
 	After      string // cursor token
 	CursorMode CursorMode
 	Show       OutputFieldSelector
 	Explain    bool
+	Highlight  *storage.HighlightSpec // nil disables highlights
+	Location   *time.Location         // index time zone for bare dates; nil means UTC
+
+	IncludeDeleted bool // also return soft-deleted items
+
+	// MatchPositions returns, per text field, the byte offsets of each
+	// matched term in the field value
+	MatchPositions bool
+
+	// MinScore drops results whose FTS score is below it. It is ignored
+	// unless results are ranked by FTS score.
+	MinScore *float64
+
+	// ExplainPlan also returns the typed plan tree with measured
+	// selectivities; it implies Explain
+	ExplainPlan bool
+
+	// Profile also runs each CTE on its own and returns its row count and
+	// elapsed time; it implies Explain
+	Profile bool
+
+	// Snapshot, on a first page, records the highest item id in the cursor
+	// so later pages leave out items inserted after the search began. Pages
+	// after the first follow their cursor whatever Snapshot is set to.
+	Snapshot bool
+
+	// SuggestOnEmpty fills SearchResult.Suggestions, up to MaxSuggestions,
+	// when a first page has no rows; see SuggestValues
+	SuggestOnEmpty bool
+	MaxSuggestions int
+
+	// EstimateTotal fills SearchResult.EstimatedTotal on every page, at the
+	// cost of a count query; see CountMatches
+	EstimateTotal bool
+
+	// DistinctBy, if set, returns only the top-ranked item per value of this
+	// keyword, number or date field
+	DistinctBy string
+
+	// Normalize holds the query guardrails; nil uses NormalizeOptionsFor
+	// the schema
+	Normalize *query.NormalizeOptions
+
+	// LoadDoc, if set, returns the full document for a row whose data_json
+	// only holds the indexed fields. It is called for ShowAll, and for
+	// ShowFields when a requested field is not in the schema.
+	LoadDoc func(path string, dataJSON []byte) ([]byte, error)
 }
 
 // CursorMode specifies cursor type
@@ -34,6 +84,7 @@ const (
 type OutputFieldSelector struct {
 	Kind   OutputFieldKind
 	Fields []string
+	Nested bool // dotted fields as nested objects rather than flat keys
 }
 
 // OutputFieldKind is the type of output selection
@@ -52,16 +103,69 @@ type SearchResult struct {
 	HasMore      bool
 	ExplainSQL   string
 	ExplainSteps []string
+	ExplainPlan  *planner.PlanNode // only with SearchOptions.ExplainPlan
+	Profile      []CTEProfile      // only with SearchOptions.Profile
+	Suggestions  []ValueCount      // only with SearchOptions.SuggestOnEmpty
+	SuggestField string
+	FTS          bool // the query has text predicates
+
+	Limit    int // page size asked for, after defaults
+	Returned int // len(Items)
+
+	// EstimatedTotal is the number of items the query matches across all
+	// pages. It is set with SearchOptions.EstimateTotal, and for free on a
+	// first page that holds every result.
+	EstimatedTotal *uint64
 }
 
 // SearchRow is a raw row from the search query
 type SearchRow struct {
-	ItemID    int64
-	Path      string
-	DataJSON  string
-	CreatedAt int64
-	UpdatedAt int64
-	Score     *float64
+	ItemID     int64
+	Path       string
+	DataJSON   string
+	CreatedAt  int64
+	UpdatedAt  int64
+	Score      *float64
+	Highlights map[string]string // text field -> snippet; nil without highlights
+	// MatchPositions maps a text field to start, end byte offset pairs of
+	// its matched terms; nil unless requested
+	MatchPositions map[string][]int
+	RankValues     []float64 // RankField sort key values; Score is the first
+}
+
+// RejectedError is a query Search refused to run: it does not parse (Parse
+// set), or Normalize or a guardrail such as MaxPrefixExpansion rejected it.
+// Any other Search error is a failure running the query.
+type RejectedError struct {
+	Op    string
+	Parse bool
+	Err   error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// IsRejection reports whether err is a guardrail refusing a query rather
+// than a failure checking it
+func IsRejection(err error) bool {
+	var re *RejectedError
+	var xe *ExpansionError
+	var le *LikeError
+	return errors.As(err, &re) || errors.As(err, &xe) || errors.As(err, &le)
+}
+
+// guardError is the error for a failed query check: a *RejectedError when
+// the check rejected the query
+func guardError(op string, err error) error {
+	if IsRejection(err) {
+		return &RejectedError{Op: op, Err: err}
+	}
+	return fmt.Errorf("%s: %w", op, err)
 }
 
 // Search executes a search query
@@ -75,81 +179,170 @@ func Search(
 	nowMS int64,
 	cursorStore CursorStore,
 ) (*SearchResult, error) {
-	// 1. Parse query
-	expr, err := query.Parse(queryStr)
-	if err != nil {
-		return nil, fmt.Errorf("parse query: %w", err)
+	// 1. Parse query; an empty or "*" query lists every item
+	var expr query.Expr = query.Pred{Predicate: query.MatchAll{}}
+	if !query.IsMatchAll(queryStr) {
+		var err error
+		if expr, err = query.ParseInLocation(queryStr, opts.Location); err != nil {
+			return nil, &RejectedError{Op: "parse query", Parse: true, Err: err}
+		}
 	}
 
 	// 2. Normalize (validate positive anchor and guardrails)
-	normalizedExpr, err := query.Normalize(expr, query.DefaultNormalizeOptions())
+	nopts := NormalizeOptionsFor(schema)
+	if opts.Normalize != nil {
+		nopts = *opts.Normalize
+	}
+	normalizedExpr, err := query.Normalize(expr, nopts)
 	if err != nil {
-		return nil, fmt.Errorf("normalize query: %w", err)
+		return nil, &RejectedError{Op: "normalize query", Err: err}
+	}
+	normalizedExpr, err = CheckPrefixExpansion(ctx, db, adapter, schema, normalizedExpr, nopts.MaxPrefixExpansion, opts.Explain || opts.ExplainPlan)
+	if err != nil {
+		return nil, guardError("normalize query", err)
+	}
+	if err := CheckLike(ctx, db, adapter, normalizedExpr); err != nil {
+		return nil, guardError("normalize query", err)
+	}
+	normalizedExpr, err = ResolveFuzzy(ctx, db, adapter, schema, normalizedExpr, nopts.MaxPrefixExpansion)
+	if err != nil {
+		return nil, guardError("resolve fuzzy", err)
 	}
 
 	// 3. Create builder for placeholder management
 	builder := sqlbuilder.New(adapter.PlaceholderStyle())
 
 	// 4. Compile to CTEs (adapter-aware)
-	compiled, err := planner.Compile(adapter, schema, builder, normalizedExpr, nowMS)
+	compiled, err := planner.Compile(adapter, schema, builder, normalizedExpr, nowMS, opts.Location)
 	if err != nil {
 		return nil, fmt.Errorf("compile query: %w", err)
 	}
+	cteArgs := append([]any(nil), builder.Args()...)
 
 	// Does RankDefault actually use FTS scoring?
 	hasFTSScore := opts.Rank.Kind == planner.RankDefault && len(compiled.TextPreds) > 0 && adapter.FTS().HasFTS(schema)
 
 	// 5. Resolve cursor if present
-	var afterFilter string
+	var afterFilter func(storage.Builder) (string, error)
+	var snapshotMaxID int64
+	if opts.After == "" && opts.Snapshot {
+		// The first page is pinned too, so an insert racing this search
+		// cannot show up on it and then vanish from the pages after
+		if snapshotMaxID, err = MaxItemID(ctx, db); err != nil {
+			return nil, err
+		}
+	}
 	if opts.After != "" {
 		cursor, err := cursorStore.Resolve(ctx, opts.After)
 		if err != nil {
 			return nil, fmt.Errorf("resolve cursor: %w", err)
 		}
 
-		afterFilter, err = planner.BuildAfterFilter(
-			opts.Rank,
-			hasFTSScore,
-			builder,
-			cursor.Score,
-			cursor.ItemID,
-			cursor.UpdatedAtMS,
-			cursor.Path,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("build after filter: %w", err)
+		snapshotMaxID = cursor.SnapshotMaxID
+		rankValues := cursor.RankValues
+		if len(rankValues) == 0 && cursor.Kind == CursorKindField {
+			// Cursors from before multi-key sorts carry a single value
+			rankValues = []float64{cursor.RankValue}
+		}
+		afterFilter = func(b storage.Builder) (string, error) {
+			filter, err := planner.BuildAfterFilter(
+				opts.Rank,
+				hasFTSScore,
+				b,
+				cursor.Score,
+				rankValues,
+				cursor.ItemID,
+				cursor.UpdatedAtMS,
+				cursor.Path,
+			)
+			if err != nil {
+				return "", fmt.Errorf("build after filter: %w", err)
+			}
+			return filter, nil
 		}
 	}
 
 	// 6. Build final SQL
 	limit := opts.Limit
-	if limit <= 0 {
-		limit = 20
-	}
 	limitPlusOne := limit + 1
 
-	searchSQL, err := planner.BuildSearchSQL(adapter, schema, compiled, opts.Rank, limitPlusOne, afterFilter, builder)
+	var highlight *storage.HighlightSpec
+	if opts.Highlight != nil {
+		spec := withHighlightDefaults(*opts.Highlight)
+		highlight = &spec
+	}
+
+	searchSQL, hlFields, posFields, err := planner.BuildSearchSQL(adapter, schema, compiled, opts.Rank, limitPlusOne, afterFilter, builder, highlight, opts.MatchPositions, opts.IncludeDeleted, opts.MinScore, snapshotMaxID, opts.DistinctBy, opts.Show.Kind != ShowNone)
 	if err != nil {
 		return nil, fmt.Errorf("build search SQL: %w", err)
 	}
 
+	var sortKeys []planner.SortKey
+	if opts.Rank.Kind == planner.RankField {
+		sortKeys = opts.Rank.SortKeys()
+	}
+
 	// 7. Execute query
 	rows, err := db.QueryContext(ctx, searchSQL, builder.Args()...)
 	if err != nil {
-		return nil, fmt.Errorf("execute search: %w", err)
+		return nil, storage.WrapSQL("execute search", searchSQL, builder.Len(), err)
 	}
 	defer rows.Close()
 
 	var searchRows []SearchRow
 	for rows.Next() {
 		var row SearchRow
+		var dataEnc sql.NullString
 		var score sql.NullFloat64
-		if err := rows.Scan(&row.ItemID, &row.Path, &row.DataJSON, &row.CreatedAt, &row.UpdatedAt, &score); err != nil {
+		snippets := make([]sql.NullString, len(hlFields))
+		marked := make([]sql.NullString, len(posFields))
+		var extraRanks []float64
+		if n := len(sortKeys); n > 1 {
+			extraRanks = make([]float64, n-1)
+		}
+		dest := []any{&row.ItemID, &row.Path, &row.DataJSON, &dataEnc, &row.CreatedAt, &row.UpdatedAt, &score}
+		for i := range snippets {
+			dest = append(dest, &snippets[i])
+		}
+		for i := range marked {
+			dest = append(dest, &marked[i])
+		}
+		for i := range extraRanks {
+			dest = append(dest, &extraRanks[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, fmt.Errorf("scan row: %w", err)
 		}
+		if dataEnc.Valid {
+			doc, err := DecodeDoc([]byte(row.DataJSON), dataEnc.String)
+			if err != nil {
+				return nil, fmt.Errorf("item %s: %w", row.Path, err)
+			}
+			row.DataJSON = string(doc)
+		}
 		if score.Valid {
 			row.Score = &score.Float64
 		}
+		if len(sortKeys) > 0 {
+			row.RankValues = append([]float64{score.Float64}, extraRanks...)
+		}
+		if highlight != nil {
+			row.Highlights = map[string]string{}
+			for i, s := range snippets {
+				// Fields without a match come back unmarked; skip them
+				if s.Valid && strings.Contains(s.String, highlight.Open) {
+					row.Highlights[hlFields[i]] = s.String
+				}
+			}
+		}
+		if opts.MatchPositions {
+			row.MatchPositions = map[string][]int{}
+			for i, s := range marked {
+				if offsets := matchOffsets(s.String); s.Valid && len(offsets) > 0 {
+					row.MatchPositions[posFields[i]] = offsets
+				}
+			}
+		}
 		searchRows = append(searchRows, row)
 	}
 	if err := rows.Err(); err != nil {
@@ -164,15 +357,55 @@ func Search(
 
 	// 9. Shape output
 	result := &SearchResult{
-		HasMore: hasMore,
+		HasMore:  hasMore,
+		FTS:      len(compiled.TextPreds) > 0,
+		Limit:    limit,
+		Returned: len(searchRows),
+	}
+	switch {
+	case opts.After == "" && !hasMore:
+		total := uint64(len(searchRows))
+		result.EstimatedTotal = &total
+	case opts.EstimateTotal:
+		total, err := CountMatches(ctx, db, adapter.PlaceholderStyle(), compiled, cteArgs, opts.IncludeDeleted, snapshotMaxID)
+		if err != nil {
+			return nil, err
+		}
+		result.EstimatedTotal = &total
 	}
 
-	if opts.Explain {
+	if opts.Explain || opts.ExplainPlan || opts.Profile {
 		result.ExplainSQL = searchSQL
 		result.ExplainSteps = compiled.ExplainSteps
 	}
+	if opts.ExplainPlan {
+		if err := MeasurePlan(ctx, db, compiled, cteArgs); err != nil {
+			return nil, fmt.Errorf("explain plan: %w", err)
+		}
+		result.ExplainPlan = compiled.Plan
+	}
+	if opts.Profile {
+		if result.Profile, err = ProfileCTEs(ctx, db, adapter.Backend(), compiled, cteArgs); err != nil {
+			return nil, fmt.Errorf("profile: %w", err)
+		}
+	}
+
+	if opts.SuggestOnEmpty && opts.After == "" && len(searchRows) == 0 {
+		result.SuggestField, result.Suggestions, err = SuggestValues(ctx, db, adapter, schema, normalizedExpr, opts.MaxSuggestions)
+		if err != nil {
+			return nil, fmt.Errorf("suggest values: %w", err)
+		}
+	}
 
+	loadFull := opts.LoadDoc != nil && needsFullDoc(schema, opts.Show)
 	for _, row := range searchRows {
+		if loadFull {
+			doc, err := opts.LoadDoc(row.Path, []byte(row.DataJSON))
+			if err != nil {
+				return nil, fmt.Errorf("load document: %w", err)
+			}
+			row.DataJSON = string(doc)
+		}
 		shaped, err := shapeOutput(row, opts.Show)
 		if err != nil {
 			return nil, fmt.Errorf("shape output: %w", err)
@@ -184,9 +417,10 @@ func Search(
 	if hasMore && len(searchRows) > 0 {
 		lastRow := searchRows[len(searchRows)-1]
 		cursor := CursorPayload{
-			ItemID:      lastRow.ItemID,
-			Path:        lastRow.Path,
-			UpdatedAtMS: lastRow.UpdatedAt,
+			ItemID:        lastRow.ItemID,
+			Path:          lastRow.Path,
+			UpdatedAtMS:   lastRow.UpdatedAt,
+			SnapshotMaxID: snapshotMaxID,
 		}
 		if lastRow.Score != nil {
 			cursor.Score = *lastRow.Score
@@ -204,8 +438,9 @@ func Search(
 			cursor.Kind = CursorKindRecency
 		case planner.RankField:
 			cursor.Kind = CursorKindField
-			cursor.Field = opts.Rank.Field
+			cursor.Field = sortKeys[0].Field
 			cursor.RankValue = cursor.Score
+			cursor.RankValues = lastRow.RankValues
 		case planner.RankNone:
 			cursor.Kind = CursorKindNone
 		}
@@ -220,13 +455,111 @@ func Search(
 	return result, nil
 }
 
-// shapeOutput shapes a search row for output based on field selector
+// CountMatches counts the items compiled matches, the way a search over it
+// would see them: live items unless includeDeleted, and none above a
+// positive snapshotMaxID. It ignores MinScore and DistinctBy, so it can
+// count more items than the search pages through. args are the arguments of
+// the compiled CTEs, as for MeasurePlan.
+func CountMatches(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, compiled *planner.CompileOutput, args []any, includeDeleted bool, snapshotMaxID int64) (uint64, error) {
+	q := fmt.Sprintf("%s SELECT COUNT(*) FROM items i WHERE i.id IN (SELECT item_id FROM %s)", withClause(compiled), compiled.ResultCTE)
+	if !includeDeleted {
+		q += " AND i.deleted_at IS NULL"
+	}
+	if snapshotMaxID > 0 {
+		args = append(append([]any(nil), args...), snapshotMaxID)
+		q += " AND i.id <= " + ph(style, len(args))
+	}
+	var n uint64
+	if err := db.QueryRowContext(ctx, q, args...).Scan(&n); err != nil {
+		return 0, storage.WrapSQL("count matches", q, len(args), err)
+	}
+	return n, nil
+}
+
+// NormalizeOptionsFor returns the default normalize options with checks that
+// need the schema (such as fields(...) validation) wired up
+func NormalizeOptionsFor(schema storage.Schema) query.NormalizeOptions {
+	opts := query.DefaultNormalizeOptions()
+	opts.IsTextField = func(name string) bool {
+		spec, ok := schema.Get(name)
+		return ok && spec.HasFTS()
+	}
+	opts.Aliases = schema.Aliases()
+	return opts
+}
+
+// withHighlightDefaults fills unset HighlightSpec fields. Each marker
+// defaults on its own, so setting only Open still closes every match.
+func withHighlightDefaults(spec storage.HighlightSpec) storage.HighlightSpec {
+	if spec.Tokens <= 0 {
+		spec.Tokens = 10
+	}
+	if spec.Open == "" {
+		spec.Open = "<b>"
+	}
+	if spec.Close == "" {
+		spec.Close = "</b>"
+	}
+	return spec
+}
+
+// needsFullDoc reports whether show reads keys that a projected data_json
+// may lack
+func needsFullDoc(schema storage.Schema, show OutputFieldSelector) bool {
+	switch show.Kind {
+	case ShowAll:
+		return true
+	case ShowFields:
+		for _, f := range show.Fields {
+			if !schema.HasField(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchOffsets returns the start, end byte offset pairs of the terms marked
+// with storage.MatchOpen and MatchClose, as offsets into the unmarked text
+func matchOffsets(marked string) []int {
+	var offsets []int
+	pos := 0
+	for i := 0; i < len(marked); i++ {
+		switch marked[i] {
+		case storage.MatchOpen[0], storage.MatchClose[0]:
+			offsets = append(offsets, pos)
+		default:
+			pos++
+		}
+	}
+	if len(offsets)%2 != 0 {
+		return nil // a marker byte in the text itself; the pairs cannot be trusted
+	}
+	return offsets
+}
+
+// shapeOutput shapes a search row for output based on field selector.
+// Rows with highlights get them under a "highlights" key, and match
+// positions under "match_positions".
 func shapeOutput(row SearchRow, show OutputFieldSelector) ([]byte, error) {
+	output, err := shapeFields(row, show)
+	if err != nil {
+		return nil, err
+	}
+	if row.Highlights != nil {
+		output["highlights"] = row.Highlights
+	}
+	if row.MatchPositions != nil {
+		output["match_positions"] = row.MatchPositions
+	}
+	return json.Marshal(output)
+}
+
+func shapeFields(row SearchRow, show OutputFieldSelector) (map[string]interface{}, error) {
 	switch show.Kind {
 	case ShowNone:
 		// Just return path
-		output := map[string]interface{}{"path": row.Path}
-		return json.Marshal(output)
+		return map[string]interface{}{"path": row.Path}, nil
 
 	case ShowAll:
 		// Return entire document (ensure path is present)
@@ -237,7 +570,7 @@ func shapeOutput(row SearchRow, show OutputFieldSelector) ([]byte, error) {
 		if _, ok := doc["path"]; !ok {
 			doc["path"] = row.Path
 		}
-		return json.Marshal(doc)
+		return doc, nil
 
 	case ShowFields:
 		// Return path + selected fields
@@ -246,17 +579,134 @@ func shapeOutput(row SearchRow, show OutputFieldSelector) ([]byte, error) {
 			return nil, err
 		}
 
+		if show.Nested {
+			output := projectNested(doc, show.Fields)
+			output["path"] = row.Path
+			return output, nil
+		}
 		output := map[string]interface{}{"path": row.Path}
 		for _, field := range show.Fields {
-			if val, ok := doc[field]; ok {
+			if val, ok := lookupPath(doc, field); ok {
 				output[field] = val
 			}
 		}
-		return json.Marshal(output)
+		return output, nil
 
 	default:
-		return json.Marshal(map[string]interface{}{"path": row.Path})
+		return map[string]interface{}{"path": row.Path}, nil
+	}
+}
+
+// lookupPath returns the value at a dotted field path in doc. A top-level key
+// holding the whole path wins; otherwise the path is walked through objects,
+// and through arrays of objects collecting each element's values the way
+// put flattens them, so "authors.name" over [{"name":"a"},{"name":"b"}] is
+// ["a","b"].
+func lookupPath(doc map[string]interface{}, field string) (interface{}, bool) {
+	if val, ok := doc[field]; ok {
+		return val, true
+	}
+	root, rest, ok := strings.Cut(field, ".")
+	if !ok {
+		return nil, false
 	}
+	return walkPath(doc[root], strings.Split(rest, "."))
+}
+
+func walkPath(val interface{}, parts []string) (interface{}, bool) {
+	if len(parts) == 0 {
+		return val, val != nil
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return walkPath(v[parts[0]], parts[1:])
+	case []interface{}:
+		var out []interface{}
+		for _, item := range v {
+			got, ok := walkPath(item, parts)
+			if !ok {
+				continue
+			}
+			if arr, isArr := got.([]interface{}); isArr {
+				out = append(out, arr...)
+			} else {
+				out = append(out, got)
+			}
+		}
+		return out, len(out) > 0
+	}
+	return nil, false
+}
+
+// projectNode is one level of the field paths selected by projectNested; a
+// leaf keeps its whole value
+type projectNode struct {
+	leaf     bool
+	children map[string]*projectNode
+}
+
+// projectNested returns the parts of doc selected by dotted field paths,
+// keeping their nesting: "author.name" and "author.email" give
+// {"author":{"name":...,"email":...}}. Arrays of objects keep one pruned
+// object per element that holds a selected key.
+func projectNested(doc map[string]interface{}, fields []string) map[string]interface{} {
+	root := &projectNode{}
+	for _, field := range fields {
+		node := root
+		for _, part := range strings.Split(field, ".") {
+			if node.children == nil {
+				node.children = make(map[string]*projectNode)
+			}
+			child, ok := node.children[part]
+			if !ok {
+				child = &projectNode{}
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.leaf = true
+	}
+	out, _ := pruneValue(doc, root).(map[string]interface{})
+	if out == nil {
+		out = make(map[string]interface{})
+	}
+	return out
+}
+
+// pruneValue keeps only the keys of val selected by node; it returns nil when
+// nothing is selected
+func pruneValue(val interface{}, node *projectNode) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for key, child := range node.children {
+			if child.leaf {
+				if kept, ok := v[key]; ok {
+					out[key] = kept
+				}
+				continue
+			}
+			if kept := pruneValue(v[key], child); kept != nil {
+				out[key] = kept
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	case []interface{}:
+		var out []interface{}
+		for _, item := range v {
+			if kept := pruneValue(item, node); kept != nil {
+				out = append(out, kept)
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	}
+	return nil
 }
 
 // CursorKind specifies the cursor payload type
@@ -278,6 +728,11 @@ type CursorPayload struct {
 	Path        string     `json:"path,omitempty"`
 	Field       string     `json:"field,omitempty"`
 	RankValue   float64    `json:"rank_value,omitempty"`
+	RankValues  []float64  `json:"rank_values,omitempty"` // one per RankField sort key
+
+	// SnapshotMaxID is the highest item id when a snapshot search began;
+	// 0 for searches without a snapshot
+	SnapshotMaxID int64 `json:"snapshot_max_id,omitempty"`
 }
 
 // CursorStore abstracts cursor storage
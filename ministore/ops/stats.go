@@ -4,7 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
+	"strings"
+	"time"
 
+	"github.com/ministore/ministore/ministore/query"
 	"github.com/ministore/ministore/ministore/storage"
 	"github.com/ministore/ministore/ministore/storage/sqlbuilder"
 )
@@ -17,10 +21,18 @@ type StatsResult struct {
 	Max    *float64
 	Avg    *float64
 	Median *float64
+
+	// Percentiles maps each requested percentile (0-100) to its value
+	Percentiles map[float64]float64
 }
 
 // Stats computes statistics for a numeric or date field
-func Stats(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field string, whereSQL string, whereArgs []any) (*StatsResult, error) {
+func Stats(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field string, whereSQL string, whereArgs []any, percentiles []float64) (*StatsResult, error) {
+	for _, p := range percentiles {
+		if !(p >= 0 && p <= 100) {
+			return nil, fmt.Errorf("percentile must be between 0 and 100, got %v", p)
+		}
+	}
 	style := adapter.PlaceholderStyle()
 
 	// Handle implicit created/updated fields
@@ -29,7 +41,7 @@ func Stats(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema stor
 		if field == "updated" {
 			col = "updated_at"
 		}
-		return statsFromItemsColumn(ctx, db, style, field, col, whereSQL, whereArgs)
+		return statsFromItemsColumn(ctx, db, style, field, col, whereSQL, whereArgs, percentiles)
 	}
 
 	// Validate field exists
@@ -49,12 +61,12 @@ func Stats(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema stor
 	}
 
 	if whereSQL == "" {
-		return statsFromTable(ctx, db, style, field, table)
+		return statsFromTable(ctx, db, style, field, table, percentiles)
 	}
-	return statsFromTableFiltered(ctx, db, style, field, table, whereSQL, whereArgs)
+	return statsFromTableFiltered(ctx, db, style, field, table, whereSQL, whereArgs, percentiles)
 }
 
-func statsFromItemsColumn(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, field, col, whereSQL string, whereArgs []any) (*StatsResult, error) {
+func statsFromItemsColumn(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, field, col, whereSQL string, whereArgs []any, percentiles []float64) (*StatsResult, error) {
 	result := &StatsResult{Field: field}
 
 	var querySQL string
@@ -64,6 +76,7 @@ func statsFromItemsColumn(ctx context.Context, db *sql.DB, style sqlbuilder.Plac
 		querySQL = fmt.Sprintf(`
 			SELECT COUNT(*), MIN(%s), MAX(%s), AVG(%s)
 			FROM items
+			WHERE deleted_at IS NULL
 		`, col, col, col)
 	} else {
 		querySQL = fmt.Sprintf(`
@@ -71,6 +84,7 @@ func statsFromItemsColumn(ctx context.Context, db *sql.DB, style sqlbuilder.Plac
 			SELECT COUNT(*), MIN(i.%s), MAX(i.%s), AVG(i.%s)
 			FROM items i
 			JOIN filtered f ON f.item_id = i.id
+			WHERE i.deleted_at IS NULL
 		`, whereSQL, col, col, col)
 		args = whereArgs
 	}
@@ -93,18 +107,18 @@ func statsFromItemsColumn(ctx context.Context, db *sql.DB, style sqlbuilder.Plac
 		result.Avg = &avgVal.Float64
 	}
 
-	// Calculate median
+	// Calculate median and requested percentiles
 	if count > 0 {
-		median, err := medianFromItemsColumn(ctx, db, style, col, whereSQL, whereArgs, count)
-		if err == nil {
-			result.Median = median
+		valueAt := itemsColumnValueAt(ctx, db, style, col, whereSQL, whereArgs)
+		if err := fillPercentiles(result, count, percentiles, valueAt); err != nil {
+			return nil, err
 		}
 	}
 
 	return result, nil
 }
 
-func statsFromTable(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, field, table string) (*StatsResult, error) {
+func statsFromTable(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, field, table string, percentiles []float64) (*StatsResult, error) {
 	result := &StatsResult{Field: field}
 
 	querySQL := fmt.Sprintf(`
@@ -131,18 +145,18 @@ func statsFromTable(ctx context.Context, db *sql.DB, style sqlbuilder.Placeholde
 		result.Avg = &avgVal.Float64
 	}
 
-	// Calculate median
+	// Calculate median and requested percentiles
 	if count > 0 {
-		median, err := medianFromTable(ctx, db, style, table, field, count)
-		if err == nil {
-			result.Median = median
+		valueAt := tableValueAt(ctx, db, style, table, field)
+		if err := fillPercentiles(result, count, percentiles, valueAt); err != nil {
+			return nil, err
 		}
 	}
 
 	return result, nil
 }
 
-func statsFromTableFiltered(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, field, table, whereSQL string, whereArgs []any) (*StatsResult, error) {
+func statsFromTableFiltered(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, field, table, whereSQL string, whereArgs []any, percentiles []float64) (*StatsResult, error) {
 	result := &StatsResult{Field: field}
 
 	base := len(whereArgs)
@@ -174,20 +188,64 @@ func statsFromTableFiltered(ctx context.Context, db *sql.DB, style sqlbuilder.Pl
 		result.Avg = &avgVal.Float64
 	}
 
-	// Calculate median
+	// Calculate median and requested percentiles
 	if count > 0 {
-		median, err := medianFromTableFiltered(ctx, db, style, table, field, whereSQL, whereArgs, count)
-		if err == nil {
-			result.Median = median
+		valueAt := tableFilteredValueAt(ctx, db, style, table, field, whereSQL, whereArgs)
+		if err := fillPercentiles(result, count, percentiles, valueAt); err != nil {
+			return nil, err
 		}
 	}
 
 	return result, nil
 }
 
-func medianFromTable(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, table, field string, count uint64) (*float64, error) {
-	offset := (count - 1) / 2
+// fillPercentiles sets result's median and requested percentiles from count
+// sorted values read by valueAt. The median is left nil if it cannot be
+// read; a requested percentile that cannot be read is an error.
+func fillPercentiles(result *StatsResult, count uint64, percentiles []float64, valueAt func(offset uint64) (float64, error)) error {
+	if median, err := percentile(count, 50, valueAt); err == nil {
+		result.Median = &median
+	}
+	if len(percentiles) == 0 {
+		return nil
+	}
+	result.Percentiles = make(map[float64]float64, len(percentiles))
+	for _, p := range percentiles {
+		v, err := percentile(count, p, valueAt)
+		if err != nil {
+			return fmt.Errorf("query percentile %v: %w", p, err)
+		}
+		result.Percentiles[p] = v
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile (0-100) of count sorted values read
+// by valueAt: the value at rank p*(count-1)/100, interpolated linearly
+// between the two neighbouring values when the rank is fractional. p=50 is
+// the median, the mean of the middle two values for an even count.
+func percentile(count uint64, p float64, valueAt func(offset uint64) (float64, error)) (float64, error) {
+	rank := p * float64(count-1) / 100
+	offset := uint64(math.Floor(rank))
+	if offset >= count {
+		offset = count - 1
+	}
+	v, err := valueAt(offset)
+	if err != nil {
+		return 0, err
+	}
+	if frac := rank - float64(offset); frac > 0 && offset+1 < count {
+		next, err := valueAt(offset + 1)
+		if err != nil {
+			return 0, err
+		}
+		v += frac * (next - v)
+	}
+	return v, nil
+}
 
+// tableValueAt reads the value at offset among field's sorted values in table
+func tableValueAt(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, table, field string) func(uint64) (float64, error) {
 	querySQL := fmt.Sprintf(`
 		SELECT value FROM %s
 		WHERE field = %s
@@ -195,29 +253,15 @@ func medianFromTable(ctx context.Context, db *sql.DB, style sqlbuilder.Placehold
 		LIMIT 1 OFFSET %s
 	`, table, ph(style, 1), ph(style, 2))
 
-	var val1 float64
-	err := db.QueryRowContext(ctx, querySQL, field, offset).Scan(&val1)
-	if err != nil {
-		return nil, err
-	}
-
-	// For even count, average middle two values
-	if count%2 == 0 {
-		var val2 float64
-		err := db.QueryRowContext(ctx, querySQL, field, offset+1).Scan(&val2)
-		if err != nil {
-			return nil, err
-		}
-		median := (val1 + val2) / 2
-		return &median, nil
+	return func(offset uint64) (float64, error) {
+		var v float64
+		err := db.QueryRowContext(ctx, querySQL, field, offset).Scan(&v)
+		return v, err
 	}
-
-	return &val1, nil
 }
 
-func medianFromTableFiltered(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, table, field, whereSQL string, whereArgs []any, count uint64) (*float64, error) {
-	offset := (count - 1) / 2
-
+// tableFilteredValueAt is tableValueAt restricted to the items of whereSQL
+func tableFilteredValueAt(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, table, field, whereSQL string, whereArgs []any) func(uint64) (float64, error) {
 	base := len(whereArgs)
 	querySQL := fmt.Sprintf(`
 		WITH filtered AS (%s)
@@ -228,77 +272,256 @@ func medianFromTableFiltered(ctx context.Context, db *sql.DB, style sqlbuilder.P
 		LIMIT 1 OFFSET %s
 	`, whereSQL, table, ph(style, base+1), ph(style, base+2))
 
-	args := append(whereArgs, field, offset)
-
-	var val1 float64
-	err := db.QueryRowContext(ctx, querySQL, args...).Scan(&val1)
-	if err != nil {
-		return nil, err
-	}
-
-	// For even count, average middle two values
-	if count%2 == 0 {
-		args2 := append(whereArgs, field, offset+1)
-		var val2 float64
-		err := db.QueryRowContext(ctx, querySQL, args2...).Scan(&val2)
-		if err != nil {
-			return nil, err
-		}
-		median := (val1 + val2) / 2
-		return &median, nil
+	return func(offset uint64) (float64, error) {
+		args := append(append([]any{}, whereArgs...), field, offset)
+		var v float64
+		err := db.QueryRowContext(ctx, querySQL, args...).Scan(&v)
+		return v, err
 	}
-
-	return &val1, nil
 }
 
-func medianFromItemsColumn(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, col, whereSQL string, whereArgs []any, count uint64) (*float64, error) {
-	offset := (count - 1) / 2
-
+// itemsColumnValueAt reads the value at offset among the sorted values of an
+// items column, restricted to the items of whereSQL when it is set
+func itemsColumnValueAt(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, col, whereSQL string, whereArgs []any) func(uint64) (float64, error) {
 	var querySQL string
-	var args []any
-
 	if whereSQL == "" {
 		querySQL = fmt.Sprintf(`
 			SELECT %s FROM items
+			WHERE deleted_at IS NULL
 			ORDER BY %s
 			LIMIT 1 OFFSET %s
 		`, col, col, ph(style, 1))
-		args = []any{offset}
 	} else {
-		base := len(whereArgs)
 		querySQL = fmt.Sprintf(`
 			WITH filtered AS (%s)
 			SELECT i.%s FROM items i
 			JOIN filtered f ON f.item_id = i.id
+			WHERE i.deleted_at IS NULL
 			ORDER BY i.%s
 			LIMIT 1 OFFSET %s
-		`, whereSQL, col, col, ph(style, base+1))
-		args = append(whereArgs, offset)
+		`, whereSQL, col, col, ph(style, len(whereArgs)+1))
+	}
+
+	return func(offset uint64) (float64, error) {
+		args := append(append([]any{}, whereArgs...), offset)
+		var v float64
+		err := db.QueryRowContext(ctx, querySQL, args...).Scan(&v)
+		return v, err
+	}
+}
+
+// MaxHistogramBuckets caps the number of buckets one histogram may span
+const MaxHistogramBuckets = 5000
+
+// Bucket is a number histogram bucket covering [Lo, Hi)
+type Bucket struct {
+	Lo    float64
+	Hi    float64
+	Count uint64
+}
+
+// DateBucket is a date histogram bucket covering [StartMS, EndMS)
+type DateBucket struct {
+	StartMS int64
+	EndMS   int64
+	Count   uint64
+}
+
+// Histogram counts the values of a number field in buckets of bucketWidth
+// aligned to multiples of it. Buckets run from the one holding the smallest
+// value to the one holding the largest, empty buckets in between included.
+func Histogram(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field string, whereSQL string, whereArgs []any, bucketWidth float64) ([]Bucket, error) {
+	spec, ok := schema.Get(field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field: %s", field)
+	}
+	if spec.Type != storage.FieldType("number") {
+		return nil, fmt.Errorf("histogram only available for number fields, got %s", spec.Type)
+	}
+	if !(bucketWidth > 0) || math.IsInf(bucketWidth, 1) {
+		return nil, fmt.Errorf("bucket width must be a positive number, got %v", bucketWidth)
+	}
+
+	style := adapter.PlaceholderStyle()
+	with, args := histogramValues(style, "field_number", field, whereSQL, whereArgs)
+	lo, hi, ok, err := histogramRange(ctx, db, with, args)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	first, last := math.Floor(lo/bucketWidth), math.Floor(hi/bucketWidth)
+	if last-first+1 > MaxHistogramBuckets {
+		return nil, fmt.Errorf("histogram of %s would span %.0f buckets (max %d); use a wider bucket or narrow the where clause", field, last-first+1, MaxHistogramBuckets)
+	}
+	var buckets []Bucket
+	var bounds [][2]any
+	for i := first; i <= last; i++ {
+		b := Bucket{Lo: i * bucketWidth, Hi: (i + 1) * bucketWidth}
+		buckets = append(buckets, b)
+		bounds = append(bounds, [2]any{b.Lo, b.Hi})
 	}
 
-	var val1 float64
-	err := db.QueryRowContext(ctx, querySQL, args...).Scan(&val1)
+	counts, err := histogramCounts(ctx, db, style, with, args, "DOUBLE PRECISION", bounds)
 	if err != nil {
 		return nil, err
 	}
+	for i := range buckets {
+		buckets[i].Count = counts[i]
+	}
+	return buckets, nil
+}
 
-	// For even count, average middle two values
-	if count%2 == 0 {
-		var args2 []any
-		if whereSQL == "" {
-			args2 = []any{offset + 1}
-		} else {
-			args2 = append(whereArgs, offset+1)
+// DateHistogram counts the values of a date field, or the implicit created
+// and updated fields, in calendar buckets of interval ("day", "week" or
+// "month") computed in loc. Weeks start on Monday. Buckets run from the one
+// holding the earliest value to the one holding the latest, empty buckets
+// in between included.
+func DateHistogram(ctx context.Context, db *sql.DB, adapter storage.Adapter, schema storage.Schema, field string, whereSQL string, whereArgs []any, interval string, loc *time.Location) ([]DateBucket, error) {
+	var window query.CalendarWindow
+	switch interval {
+	case "day":
+		window = query.CalToday
+	case "week":
+		window = query.CalThisWeek
+	case "month":
+		window = query.CalThisMonth
+	default:
+		return nil, fmt.Errorf("unknown histogram interval %q (want day, week or month)", interval)
+	}
+
+	style := adapter.PlaceholderStyle()
+	var with string
+	var args []any
+	if field == "created" || field == "updated" {
+		col := "created_at"
+		if field == "updated" {
+			col = "updated_at"
+		}
+		with, args = histogramItemsColumn(col, whereSQL, whereArgs)
+	} else {
+		spec, ok := schema.Get(field)
+		if !ok {
+			return nil, fmt.Errorf("unknown field: %s", field)
+		}
+		if spec.Type != storage.FieldType("date") {
+			return nil, fmt.Errorf("date histogram only available for date fields, got %s", spec.Type)
 		}
+		with, args = histogramValues(style, "field_date", field, whereSQL, whereArgs)
+	}
 
-		var val2 float64
-		err := db.QueryRowContext(ctx, querySQL, args2...).Scan(&val2)
-		if err != nil {
-			return nil, err
+	lo, hi, ok, err := histogramRange(ctx, db, with, args)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var buckets []DateBucket
+	var bounds [][2]any
+	startMS, _ := window.Bounds(time.UnixMilli(int64(lo)).In(loc))
+	for startMS <= int64(hi) {
+		if len(buckets) == MaxHistogramBuckets {
+			return nil, fmt.Errorf("histogram of %s would span more than %d buckets; use a coarser interval or narrow the where clause", field, MaxHistogramBuckets)
 		}
-		median := (val1 + val2) / 2
-		return &median, nil
+		_, endMS := window.Bounds(time.UnixMilli(startMS).In(loc))
+		buckets = append(buckets, DateBucket{StartMS: startMS, EndMS: endMS})
+		bounds = append(bounds, [2]any{startMS, endMS})
+		startMS = endMS
 	}
 
-	return &val1, nil
+	counts, err := histogramCounts(ctx, db, style, with, args, "BIGINT", bounds)
+	if err != nil {
+		return nil, err
+	}
+	for i := range buckets {
+		buckets[i].Count = counts[i]
+	}
+	return buckets, nil
+}
+
+// histogramValues returns a WITH clause declaring a vals CTE with the values
+// of field in table, restricted to the items of whereSQL when it is set
+func histogramValues(style sqlbuilder.PlaceholderStyle, table, field, whereSQL string, whereArgs []any) (string, []any) {
+	if whereSQL == "" {
+		return fmt.Sprintf(`
+			WITH vals AS (
+				SELECT value FROM %s WHERE field = %s
+			)`, table, ph(style, 1)), []any{field}
+	}
+	return fmt.Sprintf(`
+		WITH filtered AS (%s),
+		vals AS (
+			SELECT t.value
+			FROM %s t
+			JOIN filtered f ON f.item_id = t.item_id
+			WHERE t.field = %s
+		)`, whereSQL, table, ph(style, len(whereArgs)+1)), append(append([]any{}, whereArgs...), field)
+}
+
+// histogramItemsColumn is histogramValues for an items column
+func histogramItemsColumn(col, whereSQL string, whereArgs []any) (string, []any) {
+	if whereSQL == "" {
+		return fmt.Sprintf(`
+			WITH vals AS (
+				SELECT %s AS value FROM items WHERE deleted_at IS NULL
+			)`, col), nil
+	}
+	return fmt.Sprintf(`
+		WITH filtered AS (%s),
+		vals AS (
+			SELECT i.%s AS value
+			FROM items i
+			JOIN filtered f ON f.item_id = i.id
+			WHERE i.deleted_at IS NULL
+		)`, whereSQL, col), whereArgs
+}
+
+// histogramRange returns the smallest and largest value of the vals CTE;
+// ok is false when it is empty
+func histogramRange(ctx context.Context, db *sql.DB, with string, args []any) (lo, hi float64, ok bool, err error) {
+	var minVal, maxVal sql.NullFloat64
+	if err := db.QueryRowContext(ctx, with+" SELECT MIN(value), MAX(value) FROM vals", args...).Scan(&minVal, &maxVal); err != nil {
+		return 0, 0, false, fmt.Errorf("query histogram range: %w", err)
+	}
+	return minVal.Float64, maxVal.Float64, minVal.Valid && maxVal.Valid, nil
+}
+
+// histogramCounts counts the values of the vals CTE falling in each
+// [lo, hi) of bounds, which must be sorted. Bounds are passed as a VALUES
+// list cast to sqlType so both backends compare them numerically.
+func histogramCounts(ctx context.Context, db *sql.DB, style sqlbuilder.PlaceholderStyle, with string, args []any, sqlType string, bounds [][2]any) ([]uint64, error) {
+	args = append([]any{}, args...)
+	rows := make([]string, len(bounds))
+	for i, b := range bounds {
+		args = append(args, b[0], b[1])
+		rows[i] = fmt.Sprintf("(CAST(%s AS %s), CAST(%s AS %s))", ph(style, len(args)-1), sqlType, ph(style, len(args)), sqlType)
+	}
+	querySQL := fmt.Sprintf(`%s,
+		bounds (lo, hi) AS (VALUES %s)
+		SELECT COUNT(v.value)
+		FROM bounds b
+		LEFT JOIN vals v ON v.value >= b.lo AND v.value < b.hi
+		GROUP BY b.lo
+		ORDER BY b.lo
+	`, with, strings.Join(rows, ", "))
+
+	result, err := db.QueryContext(ctx, querySQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query histogram: %w", err)
+	}
+	defer result.Close()
+
+	counts := make([]uint64, 0, len(bounds))
+	for result.Next() {
+		var n uint64
+		if err := result.Scan(&n); err != nil {
+			return nil, fmt.Errorf("scan histogram: %w", err)
+		}
+		counts = append(counts, n)
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	if len(counts) != len(bounds) {
+		return nil, fmt.Errorf("histogram returned %d buckets, want %d", len(counts), len(bounds))
+	}
+	return counts, nil
 }
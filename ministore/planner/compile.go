@@ -2,6 +2,11 @@ package planner
 
 import (
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ministore/ministore/ministore/query"
 	"github.com/ministore/ministore/ministore/storage"
@@ -12,6 +17,7 @@ type CompileOutput struct {
 	CTEs            []CTE
 	ResultCTE       string
 	ExplainSteps    []string
+	Plan            *PlanNode               // typed tree of the steps, rooted at ResultCTE
 	TextPreds       []storage.TextPredicate // positive-context only, for scoring
 	RequiresFTSJoin bool                    // query evaluation needs FTS
 }
@@ -20,6 +26,7 @@ type CompileOutput struct {
 type CTE struct {
 	Name string
 	SQL  string
+	Step string // explain step that produced it
 }
 
 // Compiler compiles query expressions to CTEs
@@ -30,15 +37,22 @@ type Compiler struct {
 	schema          storage.Schema
 	builder         storage.Builder
 	nowMS           int64
+	loc             *time.Location // for bare dates and calendar windows
 	ctes            []CTE
 	explainSteps    []string
+	nodes           map[string]*PlanNode // CTE name -> plan node
 	cteCounter      int
 	textPreds       []storage.TextPredicate
 	requiresFTSJoin bool
+	universe        string // CTE that NOT complements against; "" means all items
 }
 
-// Compile compiles a query expression into CTEs.
-func Compile(adapter storage.Adapter, schema storage.Schema, builder storage.Builder, expr query.Expr, nowMS int64) (*CompileOutput, error) {
+// Compile compiles a query expression into CTEs. loc is the index's time
+// zone (nil means UTC).
+func Compile(adapter storage.Adapter, schema storage.Schema, builder storage.Builder, expr query.Expr, nowMS int64, loc *time.Location) (*CompileOutput, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
 	c := &Compiler{
 		adapter: adapter,
 		fts:     adapter.FTS(),
@@ -46,6 +60,8 @@ func Compile(adapter storage.Adapter, schema storage.Schema, builder storage.Bui
 		schema:  schema,
 		builder: builder,
 		nowMS:   nowMS,
+		loc:     loc,
+		nodes:   make(map[string]*PlanNode),
 	}
 
 	resultCTE, err := c.compileExpr(expr, true /*positive*/)
@@ -57,6 +73,7 @@ func Compile(adapter storage.Adapter, schema storage.Schema, builder storage.Bui
 		CTEs:            c.ctes,
 		ResultCTE:       resultCTE,
 		ExplainSteps:    c.explainSteps,
+		Plan:            c.nodes[resultCTE],
 		TextPreds:       c.textPreds,
 		RequiresFTSJoin: c.requiresFTSJoin,
 	}, nil
@@ -71,11 +88,22 @@ func (c *Compiler) nextCTEName() string {
 func (c *Compiler) compileExpr(expr query.Expr, positive bool) (string, error) {
 	switch e := expr.(type) {
 	case query.And:
-		leftName, err := c.compileExpr(e.Left, positive)
+		// A restricted OR is compiled within the other side, whose items
+		// bound the AND anyway, so its anchorless branch can add items
+		first, second := e.Left, e.Right
+		if hasRestrictedOr(first) && !hasRestrictedOr(second) {
+			first, second = second, first
+		}
+		leftName, err := c.compileExpr(first, positive)
 		if err != nil {
 			return "", err
 		}
-		rightName, err := c.compileExpr(e.Right, positive)
+		prevUniverse := c.universe
+		if hasRestrictedOr(second) {
+			c.universe = leftName
+		}
+		rightName, err := c.compileExpr(second, positive)
+		c.universe = prevUniverse
 		if err != nil {
 			return "", err
 		}
@@ -84,9 +112,20 @@ func (c *Compiler) compileExpr(expr query.Expr, positive bool) (string, error) {
 		sql := fmt.Sprintf("SELECT item_id FROM %s INTERSECT SELECT item_id FROM %s", leftName, rightName)
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("INTERSECT %s AND %s", leftName, rightName))
+		c.addNode(resultName, PlanIntersect, "", "", leftName, rightName)
 		return resultName, nil
 
 	case query.Or:
+		// The anchorless branch is evaluated within the enclosing AND
+		// rather than the whole items table
+		if e.RestrictToAnchored {
+			anchored, loose := e.Left, e.Right
+			if !query.HasPositiveAnchor(e.Left, query.NormalizeOptions{}) {
+				anchored, loose = e.Right, e.Left
+			}
+			return c.compileRestrictedOr(anchored, loose, positive)
+		}
+
 		leftName, err := c.compileExpr(e.Left, positive)
 		if err != nil {
 			return "", err
@@ -100,6 +139,7 @@ func (c *Compiler) compileExpr(expr query.Expr, positive bool) (string, error) {
 		sql := fmt.Sprintf("SELECT item_id FROM %s UNION SELECT item_id FROM %s", leftName, rightName)
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("UNION %s OR %s", leftName, rightName))
+		c.addNode(resultName, PlanUnion, "", "", leftName, rightName)
 		return resultName, nil
 
 	case query.Not:
@@ -108,10 +148,16 @@ func (c *Compiler) compileExpr(expr query.Expr, positive bool) (string, error) {
 			return "", err
 		}
 
+		universeSQL := "SELECT id AS item_id FROM items"
+		if c.universe != "" {
+			universeSQL = fmt.Sprintf("SELECT item_id FROM %s", c.universe)
+		}
+
 		resultName := c.nextCTEName()
-		sql := fmt.Sprintf("SELECT id AS item_id FROM items EXCEPT SELECT item_id FROM %s", innerName)
+		sql := fmt.Sprintf("%s EXCEPT SELECT item_id FROM %s", universeSQL, innerName)
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("EXCEPT NOT %s", innerName))
+		c.addNode(resultName, PlanExcept, "", "", innerName)
 		return resultName, nil
 
 	case query.Pred:
@@ -122,18 +168,57 @@ func (c *Compiler) compileExpr(expr query.Expr, positive bool) (string, error) {
 	}
 }
 
+// compileRestrictedOr compiles anchored OR loose, where loose has no positive
+// anchor. loose only adds the items of c.universe, the other side of an
+// enclosing AND, that it matches; at the top level there is no such set and
+// loose adds nothing, so only the anchored branch is compiled.
+func (c *Compiler) compileRestrictedOr(anchored, loose query.Expr, positive bool) (string, error) {
+	anchoredName, err := c.compileExpr(anchored, positive)
+	if err != nil {
+		return "", err
+	}
+	universe := c.universe
+	if universe == "" {
+		c.explainSteps = append(c.explainSteps, fmt.Sprintf("IGNORE unanchored OR branch of %s (no enclosing AND to evaluate it within)", anchoredName))
+		return anchoredName, nil
+	}
+
+	looseName, err := c.compileExpr(loose, positive)
+	if err != nil {
+		return "", err
+	}
+
+	restrictedName := c.nextCTEName()
+	sql := fmt.Sprintf("SELECT item_id FROM %s INTERSECT SELECT item_id FROM %s", looseName, universe)
+	c.ctes = append(c.ctes, CTE{Name: restrictedName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("RESTRICT %s TO %s", looseName, universe))
+	c.addNode(restrictedName, PlanRestrict, "", "", looseName, universe)
+
+	resultName := c.nextCTEName()
+	sql = fmt.Sprintf("SELECT item_id FROM %s UNION SELECT item_id FROM %s", anchoredName, restrictedName)
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("UNION %s OR %s", anchoredName, restrictedName))
+	c.addNode(resultName, PlanUnion, "", "", anchoredName, restrictedName)
+	return resultName, nil
+}
+
+// hasRestrictedOr reports whether expr holds an OR marked RestrictToAnchored
+// outside any NOT
+func hasRestrictedOr(expr query.Expr) bool {
+	switch e := expr.(type) {
+	case query.And:
+		return hasRestrictedOr(e.Left) || hasRestrictedOr(e.Right)
+	case query.Or:
+		return e.RestrictToAnchored || hasRestrictedOr(e.Left) || hasRestrictedOr(e.Right)
+	default:
+		return false
+	}
+}
+
 func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string, error) {
 	switch p := pred.(type) {
 	case query.Has:
-		if !c.schema.HasField(p.Field) {
-			return "", fmt.Errorf("unknown field: %s", p.Field)
-		}
-		resultName := c.nextCTEName()
-		ph := c.builder.Arg(p.Field)
-		sql := fmt.Sprintf("SELECT item_id FROM field_present WHERE field = %s", ph)
-		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
-		c.explainSteps = append(c.explainSteps, fmt.Sprintf("HAS %s", p.Field))
-		return resultName, nil
+		return c.compileHas(p)
 
 	case query.PathGlob:
 		resultName := c.nextCTEName()
@@ -158,15 +243,56 @@ func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string
 
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("PATH %s", pattern))
+		c.addNode(resultName, PlanPathMatch, "", pattern)
+		return resultName, nil
+
+	case query.PathExact:
+		// Equality uses the unique index on items.path
+		resultName := c.nextCTEName()
+		ph := c.builder.Arg(p.Path)
+		sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE path = %s", ph)
+		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+		c.explainSteps = append(c.explainSteps, fmt.Sprintf("PATH = %s", p.Path))
+		c.addNode(resultName, PlanPathEqual, "", p.Path)
+		return resultName, nil
+
+	case query.MatchAll:
+		resultName := c.nextCTEName()
+		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: "SELECT id AS item_id FROM items"})
+		c.explainSteps = append(c.explainSteps, "ALL ITEMS")
+		c.addNode(resultName, PlanAllItems, "", "")
 		return resultName, nil
 
+	case query.FuzzyKeyword:
+		return c.compileFuzzyKeyword(p)
+	case query.AnyKeyword:
+		return c.compileAnyKeyword(p)
+	case query.InSet:
+		return c.compileInSet(p)
+	case query.AllSet:
+		return c.compileAllSet(p)
 	case query.Keyword:
 		return c.compileKeyword(p, positive)
 
 	case query.Text:
 		return c.compileText(p, positive)
 
+	case query.MultiFieldText:
+		return c.compileMultiFieldText(p, positive)
+	case query.TextAny:
+		return c.compileTextAny(p, positive)
+	case query.NearText:
+		return c.compileNearText(p, positive)
+
 	case query.NumberCmp:
+		// A bare year lexes as a number: created:2024 is the whole year
+		if p.Op == query.CmpEq && p.Value == math.Trunc(p.Value) && c.isDateField(p.Field) {
+			year := strconv.FormatFloat(p.Value, 'f', -1, 64)
+			if loMS, hiMS, ok := query.ParsePartialDate(year, c.loc); ok {
+				return c.compilePartialDate(p.Field, year, loMS, hiMS)
+			}
+		}
+
 		// Handle implicit created/updated fields (timestamps as numbers)
 		if p.Field == "created" || p.Field == "updated" {
 			resultName := c.nextCTEName()
@@ -178,6 +304,7 @@ func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string
 			sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s %s %s", col, p.Op.String(), ph)
 			c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 			c.explainSteps = append(c.explainSteps, fmt.Sprintf("IMPLICIT TIMESTAMP %s%s%v", p.Field, p.Op.String(), p.Value))
+			c.addNode(resultName, PlanTimestampCmp, p.Field, fmt.Sprintf("%s%v", p.Op.String(), p.Value))
 			return resultName, nil
 		}
 
@@ -192,11 +319,12 @@ func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string
 		resultName := c.nextCTEName()
 		phField := c.builder.Arg(p.Field)
 		phVal := c.builder.Arg(p.Value)
-		sql := fmt.Sprintf("SELECT item_id FROM field_number WHERE field = %s AND value %s %s",
+		sql := fmt.Sprintf("SELECT DISTINCT item_id FROM field_number WHERE field = %s AND value %s %s",
 			phField, p.Op.String(), phVal)
 
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("NUMBER %s%s%v", p.Field, p.Op.String(), p.Value))
+		c.addNode(resultName, PlanNumberCmp, p.Field, fmt.Sprintf("%s%v", p.Op.String(), p.Value))
 		return resultName, nil
 
 	case query.NumberRange:
@@ -207,11 +335,13 @@ func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string
 			if p.Field == "updated" {
 				col = "updated_at"
 			}
+			loOp, hiOp := rangeOps(p.LoInclusive, p.HiInclusive)
 			phLo := c.builder.Arg(int64(p.Lo))
 			phHi := c.builder.Arg(int64(p.Hi))
-			sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s >= %s AND %s <= %s", col, phLo, col, phHi)
+			sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s %s %s AND %s %s %s", col, loOp, phLo, col, hiOp, phHi)
 			c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
-			c.explainSteps = append(c.explainSteps, fmt.Sprintf("IMPLICIT TIMESTAMP RANGE %s:%v..%v", p.Field, p.Lo, p.Hi))
+			c.explainSteps = append(c.explainSteps, fmt.Sprintf("IMPLICIT TIMESTAMP RANGE %s:%s", p.Field, rangeString(p.Lo, p.Hi, p.LoInclusive, p.HiInclusive)))
+			c.addNode(resultName, PlanTimestampRange, p.Field, rangeString(p.Lo, p.Hi, p.LoInclusive, p.HiInclusive))
 			return resultName, nil
 		}
 
@@ -224,16 +354,23 @@ func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string
 		}
 
 		resultName := c.nextCTEName()
+		loOp, hiOp := rangeOps(p.LoInclusive, p.HiInclusive)
 		phField := c.builder.Arg(p.Field)
 		phLo := c.builder.Arg(p.Lo)
 		phHi := c.builder.Arg(p.Hi)
-		sql := fmt.Sprintf("SELECT item_id FROM field_number WHERE field = %s AND value >= %s AND value <= %s",
-			phField, phLo, phHi)
+		sql := fmt.Sprintf("SELECT DISTINCT item_id FROM field_number WHERE field = %s AND value %s %s AND value %s %s",
+			phField, loOp, phLo, hiOp, phHi)
 
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
-		c.explainSteps = append(c.explainSteps, fmt.Sprintf("NUMBER %s:%v..%v", p.Field, p.Lo, p.Hi))
+		c.explainSteps = append(c.explainSteps, fmt.Sprintf("NUMBER %s:%s", p.Field, rangeString(p.Lo, p.Hi, p.LoInclusive, p.HiInclusive)))
+		c.addNode(resultName, PlanNumberRange, p.Field, rangeString(p.Lo, p.Hi, p.LoInclusive, p.HiInclusive))
 		return resultName, nil
 
+	case query.GeoWithin:
+		return c.compileGeoWithin(p)
+	case query.LikePattern:
+		return c.compileLike(p)
+
 	case query.DateCmpAbs:
 		return c.compileDateCmpAbs(p)
 
@@ -263,6 +400,7 @@ func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string
 
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("BOOL %s:%v", p.Field, p.Value))
+		c.addNode(resultName, PlanBoolMatch, p.Field, strconv.FormatBool(p.Value))
 		return resultName, nil
 
 	default:
@@ -271,12 +409,35 @@ func (c *Compiler) compilePredicate(pred query.Predicate, positive bool) (string
 }
 
 func (c *Compiler) compileKeyword(p query.Keyword, positive bool) (string, error) {
+	// Calendar windows on date fields: created:today, due:thisweek, ...
+	if w, ok := query.ParseCalendarWindow(p.Pattern); ok && p.Kind == query.KeywordExact {
+		if p.Field == "created" || p.Field == "updated" {
+			return c.compileCalendarWindow(p.Field, w)
+		}
+		if spec, ok := c.schema.Get(p.Field); ok {
+			switch spec.Type {
+			case storage.FieldType("date"):
+				return c.compileCalendarWindow(p.Field, w)
+			case storage.FieldType("number"), storage.FieldType("bool"):
+				return "", fmt.Errorf("%s:%s requires a date field; %s is a %s field", p.Field, p.Pattern, p.Field, spec.Type)
+			}
+			// keyword and text fields keep matching the literal value
+		}
+	}
+
+	// A year or month on a date field covers the whole of it: created:2024-03
+	if p.Kind == query.KeywordExact && c.isDateField(p.Field) {
+		if loMS, hiMS, ok := query.ParsePartialDate(p.Pattern, c.loc); ok {
+			return c.compilePartialDate(p.Field, p.Pattern, loMS, hiMS)
+		}
+	}
+
 	// Handle implicit created/updated fields
 	if p.Field == "created" || p.Field == "updated" {
 		if p.Kind != query.KeywordExact {
 			return "", fmt.Errorf("wildcards not supported for implicit date fields")
 		}
-		epochMS, err := parseDateToEpochMS(p.Pattern)
+		epochMS, err := parseDateToEpochMS(p.Pattern, c.loc)
 		if err != nil {
 			return "", err
 		}
@@ -290,6 +451,7 @@ func (c *Compiler) compileKeyword(p query.Keyword, positive bool) (string, error
 		sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s = %s", col, ph)
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("IMPLICIT DATE %s:%s", p.Field, p.Pattern))
+		c.addNode(resultName, PlanTimestampCmp, p.Field, "="+p.Pattern)
 		return resultName, nil
 	}
 
@@ -298,9 +460,11 @@ func (c *Compiler) compileKeyword(p query.Keyword, positive bool) (string, error
 		return "", fmt.Errorf("unknown field: %s", p.Field)
 	}
 
-	// If schema says this is a TEXT field, treat field:term as FTS query
-	if spec.Type == storage.FieldType("text") {
-		return c.compileText(query.Text{Field: &p.Field, FTS: p.Pattern}, positive)
+	// If schema says this is a TEXT field, treat field:term as FTS query.
+	// full_text keyword fields do the same for unquoted terms; a quoted
+	// value stays an exact keyword match.
+	if spec.Type == storage.FieldType("text") || (spec.FullText && !p.Quoted) {
+		return c.compileText(query.Text{Field: &p.Field, FTS: p.Pattern, Boost: p.Boost}, positive)
 	}
 
 	// Bool fields: accept true/false via field:...
@@ -313,7 +477,7 @@ func (c *Compiler) compileKeyword(p query.Keyword, positive bool) (string, error
 		if p.Kind != query.KeywordExact {
 			return "", fmt.Errorf("wildcards not supported for date fields; use comparisons")
 		}
-		epochMS, err := parseDateToEpochMS(p.Pattern)
+		epochMS, err := parseDateToEpochMS(p.Pattern, c.loc)
 		if err != nil {
 			return "", err
 		}
@@ -324,42 +488,272 @@ func (c *Compiler) compileKeyword(p query.Keyword, positive bool) (string, error
 		return "", fmt.Errorf("field %s type %s cannot be used with keyword predicate", p.Field, spec.Type)
 	}
 
+	// Terms get the field's normalizer, as stored values did; CaseFold
+	// fields match against the folded dictionary column
+	valueCol := "d.value"
+	pattern := storage.NormalizeKeywordPattern(spec.Normalizer, p.Pattern)
+	if spec.CaseFold {
+		valueCol = "d.value_folded"
+		pattern = storage.FoldKeyword(pattern)
+	}
+
 	resultName := c.nextCTEName()
 	phField := c.builder.Arg(p.Field)
 
+	// Wildcard patterns carry backslash escapes for literal * and ?, which
+	// the LIKE and GLOB forms spell their own way
 	var sql string
-	switch p.Kind {
-	case query.KeywordExact:
-		phVal := c.builder.Arg(p.Pattern)
-		sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND d.value = %s", phField, phVal)
-	case query.KeywordPrefix:
-		prefix := p.Pattern[:len(p.Pattern)-1] // remove trailing *
-		phVal := c.builder.Arg(prefix + "%")
-		sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND d.value LIKE %s", phField, phVal)
-	case query.KeywordContains:
-		inner := p.Pattern[1 : len(p.Pattern)-1] // remove leading and trailing *
-		phVal := c.builder.Arg("%" + inner + "%")
-		sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND d.value LIKE %s", phField, phVal)
-	case query.KeywordGlob:
-		if c.backend == storage.BackendSQLite {
-			phVal := c.builder.Arg(p.Pattern)
-			sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND d.value GLOB %s", phField, phVal)
-		} else {
-			like := globToLike(p.Pattern)
-			phVal := c.builder.Arg(like)
-			sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND d.value LIKE %s ESCAPE '\\'", phField, phVal)
+	switch {
+	case p.Kind == query.KeywordExact:
+		phVal := c.builder.Arg(pattern)
+		sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND %s = %s", phField, valueCol, phVal)
+	case p.Kind == query.KeywordGlob && c.backend == storage.BackendSQLite:
+		phVal := c.builder.Arg(storage.KeywordPatternGlob(pattern))
+		sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND %s GLOB %s", phField, valueCol, phVal)
+	default:
+		// Prefix (abc*) and contains (*abc*) are LIKE abc% and %abc%
+		phVal := c.builder.Arg(storage.KeywordPatternLike(pattern))
+		sql = fmt.Sprintf("SELECT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND %s LIKE %s ESCAPE '\\'", phField, valueCol, phVal)
+	}
+
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, keywordStep(p))
+	c.addNode(resultName, PlanKeywordScan, p.Field, p.Pattern)
+	return resultName, nil
+}
+
+func (c *Compiler) compileInSet(p query.InSet) (string, error) {
+	spec, ok := c.schema.Get(p.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown field: %s", p.Field)
+	}
+	if spec.Type != storage.FieldType("keyword") {
+		return "", fmt.Errorf("field %s type %s cannot be used with in(...)", p.Field, spec.Type)
+	}
+
+	valueCol := "d.value"
+	if spec.CaseFold {
+		valueCol = "d.value_folded"
+	}
+
+	resultName := c.nextCTEName()
+	phField := c.builder.Arg(p.Field)
+	phs := make([]string, len(p.Values))
+	for i, v := range p.Values {
+		v = storage.NormalizeKeyword(spec.Normalizer, v)
+		if spec.CaseFold {
+			v = storage.FoldKeyword(v)
+		}
+		phs[i] = c.builder.Arg(v)
+	}
+	sql := fmt.Sprintf("SELECT DISTINCT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND %s IN (%s)",
+		phField, valueCol, strings.Join(phs, ", "))
+
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("KEYWORD %s:in(%s)", p.Field, strings.Join(p.Values, ",")))
+	c.addNode(resultName, PlanKeywordIn, p.Field, strings.Join(p.Values, ","))
+	return resultName, nil
+}
+
+// compileAnyKeyword matches p.Value in every keyword field. The value is
+// normalized per field as stored values were, so fields sharing a dictionary
+// column and normalized value are matched with one d.field IN (...) term.
+func (c *Compiler) compileAnyKeyword(p query.AnyKeyword) (string, error) {
+	type match struct {
+		valueCol, value string
+		fields          []string
+	}
+	var matches []*match
+	for _, field := range c.schema.KeywordFields() {
+		spec, _ := c.schema.Get(field)
+		valueCol := "d.value"
+		value := storage.NormalizeKeyword(spec.Normalizer, p.Value)
+		if spec.CaseFold {
+			valueCol = "d.value_folded"
+			value = storage.FoldKeyword(value)
+		}
+		i := slices.IndexFunc(matches, func(m *match) bool { return m.valueCol == valueCol && m.value == value })
+		if i < 0 {
+			matches = append(matches, &match{valueCol: valueCol, value: value})
+			i = len(matches) - 1
+		}
+		matches[i].fields = append(matches[i].fields, field)
+	}
+
+	resultName := c.nextCTEName()
+	var sql string
+	if len(matches) == 0 {
+		sql = "SELECT item_id FROM kw_postings WHERE 1 = 0"
+	} else {
+		conds := make([]string, len(matches))
+		for i, m := range matches {
+			phs := make([]string, len(m.fields))
+			for j, f := range m.fields {
+				phs[j] = c.builder.Arg(f)
+			}
+			phVal := c.builder.Arg(m.value)
+			conds[i] = fmt.Sprintf("(d.field IN (%s) AND %s = %s)", strings.Join(phs, ", "), m.valueCol, phVal)
+		}
+		sql = fmt.Sprintf("SELECT DISTINCT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE %s",
+			strings.Join(conds, " OR "))
+	}
+
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("KEYWORD *:%s", p.Value))
+	c.addNode(resultName, PlanKeywordAny, "", p.Value)
+	return resultName, nil
+}
+
+// compileAllSet intersects one single-value set per value, the plan
+// tags:a AND tags:b would get
+func (c *Compiler) compileAllSet(p query.AllSet) (string, error) {
+	parts := make([]string, len(p.Values))
+	for i, v := range p.Values {
+		name, err := c.compileInSet(query.InSet{Field: p.Field, Values: []string{v}})
+		if err != nil {
+			return "", err
+		}
+		parts[i] = name
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+
+	resultName := c.nextCTEName()
+	selects := make([]string, len(parts))
+	for i, name := range parts {
+		selects[i] = "SELECT item_id FROM " + name
+	}
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: strings.Join(selects, " INTERSECT ")})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("INTERSECT %s (%s:all)", strings.Join(parts, " AND "), p.Field))
+	c.addNode(resultName, PlanIntersect, p.Field, "", parts...)
+	return resultName, nil
+}
+
+func (c *Compiler) compileFuzzyKeyword(p query.FuzzyKeyword) (string, error) {
+	caps := c.adapter.Capabilities()
+	if !caps.FuzzyKeyword {
+		return "", fmt.Errorf("fuzzy keyword matching not supported by %s backend", c.backend)
+	}
+
+	spec, ok := c.schema.Get(p.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown field: %s", p.Field)
+	}
+	if spec.Type != storage.FieldType("keyword") {
+		return "", fmt.Errorf("field %s type %s cannot be used with fuzzy predicate", p.Field, spec.Type)
+	}
+
+	resultName := c.nextCTEName()
+
+	var sql string
+	switch {
+	case caps.EditDistance && !p.Resolved:
+		valueCol := "d.value"
+		term := storage.NormalizeKeyword(spec.Normalizer, p.Term)
+		if spec.CaseFold {
+			valueCol = "COALESCE(d.value_folded, d.value)"
+			term = storage.FoldKeyword(term)
+		}
+		n := len([]rune(term))
+		phField := c.builder.Arg(p.Field)
+		phLo := c.builder.Arg(n - p.MaxDistance)
+		phHi := c.builder.Arg(n + p.MaxDistance)
+		phVal := c.builder.Arg(term)
+		phMax := c.builder.Arg(p.MaxDistance)
+		phBound := c.builder.Arg(p.MaxDistance)
+		// The length guard keeps levenshtein_less_equal off values it would
+		// reject anyway, including those over fuzzystrmatch's 255 limit.
+		sql = fmt.Sprintf("SELECT DISTINCT p.item_id FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id WHERE d.field = %s AND CASE WHEN length(%s) BETWEEN %s AND %s THEN levenshtein_less_equal(%s, %s, %s) <= %s ELSE false END",
+			phField, valueCol, phLo, phHi, valueCol, phVal, phMax, phBound)
+	case !p.Resolved:
+		return "", fmt.Errorf("fuzzy predicate %s~%s was not resolved", p.Field, p.Term)
+	case len(p.ValueIDs) == 0:
+		sql = "SELECT item_id FROM kw_postings WHERE 1 = 0"
+	default:
+		ids := make([]string, len(p.ValueIDs))
+		for i, id := range p.ValueIDs {
+			ids[i] = c.builder.Arg(id)
 		}
+		sql = fmt.Sprintf("SELECT DISTINCT item_id FROM kw_postings WHERE value_id IN (%s)", strings.Join(ids, ", "))
 	}
 
 	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
-	c.explainSteps = append(c.explainSteps, fmt.Sprintf("KEYWORD %s:%s", p.Field, p.Pattern))
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("FUZZY %s~%s (max edits %d)", p.Field, p.Term, p.MaxDistance))
+	c.addNode(resultName, PlanFuzzyKeyword, p.Field, p.Term)
 	return resultName, nil
 }
 
 func (c *Compiler) compileText(p query.Text, positive bool) (string, error) {
+	if p.Field == nil && len(c.schema.TextFieldsInOrder()) == 0 {
+		return "", storage.ErrNoTextFields
+	}
+	c.requiresFTSJoin = true
+
+	sp := storage.TextPredicate{Field: p.Field, Query: p.FTS, Boost: p.Boost}
+	if prefix, ok := query.TextPrefix(p.FTS); ok {
+		sp.Query, sp.Prefix = prefix, true
+	}
+	if positive {
+		c.textPreds = append(c.textPreds, sp)
+	}
+
+	resultName := c.nextCTEName()
+	sqlBody, _, err := c.fts.CompileTextPredicate(c.builder, c.schema, sp)
+	if err != nil {
+		return "", err
+	}
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sqlBody})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("FTS %s%s", p.FTS, boostString(p.Boost)))
+	c.addNode(resultName, PlanFTSMatch, fieldName(p.Field), p.FTS)
+	return resultName, nil
+}
+
+func (c *Compiler) compileMultiFieldText(p query.MultiFieldText, positive bool) (string, error) {
+	for _, f := range p.Fields {
+		spec, ok := c.schema.Get(f)
+		if !ok {
+			return "", fmt.Errorf("unknown field: %s", f)
+		}
+		if !spec.HasFTS() {
+			return "", fmt.Errorf("field %s is not a text field", f)
+		}
+	}
+
+	c.requiresFTSJoin = true
+
+	sp := storage.TextPredicate{Fields: p.Fields, Query: p.FTS, Boost: p.Boost}
+	if prefix, ok := query.TextPrefix(p.FTS); ok {
+		sp.Query, sp.Prefix = prefix, true
+	}
+	if positive {
+		c.textPreds = append(c.textPreds, sp)
+	}
+
+	resultName := c.nextCTEName()
+	sqlBody, _, err := c.fts.CompileTextPredicate(c.builder, c.schema, sp)
+	if err != nil {
+		return "", err
+	}
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sqlBody})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("FTS fields(%s) %s%s", strings.Join(p.Fields, ","), p.FTS, boostString(p.Boost)))
+	c.addNode(resultName, PlanFTSMatch, strings.Join(p.Fields, ","), p.FTS)
+	return resultName, nil
+}
+
+func (c *Compiler) compileTextAny(p query.TextAny, positive bool) (string, error) {
+	spec, ok := c.schema.Get(p.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown field: %s", p.Field)
+	}
+	if !spec.HasFTS() {
+		return "", fmt.Errorf("field %s is not a text field", p.Field)
+	}
+
 	c.requiresFTSJoin = true
 
-	sp := storage.TextPredicate{Field: p.Field, Query: p.FTS}
+	field := p.Field
+	sp := storage.TextPredicate{Field: &field, AnyOf: p.Terms, Boost: p.Boost}
 	if positive {
 		c.textPreds = append(c.textPreds, sp)
 	}
@@ -370,7 +764,75 @@ func (c *Compiler) compileText(p query.Text, positive bool) (string, error) {
 		return "", err
 	}
 	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sqlBody})
-	c.explainSteps = append(c.explainSteps, fmt.Sprintf("FTS %s", p.FTS))
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("FTS %s:(%s)%s", p.Field, strings.Join(p.Terms, " OR "), boostString(p.Boost)))
+	c.addNode(resultName, PlanFTSMatch, p.Field, strings.Join(p.Terms, " OR "))
+	return resultName, nil
+}
+
+func (c *Compiler) compileNearText(p query.NearText, positive bool) (string, error) {
+	spec, ok := c.schema.Get(p.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown field: %s", p.Field)
+	}
+	if !spec.HasFTS() {
+		return "", fmt.Errorf("field %s is not a text field", p.Field)
+	}
+
+	c.requiresFTSJoin = true
+
+	field := p.Field
+	sp := storage.TextPredicate{Field: &field, Near: p.Terms, NearDistance: p.Distance, Boost: p.Boost}
+	if positive {
+		c.textPreds = append(c.textPreds, sp)
+	}
+
+	resultName := c.nextCTEName()
+	sqlBody, _, err := c.fts.CompileTextPredicate(c.builder, c.schema, sp)
+	if err != nil {
+		return "", err
+	}
+	pattern := fmt.Sprintf("near(%s,%d)", strings.Join(p.Terms, ","), p.Distance)
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sqlBody})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("FTS %s:%s%s", p.Field, pattern, boostString(p.Boost)))
+	c.addNode(resultName, PlanFTSNear, p.Field, pattern)
+	return resultName, nil
+}
+
+// compileGeoWithin narrows field_geo with a lat/lon bounding box around the
+// circle, which idx_geo_lookup serves, then keeps points whose unit vectors
+// are close enough to the centre's for the great-circle distance
+func (c *Compiler) compileGeoWithin(p query.GeoWithin) (string, error) {
+	spec, ok := c.schema.Get(p.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown field: %s", p.Field)
+	}
+	if spec.Type != storage.FieldType("geo") {
+		return "", fmt.Errorf("field %s is not a geo field", p.Field)
+	}
+
+	box := geoBoundingBox(p.Lat, p.Lon, p.RadiusKm)
+	cx, cy, cz := storage.GeoPoint{Lat: p.Lat, Lon: p.Lon}.UnitVector()
+
+	conds := []string{
+		"field = " + c.builder.Arg(p.Field),
+		fmt.Sprintf("lat BETWEEN %s AND %s", c.builder.Arg(box.minLat), c.builder.Arg(box.maxLat)),
+	}
+	switch {
+	case box.allLon:
+	case box.minLon > box.maxLon: // crosses the antimeridian
+		conds = append(conds, fmt.Sprintf("(lon >= %s OR lon <= %s)", c.builder.Arg(box.minLon), c.builder.Arg(box.maxLon)))
+	default:
+		conds = append(conds, fmt.Sprintf("lon BETWEEN %s AND %s", c.builder.Arg(box.minLon), c.builder.Arg(box.maxLon)))
+	}
+	conds = append(conds, fmt.Sprintf("x * %s + y * %s + z * %s >= %s",
+		c.builder.Arg(cx), c.builder.Arg(cy), c.builder.Arg(cz), c.builder.Arg(box.minDot)))
+
+	resultName := c.nextCTEName()
+	sql := "SELECT DISTINCT item_id FROM field_geo WHERE " + strings.Join(conds, " AND ")
+	pattern := fmt.Sprintf("within(%g,%g,%g)", p.Lat, p.Lon, p.RadiusKm)
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("GEO %s:%s", p.Field, pattern))
+	c.addNode(resultName, PlanGeoWithin, p.Field, pattern)
 	return resultName, nil
 }
 
@@ -386,6 +848,7 @@ func (c *Compiler) compileDateCmpAbs(p query.DateCmpAbs) (string, error) {
 		sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s %s %s", col, p.Op.String(), ph)
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("DATE %s%s%d", p.Field, p.Op.String(), p.EpochMS))
+		c.addNode(resultName, PlanTimestampCmp, p.Field, fmt.Sprintf("%s%d", p.Op.String(), p.EpochMS))
 		return resultName, nil
 	}
 
@@ -400,10 +863,11 @@ func (c *Compiler) compileDateCmpAbs(p query.DateCmpAbs) (string, error) {
 	resultName := c.nextCTEName()
 	phField := c.builder.Arg(p.Field)
 	phVal := c.builder.Arg(p.EpochMS)
-	sql := fmt.Sprintf("SELECT item_id FROM field_date WHERE field = %s AND value %s %s", phField, p.Op.String(), phVal)
+	sql := fmt.Sprintf("SELECT DISTINCT item_id FROM field_date WHERE field = %s AND value %s %s", phField, p.Op.String(), phVal)
 
 	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 	c.explainSteps = append(c.explainSteps, fmt.Sprintf("DATE %s%s%d", p.Field, p.Op.String(), p.EpochMS))
+	c.addNode(resultName, PlanDateCmp, p.Field, fmt.Sprintf("%s%d", p.Op.String(), p.EpochMS))
 	return resultName, nil
 }
 
@@ -415,11 +879,13 @@ func (c *Compiler) compileDateRangeAbs(p query.DateRangeAbs) (string, error) {
 		if p.Field == "updated" {
 			col = "updated_at"
 		}
+		loOp, hiOp := rangeOps(p.LoInclusive, p.HiInclusive)
 		phLo := c.builder.Arg(p.LoMS)
 		phHi := c.builder.Arg(p.HiMS)
-		sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s >= %s AND %s <= %s", col, phLo, col, phHi)
+		sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s %s %s AND %s %s %s", col, loOp, phLo, col, hiOp, phHi)
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
-		c.explainSteps = append(c.explainSteps, fmt.Sprintf("IMPLICIT DATE RANGE %s:%d..%d", p.Field, p.LoMS, p.HiMS))
+		c.explainSteps = append(c.explainSteps, fmt.Sprintf("IMPLICIT DATE RANGE %s:%s", p.Field, rangeString(p.LoMS, p.HiMS, p.LoInclusive, p.HiInclusive)))
+		c.addNode(resultName, PlanTimestampRange, p.Field, rangeString(p.LoMS, p.HiMS, p.LoInclusive, p.HiInclusive))
 		return resultName, nil
 	}
 
@@ -432,19 +898,53 @@ func (c *Compiler) compileDateRangeAbs(p query.DateRangeAbs) (string, error) {
 	}
 
 	resultName := c.nextCTEName()
+	loOp, hiOp := rangeOps(p.LoInclusive, p.HiInclusive)
 	phField := c.builder.Arg(p.Field)
 	phLo := c.builder.Arg(p.LoMS)
 	phHi := c.builder.Arg(p.HiMS)
-	sql := fmt.Sprintf("SELECT item_id FROM field_date WHERE field = %s AND value >= %s AND value <= %s", phField, phLo, phHi)
+	sql := fmt.Sprintf("SELECT DISTINCT item_id FROM field_date WHERE field = %s AND value %s %s AND value %s %s", phField, loOp, phLo, hiOp, phHi)
 
 	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
-	c.explainSteps = append(c.explainSteps, fmt.Sprintf("DATE %s:%d..%d", p.Field, p.LoMS, p.HiMS))
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("DATE %s:%s", p.Field, rangeString(p.LoMS, p.HiMS, p.LoInclusive, p.HiInclusive)))
+	c.addNode(resultName, PlanDateRange, p.Field, rangeString(p.LoMS, p.HiMS, p.LoInclusive, p.HiInclusive))
 	return resultName, nil
 }
 
-func (c *Compiler) compileDateCmpRel(p query.DateCmpRel) (string, error) {
-	durationMS := p.Unit.ToMillis(p.Amount)
+// compileCalendarWindow compiles a calendar window to a half-open date range
+// relative to the compiler's clock, in the index's time zone
+func (c *Compiler) compileCalendarWindow(field string, w query.CalendarWindow) (string, error) {
+	loMS, hiMS := w.Bounds(time.UnixMilli(c.nowMS).In(c.loc))
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("CALENDAR %s:%s", field, w))
+	name, err := c.compileDateRangeAbs(query.DateRangeAbs{Field: field, LoMS: loMS, HiMS: hiMS, LoInclusive: true, HiInclusive: false})
+	if err != nil {
+		return "", err
+	}
+	c.nodes[name].Pattern = w.String()
+	return name, nil
+}
+
+// compilePartialDate matches a date field within [loMS, hiMS), the year or
+// month written as pattern
+func (c *Compiler) compilePartialDate(field, pattern string, loMS, hiMS int64) (string, error) {
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("DATE %s:%s", field, pattern))
+	name, err := c.compileDateRangeAbs(query.DateRangeAbs{Field: field, LoMS: loMS, HiMS: hiMS, LoInclusive: true, HiInclusive: false})
+	if err != nil {
+		return "", err
+	}
+	c.nodes[name].Pattern = pattern
+	return name, nil
+}
+
+// isDateField reports whether field is created, updated or a schema date field
+func (c *Compiler) isDateField(field string) bool {
+	if field == "created" || field == "updated" {
+		return true
+	}
+	spec, ok := c.schema.Get(field)
+	return ok && spec.Type == storage.FieldType("date")
+}
 
+func (c *Compiler) compileDateCmpRel(p query.DateCmpRel) (string, error) {
 	// Relative semantics:
 	// - created/updated: interpret as "age"
 	// - schema date fields: interpret as "offset from now"
@@ -452,9 +952,9 @@ func (c *Compiler) compileDateCmpRel(p query.DateCmpRel) (string, error) {
 
 	var targetMS int64
 	if isImplicit {
-		targetMS = c.nowMS - durationMS
+		targetMS = p.Unit.Shift(c.nowMS, -p.Amount)
 	} else {
-		targetMS = c.nowMS + durationMS
+		targetMS = p.Unit.Shift(c.nowMS, p.Amount)
 	}
 
 	if isImplicit {
@@ -472,14 +972,15 @@ func (c *Compiler) compileDateCmpRel(p query.DateCmpRel) (string, error) {
 			mappedOp = query.CmpGte
 		case query.CmpGt, query.CmpGte:
 			mappedOp = query.CmpLte
-		case query.CmpEq:
-			mappedOp = query.CmpEq
+		case query.CmpEq, query.CmpNe:
+			mappedOp = p.Op
 		}
 		ph := c.builder.Arg(targetMS)
 		sql := fmt.Sprintf("SELECT id AS item_id FROM items WHERE %s %s %s", col, mappedOp.String(), ph)
 
 		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 		c.explainSteps = append(c.explainSteps, fmt.Sprintf("DATE(rel-age) %s%s%d%s", p.Field, mappedOp.String(), p.Amount, p.Unit.String()))
+		c.addNode(resultName, PlanTimestampCmp, p.Field, fmt.Sprintf("%s%d%s", mappedOp.String(), p.Amount, p.Unit.String()))
 		return resultName, nil
 	}
 
@@ -495,9 +996,72 @@ func (c *Compiler) compileDateCmpRel(p query.DateCmpRel) (string, error) {
 	resultName := c.nextCTEName()
 	phField := c.builder.Arg(p.Field)
 	phVal := c.builder.Arg(targetMS)
-	sql := fmt.Sprintf("SELECT item_id FROM field_date WHERE field = %s AND value %s %s", phField, p.Op.String(), phVal)
+	sql := fmt.Sprintf("SELECT DISTINCT item_id FROM field_date WHERE field = %s AND value %s %s", phField, p.Op.String(), phVal)
 
 	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
 	c.explainSteps = append(c.explainSteps, fmt.Sprintf("DATE(rel) %s%s%d%s", p.Field, p.Op.String(), p.Amount, p.Unit.String()))
+	c.addNode(resultName, PlanDateCmp, p.Field, fmt.Sprintf("%s%d%s", p.Op.String(), p.Amount, p.Unit.String()))
+	return resultName, nil
+}
+
+// compileHas matches items holding field. The implicit fields path, created
+// and updated are on every item; a text field must have non-empty content,
+// which the full-text table records where field_present would also count "".
+func (c *Compiler) compileHas(p query.Has) (string, error) {
+	resultName := c.nextCTEName()
+	switch p.Field {
+	case "path", "created", "updated":
+		c.ctes = append(c.ctes, CTE{Name: resultName, SQL: "SELECT id AS item_id FROM items"})
+		c.explainSteps = append(c.explainSteps, fmt.Sprintf("HAS %s (every item)", p.Field))
+		c.addNode(resultName, PlanAllItems, p.Field, "")
+		return resultName, nil
+	}
+
+	spec, ok := c.schema.Get(p.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown field: %s", p.Field)
+	}
+	var sql string
+	if spec.Type == storage.FieldType("text") {
+		sql = c.fts.CompileTextPresent(p.Field)
+	} else {
+		sql = fmt.Sprintf("SELECT item_id FROM field_present WHERE field = %s", c.builder.Arg(p.Field))
+	}
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("HAS %s", p.Field))
+	c.addNode(resultName, PlanFieldPresent, p.Field, "")
+	return resultName, nil
+}
+
+// compileLike matches a text field's value in items.data_json, which keeps
+// the original text the FTS table does not. Rows stored compressed cannot be
+// read here; Normalize and ops.CheckLike reject like(...) while any exist.
+func (c *Compiler) compileLike(p query.LikePattern) (string, error) {
+	spec, ok := c.schema.Get(p.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown field: %s", p.Field)
+	}
+	if spec.Type != storage.FieldType("text") {
+		return "", fmt.Errorf("%s:like(...) is only supported for text fields", p.Field)
+	}
+
+	resultName := c.nextCTEName()
+	phField := c.builder.Arg(p.Field)
+	var value, op string
+	if c.backend == storage.BackendSQLite {
+		value = fmt.Sprintf("CASE WHEN i.data_enc IS NULL THEN json_extract(i.data_json, %s) END", c.builder.Arg("$."+p.Field))
+		op = "LIKE" // case-insensitive for ASCII
+	} else {
+		value = fmt.Sprintf("i.data_json ->> %s", c.builder.Arg(p.Field))
+		op = "ILIKE"
+	}
+	phPattern := c.builder.Arg(p.Pattern)
+	sql := fmt.Sprintf(
+		"SELECT p.item_id FROM field_present p JOIN items i ON i.id = p.item_id WHERE p.field = %s AND %s %s %s ESCAPE '\\'",
+		phField, value, op, phPattern,
+	)
+	c.ctes = append(c.ctes, CTE{Name: resultName, SQL: sql})
+	c.explainSteps = append(c.explainSteps, fmt.Sprintf("LIKE %s %q", p.Field, p.Pattern))
+	c.addNode(resultName, PlanLike, p.Field, p.Pattern)
 	return resultName, nil
 }
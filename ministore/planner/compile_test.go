@@ -0,0 +1,70 @@
+package planner_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ministore/ministore/ministore"
+	"github.com/ministore/ministore/ministore/planner"
+	"github.com/ministore/ministore/ministore/query"
+	"github.com/ministore/ministore/ministore/storage/sqlbuilder"
+	"github.com/ministore/ministore/ministore/storage/sqlite"
+)
+
+func compileLoose(t *testing.T, q string) *planner.CompileOutput {
+	t.Helper()
+	schema := ministore.Schema{Fields: map[string]ministore.FieldSpec{
+		"tags":   {Type: ministore.FieldKeyword, Multi: true},
+		"status": {Type: ministore.FieldKeyword},
+	}}
+	expr, err := query.Parse(q)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", q, err)
+	}
+	opts := query.DefaultNormalizeOptions()
+	opts.RequireAllOrBranchesAnchored = false
+	expr, err = query.Normalize(expr, opts)
+	if err != nil {
+		t.Fatalf("Normalize(%q): %v", q, err)
+	}
+	adapter := sqlite.New(":memory:")
+	out, err := planner.Compile(adapter, schema.AsStorageSchema(), sqlbuilder.New(adapter.PlaceholderStyle()), expr, 0, nil)
+	if err != nil {
+		t.Fatalf("Compile(%q): %v", q, err)
+	}
+	return out
+}
+
+func TestCompileRestrictedOrTopLevel(t *testing.T) {
+	// Restricted to the anchored branch, the loose branch could add nothing,
+	// so it is not compiled at all
+	out := compileLoose(t, "tags:rust OR NOT tags:archived")
+	if len(out.CTEs) != 1 || out.ResultCTE != out.CTEs[0].Name {
+		t.Fatalf("CTEs = %+v, want only the anchored branch", out.CTEs)
+	}
+	if !strings.Contains(strings.Join(out.ExplainSteps, "\n"), "IGNORE unanchored OR branch") {
+		t.Errorf("explain should say the branch is ignored: %v", out.ExplainSteps)
+	}
+}
+
+func TestCompileRestrictedOrWithinAnd(t *testing.T) {
+	// The loose branch is evaluated within status:open, which is compiled
+	// first even though it is on the right
+	out := compileLoose(t, "(tags:rust OR NOT tags:archived) AND status:open")
+	want := []string{
+		"KEYWORD status:open",
+		"KEYWORD tags:rust",
+		"KEYWORD tags:archived",
+		"EXCEPT NOT cte_2",
+		"RESTRICT cte_3 TO cte_0",
+		"UNION cte_1 OR cte_4",
+		"INTERSECT cte_0 AND cte_5",
+	}
+	if !reflect.DeepEqual(out.ExplainSteps, want) {
+		t.Fatalf("steps = %q, want %q", out.ExplainSteps, want)
+	}
+	if sql := out.CTEs[3].SQL; sql != "SELECT item_id FROM cte_0 EXCEPT SELECT item_id FROM cte_2" {
+		t.Errorf("NOT should complement against status:open: %q", sql)
+	}
+}
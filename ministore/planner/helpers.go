@@ -2,8 +2,12 @@ package planner
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"time"
+
+	"github.com/ministore/ministore/ministore/query"
+	"github.com/ministore/ministore/ministore/storage"
 )
 
 // literalPrefixBeforeWildcard returns the literal part before the first wildcard
@@ -37,10 +41,11 @@ func globToLike(pattern string) string {
 	return b.String()
 }
 
-// parseDateToEpochMS parses a date string to epoch milliseconds
-func parseDateToEpochMS(s string) (int64, error) {
+// parseDateToEpochMS parses a date string to epoch milliseconds. Bare dates
+// are midnight in loc; RFC3339 keeps its explicit offset.
+func parseDateToEpochMS(s string, loc *time.Location) (int64, error) {
 	// Try YYYY-MM-DD
-	if t, err := time.Parse("2006-01-02", s); err == nil {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
 		return t.UnixMilli(), nil
 	}
 	// Try RFC3339
@@ -76,3 +81,97 @@ func quoteFTSTerm(term string) string {
 func joinOr(parts []string) string {
 	return strings.Join(parts, " OR ")
 }
+
+// rangeOps returns the SQL comparison operators for a range's lower and upper bounds
+func rangeOps(loInclusive, hiInclusive bool) (loOp, hiOp string) {
+	loOp, hiOp = ">", "<"
+	if loInclusive {
+		loOp = ">="
+	}
+	if hiInclusive {
+		hiOp = "<="
+	}
+	return loOp, hiOp
+}
+
+// rangeString formats a range for explain output: lo..hi when fully
+// inclusive, bracket notation otherwise
+func rangeString[T int64 | float64](lo, hi T, loInclusive, hiInclusive bool) string {
+	if loInclusive && hiInclusive {
+		return fmt.Sprintf("%v..%v", lo, hi)
+	}
+	left, right := "(", ")"
+	if loInclusive {
+		left = "["
+	}
+	if hiInclusive {
+		right = "]"
+	}
+	return fmt.Sprintf("%s%v,%v%s", left, lo, hi, right)
+}
+
+// boostString formats a text predicate's boost for explain output; no boost
+// and a boost of 1 show nothing
+func boostString(boost float64) string {
+	if boost == 0 || boost == 1 {
+		return ""
+	}
+	return fmt.Sprintf("^%g", boost)
+}
+
+// keywordStep formats a keyword predicate for explain output, with the
+// number of values a counted wildcard pattern expands to
+func keywordStep(p query.Keyword) string {
+	if p.ExpansionLimit == 0 {
+		return fmt.Sprintf("KEYWORD %s:%s", p.Field, p.Pattern)
+	}
+	kind := map[query.KeywordPatternKind]string{
+		query.KeywordPrefix:   "PREFIX",
+		query.KeywordContains: "CONTAINS",
+		query.KeywordGlob:     "GLOB",
+	}[p.Kind]
+	if p.Expansion > p.ExpansionLimit {
+		return fmt.Sprintf("KEYWORD %s %s:%s expands to more than %d values", kind, p.Field, p.Pattern, p.ExpansionLimit)
+	}
+	return fmt.Sprintf("KEYWORD %s %s:%s expands to %d values", kind, p.Field, p.Pattern, p.Expansion)
+}
+
+// geoBox bounds the points within a radius of a centre. Longitudes wrap
+// across the antimeridian when minLon > maxLon; allLon is set when the
+// circle reaches a pole.
+type geoBox struct {
+	minLat, maxLat float64
+	minLon, maxLon float64
+	allLon         bool
+	minDot         float64 // least unit vector dot product with the centre
+}
+
+func geoBoundingBox(lat, lon, radiusKm float64) geoBox {
+	const deg = 180 / math.Pi
+	angle := radiusKm / storage.EarthRadiusKm
+	if angle >= math.Pi {
+		return geoBox{minLat: -90, maxLat: 90, allLon: true, minDot: -1}
+	}
+
+	box := geoBox{
+		minLat: lat - angle*deg,
+		maxLat: lat + angle*deg,
+		minDot: math.Cos(angle),
+	}
+	if box.minLat <= -90 || box.maxLat >= 90 {
+		box.minLat = math.Max(box.minLat, -90)
+		box.maxLat = math.Min(box.maxLat, 90)
+		box.allLon = true
+		return box
+	}
+
+	dLon := math.Asin(math.Sin(angle)/math.Cos(lat/deg)) * deg
+	box.minLon, box.maxLon = lon-dLon, lon+dLon
+	if box.minLon < -180 {
+		box.minLon += 360
+	}
+	if box.maxLon > 180 {
+		box.maxLon -= 360
+	}
+	return box
+}
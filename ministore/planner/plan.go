@@ -0,0 +1,80 @@
+package planner
+
+// PlanOp is the kind of a query plan node
+type PlanOp string
+
+const (
+	PlanIntersect      PlanOp = "Intersect"
+	PlanUnion          PlanOp = "Union"
+	PlanExcept         PlanOp = "Except"   // all items (or the restricted universe) minus the child
+	PlanRestrict       PlanOp = "Restrict" // first child limited to the second
+	PlanFieldPresent   PlanOp = "FieldPresent"
+	PlanPathMatch      PlanOp = "PathMatch"
+	PlanPathEqual      PlanOp = "PathEqual"
+	PlanAllItems       PlanOp = "AllItems"
+	PlanKeywordScan    PlanOp = "KeywordScan"
+	PlanKeywordIn      PlanOp = "KeywordIn"
+	PlanKeywordAny     PlanOp = "KeywordAny" // *:value across keyword fields
+	PlanFuzzyKeyword   PlanOp = "FuzzyKeyword"
+	PlanFTSMatch       PlanOp = "FTSMatch"
+	PlanFTSNear        PlanOp = "FTSNear"
+	PlanNumberCmp      PlanOp = "NumberCmp"
+	PlanNumberRange    PlanOp = "NumberRange"
+	PlanDateCmp        PlanOp = "DateCmp"
+	PlanDateRange      PlanOp = "DateRange"
+	PlanTimestampCmp   PlanOp = "TimestampCmp" // created/updated columns
+	PlanTimestampRange PlanOp = "TimestampRange"
+	PlanBoolMatch      PlanOp = "BoolMatch"
+	PlanGeoWithin      PlanOp = "GeoWithin"
+	PlanLike           PlanOp = "Like"
+)
+
+// PlanNode is one CTE of a compiled query. Set operations have children;
+// predicates are leaves. A CTE shared by two parents (restricted ORs) appears
+// under both.
+type PlanNode struct {
+	Op      PlanOp `json:"op"`
+	CTE     string `json:"cte"`
+	Field   string `json:"field,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	// Selectivity is the fraction of live items the node matches. The
+	// compiler leaves it nil; search fills it in when a JSON plan is
+	// requested.
+	Selectivity *float64    `json:"selectivity,omitempty"`
+	Children    []*PlanNode `json:"children,omitempty"`
+}
+
+// Walk calls fn for n and every node below it, parents first
+func (n *PlanNode) Walk(fn func(*PlanNode)) {
+	if n == nil {
+		return
+	}
+	fn(n)
+	for _, child := range n.Children {
+		child.Walk(fn)
+	}
+}
+
+// addNode records the plan node for CTE name; children are CTE names
+// compiled earlier. It is called right after name's CTE and explain step are
+// appended, and labels the CTE with that step.
+func (c *Compiler) addNode(name string, op PlanOp, field, pattern string, children ...string) {
+	if n := len(c.ctes); n > 0 && c.ctes[n-1].Name == name {
+		c.ctes[n-1].Step = c.explainSteps[len(c.explainSteps)-1]
+	}
+	node := &PlanNode{Op: op, CTE: name, Field: field, Pattern: pattern}
+	for _, child := range children {
+		if n, ok := c.nodes[child]; ok {
+			node.Children = append(node.Children, n)
+		}
+	}
+	c.nodes[name] = node
+}
+
+// fieldName returns *f, or "" for an unfielded text predicate
+func fieldName(f *string) string {
+	if f == nil {
+		return ""
+	}
+	return *f
+}
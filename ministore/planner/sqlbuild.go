@@ -2,6 +2,8 @@ package planner
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/ministore/ministore/ministore/storage"
@@ -10,7 +12,98 @@ import (
 // RankMode specifies how results should be ranked
 type RankMode struct {
 	Kind  RankKind
-	Field string // only when Kind == RankField
+	Field string    // only when Kind == RankField; shorthand for Keys [{Field, Desc}]
+	Keys  []SortKey // only when Kind == RankField; overrides Field
+
+	// Ascending reverses a RankRecency or RankField order, including each
+	// sort key and the updated_at/path tie-breakers
+	Ascending bool
+
+	// MissingLast keeps RankField items lacking a sort key's field, with the
+	// key valued by missingRankValue so they sort after the rest
+	MissingLast bool
+}
+
+// SortKey is one number or date field in a RankField sort
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// SortKeys returns the RankField sort keys, falling back to Field descending,
+// with directions reversed when Ascending is set
+func (r RankMode) SortKeys() []SortKey {
+	keys := r.Keys
+	if len(keys) == 0 && r.Field != "" {
+		keys = []SortKey{{Field: r.Field, Desc: true}}
+	}
+	if !r.Ascending {
+		return keys
+	}
+	flipped := make([]SortKey, len(keys))
+	for i, k := range keys {
+		flipped[i] = SortKey{Field: k.Field, Desc: !k.Desc}
+	}
+	return flipped
+}
+
+// ftsScoreOrder orders RankDefault results with an FTS score on every
+// backend. item_id breaks score ties, which are common (identical documents,
+// short fields), so pages neither skip nor repeat rows; BuildAfterFilter
+// mirrors it.
+const ftsScoreOrder = "ORDER BY score DESC, item_id ASC"
+
+// recencyOrder is the updated_at/path ordering used by RankRecency and as the
+// RankField tie-breaker
+func recencyOrder(asc bool) string {
+	if asc {
+		return "updated_at ASC, path DESC"
+	}
+	return "updated_at DESC, path ASC"
+}
+
+// recencyAfter is the cursor condition matching recencyOrder
+func recencyAfter(builder storage.Builder, asc bool, updatedAtMS int64, path string) string {
+	cmp, pathCmp := "<", ">"
+	if asc {
+		cmp, pathCmp = ">", "<"
+	}
+	ph1 := builder.Arg(updatedAtMS)
+	ph2 := builder.Arg(updatedAtMS)
+	ph3 := builder.Arg(path)
+	return fmt.Sprintf("(updated_at %s %s OR (updated_at = %s AND path %s %s))", cmp, ph1, ph2, pathCmp, ph3)
+}
+
+// rankFieldCTE names the aggregation CTE for sort key i
+func rankFieldCTE(i int) string {
+	return fmt.Sprintf("rank_field_%d", i)
+}
+
+// missingRankValue is the sort value of an item lacking the key's field
+// under MissingLast: the far end of the key's direction. It is a finite
+// float64 so cursors can carry it like any other value.
+func missingRankValue(key SortKey) string {
+	v := math.MaxFloat64
+	if key.Desc {
+		v = -math.MaxFloat64
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// rankValueExpr is the value of sort key i for an item
+func rankValueExpr(rank RankMode, key SortKey, i int) string {
+	if rank.MissingLast {
+		return fmt.Sprintf("CAST(COALESCE(%s.rank_value, %s) AS DOUBLE PRECISION)", rankFieldCTE(i), missingRankValue(key))
+	}
+	return fmt.Sprintf("CAST(%s.rank_value AS DOUBLE PRECISION)", rankFieldCTE(i))
+}
+
+// rankColumn is the output column holding the value of sort key i
+func rankColumn(i int) string {
+	if i == 0 {
+		return "score"
+	}
+	return fmt.Sprintf("rank_%d", i)
 }
 
 // RankKind is the type of ranking
@@ -23,16 +116,39 @@ const (
 	RankNone
 )
 
-// BuildSearchSQL builds the final search SQL
+// BuildSearchSQL builds the final search SQL. When highlight is set and the
+// query has text predicates, one snippet column per returned field name is
+// selected after score. When positions is set, one column per returned
+// position field follows them, holding the whole field marked with
+// storage.MatchSpec. Soft-deleted items are excluded unless includeDeleted.
+// minScore, if non-nil, drops rows scoring below it; it only applies when the
+// query is ranked by FTS score. A positive snapshotMaxID drops items inserted
+// after a snapshot search began. distinctBy, if set, keeps only the
+// top-ranked row per value of that keyword, number or date field; items
+// without the field are dropped, and a multi-valued field groups by its
+// smallest value. The cursor condition applies after that collapse, so pages
+// stay consistent with it. Unless withDoc is set, data_json is selected
+// empty and data_enc NULL, so searches that show no fields never read
+// document bodies.
+// afterFilter, if non-nil, builds the cursor condition; it is called last so
+// its arguments follow every other argument in the SQL text, as positional
+// placeholders require.
 func BuildSearchSQL(
 	adapter storage.Adapter,
 	schema storage.Schema,
 	compiled *CompileOutput,
 	rank RankMode,
 	limitPlusOne int,
-	afterFilter string,
+	afterFilter func(storage.Builder) (string, error),
 	builder storage.Builder,
-) (string, error) {
+	highlight *storage.HighlightSpec,
+	positions bool,
+	includeDeleted bool,
+	minScore *float64,
+	snapshotMaxID int64,
+	distinctBy string,
+	withDoc bool,
+) (sqlText string, hlFields, posFields []string, err error) {
 	var cteParts []string
 
 	// Base CTEs
@@ -40,33 +156,41 @@ func BuildSearchSQL(
 		cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", cte.Name, cte.SQL))
 	}
 
-	// RankField: build rank aggregation CTE
-	var fieldRankCTEName string
+	// RankField: one rank aggregation CTE per sort key. Multi-valued fields
+	// sort by their largest value descending, smallest ascending.
+	var sortKeys []SortKey
 	if rank.Kind == RankField {
-		spec, ok := schema.Get(rank.Field)
+		sortKeys = rank.SortKeys()
+		if len(sortKeys) == 0 {
+			return "", nil, nil, fmt.Errorf("rank field requires at least one sort key")
+		}
+	}
+	for i, key := range sortKeys {
+		spec, ok := schema.Get(key.Field)
 		if !ok {
-			return "", fmt.Errorf("unknown rank field: %s", rank.Field)
+			return "", nil, nil, fmt.Errorf("unknown rank field: %s", key.Field)
 		}
 
-		var table, valueCol string
+		var table string
 		switch spec.Type {
 		case storage.FieldType("number"):
 			table = "field_number"
-			valueCol = "value"
 		case storage.FieldType("date"):
 			table = "field_date"
-			valueCol = "value"
 		default:
-			return "", fmt.Errorf("rank field must be number or date, got %s", spec.Type)
+			return "", nil, nil, fmt.Errorf("rank field must be number or date, got %s", spec.Type)
 		}
 
-		fieldRankCTEName = "rank_field"
-		phField := builder.Arg(rank.Field)
+		agg := "MIN"
+		if key.Desc {
+			agg = "MAX"
+		}
+		phField := builder.Arg(key.Field)
 		cteSQL := fmt.Sprintf(
-			"SELECT item_id, MAX(%s) AS rank_value FROM %s WHERE field = %s GROUP BY item_id",
-			valueCol, table, phField,
+			"SELECT item_id, %s(value) AS rank_value FROM %s WHERE field = %s GROUP BY item_id",
+			agg, table, phField,
 		)
-		cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", fieldRankCTEName, cteSQL))
+		cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", rankFieldCTE(i), cteSQL))
 	}
 
 	// RankDefault+FTS: add FTS score CTEs (positive-context text predicates only)
@@ -78,24 +202,76 @@ func BuildSearchSQL(
 	if hasFTSScore {
 		extraCTEs, joinSQL, score, err := adapter.FTS().ScoreCTEsAndJoin(builder, schema, compiled.TextPreds)
 		if err != nil {
-			return "", err
+			return "", nil, nil, err
 		}
 		for _, c := range extraCTEs {
 			cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", c.Name, c.SQL))
 		}
 		ftsJoinSQL = joinSQL
 		scoreExpr = score
-		orderClause = "ORDER BY score DESC, item_id ASC"
+		orderClause = ftsScoreOrder
+	}
+
+	// Highlights: snippet columns for positive-context text predicates,
+	// then whole marked fields for match positions
+	var hlJoins, hlCols []string
+	addHighlight := func(spec storage.HighlightSpec) ([]string, error) {
+		if len(compiled.TextPreds) == 0 || !adapter.FTS().HasFTS(schema) {
+			return nil, nil
+		}
+		extraCTEs, joinSQL, cols, err := adapter.FTS().HighlightCTEsAndCols(builder, schema, compiled.TextPreds, spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range extraCTEs {
+			cteParts = append(cteParts, fmt.Sprintf("%s AS (%s)", c.Name, c.SQL))
+		}
+		if joinSQL != "" {
+			hlJoins = append(hlJoins, joinSQL)
+		}
+		fields := make([]string, 0, len(cols))
+		for _, col := range cols {
+			fields = append(fields, col.Field)
+			hlCols = append(hlCols, fmt.Sprintf("%s AS hl_%d", col.Expr, len(hlCols)))
+		}
+		return fields, nil
+	}
+	if highlight != nil {
+		if hlFields, err = addHighlight(*highlight); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if positions {
+		if posFields, err = addHighlight(storage.MatchSpec); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	// DistinctBy: one value per item to partition on
+	if distinctBy != "" {
+		cteSQL, err := distinctKeySQL(schema, builder, distinctBy)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		cteParts = append(cteParts, fmt.Sprintf("distinct_key AS (%s)", cteSQL))
 	}
 
 	if !hasFTSScore {
 		switch rank.Kind {
 		case RankRecency:
-			orderClause = "ORDER BY updated_at DESC, path ASC"
+			orderClause = "ORDER BY " + recencyOrder(rank.Ascending)
 			scoreExpr = "CAST(i.updated_at AS DOUBLE PRECISION)"
 		case RankField:
-			orderClause = "ORDER BY score DESC, updated_at DESC, path ASC"
-			scoreExpr = fmt.Sprintf("CAST(%s.rank_value AS DOUBLE PRECISION)", fieldRankCTEName)
+			var order []string
+			for i, key := range sortKeys {
+				dir := "ASC"
+				if key.Desc {
+					dir = "DESC"
+				}
+				order = append(order, rankColumn(i)+" "+dir)
+			}
+			orderClause = fmt.Sprintf("ORDER BY %s, %s", strings.Join(order, ", "), recencyOrder(rank.Ascending))
+			scoreExpr = rankValueExpr(rank, sortKeys[0], 0)
 		case RankNone:
 			orderClause = "ORDER BY item_id ASC"
 			scoreExpr = "NULL"
@@ -111,48 +287,141 @@ func BuildSearchSQL(
 		withClause = fmt.Sprintf("WITH %s ", strings.Join(cteParts, ", "))
 	}
 
-	selectColsInner := "i.id AS item_id, i.path AS path, i.data_json AS data_json, i.created_at AS created_at, i.updated_at AS updated_at"
+	docCols := "i.data_json AS data_json, i.data_enc AS data_enc"
+	if !withDoc {
+		docCols = "'' AS data_json, NULL AS data_enc"
+	}
+	selectColsInner := "i.id AS item_id, i.path AS path, " + docCols + ", i.created_at AS created_at, i.updated_at AS updated_at"
+	selectColsOuter := "item_id, path, data_json, data_enc, created_at, updated_at, score"
+	var hlSelectInner string
+	for i, col := range hlCols {
+		hlSelectInner += ", " + col
+		selectColsOuter += fmt.Sprintf(", hl_%d", i)
+	}
+	// Secondary sort keys follow the highlight columns
+	for i := 1; i < len(sortKeys); i++ {
+		hlSelectInner += fmt.Sprintf(", %s AS %s", rankValueExpr(rank, sortKeys[i], i), rankColumn(i))
+		selectColsOuter += ", " + rankColumn(i)
+	}
 
 	var joins []string
 	if ftsJoinSQL != "" {
 		joins = append(joins, ftsJoinSQL)
 	}
-	if rank.Kind == RankField {
-		joins = append(joins, fmt.Sprintf("JOIN %s ON %s.item_id = i.id", fieldRankCTEName, fieldRankCTEName))
+	joins = append(joins, hlJoins...)
+	// Items missing any sort field are excluded, unless MissingLast
+	rankJoin := "JOIN"
+	if rank.MissingLast {
+		rankJoin = "LEFT JOIN"
+	}
+	for i := range sortKeys {
+		joins = append(joins, fmt.Sprintf("%s %s ON %s.item_id = i.id", rankJoin, rankFieldCTE(i), rankFieldCTE(i)))
+	}
+	if distinctBy != "" {
+		joins = append(joins, "JOIN distinct_key dk ON dk.item_id = i.id")
+		hlSelectInner += ", dk.value AS distinct_value"
 	}
 	joinsSQL := strings.Join(joins, "\n  ")
 
+	var deletedWhere string
+	if !includeDeleted {
+		deletedWhere = "WHERE i.deleted_at IS NULL"
+	}
+
+	var scoreWhere string
+	if hasFTSScore && minScore != nil {
+		scoreWhere = fmt.Sprintf("AND score >= %s", builder.Arg(*minScore))
+	}
+
+	var snapshotWhere string
+	if snapshotMaxID > 0 {
+		snapshotWhere = fmt.Sprintf("AND item_id <= %s", builder.Arg(snapshotMaxID))
+	}
+
 	var afterWhere string
-	if afterFilter != "" {
-		afterWhere = fmt.Sprintf("AND (%s)", afterFilter)
+	if afterFilter != nil {
+		filter, err := afterFilter(builder)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		afterWhere = fmt.Sprintf("AND (%s)", filter)
 	}
 
-	sql := fmt.Sprintf(`%s
-SELECT item_id, path, data_json, created_at, updated_at, score
-FROM (
-  SELECT %s, %s AS score
+	rowsSQL := fmt.Sprintf(`(
+  SELECT %s, %s AS score%s
   FROM items i
   %s
   JOIN %s r ON r.item_id = i.id
-) q
-WHERE 1=1 %s
-%s
-LIMIT %d`,
-		withClause,
+  %s
+) q`,
 		selectColsInner,
 		scoreExpr,
+		hlSelectInner,
 		joinsSQL,
 		compiled.ResultCTE,
+		deletedWhere,
+	)
+	if distinctBy != "" {
+		// Rank rows within each value, keep the first, then page over those.
+		// The window ORDER BY is the result order, so the kept row is the
+		// one that would have come first.
+		rowsSQL = fmt.Sprintf(`(
+SELECT q.*, ROW_NUMBER() OVER (PARTITION BY distinct_value %s) AS distinct_rank
+FROM %s
+WHERE 1=1 %s %s
+) d`,
+			orderClause,
+			rowsSQL,
+			scoreWhere,
+			snapshotWhere,
+		)
+		scoreWhere, snapshotWhere = "AND distinct_rank = 1", ""
+	}
+
+	sql := fmt.Sprintf(`%s
+SELECT %s
+FROM %s
+WHERE 1=1 %s %s %s
+%s
+LIMIT %d`,
+		withClause,
+		selectColsOuter,
+		rowsSQL,
+		scoreWhere,
+		snapshotWhere,
 		afterWhere,
 		orderClause,
 		limitPlusOne,
 	)
 
-	return sql, nil
+	return sql, hlFields, posFields, nil
 }
 
-// BuildAfterFilter builds the after-filter fragment for cursor pagination
-func BuildAfterFilter(rank RankMode, hasFTSScore bool, builder storage.Builder, score float64, itemID int64, updatedAtMS int64, path string) (string, error) {
+// distinctKeySQL selects one value of field per item for DistinctBy: the
+// smallest, for multi-valued fields
+func distinctKeySQL(schema storage.Schema, builder storage.Builder, field string) (string, error) {
+	spec, ok := schema.Get(field)
+	if !ok {
+		return "", fmt.Errorf("unknown distinct field: %s", field)
+	}
+	switch spec.Type {
+	case storage.FieldType("keyword"):
+		return fmt.Sprintf(
+			"SELECT p.item_id, MIN(d.value) AS value FROM kw_postings p JOIN kw_dict d ON d.id = p.value_id WHERE d.field = %s GROUP BY p.item_id",
+			builder.Arg(field),
+		), nil
+	case storage.FieldType("number"):
+		return fmt.Sprintf("SELECT item_id, MIN(value) AS value FROM field_number WHERE field = %s GROUP BY item_id", builder.Arg(field)), nil
+	case storage.FieldType("date"):
+		return fmt.Sprintf("SELECT item_id, MIN(value) AS value FROM field_date WHERE field = %s GROUP BY item_id", builder.Arg(field)), nil
+	default:
+		return "", fmt.Errorf("distinct field must be keyword, number or date, got %s", spec.Type)
+	}
+}
+
+// BuildAfterFilter builds the after-filter fragment for cursor pagination.
+// rankValues holds the last row's value for each RankField sort key.
+func BuildAfterFilter(rank RankMode, hasFTSScore bool, builder storage.Builder, score float64, rankValues []float64, itemID int64, updatedAtMS int64, path string) (string, error) {
 	switch rank.Kind {
 	case RankNone:
 		ph := builder.Arg(itemID)
@@ -160,32 +429,40 @@ func BuildAfterFilter(rank RankMode, hasFTSScore bool, builder storage.Builder,
 
 	case RankDefault:
 		if hasFTSScore {
-			// Default w/ FTS score: ORDER BY score DESC, item_id ASC
+			// Default w/ FTS score: ftsScoreOrder
 			phScore1 := builder.Arg(score)
 			phScore2 := builder.Arg(score)
 			phItemID := builder.Arg(itemID)
 			return fmt.Sprintf("(score < %s OR (score = %s AND item_id > %s))", phScore1, phScore2, phItemID), nil
 		}
-		fallthrough
+		// Default without FTS score falls back to recency, always descending
+		return recencyAfter(builder, false, updatedAtMS, path), nil
 
 	case RankRecency:
-		// ORDER BY updated_at DESC, path ASC
-		ph1 := builder.Arg(updatedAtMS)
-		ph2 := builder.Arg(updatedAtMS)
-		ph3 := builder.Arg(path)
-		return fmt.Sprintf("(updated_at < %s OR (updated_at = %s AND path > %s))", ph1, ph2, ph3), nil
+		// ORDER BY updated_at DESC, path ASC (reversed when Ascending)
+		return recencyAfter(builder, rank.Ascending, updatedAtMS, path), nil
 
 	case RankField:
-		// ORDER BY score DESC, updated_at DESC, path ASC
-		phScore1 := builder.Arg(score)
-		phScore2 := builder.Arg(score)
-		phUpdated1 := builder.Arg(updatedAtMS)
-		phUpdated2 := builder.Arg(updatedAtMS)
-		phPath := builder.Arg(path)
-		return fmt.Sprintf(
-			"(score < %s OR (score = %s AND (updated_at < %s OR (updated_at = %s AND path > %s))))",
-			phScore1, phScore2, phUpdated1, phUpdated2, phPath,
-		), nil
+		// ORDER BY key_0, ..., key_n, then the recency tie-breakers: compare
+		// the key tuple lexicographically
+		keys := rank.SortKeys()
+		if len(rankValues) != len(keys) {
+			return "", fmt.Errorf("cursor has %d sort values, rank has %d keys", len(rankValues), len(keys))
+		}
+		var sb strings.Builder
+		for i, key := range keys {
+			op := ">"
+			if key.Desc {
+				op = "<"
+			}
+			col := rankColumn(i)
+			ph1 := builder.Arg(rankValues[i])
+			ph2 := builder.Arg(rankValues[i])
+			fmt.Fprintf(&sb, "(%s %s %s OR (%s = %s AND ", col, op, ph1, col, ph2)
+		}
+		sb.WriteString(recencyAfter(builder, rank.Ascending, updatedAtMS, path))
+		sb.WriteString(strings.Repeat("))", len(keys)))
+		return sb.String(), nil
 
 	default:
 		return "", fmt.Errorf("unknown rank kind")
@@ -0,0 +1,90 @@
+package query
+
+// resolveAliases rewrites the field of every predicate in expr that names an
+// alias to the aliased field
+func resolveAliases(expr Expr, aliases map[string]string) Expr {
+	switch e := expr.(type) {
+	case And:
+		return And{Left: resolveAliases(e.Left, aliases), Right: resolveAliases(e.Right, aliases)}
+	case Or:
+		return Or{Left: resolveAliases(e.Left, aliases), Right: resolveAliases(e.Right, aliases), RestrictToAnchored: e.RestrictToAnchored}
+	case Not:
+		return Not{Inner: resolveAliases(e.Inner, aliases)}
+	case Pred:
+		return Pred{Predicate: resolvePredicateAliases(e.Predicate, aliases)}
+	default:
+		return expr
+	}
+}
+
+func resolvePredicateAliases(pred Predicate, aliases map[string]string) Predicate {
+	field := func(name string) string {
+		if target, ok := aliases[name]; ok {
+			return target
+		}
+		return name
+	}
+
+	switch p := pred.(type) {
+	case Has:
+		p.Field = field(p.Field)
+		return p
+	case Keyword:
+		p.Field = field(p.Field)
+		return p
+	case InSet:
+		p.Field = field(p.Field)
+		return p
+	case AllSet:
+		p.Field = field(p.Field)
+		return p
+	case FuzzyKeyword:
+		p.Field = field(p.Field)
+		return p
+	case Text:
+		if p.Field != nil {
+			f := field(*p.Field)
+			p.Field = &f
+		}
+		return p
+	case MultiFieldText:
+		fields := make([]string, len(p.Fields))
+		for i, f := range p.Fields {
+			fields[i] = field(f)
+		}
+		p.Fields = fields
+		return p
+	case TextAny:
+		p.Field = field(p.Field)
+		return p
+	case NearText:
+		p.Field = field(p.Field)
+		return p
+	case NumberCmp:
+		p.Field = field(p.Field)
+		return p
+	case NumberRange:
+		p.Field = field(p.Field)
+		return p
+	case GeoWithin:
+		p.Field = field(p.Field)
+		return p
+	case LikePattern:
+		p.Field = field(p.Field)
+		return p
+	case DateCmpAbs:
+		p.Field = field(p.Field)
+		return p
+	case DateRangeAbs:
+		p.Field = field(p.Field)
+		return p
+	case DateCmpRel:
+		p.Field = field(p.Field)
+		return p
+	case Bool:
+		p.Field = field(p.Field)
+		return p
+	default:
+		return pred // path predicates have no field
+	}
+}
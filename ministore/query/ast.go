@@ -1,5 +1,10 @@
 package query
 
+import (
+	"strings"
+	"time"
+)
+
 // Expr represents a query expression
 type Expr interface {
 	isExpr()
@@ -17,6 +22,11 @@ func (And) isExpr() {}
 type Or struct {
 	Left  Expr
 	Right Expr
+
+	// RestrictToAnchored is set by Normalize when exactly one branch has a
+	// positive anchor; the other branch is then only evaluated within the
+	// other side of an enclosing AND.
+	RestrictToAnchored bool
 }
 
 func (Or) isExpr() {}
@@ -54,6 +64,19 @@ type PathGlob struct {
 
 func (PathGlob) isPredicate() {}
 
+// MatchAll matches every item. The parser never produces it; search builds
+// it for a query accepted by IsMatchAll.
+type MatchAll struct{}
+
+func (MatchAll) isPredicate() {}
+
+// PathExact matches the one item whose path equals Path
+type PathExact struct {
+	Path string
+}
+
+func (PathExact) isPredicate() {}
+
 // KeywordPatternKind indicates the type of keyword pattern
 type KeywordPatternKind int
 
@@ -69,18 +92,155 @@ type Keyword struct {
 	Field   string
 	Pattern string
 	Kind    KeywordPatternKind
+
+	// Quoted is set for a quoted value (status:"open"), which stays a
+	// keyword match on full_text keyword fields
+	Quoted bool
+
+	// Boost is carried over to the Text predicate when the field turns out
+	// to be a text field; see Text.Boost
+	Boost float64
+
+	// Expansion is the number of keyword values a wildcard pattern matched,
+	// counted up to ExpansionLimit+1 before compiling so explain can show it.
+	// ExpansionLimit is 0 until counted.
+	Expansion      int
+	ExpansionLimit int
 }
 
 func (Keyword) isPredicate() {}
 
+// AnyKeyword matches items holding Value in any keyword field: *:urgent.
+// Unlike bare text it compares whole keyword values, not FTS tokens.
+type AnyKeyword struct {
+	Value string
+}
+
+func (AnyKeyword) isPredicate() {}
+
+// InSet matches keyword values equal to any of Values: tags:in(a,b,c), or
+// tags:any(a,b,c)
+type InSet struct {
+	Field  string
+	Values []string
+}
+
+func (InSet) isPredicate() {}
+
+// AnySet is the name of InSet in the any(...) spelling
+type AnySet = InSet
+
+// AllSet matches items holding every one of Values in a multi-valued keyword
+// field: tags:all(a,b,c), the same as tags:a AND tags:b AND tags:c
+type AllSet struct {
+	Field  string
+	Values []string
+}
+
+func (AllSet) isPredicate() {}
+
+// FuzzyKeyword matches keyword values within an edit distance of Term
+type FuzzyKeyword struct {
+	Field       string
+	Term        string
+	MaxDistance int
+
+	// ValueIDs holds the kw_dict ids matched client-side on backends without
+	// native edit distance. Resolved is false until that lookup has run.
+	ValueIDs []int64
+	Resolved bool
+}
+
+func (FuzzyKeyword) isPredicate() {}
+
+// FuzzyMaxDistance returns the edit distance allowed for a fuzzy term of the given length
+func FuzzyMaxDistance(term string) int {
+	n := len([]rune(term))
+	switch {
+	case n < 3:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
 // Text performs full-text search
 type Text struct {
 	Field *string // nil means search all text fields
 	FTS   string
+
+	// Boost multiplies this predicate's share of the FTS score: rust^2.
+	// 0 means no boost, the same as 1.
+	Boost float64
 }
 
 func (Text) isPredicate() {}
 
+// MultiFieldText performs full-text search restricted to a subset of text fields
+type MultiFieldText struct {
+	Fields []string
+	FTS    string
+	Boost  float64 // see Text.Boost
+}
+
+func (MultiFieldText) isPredicate() {}
+
+// TextAny performs full-text search for any of several terms within one
+// field: title:(rust OR golang)
+type TextAny struct {
+	Field string
+	Terms []string
+	Boost float64 // see Text.Boost
+}
+
+func (TextAny) isPredicate() {}
+
+// NearText matches two terms close together in one text field:
+// content:near(error,timeout,5). SQLite compiles it to FTS5
+// NEAR(a b, Distance), which allows up to Distance tokens between the terms
+// in either order. Postgres compiles it to a <Distance> b, which requires b
+// exactly Distance positions after a.
+type NearText struct {
+	Field    string
+	Terms    []string
+	Distance int
+	Boost    float64 // see Text.Boost
+}
+
+func (NearText) isPredicate() {}
+
+// LikePattern matches a text field's stored value with SQL LIKE semantics:
+// title:like("INV-%-2024"). % matches any run of characters, _ any one, and
+// \ escapes either. Matching is case-insensitive (ASCII only on SQLite).
+type LikePattern struct {
+	Field   string
+	Pattern string
+}
+
+func (LikePattern) isPredicate() {}
+
+// LikeLiteralPrefix returns the part of a LIKE pattern before its first
+// unescaped wildcard, with escapes removed
+func LikeLiteralPrefix(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '%', '_':
+			return sb.String()
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteByte(pattern[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
 // CmpOp is a comparison operator
 type CmpOp int
 
@@ -90,6 +250,7 @@ const (
 	CmpGte
 	CmpLt
 	CmpLte
+	CmpNe // any value differs; see NumberCmp
 )
 
 func (op CmpOp) String() string {
@@ -104,12 +265,17 @@ func (op CmpOp) String() string {
 		return "<"
 	case CmpLte:
 		return "<="
+	case CmpNe:
+		return "!="
 	default:
 		return "?"
 	}
 }
 
-// NumberCmp compares a numeric field
+// NumberCmp compares a numeric field. Like every comparison on a multi-valued
+// field it matches when any one value satisfies it, so CmpNe matches an item
+// holding 5 and 6 for !=5; items without the field never match. NOT field:5
+// is the predicate that excludes every item holding 5.
 type NumberCmp struct {
 	Field string
 	Op    CmpOp
@@ -118,15 +284,29 @@ type NumberCmp struct {
 
 func (NumberCmp) isPredicate() {}
 
-// NumberRange matches a numeric field within a range (inclusive)
+// NumberRange matches a numeric field within a range. lo..hi is inclusive on
+// both ends; bracket syntax ([lo,hi), (lo,hi], ...) controls each bound.
 type NumberRange struct {
-	Field string
-	Lo    float64
-	Hi    float64
+	Field       string
+	Lo          float64
+	Hi          float64
+	LoInclusive bool
+	HiInclusive bool
 }
 
 func (NumberRange) isPredicate() {}
 
+// GeoWithin matches a geo field holding a point within RadiusKm kilometres
+// (great-circle distance) of Lat,Lon: location:within(48.85,2.35,10)
+type GeoWithin struct {
+	Field    string
+	Lat      float64
+	Lon      float64
+	RadiusKm float64
+}
+
+func (GeoWithin) isPredicate() {}
+
 // DateCmpAbs compares a date field to an absolute timestamp
 type DateCmpAbs struct {
 	Field   string
@@ -136,11 +316,14 @@ type DateCmpAbs struct {
 
 func (DateCmpAbs) isPredicate() {}
 
-// DateRangeAbs matches a date field within an absolute range (inclusive)
+// DateRangeAbs matches a date field within an absolute range. Bounds follow
+// the same inclusivity rules as NumberRange.
 type DateRangeAbs struct {
-	Field string
-	LoMS  int64
-	HiMS  int64
+	Field       string
+	LoMS        int64
+	HiMS        int64
+	LoInclusive bool
+	HiInclusive bool
 }
 
 func (DateRangeAbs) isPredicate() {}
@@ -173,7 +356,22 @@ func (u RelUnit) String() string {
 	}
 }
 
-// ToMillis converts an amount and unit to milliseconds
+// Shift moves nowMS by amount units. Months and years use calendar
+// arithmetic in UTC (so 1m from Jan 31 lands in early March, as with
+// time.AddDate); hours, days and weeks are exact millisecond offsets.
+func (u RelUnit) Shift(nowMS, amount int64) int64 {
+	switch u {
+	case RelM:
+		return time.UnixMilli(nowMS).UTC().AddDate(0, int(amount), 0).UnixMilli()
+	case RelY:
+		return time.UnixMilli(nowMS).UTC().AddDate(int(amount), 0, 0).UnixMilli()
+	default:
+		return nowMS + u.ToMillis(amount)
+	}
+}
+
+// ToMillis converts an amount and unit to milliseconds. Months and years are
+// approximated as 30 and 365 days; use Shift for calendar-accurate offsets.
 func (u RelUnit) ToMillis(amount int64) int64 {
 	switch u {
 	case RelH:
@@ -191,6 +389,54 @@ func (u RelUnit) ToMillis(amount int64) int64 {
 	}
 }
 
+// CalendarWindow is a named calendar period such as "today"
+type CalendarWindow int
+
+const (
+	CalToday CalendarWindow = iota
+	CalYesterday
+	CalThisWeek // weeks start on Monday
+	CalThisMonth
+)
+
+func (w CalendarWindow) String() string {
+	switch w {
+	case CalToday:
+		return "today"
+	case CalYesterday:
+		return "yesterday"
+	case CalThisWeek:
+		return "thisweek"
+	case CalThisMonth:
+		return "thismonth"
+	default:
+		return "?"
+	}
+}
+
+// Bounds returns the half-open range [lo, hi) in epoch milliseconds covering
+// the window that contains now, in now's location
+func (w CalendarWindow) Bounds(now time.Time) (loMS, hiMS int64) {
+	y, m, d := now.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+
+	var lo, hi time.Time
+	switch w {
+	case CalToday:
+		lo, hi = day, day.AddDate(0, 0, 1)
+	case CalYesterday:
+		lo, hi = day.AddDate(0, 0, -1), day
+	case CalThisWeek:
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		lo = day.AddDate(0, 0, -offset)
+		hi = lo.AddDate(0, 0, 7)
+	case CalThisMonth:
+		lo = time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+		hi = lo.AddDate(0, 1, 0)
+	}
+	return lo.UnixMilli(), hi.UnixMilli()
+}
+
 // DateCmpRel compares a date field to a relative time offset
 type DateCmpRel struct {
 	Field  string
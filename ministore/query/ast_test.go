@@ -0,0 +1,39 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelUnitShiftCalendar(t *testing.T) {
+	ms := func(s string) int64 {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatalf("parse %s: %v", s, err)
+		}
+		return tm.UnixMilli()
+	}
+
+	tests := []struct {
+		name   string
+		now    string
+		unit   RelUnit
+		amount int64
+		want   string
+	}{
+		{"month forward", "2024-01-15T12:00:00Z", RelM, 1, "2024-02-15T12:00:00Z"},
+		{"month back across year", "2024-01-15T12:00:00Z", RelM, -1, "2023-12-15T12:00:00Z"},
+		{"month back from 31st overflows", "2024-03-31T00:00:00Z", RelM, -1, "2024-03-02T00:00:00Z"},
+		{"six months", "2024-08-31T00:00:00Z", RelM, -6, "2024-03-02T00:00:00Z"},
+		{"year back over leap day", "2024-03-01T00:00:00Z", RelY, -1, "2023-03-01T00:00:00Z"},
+		{"year from Feb 29", "2024-02-29T00:00:00Z", RelY, 1, "2025-03-01T00:00:00Z"},
+		{"year back from Feb 29", "2024-02-29T00:00:00Z", RelY, -1, "2023-03-01T00:00:00Z"},
+		{"days stay exact", "2024-02-28T00:00:00Z", RelD, 1, "2024-02-29T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		got := tt.unit.Shift(ms(tt.now), tt.amount)
+		if got != ms(tt.want) {
+			t.Errorf("%s: got %s want %s", tt.name, time.UnixMilli(got).UTC().Format(time.RFC3339), tt.want)
+		}
+	}
+}
@@ -12,6 +12,11 @@ type Token struct {
 	Kind  TokenKind
 	Value string
 	Num   float64
+
+	// Pattern is Value with backslash escapes of '*', '?' and '\\' kept, for
+	// reading the token as a keyword pattern. It is empty when the token has
+	// none of those escapes, and Value serves as the pattern.
+	Pattern string
 }
 
 // TokenKind is the type of token
@@ -31,7 +36,13 @@ const (
 	TokGte
 	TokLt
 	TokLte
+	TokNe
 	TokDotDot
+	TokComma
+	TokTilde
+	TokLBracket
+	TokRBracket
+	TokCaret
 	TokEOF
 )
 
@@ -63,8 +74,20 @@ func (k TokenKind) String() string {
 		return "Lt"
 	case TokLte:
 		return "Lte"
+	case TokNe:
+		return "Ne"
 	case TokDotDot:
 		return "DotDot"
+	case TokComma:
+		return "Comma"
+	case TokTilde:
+		return "Tilde"
+	case TokLBracket:
+		return "LBracket"
+	case TokRBracket:
+		return "RBracket"
+	case TokCaret:
+		return "Caret"
 	case TokEOF:
 		return "EOF"
 	default:
@@ -133,8 +156,27 @@ func (l *Lexer) Next() (Token, error) {
 		l.pos++
 		return Token{Kind: TokOr}, nil
 	case '!':
+		if l.peek(1) == '=' {
+			l.pos += 2
+			return Token{Kind: TokNe}, nil
+		}
 		l.pos++
 		return Token{Kind: TokNot}, nil
+	case ',':
+		l.pos++
+		return Token{Kind: TokComma}, nil
+	case '~':
+		l.pos++
+		return Token{Kind: TokTilde}, nil
+	case '[':
+		l.pos++
+		return Token{Kind: TokLBracket}, nil
+	case ']':
+		l.pos++
+		return Token{Kind: TokRBracket}, nil
+	case '^':
+		l.pos++
+		return Token{Kind: TokCaret}, nil
 	}
 
 	// Two-character tokens
@@ -193,34 +235,34 @@ func (l *Lexer) peek(offset int) rune {
 
 func (l *Lexer) scanString() (Token, error) {
 	l.pos++ // consume opening quote
-	var sb strings.Builder
+	var sb, pattern strings.Builder
 
 	for l.pos < len(l.input) {
 		ch := l.input[l.pos]
 		if ch == '"' {
 			l.pos++ // consume closing quote
-			return Token{Kind: TokString, Value: sb.String()}, nil
+			tok := Token{Kind: TokString, Value: sb.String()}
+			if pattern.Len() != sb.Len() {
+				tok.Pattern = pattern.String()
+			}
+			return tok, nil
 		}
 		if ch == '\\' && l.pos+1 < len(l.input) {
 			l.pos++
-			switch l.input[l.pos] {
-			case '"':
-				sb.WriteRune('"')
-			case '\\':
-				sb.WriteRune('\\')
+			ch = l.input[l.pos]
+			switch ch {
+			case '*', '?', '\\':
+				pattern.WriteRune('\\')
 			case 'n':
-				sb.WriteRune('\n')
+				ch = '\n'
 			case 't':
-				sb.WriteRune('\t')
+				ch = '\t'
 			case 'r':
-				sb.WriteRune('\r')
-			default:
-				sb.WriteRune(l.input[l.pos])
+				ch = '\r'
 			}
-			l.pos++
-			continue
 		}
 		sb.WriteRune(ch)
+		pattern.WriteRune(ch)
 		l.pos++
 	}
 
@@ -240,6 +282,14 @@ func (l *Lexer) scanNumber() (Token, error) {
 		l.pos++
 	}
 
+	// Date literal (2024-01-31): digits followed by '-' and a digit
+	if l.input[start] != '-' && l.pos < len(l.input) && l.input[l.pos] == '-' && unicode.IsDigit(l.peek(1)) {
+		for l.pos < len(l.input) && isIdentChar(l.input[l.pos]) && !(l.input[l.pos] == '.' && l.peek(1) == '.') {
+			l.pos++
+		}
+		return Token{Kind: TokIdent, Value: string(l.input[start:l.pos])}, nil
+	}
+
 	// Decimal part
 	if l.pos < len(l.input) && l.input[l.pos] == '.' {
 		// Check if it's ".." (range operator)
@@ -262,14 +312,41 @@ func (l *Lexer) scanNumber() (Token, error) {
 	return Token{Kind: TokNumber, Value: numStr, Num: num}, nil
 }
 
+// scanIdent reads an identifier. A backslash makes the next character part
+// of it literally: c\* is the keyword value "c*" rather than a prefix.
 func (l *Lexer) scanIdent() (Token, error) {
-	start := l.pos
+	var sb, pattern strings.Builder
+	escaped := false
 
-	for l.pos < len(l.input) && isIdentChar(l.input[l.pos]) {
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		if ch == '\\' {
+			if l.pos+1 >= len(l.input) {
+				return Token{}, fmt.Errorf("unfinished escape at end of query")
+			}
+			l.pos++
+			ch = l.input[l.pos]
+			if ch == '*' || ch == '?' || ch == '\\' {
+				pattern.WriteRune('\\')
+			}
+			escaped = true
+		} else if !isIdentChar(ch) {
+			break
+		}
+		sb.WriteRune(ch)
+		pattern.WriteRune(ch)
 		l.pos++
 	}
 
-	value := string(l.input[start:l.pos])
+	value := sb.String()
+	if escaped {
+		// An escaped ident is never an operator: \AND is the term "AND"
+		tok := Token{Kind: TokIdent, Value: value}
+		if pattern.Len() != sb.Len() {
+			tok.Pattern = pattern.String()
+		}
+		return tok, nil
+	}
 	upper := strings.ToUpper(value)
 
 	// Check for keywords
@@ -286,7 +363,7 @@ func (l *Lexer) scanIdent() (Token, error) {
 }
 
 func isIdentStart(ch rune) bool {
-	return unicode.IsLetter(ch) || ch == '_' || ch == '*' || ch == '?' || ch == '/' || ch == '-'
+	return unicode.IsLetter(ch) || ch == '_' || ch == '*' || ch == '?' || ch == '/' || ch == '-' || ch == '\\'
 }
 
 func isIdentChar(ch rune) bool {
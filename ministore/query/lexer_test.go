@@ -155,3 +155,43 @@ func TestLexEscapedString(t *testing.T) {
 		t.Errorf("expected String(hello\\nworld), got %v", tokens[0])
 	}
 }
+
+func TestLexEscapedWildcards(t *testing.T) {
+	for input, want := range map[string]Token{
+		`c\*`:      {Kind: TokIdent, Value: "c*", Pattern: `c\*`},
+		`c\*x*`:    {Kind: TokIdent, Value: "c*x*", Pattern: `c\*x*`},
+		`\AND`:     {Kind: TokIdent, Value: "AND"},
+		`a\:b`:     {Kind: TokIdent, Value: "a:b"},
+		`"c\*"`:    {Kind: TokString, Value: "c*", Pattern: `c\*`},
+		`"a\\b"`:   {Kind: TokString, Value: `a\b`, Pattern: `a\\b`},
+		`"plain*"`: {Kind: TokString, Value: "plain*"},
+	} {
+		tokens, err := Lex(input)
+		if err != nil {
+			t.Fatalf("Lex(%s): %v", input, err)
+		}
+		if tokens[0] != want {
+			t.Errorf("Lex(%s) = %+v, want %+v", input, tokens[0], want)
+		}
+	}
+
+	if _, err := Lex(`tags:c\`); err == nil {
+		t.Error("expected an error for a trailing backslash")
+	}
+}
+
+func TestLexDateLiteral(t *testing.T) {
+	tokens, err := Lex("due:2024-01-01..2024-06-30")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tokens[2].Kind != TokIdent || tokens[2].Value != "2024-01-01" {
+		t.Errorf("expected Ident(2024-01-01), got %v", tokens[2])
+	}
+	if tokens[3].Kind != TokDotDot {
+		t.Errorf("expected DotDot, got %v", tokens[3])
+	}
+	if tokens[4].Kind != TokIdent || tokens[4].Value != "2024-06-30" {
+		t.Errorf("expected Ident(2024-06-30), got %v", tokens[4])
+	}
+}
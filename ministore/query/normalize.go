@@ -10,6 +10,25 @@ type NormalizeOptions struct {
 	MinContainsLen     int
 	MinPrefixLen       int
 	MaxPrefixExpansion int
+
+	// IsTextField reports whether a schema field is a text field. When set,
+	// fields(...) predicates are checked against it.
+	IsTextField func(name string) bool
+
+	// NoLike rejects like(...) predicates, for indexes whose stored text
+	// cannot be matched in SQL
+	NoLike bool
+
+	// Aliases maps alternative field names to schema field names. Normalize
+	// rewrites every predicate's field through it first.
+	Aliases map[string]string
+
+	// RequireAllOrBranchesAnchored rejects OR expressions unless both
+	// branches have a positive anchor. When false, an OR is accepted if at
+	// least one branch is anchored; the anchorless branch is then evaluated
+	// only within the other side of an enclosing AND, never the whole index,
+	// and adds nothing to an OR outside any AND.
+	RequireAllOrBranchesAnchored bool
 }
 
 // DefaultNormalizeOptions returns default normalization options
@@ -18,14 +37,24 @@ func DefaultNormalizeOptions() NormalizeOptions {
 		MinContainsLen:     3,
 		MinPrefixLen:       2,
 		MaxPrefixExpansion: 20000,
+
+		RequireAllOrBranchesAnchored: true,
 	}
 }
 
 // Normalize validates and normalizes a parsed expression
 // It enforces positive anchors and guardrails
 func Normalize(expr Expr, opts NormalizeOptions) (Expr, error) {
+	if len(opts.Aliases) > 0 {
+		expr = resolveAliases(expr, opts.Aliases)
+	}
+
 	// Check for positive anchor
-	if !hasPositiveAnchor(expr) {
+	if !HasPositiveAnchor(expr, opts) {
+		if opts.RequireAllOrBranchesAnchored && HasPositiveAnchor(expr, NormalizeOptions{}) {
+			return nil, fmt.Errorf("every OR branch must have a positive anchor; an unanchored branch would scan all items " +
+				"(IndexOptions.AllowUnanchoredOrBranches evaluates it only within the rest of an enclosing AND, which is cheaper but ignores it outside one)")
+		}
 		return nil, fmt.Errorf("query must have at least one positive anchor (text search, exact keyword match, numeric/date predicate, or path with literal prefix)")
 	}
 
@@ -34,29 +63,65 @@ func Normalize(expr Expr, opts NormalizeOptions) (Expr, error) {
 		return nil, err
 	}
 
+	if !opts.RequireAllOrBranchesAnchored {
+		expr = markRestrictedOrs(expr)
+	}
+
 	return expr, nil
 }
 
-// hasPositiveAnchor checks if the expression contains at least one positive anchor
-func hasPositiveAnchor(expr Expr) bool {
+// markRestrictedOrs flags OR nodes that have exactly one anchored branch
+func markRestrictedOrs(expr Expr) Expr {
+	anyAnchor := NormalizeOptions{}
 	switch e := expr.(type) {
 	case And:
-		return hasPositiveAnchor(e.Left) || hasPositiveAnchor(e.Right)
+		return And{Left: markRestrictedOrs(e.Left), Right: markRestrictedOrs(e.Right)}
 	case Or:
-		return hasPositiveAnchor(e.Left) && hasPositiveAnchor(e.Right) // both branches need anchors
+		left, right := markRestrictedOrs(e.Left), markRestrictedOrs(e.Right)
+		restrict := HasPositiveAnchor(left, anyAnchor) != HasPositiveAnchor(right, anyAnchor)
+		return Or{Left: left, Right: right, RestrictToAnchored: restrict}
+	case Not:
+		return Not{Inner: markRestrictedOrs(e.Inner)}
+	default:
+		return expr
+	}
+}
+
+// HasPositiveAnchor checks if the expression contains at least one positive anchor.
+// OR expressions need an anchor on both sides unless opts.RequireAllOrBranchesAnchored is false.
+func HasPositiveAnchor(expr Expr, opts NormalizeOptions) bool {
+	switch e := expr.(type) {
+	case And:
+		return HasPositiveAnchor(e.Left, opts) || HasPositiveAnchor(e.Right, opts)
+	case Or:
+		if opts.RequireAllOrBranchesAnchored {
+			return HasPositiveAnchor(e.Left, opts) && HasPositiveAnchor(e.Right, opts)
+		}
+		return HasPositiveAnchor(e.Left, opts) || HasPositiveAnchor(e.Right, opts)
 	case Not:
 		return false // NOT is not a positive anchor
 	case Pred:
-		return predicateIsAnchor(e.Predicate)
+		return predicateIsAnchor(e.Predicate, opts)
 	default:
 		return false
 	}
 }
 
-// predicateIsAnchor returns true if the predicate can serve as a positive anchor
-func predicateIsAnchor(pred Predicate) bool {
+// predicateIsAnchor returns true if the predicate can serve as a positive
+// anchor. Pattern length limits come from opts; unset ones use the defaults,
+// so a zero NormalizeOptions asks about the default guardrails.
+func predicateIsAnchor(pred Predicate, opts NormalizeOptions) bool {
+	defaults := DefaultNormalizeOptions()
+	minPrefix, minContains := opts.MinPrefixLen, opts.MinContainsLen
+	if minPrefix <= 0 {
+		minPrefix = defaults.MinPrefixLen
+	}
+	if minContains <= 0 {
+		minContains = defaults.MinContainsLen
+	}
+
 	switch p := pred.(type) {
-	case Text:
+	case Text, MultiFieldText, TextAny, NearText:
 		return true // FTS is always an anchor
 	case Keyword:
 		// Exact match is an anchor
@@ -65,17 +130,20 @@ func predicateIsAnchor(pred Predicate) bool {
 		case KeywordExact:
 			return true
 		case KeywordPrefix:
-			prefix := strings.TrimSuffix(p.Pattern, "*")
-			return len(prefix) >= 2 // needs at least 2 chars
+			return len(keywordLiteralPrefix(p.Pattern)) >= minPrefix
 		case KeywordContains:
-			inner := strings.Trim(p.Pattern, "*")
-			return len(inner) >= 3 // needs at least 3 chars
+			return len(keywordContainsInner(p.Pattern)) >= minContains
 		case KeywordGlob:
 			// Find literal prefix before first wildcard
-			prefix := literalPrefixBeforeWildcard(p.Pattern)
-			return len(prefix) >= 2
+			return len(keywordLiteralPrefix(p.Pattern)) >= minPrefix
 		}
-	case NumberCmp, NumberRange:
+	case FuzzyKeyword, AnyKeyword:
+		return true
+	case InSet:
+		return len(p.Values) > 0
+	case AllSet:
+		return len(p.Values) > 0
+	case NumberCmp, NumberRange, GeoWithin:
 		return true
 	case DateCmpAbs, DateRangeAbs, DateCmpRel:
 		return true
@@ -85,6 +153,11 @@ func predicateIsAnchor(pred Predicate) bool {
 		// Path with literal prefix is an anchor
 		prefix := literalPrefixBeforeWildcard(p.Pattern)
 		return len(prefix) >= 1 // even "/" is enough
+	case LikePattern:
+		// Like a keyword glob, only with a literal prefix
+		return len(LikeLiteralPrefix(p.Pattern)) >= minPrefix
+	case PathExact, MatchAll:
+		return true
 	case Has:
 		return true // field presence is an anchor
 	}
@@ -117,18 +190,15 @@ func validatePredicateGuardrails(pred Predicate, opts NormalizeOptions) error {
 	case Keyword:
 		switch p.Kind {
 		case KeywordPrefix:
-			prefix := strings.TrimSuffix(p.Pattern, "*")
-			if len(prefix) < opts.MinPrefixLen {
+			if len(keywordLiteralPrefix(p.Pattern)) < opts.MinPrefixLen {
 				return fmt.Errorf("prefix pattern '%s' too short (min %d characters before *)", p.Pattern, opts.MinPrefixLen)
 			}
 		case KeywordContains:
-			inner := strings.Trim(p.Pattern, "*")
-			if len(inner) < opts.MinContainsLen {
+			if len(keywordContainsInner(p.Pattern)) < opts.MinContainsLen {
 				return fmt.Errorf("contains pattern '%s' too short (min %d characters)", p.Pattern, opts.MinContainsLen)
 			}
 		case KeywordGlob:
-			prefix := literalPrefixBeforeWildcard(p.Pattern)
-			if len(prefix) < opts.MinPrefixLen {
+			if len(keywordLiteralPrefix(p.Pattern)) < opts.MinPrefixLen {
 				return fmt.Errorf("glob pattern '%s' needs literal prefix of at least %d characters", p.Pattern, opts.MinPrefixLen)
 			}
 		}
@@ -142,10 +212,101 @@ func validatePredicateGuardrails(pred Predicate, opts NormalizeOptions) error {
 		if len(p.FTS) == 0 {
 			return fmt.Errorf("text search term cannot be empty")
 		}
+		if prefix, ok := TextPrefix(p.FTS); ok && len(prefix) < opts.MinPrefixLen {
+			return fmt.Errorf("prefix pattern '%s' too short (min %d characters before *)", p.FTS, opts.MinPrefixLen)
+		}
+	case FuzzyKeyword:
+		if len(p.Term) == 0 {
+			return fmt.Errorf("fuzzy term cannot be empty")
+		}
+	case AnyKeyword:
+		if len(p.Value) == 0 {
+			return fmt.Errorf("*: keyword value cannot be empty")
+		}
+	case InSet:
+		if len(p.Values) == 0 {
+			return fmt.Errorf("%s:in(...) requires at least one value", p.Field)
+		}
+	case AllSet:
+		if len(p.Values) == 0 {
+			return fmt.Errorf("%s:all(...) requires at least one value", p.Field)
+		}
+	case TextAny:
+		for _, term := range p.Terms {
+			if len(term) == 0 {
+				return fmt.Errorf("text search term cannot be empty")
+			}
+		}
+		if opts.IsTextField != nil && !opts.IsTextField(p.Field) {
+			return fmt.Errorf("%s:(...) grouping is only supported for text fields", p.Field)
+		}
+	case NearText:
+		if len(p.Terms) != 2 {
+			return fmt.Errorf("%s:near(...) takes exactly two terms, got %d", p.Field, len(p.Terms))
+		}
+		for _, term := range p.Terms {
+			if len(term) == 0 {
+				return fmt.Errorf("text search term cannot be empty")
+			}
+		}
+		if p.Distance <= 0 {
+			return fmt.Errorf("%s:near(...) distance must be a positive integer, got %d", p.Field, p.Distance)
+		}
+		if opts.IsTextField != nil && !opts.IsTextField(p.Field) {
+			return fmt.Errorf("%s:near(...) is only supported for text fields", p.Field)
+		}
+	case MultiFieldText:
+		if len(p.FTS) == 0 {
+			return fmt.Errorf("text search term cannot be empty")
+		}
+		if prefix, ok := TextPrefix(p.FTS); ok && len(prefix) < opts.MinPrefixLen {
+			return fmt.Errorf("prefix pattern '%s' too short (min %d characters before *)", p.FTS, opts.MinPrefixLen)
+		}
+		if len(p.Fields) == 0 {
+			return fmt.Errorf("fields(...) requires at least one field")
+		}
+		if opts.IsTextField != nil {
+			for _, f := range p.Fields {
+				if !opts.IsTextField(f) {
+					return fmt.Errorf("fields(...): '%s' is not a text field", f)
+				}
+			}
+		}
+	case LikePattern:
+		if opts.NoLike {
+			return fmt.Errorf("%s:like(...) is not supported on this index: stored documents are compressed", p.Field)
+		}
+		if p.Pattern == "" {
+			return fmt.Errorf("%s:like(...) pattern cannot be empty", p.Field)
+		}
+		if opts.IsTextField != nil && !opts.IsTextField(p.Field) {
+			return fmt.Errorf("%s:like(...) is only supported for text fields", p.Field)
+		}
+	case GeoWithin:
+		if p.Lat < -90 || p.Lat > 90 {
+			return fmt.Errorf("%s:within(...) latitude must be between -90 and 90, got %v", p.Field, p.Lat)
+		}
+		if p.Lon < -180 || p.Lon > 180 {
+			return fmt.Errorf("%s:within(...) longitude must be between -180 and 180, got %v", p.Field, p.Lon)
+		}
+		if p.RadiusKm <= 0 {
+			return fmt.Errorf("%s:within(...) radius must be positive, got %v", p.Field, p.RadiusKm)
+		}
 	}
 	return nil
 }
 
+// TextPrefix reports whether a full-text term is a prefix search (hel*) and
+// returns it without the trailing '*'. Terms with whitespace or other
+// wildcards are not prefix searches and are matched literally.
+func TextPrefix(term string) (string, bool) {
+	prefix, ok := strings.CutSuffix(term, "*")
+	if !ok || prefix == "" || strings.ContainsAny(prefix, "*? \t\r\n") {
+		return "", false
+	}
+	return prefix, true
+}
+
 // literalPrefixBeforeWildcard returns the literal part before the first wildcard
 func literalPrefixBeforeWildcard(pattern string) string {
 	for i, c := range pattern {
@@ -156,6 +317,32 @@ func literalPrefixBeforeWildcard(pattern string) string {
 	return pattern
 }
 
+// keywordLiteralPrefix returns the unescaped literal part of a keyword
+// pattern before its first unescaped wildcard
+func keywordLiteralPrefix(pattern string) string {
+	escaped := false
+	for i, c := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '*' || c == '?':
+			return UnescapePattern(pattern[:i])
+		}
+	}
+	return UnescapePattern(pattern)
+}
+
+// keywordContainsInner returns the unescaped value between the wildcards of
+// a KeywordContains pattern (*value*)
+func keywordContainsInner(pattern string) string {
+	if len(pattern) < 2 {
+		return ""
+	}
+	return UnescapePattern(pattern[1 : len(pattern)-1])
+}
+
 // ExtractTextPredicates collects all Text predicates from an expression
 func ExtractTextPredicates(expr Expr) []Text {
 	var result []Text
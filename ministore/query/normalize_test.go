@@ -178,3 +178,165 @@ func TestNormalizeContainsTooShort(t *testing.T) {
 		t.Fatalf("normalize should reject contains with 2-char substring when min is 3")
 	}
 }
+
+func TestNormalizeTextPrefixTooShort(t *testing.T) {
+	expr, err := Parse("h*")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	_, err = Normalize(expr, DefaultNormalizeOptions())
+	if err == nil {
+		t.Fatalf("expected normalize to reject short text prefix")
+	}
+
+	expr, err = Parse("hel*")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if _, err := Normalize(expr, DefaultNormalizeOptions()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTextPrefix(t *testing.T) {
+	tests := []struct {
+		term   string
+		prefix string
+		ok     bool
+	}{
+		{"hel*", "hel", true},
+		{"hel", "", false},
+		{"*", "", false},
+		{"*el*", "", false},
+		{"he?l*", "", false},
+		{"hello wor*", "", false},
+	}
+	for _, tt := range tests {
+		prefix, ok := TextPrefix(tt.term)
+		if prefix != tt.prefix || ok != tt.ok {
+			t.Errorf("TextPrefix(%q) = %q, %v; want %q, %v", tt.term, prefix, ok, tt.prefix, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeRejectsHalfAnchoredOrByDefault(t *testing.T) {
+	expr, err := Parse("tags:rust OR NOT tags:hidden")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	_, err = Normalize(expr, DefaultNormalizeOptions())
+	if err == nil {
+		t.Fatalf("expected normalize to reject OR with an unanchored branch")
+	}
+}
+
+func TestNormalizeAcceptsHalfAnchoredOrWhenLoose(t *testing.T) {
+	expr, err := Parse("tags:rust OR NOT tags:hidden")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	opts := DefaultNormalizeOptions()
+	opts.RequireAllOrBranchesAnchored = false
+	normalized, err := Normalize(expr, opts)
+	if err != nil {
+		t.Fatalf("normalize should accept OR with one anchored branch: %v", err)
+	}
+	or, ok := normalized.(Or)
+	if !ok {
+		t.Fatalf("expected Or, got %T", normalized)
+	}
+	if !or.RestrictToAnchored {
+		t.Fatalf("expected OR to be marked RestrictToAnchored")
+	}
+}
+
+func TestNormalizeLooseStillRejectsUnanchoredOr(t *testing.T) {
+	expr, err := Parse("NOT tags:a OR NOT tags:b")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	opts := DefaultNormalizeOptions()
+	opts.RequireAllOrBranchesAnchored = false
+	if _, err := Normalize(expr, opts); err == nil {
+		t.Fatalf("expected normalize to reject OR with no anchored branch")
+	}
+}
+
+func TestNormalizeInSet(t *testing.T) {
+	expr, err := Parse("tags:in(a,b)")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if _, err := Normalize(expr, DefaultNormalizeOptions()); err != nil {
+		t.Fatalf("normalize should accept non-empty set: %v", err)
+	}
+
+	expr, err = Parse("tags:in()")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if _, err := Normalize(expr, DefaultNormalizeOptions()); err == nil {
+		t.Fatalf("expected normalize to reject empty set")
+	}
+}
+
+func TestNormalizeNear(t *testing.T) {
+	opts := DefaultNormalizeOptions()
+	opts.IsTextField = func(name string) bool { return name == "content" }
+
+	for q, ok := range map[string]bool{
+		"content:near(error,timeout,5)":      true,
+		"content:near(error,timeout,0)":      false,
+		"content:near(error,timeout,-1)":     false,
+		"content:near(error,timeout,crit,5)": false,
+		"tags:near(error,timeout,5)":         false,
+	} {
+		expr, err := Parse(q)
+		if err != nil {
+			t.Fatalf("parse %q: %v", q, err)
+		}
+		if _, err := Normalize(expr, opts); (err == nil) != ok {
+			t.Errorf("Normalize(%q) error = %v, want ok=%v", q, err, ok)
+		}
+	}
+}
+
+func TestNormalizeWithin(t *testing.T) {
+	for q, ok := range map[string]bool{
+		"location:within(48.85,2.35,10)":   true,
+		"location:within(91,2.35,10)":      false,
+		"location:within(48.85,-181,10)":   false,
+		"location:within(48.85,2.35,0)":    false,
+		"location:within(48.85,2.35,-1.5)": false,
+	} {
+		expr, err := Parse(q)
+		if err != nil {
+			t.Fatalf("parse %q: %v", q, err)
+		}
+		if _, err := Normalize(expr, DefaultNormalizeOptions()); (err == nil) != ok {
+			t.Errorf("Normalize(%q) error = %v, want ok=%v", q, err, ok)
+		}
+	}
+}
+
+func TestNormalizeLike(t *testing.T) {
+	for q, ok := range map[string]bool{
+		`sku:like("INV-%")`:             true,
+		`sku:like("%-2024")`:            false, // no literal prefix, no anchor
+		`sku:like("I%")`:                false, // prefix shorter than MinPrefixLen
+		`tags:x AND sku:like("%-2024")`: true,
+		`tags:x AND sku:like("")`:       false,
+	} {
+		expr, err := Parse(q)
+		if err != nil {
+			t.Fatalf("parse %q: %v", q, err)
+		}
+		if _, err := Normalize(expr, DefaultNormalizeOptions()); (err == nil) != ok {
+			t.Errorf("Normalize(%q) error = %v, want ok=%v", q, err, ok)
+		}
+	}
+
+	if got := LikeLiteralPrefix(`10\%_off`); got != "10%" {
+		t.Errorf("LikeLiteralPrefix = %q, want 10%%", got)
+	}
+}
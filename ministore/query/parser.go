@@ -7,20 +7,39 @@ import (
 	"time"
 )
 
-// Parse parses a query string into an expression AST
+// Parse parses a query string into an expression AST. Bare date literals
+// (YYYY-MM-DD) are read as UTC midnight.
 func Parse(input string) (Expr, error) {
+	return ParseInLocation(input, time.UTC)
+}
+
+// ParseInLocation is like Parse but reads bare date literals as midnight in
+// loc. RFC3339 literals keep their explicit offset.
+func ParseInLocation(input string, loc *time.Location) (Expr, error) {
 	tokens, err := Lex(input)
 	if err != nil {
 		return nil, err
 	}
+	if loc == nil {
+		loc = time.UTC
+	}
 
-	p := &parser{tokens: tokens, pos: 0}
+	p := &parser{tokens: tokens, pos: 0, loc: loc}
 	return p.parseExpr()
 }
 
+// IsMatchAll reports whether input is the match-all query: empty, blank or
+// a lone "*". Parse and Normalize reject these; callers that list a whole
+// collection check IsMatchAll first and search for MatchAll instead.
+func IsMatchAll(input string) bool {
+	s := strings.TrimSpace(input)
+	return s == "" || s == "*"
+}
+
 type parser struct {
 	tokens []Token
 	pos    int
+	loc    *time.Location
 }
 
 func (p *parser) parseExpr() (Expr, error) {
@@ -72,7 +91,7 @@ func (p *parser) parseNot() (Expr, error) {
 		if p.match(TokIdent) {
 			fieldName := p.current().Value
 			next := p.peek(1)
-			isFielded := next.Kind == TokColon || next.Kind == TokGt || next.Kind == TokGte || next.Kind == TokLt || next.Kind == TokLte
+			isFielded := next.Kind == TokColon || next.Kind == TokTilde || next.Kind == TokGt || next.Kind == TokGte || next.Kind == TokLt || next.Kind == TokLte || next.Kind == TokNe
 			if !isFielded {
 				p.advance() // consume ident
 				return Pred{Predicate: Bool{Field: fieldName, Value: false}}, nil
@@ -101,6 +120,9 @@ func (p *parser) parsePrimary() (Expr, error) {
 			return nil, fmt.Errorf("expected ')', got %v", p.current())
 		}
 		p.advance()
+		if p.match(TokCaret) {
+			return nil, fmt.Errorf("'^' boosts a single predicate, not a group")
+		}
 		return expr, nil
 	}
 
@@ -109,9 +131,48 @@ func (p *parser) parsePrimary() (Expr, error) {
 	if err != nil {
 		return nil, err
 	}
+	if p.match(TokCaret) {
+		if pred, err = p.parseBoost(pred); err != nil {
+			return nil, err
+		}
+	}
 	return Pred{Predicate: pred}, nil
 }
 
+// parseBoost parses ^N after a predicate and sets it as the boost of a text
+// predicate. Other predicates do not score, so they take no boost.
+func (p *parser) parseBoost(pred Predicate) (Predicate, error) {
+	p.advance() // consume '^'
+	if !p.match(TokNumber) {
+		return nil, fmt.Errorf("expected number after '^', got %v", p.current())
+	}
+	boost := p.current().Num
+	p.advance()
+	if boost <= 0 {
+		return nil, fmt.Errorf("boost must be positive, got %v", boost)
+	}
+
+	switch t := pred.(type) {
+	case Text:
+		t.Boost = boost
+		return t, nil
+	case Keyword:
+		t.Boost = boost
+		return t, nil
+	case MultiFieldText:
+		t.Boost = boost
+		return t, nil
+	case TextAny:
+		t.Boost = boost
+		return t, nil
+	case NearText:
+		t.Boost = boost
+		return t, nil
+	default:
+		return pred, nil
+	}
+}
+
 func (p *parser) parsePredicate() (Predicate, error) {
 	// A predicate starts with either an Ident or a String (quoted)
 	var first string
@@ -125,8 +186,20 @@ func (p *parser) parsePredicate() (Predicate, error) {
 	default:
 		return nil, fmt.Errorf("expected term, got %v", p.current())
 	}
+	firstKind := p.current().Kind
 	p.advance()
 
+	// *:value matches a keyword value in any keyword field
+	if first == "*" && firstKind == TokIdent && p.match(TokColon) {
+		p.advance()
+		return p.parseAnyKeyword()
+	}
+
+	// fields(f1,f2):term
+	if first == "fields" && p.match(TokLParen) {
+		return p.parseMultiFieldText()
+	}
+
 	// field:value or has:value
 	if p.match(TokColon) {
 		p.advance()
@@ -141,8 +214,18 @@ func (p *parser) parsePredicate() (Predicate, error) {
 		return p.parseFieldPredicate(first)
 	}
 
-	// field comparisons: priority>5, due<2024-01-01, created<7d
-	if p.match(TokGt) || p.match(TokGte) || p.match(TokLt) || p.match(TokLte) {
+	// fuzzy keyword: tags~rust
+	if p.match(TokTilde) {
+		p.advance()
+		term, err := p.expectStringOrIdent()
+		if err != nil {
+			return nil, err
+		}
+		return FuzzyKeyword{Field: first, Term: term, MaxDistance: FuzzyMaxDistance(term)}, nil
+	}
+
+	// field comparisons: priority>5, due<2024-01-01, created<7d, priority!=5
+	if p.match(TokGt) || p.match(TokGte) || p.match(TokLt) || p.match(TokLte) || p.match(TokNe) {
 		return p.parseComparison(first)
 	}
 
@@ -154,6 +237,60 @@ func (p *parser) parsePredicate() (Predicate, error) {
 	return Text{Field: nil, FTS: first}, nil
 }
 
+func (p *parser) parseMultiFieldText() (Predicate, error) {
+	p.advance() // consume '('
+
+	var fields []string
+	for {
+		f, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		if p.match(TokComma) {
+			p.advance()
+			continue
+		}
+		break
+	}
+
+	if !p.match(TokRParen) {
+		return nil, fmt.Errorf("expected ')' after field list, got %v", p.current())
+	}
+	p.advance()
+
+	if !p.match(TokColon) {
+		return nil, fmt.Errorf("expected ':' after fields(...), got %v", p.current())
+	}
+	p.advance()
+
+	term, err := p.expectStringOrIdent()
+	if err != nil {
+		return nil, err
+	}
+	return MultiFieldText{Fields: fields, FTS: term}, nil
+}
+
+// parseAnyKeyword parses the value after "*:". Numbers keep their literal
+// text, since keyword values are strings.
+func (p *parser) parseAnyKeyword() (Predicate, error) {
+	tok := p.current()
+	switch tok.Kind {
+	case TokString, TokIdent, TokNumber:
+	default:
+		return nil, fmt.Errorf("expected value after '*:'")
+	}
+	p.advance()
+	pattern := tok.Value
+	if tok.Pattern != "" {
+		pattern = tok.Pattern
+	}
+	if tok.Kind == TokIdent && classifyKeywordPattern(pattern) != KeywordExact {
+		return nil, fmt.Errorf("*:%s: wildcards are not supported across keyword fields", pattern)
+	}
+	return AnyKeyword{Value: tok.Value}, nil
+}
+
 func (p *parser) parseFieldPredicate(field string) (Predicate, error) {
 	// Special handling for path field
 	if field == "path" {
@@ -161,15 +298,69 @@ func (p *parser) parseFieldPredicate(field string) (Predicate, error) {
 		if err != nil {
 			return nil, err
 		}
+		if !strings.ContainsAny(pattern, "*?") {
+			return PathExact{Path: pattern}, nil
+		}
 		return PathGlob{Pattern: pattern}, nil
 	}
 
+	// Set membership: field:in(a,b,"c,d"), field:any(a,b), field:all(a,b)
+	if p.match(TokIdent) && p.peek(1).Kind == TokLParen {
+		switch name := p.current().Value; name {
+		case "in", "any":
+			values, err := p.parseValueSet(field, name)
+			if err != nil {
+				return nil, err
+			}
+			return InSet{Field: field, Values: values}, nil
+		case "all":
+			values, err := p.parseValueSet(field, name)
+			if err != nil {
+				return nil, err
+			}
+			return AllSet{Field: field, Values: values}, nil
+		}
+	}
+
+	// Proximity: field:near(a,b,5)
+	if p.match(TokIdent) && p.current().Value == "near" && p.peek(1).Kind == TokLParen {
+		return p.parseNear(field)
+	}
+
+	// Raw pattern: field:like("abc%def")
+	if p.match(TokIdent) && p.current().Value == "like" && p.peek(1).Kind == TokLParen {
+		return p.parseLike(field)
+	}
+
+	// Geo radius: field:within(lat,lon,radiusKm)
+	if p.match(TokIdent) && p.current().Value == "within" && p.peek(1).Kind == TokLParen {
+		return p.parseWithin(field)
+	}
+
+	// Term group: field:(a OR b). Told apart from field:(lo,hi] by what
+	// follows the first value.
+	if p.match(TokLParen) && (p.peek(2).Kind == TokOr || p.peek(2).Kind == TokRParen) {
+		return p.parseTermGroup(field)
+	}
+
+	// Bracketed ranges: field:[lo,hi), field:(lo,hi], ...
+	if p.match(TokLBracket) || p.match(TokLParen) {
+		return p.parseBracketRange(field)
+	}
+
 	// Get value
 	switch p.current().Kind {
 	case TokString, TokIdent:
-		value := p.current().Value
+		tok := p.current()
+		value := tok.Value
 		p.advance()
 
+		// field:* is an existence check, the same as has:field; field:\* is
+		// the value "*"
+		if tok.Kind == TokIdent && value == "*" && tok.Pattern == "" {
+			return Has{Field: field}, nil
+		}
+
 		// Support date ranges: field:2024-01-01..2024-06-30
 		if p.match(TokDotDot) {
 			p.advance()
@@ -177,20 +368,29 @@ func (p *parser) parseFieldPredicate(field string) (Predicate, error) {
 			if err != nil {
 				return nil, err
 			}
-			loMS, err := parseDateToEpochMS(value)
+			loMS, err := parseDateToEpochMS(value, p.loc)
 			if err != nil {
 				return nil, err
 			}
-			hiMS, err := parseDateToEpochMS(hiStr)
+			hiMS, err := parseDateToEpochMS(hiStr, p.loc)
 			if err != nil {
 				return nil, err
 			}
-			return DateRangeAbs{Field: field, LoMS: loMS, HiMS: hiMS}, nil
+			return DateRangeAbs{Field: field, LoMS: loMS, HiMS: hiMS, LoInclusive: true, HiInclusive: true}, nil
 		}
 
-		// Classify as keyword pattern (planner will reinterpret based on schema type)
-		kind := classifyKeywordPattern(value)
-		return Keyword{Field: field, Pattern: value, Kind: kind}, nil
+		// Classify as keyword pattern (planner will reinterpret based on schema
+		// type). Wildcard patterns keep their escapes; an exact one is the
+		// plain value.
+		pattern := value
+		if tok.Pattern != "" {
+			pattern = tok.Pattern
+		}
+		kind := classifyKeywordPattern(pattern)
+		if kind == KeywordExact {
+			pattern = value
+		}
+		return Keyword{Field: field, Pattern: pattern, Kind: kind, Quoted: tok.Kind == TokString}, nil
 
 	case TokNumber:
 		val := p.current().Num
@@ -203,7 +403,7 @@ func (p *parser) parseFieldPredicate(field string) (Predicate, error) {
 			if err != nil {
 				return nil, err
 			}
-			return NumberRange{Field: field, Lo: val, Hi: hi}, nil
+			return NumberRange{Field: field, Lo: val, Hi: hi, LoInclusive: true, HiInclusive: true}, nil
 		}
 
 		// Single number as equality check
@@ -214,6 +414,203 @@ func (p *parser) parseFieldPredicate(field string) (Predicate, error) {
 	}
 }
 
+// parseTermGroup parses (t1 OR t2 ...) after "field:"
+func (p *parser) parseTermGroup(field string) (Predicate, error) {
+	p.advance() // consume (
+
+	var terms []string
+	for {
+		switch p.current().Kind {
+		case TokIdent, TokString, TokNumber:
+			terms = append(terms, p.current().Value)
+			p.advance()
+		default:
+			return nil, fmt.Errorf("expected term in %s:(...), got %v", field, p.current())
+		}
+		if !p.match(TokOr) {
+			break
+		}
+		p.advance()
+	}
+
+	if !p.match(TokRParen) {
+		return nil, fmt.Errorf("expected OR or ')' in %s:(...), got %v", field, p.current())
+	}
+	p.advance()
+	return TextAny{Field: field, Terms: terms}, nil
+}
+
+// parseValueSet parses name(v1, v2, ...) after "field:", for name in, any or
+// all. Values may be idents, numbers or quoted strings; quoted strings may
+// contain commas.
+func (p *parser) parseValueSet(field, name string) ([]string, error) {
+	p.advance() // consume name
+	p.advance() // consume (
+
+	var values []string
+	for !p.match(TokRParen) {
+		switch p.current().Kind {
+		case TokIdent, TokString, TokNumber:
+			values = append(values, p.current().Value)
+			p.advance()
+		default:
+			return nil, fmt.Errorf("expected value in %s:%s(...), got %v", field, name, p.current())
+		}
+		if p.match(TokComma) {
+			p.advance()
+			if p.match(TokRParen) {
+				return nil, fmt.Errorf("trailing ',' in %s:%s(...)", field, name)
+			}
+			continue
+		}
+		if !p.match(TokRParen) {
+			return nil, fmt.Errorf("expected ',' or ')' in %s:%s(...), got %v", field, name, p.current())
+		}
+	}
+	p.advance() // consume )
+
+	return values, nil
+}
+
+// parseNear parses near(t1, t2, distance) after "field:". The last value is
+// the distance and must be an integer; Normalize checks the rest.
+func (p *parser) parseNear(field string) (Predicate, error) {
+	p.advance() // consume "near"
+	p.advance() // consume (
+
+	var values []Token
+	for {
+		switch p.current().Kind {
+		case TokIdent, TokString, TokNumber:
+			values = append(values, p.current())
+			p.advance()
+		default:
+			return nil, fmt.Errorf("expected value in %s:near(...), got %v", field, p.current())
+		}
+		if !p.match(TokComma) {
+			break
+		}
+		p.advance()
+	}
+	if !p.match(TokRParen) {
+		return nil, fmt.Errorf("expected ',' or ')' in %s:near(...), got %v", field, p.current())
+	}
+	p.advance()
+
+	if len(values) < 2 {
+		return nil, fmt.Errorf("%s:near(...) takes two terms and a distance", field)
+	}
+	last := values[len(values)-1]
+	if last.Kind != TokNumber || last.Num != float64(int(last.Num)) {
+		return nil, fmt.Errorf("%s:near(...) distance must be an integer, got %q", field, last.Value)
+	}
+	terms := make([]string, 0, len(values)-1)
+	for _, v := range values[:len(values)-1] {
+		terms = append(terms, v.Value)
+	}
+	return NearText{Field: field, Terms: terms, Distance: int(last.Num)}, nil
+}
+
+// parseLike parses like(pattern) after "field:". Quote patterns holding
+// anything but identifier characters; Normalize checks the field.
+func (p *parser) parseLike(field string) (Predicate, error) {
+	p.advance() // consume "like"
+	p.advance() // consume (
+
+	tok := p.current()
+	if tok.Kind != TokString && tok.Kind != TokIdent && tok.Kind != TokNumber {
+		return nil, fmt.Errorf("expected pattern in %s:like(...), got %v", field, tok)
+	}
+	p.advance()
+	if !p.match(TokRParen) {
+		return nil, fmt.Errorf("expected ')' in %s:like(...), got %v", field, p.current())
+	}
+	p.advance()
+	return LikePattern{Field: field, Pattern: tok.Value}, nil
+}
+
+// parseWithin parses within(lat, lon, radiusKm) after "field:". All three
+// values must be numbers; Normalize checks their ranges.
+func (p *parser) parseWithin(field string) (Predicate, error) {
+	p.advance() // consume "within"
+	p.advance() // consume (
+
+	var nums []float64
+	for {
+		if !p.match(TokNumber) {
+			return nil, fmt.Errorf("expected number in %s:within(...), got %v", field, p.current())
+		}
+		nums = append(nums, p.current().Num)
+		p.advance()
+		if !p.match(TokComma) {
+			break
+		}
+		p.advance()
+	}
+	if !p.match(TokRParen) {
+		return nil, fmt.Errorf("expected ',' or ')' in %s:within(...), got %v", field, p.current())
+	}
+	p.advance()
+
+	if len(nums) != 3 {
+		return nil, fmt.Errorf("%s:within(...) takes a latitude, a longitude and a radius in km, got %d values", field, len(nums))
+	}
+	return GeoWithin{Field: field, Lat: nums[0], Lon: nums[1], RadiusKm: nums[2]}, nil
+}
+
+// parseBracketRange parses [lo,hi], [lo,hi), (lo,hi] or (lo,hi). Square
+// brackets include the bound, parentheses exclude it. Bounds are either both
+// numbers or both dates.
+func (p *parser) parseBracketRange(field string) (Predicate, error) {
+	loInclusive := p.match(TokLBracket)
+	p.advance()
+
+	lo := p.current()
+	if lo.Kind != TokNumber && lo.Kind != TokIdent && lo.Kind != TokString {
+		return nil, fmt.Errorf("expected lower bound in range for '%s', got %v", field, lo)
+	}
+	p.advance()
+
+	if !p.match(TokComma) {
+		return nil, fmt.Errorf("expected ',' in range for '%s', got %v", field, p.current())
+	}
+	p.advance()
+
+	hi := p.current()
+	if hi.Kind != TokNumber && hi.Kind != TokIdent && hi.Kind != TokString {
+		return nil, fmt.Errorf("expected upper bound in range for '%s', got %v", field, hi)
+	}
+	p.advance()
+
+	var hiInclusive bool
+	switch p.current().Kind {
+	case TokRBracket:
+		hiInclusive = true
+	case TokRParen:
+		hiInclusive = false
+	default:
+		return nil, fmt.Errorf("expected ']' or ')' to close range for '%s', got %v", field, p.current())
+	}
+	p.advance()
+
+	if lo.Kind == TokNumber && hi.Kind == TokNumber {
+		return NumberRange{Field: field, Lo: lo.Num, Hi: hi.Num, LoInclusive: loInclusive, HiInclusive: hiInclusive}, nil
+	}
+	if lo.Kind == TokNumber || hi.Kind == TokNumber {
+		return nil, fmt.Errorf("range bounds for '%s' must both be numbers or both be dates", field)
+	}
+
+	loMS, err := parseDateToEpochMS(lo.Value, p.loc)
+	if err != nil {
+		return nil, err
+	}
+	hiMS, err := parseDateToEpochMS(hi.Value, p.loc)
+	if err != nil {
+		return nil, err
+	}
+	return DateRangeAbs{Field: field, LoMS: loMS, HiMS: hiMS, LoInclusive: loInclusive, HiInclusive: hiInclusive}, nil
+}
+
 func (p *parser) parseComparison(field string) (Predicate, error) {
 	var op CmpOp
 	switch p.current().Kind {
@@ -225,6 +622,8 @@ func (p *parser) parseComparison(field string) (Predicate, error) {
 		op = CmpLt
 	case TokLte:
 		op = CmpLte
+	case TokNe:
+		op = CmpNe
 	default:
 		return nil, fmt.Errorf("expected comparison operator")
 	}
@@ -248,7 +647,7 @@ func (p *parser) parseComparison(field string) (Predicate, error) {
 		}
 
 		// Otherwise parse as absolute date/datetime
-		epochMS, err := parseDateToEpochMS(s)
+		epochMS, err := parseDateToEpochMS(s, p.loc)
 		if err != nil {
 			return nil, err
 		}
@@ -292,6 +691,15 @@ func (p *parser) expectStringOrIdent() (string, error) {
 	return "", fmt.Errorf("expected string or identifier, got %v", p.current())
 }
 
+func (p *parser) expectIdent() (string, error) {
+	if p.match(TokIdent) {
+		result := p.current().Value
+		p.advance()
+		return result, nil
+	}
+	return "", fmt.Errorf("expected identifier, got %v", p.current())
+}
+
 func (p *parser) expectNumber() (float64, error) {
 	if p.match(TokNumber) {
 		result := p.current().Num
@@ -301,24 +709,56 @@ func (p *parser) expectNumber() (float64, error) {
 	return 0, fmt.Errorf("expected number, got %v", p.current())
 }
 
+// classifyKeywordPattern classifies s by its unescaped wildcards; \* and \?
+// are literal
 func classifyKeywordPattern(s string) KeywordPatternKind {
-	if !strings.Contains(s, "*") && !strings.Contains(s, "?") {
-		return KeywordExact
+	var stars []int
+	question := false
+	n := 0 // runes of the unescaped value
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		switch rs[i] {
+		case '\\':
+			i++
+		case '*':
+			stars = append(stars, n)
+		case '?':
+			question = true
+		}
+		n++
 	}
-	if strings.Contains(s, "?") {
+	switch {
+	case len(stars) == 0 && !question:
+		return KeywordExact
+	case question:
 		return KeywordGlob
-	}
-	// only '*' remains
-	starCount := strings.Count(s, "*")
-	if strings.HasSuffix(s, "*") && !strings.HasPrefix(s, "*") && starCount == 1 {
+	case len(stars) == 1 && stars[0] == n-1 && n > 1:
 		return KeywordPrefix
-	}
-	if strings.HasPrefix(s, "*") && strings.HasSuffix(s, "*") && starCount == 2 {
+	case len(stars) == 2 && stars[0] == 0 && stars[1] == n-1:
 		return KeywordContains
 	}
 	return KeywordGlob
 }
 
+// UnescapePattern returns keyword pattern s with its backslash escapes
+// removed, the literal value of an exact pattern
+func UnescapePattern(s string) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+	var sb strings.Builder
+	escaped := false
+	for _, r := range s {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
 func parseRelativeDuration(s string) (int64, RelUnit, bool) {
 	if len(s) == 0 {
 		return 0, 0, false
@@ -362,9 +802,45 @@ func parseRelativeDuration(s string) (int64, RelUnit, bool) {
 	return amount, unit, true
 }
 
-func parseDateToEpochMS(s string) (int64, error) {
+// ParseCalendarWindow recognizes calendar window names (today, yesterday,
+// thisweek, thismonth) used as field:today on date fields
+func ParseCalendarWindow(s string) (CalendarWindow, bool) {
+	switch strings.ToLower(s) {
+	case "today":
+		return CalToday, true
+	case "yesterday":
+		return CalYesterday, true
+	case "thisweek":
+		return CalThisWeek, true
+	case "thismonth":
+		return CalThisMonth, true
+	default:
+		return 0, false
+	}
+}
+
+// ParsePartialDate recognizes a year (2024) or a month (2024-03) used as
+// field:2024 on date fields, returning the half-open range [lo, hi) in epoch
+// milliseconds that covers it in loc
+func ParsePartialDate(s string, loc *time.Location) (loMS, hiMS int64, ok bool) {
+	layout, years, months := "2006", 1, 0
+	switch len(s) {
+	case 4:
+	case 7:
+		layout, years, months = "2006-01", 0, 1
+	default:
+		return 0, 0, false
+	}
+	lo, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lo.UnixMilli(), lo.AddDate(years, months, 0).UnixMilli(), true
+}
+
+func parseDateToEpochMS(s string, loc *time.Location) (int64, error) {
 	// Try YYYY-MM-DD
-	if t, err := time.Parse("2006-01-02", s); err == nil {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
 		return t.UnixMilli(), nil
 	}
 	// Try RFC3339
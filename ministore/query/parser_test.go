@@ -1,6 +1,7 @@
 package query
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -137,6 +138,73 @@ func TestParseHasPredicate(t *testing.T) {
 	}
 }
 
+func TestParseFieldWildcardIsHas(t *testing.T) {
+	expr, err := Parse("tags:*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pred, ok := expr.(Pred)
+	if !ok {
+		t.Fatalf("expected Pred, got %T", expr)
+	}
+	hasPred, ok := pred.Predicate.(Has)
+	if !ok {
+		t.Fatalf("expected Has, got %T", pred.Predicate)
+	}
+	if hasPred.Field != "tags" {
+		t.Errorf("expected has:tags, got has:%s", hasPred.Field)
+	}
+
+	expr, err = Parse("!tags:*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	not, ok := expr.(Not)
+	if !ok {
+		t.Fatalf("expected Not, got %T", expr)
+	}
+	if _, ok := not.Inner.(Pred).Predicate.(Has); !ok {
+		t.Fatalf("expected Not(Has), got Not(%T)", not.Inner.(Pred).Predicate)
+	}
+
+	// A quoted "*" stays a literal keyword value
+	expr, err = Parse(`tags:"*"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := expr.(Pred).Predicate.(Keyword); !ok {
+		t.Errorf("expected Keyword for quoted *, got %T", expr.(Pred).Predicate)
+	}
+}
+
+func TestParseNotEqual(t *testing.T) {
+	expr, err := Parse("priority!=5 AND due != 2024-01-01")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	and, ok := expr.(And)
+	if !ok {
+		t.Fatalf("expected And, got %T", expr)
+	}
+	numCmp, ok := and.Left.(Pred).Predicate.(NumberCmp)
+	if !ok || numCmp.Field != "priority" || numCmp.Op != CmpNe || numCmp.Value != 5 {
+		t.Errorf("expected priority!=5, got %#v", and.Left)
+	}
+	dateCmp, ok := and.Right.(Pred).Predicate.(DateCmpAbs)
+	if !ok || dateCmp.Field != "due" || dateCmp.Op != CmpNe {
+		t.Errorf("expected due!=2024-01-01, got %#v", and.Right)
+	}
+
+	// A lone ! is still negation
+	expr, err = Parse("!archived")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, ok := expr.(Pred).Predicate.(Bool); !ok || b.Value {
+		t.Errorf("expected archived:false, got %#v", expr)
+	}
+}
+
 func TestParsePathGlob(t *testing.T) {
 	expr, err := Parse("path:/docs/*")
 	if err != nil {
@@ -155,6 +223,48 @@ func TestParsePathGlob(t *testing.T) {
 	}
 }
 
+func TestParsePathExact(t *testing.T) {
+	expr, err := Parse("path:/docs/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pred, ok := expr.(Pred)
+	if !ok {
+		t.Fatalf("expected Pred, got %T", expr)
+	}
+	exact, ok := pred.Predicate.(PathExact)
+	if !ok {
+		t.Fatalf("expected PathExact, got %T", pred.Predicate)
+	}
+	if exact.Path != "/docs/a" {
+		t.Errorf("expected /docs/a, got %s", exact.Path)
+	}
+
+	expr, err = Parse("path:/docs/a?")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := expr.(Pred).Predicate.(PathGlob); !ok {
+		t.Errorf("expected PathGlob for a pattern with ?, got %T", expr.(Pred).Predicate)
+	}
+}
+
+func TestIsMatchAll(t *testing.T) {
+	for input, want := range map[string]bool{
+		"":        true,
+		"  ":      true,
+		"*":       true,
+		" * ":     true,
+		"**":      false,
+		"* AND a": false,
+		"tags:*":  false,
+	} {
+		if got := IsMatchAll(input); got != want {
+			t.Errorf("IsMatchAll(%q) = %v, want %v", input, got, want)
+		}
+	}
+}
+
 func TestParseKeywordWildcard(t *testing.T) {
 	expr, err := Parse("tags:test*")
 	if err != nil {
@@ -173,6 +283,35 @@ func TestParseKeywordWildcard(t *testing.T) {
 	}
 }
 
+func TestParseKeywordEscapedWildcard(t *testing.T) {
+	for input, want := range map[string]Keyword{
+		`tags:c\*`:    {Field: "tags", Pattern: "c*", Kind: KeywordExact},
+		`tags:\*`:     {Field: "tags", Pattern: "*", Kind: KeywordExact},
+		`tags:"c\*"`:  {Field: "tags", Pattern: "c*", Kind: KeywordExact, Quoted: true},
+		`tags:c\?`:    {Field: "tags", Pattern: "c?", Kind: KeywordExact},
+		`tags:c\**`:   {Field: "tags", Pattern: `c\**`, Kind: KeywordPrefix},
+		`tags:*c\**`:  {Field: "tags", Pattern: `*c\**`, Kind: KeywordContains},
+		`tags:a\*b?`:  {Field: "tags", Pattern: `a\*b?`, Kind: KeywordGlob},
+		`tags:"a\\*"`: {Field: "tags", Pattern: `a\\*`, Kind: KeywordPrefix, Quoted: true},
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", input, err)
+		}
+		kw, ok := expr.(Pred).Predicate.(Keyword)
+		if !ok {
+			t.Fatalf("Parse(%s): expected Keyword, got %T", input, expr.(Pred).Predicate)
+		}
+		if kw != want {
+			t.Errorf("Parse(%s) = %+v, want %+v", input, kw, want)
+		}
+	}
+
+	if got := UnescapePattern(`a\*b\\c`); got != `a*b\c` {
+		t.Errorf("UnescapePattern = %q", got)
+	}
+}
+
 func TestParseBareText(t *testing.T) {
 	expr, err := Parse("hello")
 	if err != nil {
@@ -231,3 +370,324 @@ func TestParseComplexQuery(t *testing.T) {
 		t.Fatalf("expected right to be Or, got %T", andExpr.Right)
 	}
 }
+
+func TestParseMultiFieldText(t *testing.T) {
+	expr, err := Parse("fields(title,summary):rust")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pred, ok := expr.(Pred)
+	if !ok {
+		t.Fatalf("expected Pred, got %T", expr)
+	}
+	mft, ok := pred.Predicate.(MultiFieldText)
+	if !ok {
+		t.Fatalf("expected MultiFieldText, got %T", pred.Predicate)
+	}
+	if len(mft.Fields) != 2 || mft.Fields[0] != "title" || mft.Fields[1] != "summary" {
+		t.Errorf("unexpected fields: %v", mft.Fields)
+	}
+	if mft.FTS != "rust" {
+		t.Errorf("expected FTS 'rust', got %q", mft.FTS)
+	}
+}
+
+func TestParseMultiFieldTextRequiresColon(t *testing.T) {
+	if _, err := Parse("fields(title,summary) rust"); err == nil {
+		t.Fatalf("expected error for fields(...) without ':'")
+	}
+}
+
+func TestParseFuzzyKeyword(t *testing.T) {
+	expr, err := Parse("tags~rusty")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pred, ok := expr.(Pred)
+	if !ok {
+		t.Fatalf("expected Pred, got %T", expr)
+	}
+	fk, ok := pred.Predicate.(FuzzyKeyword)
+	if !ok {
+		t.Fatalf("expected FuzzyKeyword, got %T", pred.Predicate)
+	}
+	if fk.Field != "tags" || fk.Term != "rusty" {
+		t.Errorf("unexpected fuzzy predicate: %+v", fk)
+	}
+	if fk.MaxDistance != 1 {
+		t.Errorf("expected max distance 1, got %d", fk.MaxDistance)
+	}
+}
+
+func TestParseAnyKeyword(t *testing.T) {
+	for input, want := range map[string]string{
+		"*:urgent":    "urgent",
+		`*:"on hold"`: "on hold",
+		"*:42":        "42",
+		`*:"*"`:       "*",
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", input, err)
+		}
+		ak, ok := expr.(Pred).Predicate.(AnyKeyword)
+		if !ok {
+			t.Fatalf("Parse(%s): expected AnyKeyword, got %T", input, expr.(Pred).Predicate)
+		}
+		if ak.Value != want {
+			t.Errorf("Parse(%s) = %q, want %q", input, ak.Value, want)
+		}
+		if _, err := Normalize(expr, DefaultNormalizeOptions()); err != nil {
+			t.Errorf("Normalize(%s): %v", input, err)
+		}
+	}
+
+	for _, input := range []string{"*:urg*", "*:", "*:*"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%s): expected error", input)
+		}
+	}
+}
+
+func TestParseTermGroup(t *testing.T) {
+	expr, err := Parse(`title:(rust OR golang | "error handling")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pred, ok := expr.(Pred)
+	if !ok {
+		t.Fatalf("expected Pred, got %T", expr)
+	}
+	ta, ok := pred.Predicate.(TextAny)
+	if !ok {
+		t.Fatalf("expected TextAny, got %T", pred.Predicate)
+	}
+	if ta.Field != "title" || len(ta.Terms) != 3 || ta.Terms[0] != "rust" || ta.Terms[1] != "golang" || ta.Terms[2] != "error handling" {
+		t.Errorf("unexpected term group: %+v", ta)
+	}
+
+	// (lo,hi] is still a range
+	expr, err = Parse("priority:(1,10]")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := expr.(Pred).Predicate.(NumberRange); !ok {
+		t.Errorf("expected NumberRange, got %T", expr.(Pred).Predicate)
+	}
+
+	for _, bad := range []string{"title:(rust OR)", "title:(rust golang)", "title:(rust AND golang)"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%s: expected parse error", bad)
+		}
+	}
+}
+
+func TestParseBracketRanges(t *testing.T) {
+	tests := []struct {
+		input  string
+		loIncl bool
+		hiIncl bool
+	}{
+		{"priority:1..10", true, true},
+		{"priority:[1,10]", true, true},
+		{"priority:[1,10)", true, false},
+		{"priority:(1,10]", false, true},
+		{"priority:(1,10)", false, false},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		nr, ok := expr.(Pred).Predicate.(NumberRange)
+		if !ok {
+			t.Fatalf("%s: expected NumberRange, got %T", tt.input, expr.(Pred).Predicate)
+		}
+		if nr.Lo != 1 || nr.Hi != 10 || nr.LoInclusive != tt.loIncl || nr.HiInclusive != tt.hiIncl {
+			t.Errorf("%s: got %+v", tt.input, nr)
+		}
+	}
+}
+
+func TestParseBracketDateRange(t *testing.T) {
+	expr, err := Parse("created:[2024-01-01,2024-02-01)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dr, ok := expr.(Pred).Predicate.(DateRangeAbs)
+	if !ok {
+		t.Fatalf("expected DateRangeAbs, got %T", expr.(Pred).Predicate)
+	}
+	if dr.Field != "created" || !dr.LoInclusive || dr.HiInclusive {
+		t.Errorf("unexpected range: %+v", dr)
+	}
+	if dr.HiMS-dr.LoMS != 31*24*60*60*1000 {
+		t.Errorf("unexpected bounds: %d..%d", dr.LoMS, dr.HiMS)
+	}
+
+	if _, err := Parse("priority:[1,2024-01-01)"); err == nil {
+		t.Fatalf("expected error for mixed number/date bounds")
+	}
+	if _, err := Parse("priority:[1,10"); err == nil {
+		t.Fatalf("expected error for unclosed range")
+	}
+}
+
+func TestParseInSet(t *testing.T) {
+	expr, err := Parse(`tags:in(rust, go, "a,b")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	set, ok := expr.(Pred).Predicate.(InSet)
+	if !ok {
+		t.Fatalf("expected InSet, got %T", expr.(Pred).Predicate)
+	}
+	if set.Field != "tags" || len(set.Values) != 3 || set.Values[0] != "rust" || set.Values[1] != "go" || set.Values[2] != "a,b" {
+		t.Errorf("unexpected set: %+v", set)
+	}
+
+	// A bare "in" value is still a plain keyword
+	expr, err = Parse("tags:in")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := expr.(Pred).Predicate.(Keyword); !ok {
+		t.Fatalf("expected Keyword, got %T", expr.(Pred).Predicate)
+	}
+}
+
+func TestParseAnyAllSet(t *testing.T) {
+	expr, err := Parse(`tags:any(rust, go)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if set, ok := expr.(Pred).Predicate.(AnySet); !ok || len(set.Values) != 2 {
+		t.Errorf("expected AnySet of 2, got %#v", expr.(Pred).Predicate)
+	}
+
+	expr, err = Parse(`tags:all(rust, "a,b")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	set, ok := expr.(Pred).Predicate.(AllSet)
+	if !ok {
+		t.Fatalf("expected AllSet, got %T", expr.(Pred).Predicate)
+	}
+	if set.Field != "tags" || len(set.Values) != 2 || set.Values[0] != "rust" || set.Values[1] != "a,b" {
+		t.Errorf("unexpected set: %+v", set)
+	}
+
+	if _, err := Parse("tags:all(rust,)"); err == nil || !strings.Contains(err.Error(), "tags:all(...)") {
+		t.Errorf("expected trailing comma error naming all(...), got %v", err)
+	}
+}
+
+func TestParseNear(t *testing.T) {
+	expr, err := Parse(`content:near(error, "time out", 5)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	near, ok := expr.(Pred).Predicate.(NearText)
+	if !ok {
+		t.Fatalf("expected NearText, got %T", expr.(Pred).Predicate)
+	}
+	if near.Field != "content" || len(near.Terms) != 2 || near.Terms[0] != "error" || near.Terms[1] != "time out" || near.Distance != 5 {
+		t.Errorf("unexpected near: %+v", near)
+	}
+
+	for _, q := range []string{"content:near(error,timeout)", "content:near(error,timeout,2.5)", "content:near(5)"} {
+		if _, err := Parse(q); err == nil {
+			t.Errorf("Parse(%q): expected error", q)
+		}
+	}
+}
+
+func TestParseWithin(t *testing.T) {
+	expr, err := Parse("location:within(-33.87, 151.21, 2.5)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	geo, ok := expr.(Pred).Predicate.(GeoWithin)
+	if !ok {
+		t.Fatalf("expected GeoWithin, got %T", expr.(Pred).Predicate)
+	}
+	if geo.Field != "location" || geo.Lat != -33.87 || geo.Lon != 151.21 || geo.RadiusKm != 2.5 {
+		t.Errorf("unexpected within: %+v", geo)
+	}
+
+	for _, q := range []string{"location:within(1,2)", "location:within(1,2,3,4)", "location:within(a,2,3)", "location:within(1,2,3"} {
+		if _, err := Parse(q); err == nil {
+			t.Errorf("Parse(%q): expected error", q)
+		}
+	}
+}
+
+func TestParseLike(t *testing.T) {
+	expr, err := Parse(`sku:like("INV-%_2024")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	like, ok := expr.(Pred).Predicate.(LikePattern)
+	if !ok {
+		t.Fatalf("expected LikePattern, got %T", expr.(Pred).Predicate)
+	}
+	if like.Field != "sku" || like.Pattern != "INV-%_2024" {
+		t.Errorf("unexpected like: %+v", like)
+	}
+
+	for _, q := range []string{`sku:like()`, `sku:like("a","b")`, `sku:like("a"`} {
+		if _, err := Parse(q); err == nil {
+			t.Errorf("Parse(%q): expected error", q)
+		}
+	}
+}
+
+func TestParseBoost(t *testing.T) {
+	expr, err := Parse(`title:rust^2 OR body:rust^0.5`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	or, ok := expr.(Or)
+	if !ok {
+		t.Fatalf("expected Or, got %T", expr)
+	}
+	left := or.Left.(Pred).Predicate.(Keyword)
+	right := or.Right.(Pred).Predicate.(Keyword)
+	if left.Field != "title" || left.Pattern != "rust" || left.Boost != 2 {
+		t.Errorf("unexpected left: %+v", left)
+	}
+	if right.Field != "body" || right.Boost != 0.5 {
+		t.Errorf("unexpected right: %+v", right)
+	}
+
+	expr, err = Parse(`"rust lang"^3`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text := expr.(Pred).Predicate.(Text); text.FTS != "rust lang" || text.Boost != 3 {
+		t.Errorf("unexpected text: %+v", text)
+	}
+
+	expr, err = Parse(`title:(rust OR go)^2`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if any := expr.(Pred).Predicate.(TextAny); any.Boost != 2 {
+		t.Errorf("unexpected term group: %+v", any)
+	}
+
+	// Predicates that do not score take no boost
+	expr, err = Parse(`priority>3^2`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := expr.(Pred).Predicate.(NumberCmp); !ok {
+		t.Errorf("expected NumberCmp, got %T", expr.(Pred).Predicate)
+	}
+
+	for _, q := range []string{`rust^`, `rust^0`, `rust^-1`, `rust^x`, `(rust OR go)^2`} {
+		if _, err := Parse(q); err == nil {
+			t.Errorf("Parse(%q): expected error", q)
+		}
+	}
+}
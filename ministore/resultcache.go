@@ -0,0 +1,167 @@
+package ministore
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ministore/ministore/ministore/query"
+)
+
+// resultCache is an LRU of search pages. Every write bumps gen, which is
+// part of each key, so pages cached before the write are never returned
+// again; they age out of the LRU instead of being purged.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	ll      *list.List // front is most recently used
+	entries map[string]*list.Element
+
+	gen    atomic.Uint64
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key       string
+	page      SearchResultPage
+	expiresAt time.Time // zero means no expiry
+}
+
+// newIndexCache returns the cache configured by opts, or nil if disabled
+func newIndexCache(opts IndexOptions) *resultCache {
+	if opts.CacheSize <= 0 {
+		return nil
+	}
+	return newResultCache(opts.CacheSize, opts.CacheTTL)
+}
+
+func newResultCache(size int, ttl time.Duration) *resultCache {
+	return &resultCache{
+		size:    size,
+		ttl:     ttl,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// cacheKeyOpts is the part of the key taken from SearchOptions
+type cacheKeyOpts struct {
+	Rank           RankMode
+	Limit          int
+	After          string
+	CursorMode     CursorMode
+	Show           OutputFieldSelector
+	Explain        bool
+	ExplainFormat  ExplainFormat
+	Highlight      *HighlightSpec
+	MatchPositions bool
+	IncludeDeleted bool
+	MinScore       *float64
+	Snapshot       bool
+	DistinctBy     string
+	SuggestOnEmpty bool
+	EstimateTotal  bool
+}
+
+// key returns the cache key for a search, or false if the query does not
+// parse (the search itself reports the error). The query is keyed by its
+// parsed form, so spacing and redundant parentheses do not matter.
+func (c *resultCache) key(schema Schema, queryStr string, opts SearchOptions, loc *time.Location) (string, bool) {
+	expr, err := query.ParseInLocation(queryStr, loc)
+	if err != nil {
+		return "", false
+	}
+	schemaJSON, err := schema.ToJSON()
+	if err != nil {
+		return "", false
+	}
+	qh, err := hashQuery(schemaJSON, fmt.Sprintf("%#v", expr), opts.Rank)
+	if err != nil {
+		return "", false
+	}
+	rest, err := json.Marshal(cacheKeyOpts{
+		Rank:           opts.Rank,
+		Limit:          opts.Limit,
+		After:          opts.After,
+		CursorMode:     opts.CursorMode,
+		Show:           opts.Show,
+		Explain:        opts.Explain,
+		ExplainFormat:  opts.ExplainFormat,
+		Highlight:      opts.Highlight,
+		MatchPositions: opts.ReturnMatchPositions,
+		IncludeDeleted: opts.IncludeDeleted,
+		MinScore:       opts.MinScore,
+		Snapshot:       opts.Snapshot,
+		DistinctBy:     opts.DistinctBy,
+		SuggestOnEmpty: opts.SuggestOnEmpty,
+		EstimateTotal:  opts.EstimateTotal,
+	})
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%d|%s|%s", c.gen.Load(), qh, rest), true
+}
+
+func (c *resultCache) get(key string, now time.Time) (SearchResultPage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if ok {
+		e := el.Value.(*cacheEntry)
+		if e.expiresAt.IsZero() || now.Before(e.expiresAt) {
+			c.ll.MoveToFront(el)
+			c.hits.Add(1)
+			return e.page, true
+		}
+		c.ll.Remove(el)
+		delete(c.entries, key)
+	}
+	c.misses.Add(1)
+	return SearchResultPage{}, false
+}
+
+// put stores page under key. A page whose next cursor is a short handle
+// expires no later than the handle itself (maxTTL).
+func (c *resultCache) put(key string, page SearchResultPage, now time.Time, maxTTL time.Duration) {
+	ttl := c.ttl
+	if isShortCursor(page.NextCursor) && maxTTL > 0 && (ttl <= 0 || maxTTL < ttl) {
+		ttl = maxTTL
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = &cacheEntry{key: key, page: page, expiresAt: expiresAt}
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(&cacheEntry{key: key, page: page, expiresAt: expiresAt})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate makes every cached page unreachable
+func (c *resultCache) invalidate() {
+	c.gen.Add(1)
+}
+
+func (c *resultCache) stats() CacheStats {
+	c.mu.Lock()
+	n := c.ll.Len()
+	c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: n}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/ministore/ministore/ministore/storage"
 )
@@ -18,21 +19,65 @@ const (
 	FieldNumber  FieldType = "number"
 	FieldDate    FieldType = "date"
 	FieldBool    FieldType = "bool"
+	FieldGeo     FieldType = "geo" // lat/lon points
 )
 
 // FieldSpec defines a field's configuration
 type FieldSpec struct {
-	Type   FieldType `json:"type"`
-	Multi  bool      `json:"multi,omitempty"`
-	Weight *float64  `json:"weight,omitempty"` // text fields only
+	Type     FieldType `json:"type"`
+	Multi    bool      `json:"multi,omitempty"`
+	Weight   *float64  `json:"weight,omitempty"`    // text and full_text keyword fields only
+	CaseFold bool      `json:"case_fold,omitempty"` // keyword fields only
+	Required bool      `json:"required,omitempty"`  // documents must have a non-null value
+
+	// FullText also indexes a keyword field's values in the full-text
+	// index, as if it were a text field of the same name. A quoted query
+	// value (status:"open case") stays an exact keyword match; an unquoted
+	// one (status:open) is a full-text search of the field, and bare terms
+	// search it along with the text fields. Keyword fields only.
+	FullText bool `json:"full_text,omitempty"`
+
+	// Normalizer transforms keyword values before they are stored and query
+	// terms before they are matched, so "JavaScript" and "javascript" are
+	// one value. Keyword fields only.
+	Normalizer KeywordNormalizer `json:"normalizer,omitempty"`
+}
+
+// HasFTS reports whether the field has a full-text column: text fields and
+// keyword fields with FullText
+func (spec FieldSpec) HasFTS() bool {
+	return spec.Type == FieldText || (spec.Type == FieldKeyword && spec.FullText)
 }
 
+// KeywordNormalizer names a built-in transform for keyword values
+type KeywordNormalizer string
+
+const (
+	NormalizerNone      KeywordNormalizer = ""
+	NormalizerLowercase KeywordNormalizer = storage.NormalizerLowercase
+	NormalizerSlug      KeywordNormalizer = storage.NormalizerSlug // "Java Script!" -> "java-script"
+	NormalizerTrim      KeywordNormalizer = storage.NormalizerTrim
+)
+
 // Schema defines the structure of an index
 type Schema struct {
 	Fields map[string]FieldSpec `json:"fields"`
+	// FTSTokenizer is the SQLite FTS5 tokenizer for text fields, e.g.
+	// "porter unicode61 remove_diacritics 2". Empty means unicode61.
+	// Changing it requires MigrateRebuild. Postgres ignores it.
+	FTSTokenizer string `json:"fts_tokenizer,omitempty"`
+	// Strict rejects documents with fields not in the schema instead of
+	// skipping them
+	Strict bool `json:"strict,omitempty"`
+	// Aliases maps extra names that queries may use to schema field names,
+	// e.g. "priority" -> "priority_level". They apply to query predicates
+	// only; documents, rank, show and stats use the real names.
+	Aliases map[string]string `json:"aliases,omitempty"`
 }
 
-var validFieldNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+// Field names are identifiers, optionally joined by dots to address values
+// nested in the document (author.name)
+var validFieldNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
 
 var reservedFieldNames = map[string]bool{
 	"path":    true,
@@ -48,27 +93,81 @@ func (s Schema) Validate() error {
 
 	for name, spec := range s.Fields {
 		if !validFieldNameRe.MatchString(name) {
-			return SchemaError(fmt.Sprintf("invalid field name: %s (must match ^[A-Za-z_][A-Za-z0-9_]*$)", name))
+			return SchemaError(fmt.Sprintf("invalid field name: %s (must be identifiers matching ^[A-Za-z_][A-Za-z0-9_]*$, optionally joined by dots)", name))
 		}
 		if reservedFieldNames[name] {
 			return SchemaError(fmt.Sprintf("field name '%s' is reserved", name))
 		}
+		if strings.Contains(name, ".") {
+			root := name[:strings.Index(name, ".")]
+			if reservedFieldNames[root] {
+				return SchemaError(fmt.Sprintf("field name '%s' is nested under reserved field '%s'", name, root))
+			}
+			// Text field names double as FTS column names
+			if spec.Type == FieldText {
+				return SchemaError(fmt.Sprintf("field '%s': dotted paths are not supported for text fields", name))
+			}
+			if spec.FullText {
+				return SchemaError(fmt.Sprintf("field '%s': dotted paths are not supported for full_text fields", name))
+			}
+			for i := range name {
+				if name[i] == '.' && s.HasField(name[:i]) {
+					return SchemaError(fmt.Sprintf("field '%s' is nested under field '%s'", name, name[:i]))
+				}
+			}
+		}
 
 		switch spec.Type {
-		case FieldKeyword, FieldText, FieldNumber, FieldDate, FieldBool:
+		case FieldKeyword, FieldText, FieldNumber, FieldDate, FieldBool, FieldGeo:
 			// valid
 		default:
 			return SchemaError(fmt.Sprintf("unknown field type '%s' for field '%s'", spec.Type, name))
 		}
 
+		if spec.FullText && spec.Type != FieldKeyword {
+			return SchemaError(fmt.Sprintf("field '%s': full_text can only be specified for keyword fields", name))
+		}
+
 		if spec.Weight != nil {
-			if spec.Type != FieldText {
-				return SchemaError(fmt.Sprintf("field '%s': weight can only be specified for text fields", name))
+			if !spec.HasFTS() {
+				return SchemaError(fmt.Sprintf("field '%s': weight can only be specified for text and full_text keyword fields", name))
 			}
 			if *spec.Weight <= 0 {
 				return SchemaError(fmt.Sprintf("field '%s': weight must be positive", name))
 			}
 		}
+
+		if spec.CaseFold && spec.Type != FieldKeyword {
+			return SchemaError(fmt.Sprintf("field '%s': case_fold can only be specified for keyword fields", name))
+		}
+
+		if spec.Normalizer != NormalizerNone {
+			if spec.Type != FieldKeyword {
+				return SchemaError(fmt.Sprintf("field '%s': normalizer can only be specified for keyword fields", name))
+			}
+			if !storage.ValidKeywordNormalizer(string(spec.Normalizer)) {
+				return SchemaError(fmt.Sprintf("field '%s': unknown normalizer '%s' (want lowercase, slug or trim)", name, spec.Normalizer))
+			}
+		}
+	}
+
+	for alias, target := range s.Aliases {
+		if !validFieldNameRe.MatchString(alias) {
+			return SchemaError(fmt.Sprintf("invalid alias name: %s", alias))
+		}
+		if reservedFieldNames[alias] {
+			return SchemaError(fmt.Sprintf("alias '%s' is a reserved name", alias))
+		}
+		if s.HasField(alias) {
+			return SchemaError(fmt.Sprintf("alias '%s' collides with a field of the same name", alias))
+		}
+		if !s.HasField(target) {
+			return SchemaError(fmt.Sprintf("alias '%s' points at unknown field '%s'", alias, target))
+		}
+	}
+
+	if _, err := storage.NormalizeFTSTokenizer(s.FTSTokenizer); err != nil {
+		return SchemaError(err.Error())
 	}
 
 	return nil
@@ -97,11 +196,12 @@ type TextField struct {
 	Weight float64
 }
 
-// TextFieldsInOrder returns text fields sorted by name with their weights
+// TextFieldsInOrder returns the fields with a full-text column, text fields
+// and full_text keyword fields, sorted by name with their weights
 func (s Schema) TextFieldsInOrder() []TextField {
 	var fields []TextField
 	for name, spec := range s.Fields {
-		if spec.Type == FieldText {
+		if spec.HasFTS() {
 			weight := 1.0
 			if spec.Weight != nil {
 				weight = *spec.Weight
@@ -143,12 +243,173 @@ func (s *Schema) GetStorageFieldSpec(name string) (storage.FieldSpec, bool) {
 		return storage.FieldSpec{}, false
 	}
 	return storage.FieldSpec{
-		Type:   storage.FieldType(spec.Type),
-		Multi:  spec.Multi,
-		Weight: spec.Weight,
+		Type:       storage.FieldType(spec.Type),
+		Multi:      spec.Multi,
+		Weight:     spec.Weight,
+		CaseFold:   spec.CaseFold,
+		Required:   spec.Required,
+		Normalizer: string(spec.Normalizer),
+		FullText:   spec.FullText,
 	}, true
 }
 
+// RequiredFields returns the names of required fields, sorted
+func (s Schema) RequiredFields() []string {
+	var names []string
+	for name, spec := range s.Fields {
+		if spec.Required {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KeywordFields returns the names of keyword fields, sorted
+func (s Schema) KeywordFields() []string {
+	var names []string
+	for name, spec := range s.Fields {
+		if spec.Type == FieldKeyword {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SchemaDiff lists the field differences between two schemas. Names are
+// sorted.
+type SchemaDiff struct {
+	Added   []string
+	Removed []string
+	Changed []FieldChange
+}
+
+// FieldChange is a field present in both schemas with a different spec
+type FieldChange struct {
+	Field string
+	Old   FieldSpec
+	New   FieldSpec
+}
+
+// TypeChanged reports whether the field's type or multi flag changed. Such
+// changes alter how existing values are indexed and need MigrateRebuild.
+func (c FieldChange) TypeChanged() bool {
+	return c.Old.Type != c.New.Type || c.Old.Multi != c.New.Multi
+}
+
+// FullTextChanged reports whether the field's full_text flag changed, which
+// adds or drops its full-text column and needs MigrateRebuild
+func (c FieldChange) FullTextChanged() bool {
+	return c.Old.FullText != c.New.FullText
+}
+
+// NormalizerChanged reports whether the field's keyword normalizer changed.
+// Stored values keep the old form, so this also needs MigrateRebuild.
+func (c FieldChange) NormalizerChanged() bool {
+	return c.Old.Normalizer != c.New.Normalizer
+}
+
+// CaseFoldChanged reports whether the field's case_fold flag changed. Stored
+// values have no folded form to match against, so this needs MigrateRebuild.
+func (c FieldChange) CaseFoldChanged() bool {
+	return c.Old.CaseFold != c.New.CaseFold
+}
+
+// Empty reports whether the schemas have the same fields
+func (d SchemaDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff returns the changes that turn s into other
+func (s Schema) Diff(other Schema) SchemaDiff {
+	var d SchemaDiff
+	for name, spec := range other.Fields {
+		old, ok := s.Fields[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, name)
+		case !sameFieldSpec(old, spec):
+			d.Changed = append(d.Changed, FieldChange{Field: name, Old: old, New: spec})
+		}
+	}
+	for name := range s.Fields {
+		if _, ok := other.Fields[name]; !ok {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Field < d.Changed[j].Field })
+	return d
+}
+
+// clone returns a copy of s that shares no maps with it
+func (s Schema) clone() Schema {
+	out := s
+	out.Fields = make(map[string]FieldSpec, len(s.Fields)+1)
+	for name, spec := range s.Fields {
+		out.Fields[name] = spec
+	}
+	if s.Aliases != nil {
+		out.Aliases = make(map[string]string, len(s.Aliases))
+		for alias, field := range s.Aliases {
+			out.Aliases[alias] = field
+		}
+	}
+	return out
+}
+
+// WithField returns a copy of s with field name set to spec, replacing any
+// existing definition. s is not modified.
+func (s Schema) WithField(name string, spec FieldSpec) Schema {
+	out := s.clone()
+	out.Fields[name] = spec
+	return out
+}
+
+// Merge returns the union of s and other. A field or alias defined in both
+// must be defined the same way, and differing non-empty FTS tokenizers
+// conflict; Strict is set if either schema sets it. The merged schema is
+// validated.
+func (s Schema) Merge(other Schema) (Schema, error) {
+	out := s.clone()
+	for name, spec := range other.Fields {
+		if old, ok := out.Fields[name]; ok && !sameFieldSpec(old, spec) {
+			return Schema{}, &Error{Kind: ErrSchema, Field: name, Message: fmt.Sprintf("field '%s' is defined differently in the merged schemas", name)}
+		}
+		out.Fields[name] = spec
+	}
+	for alias, field := range other.Aliases {
+		if old, ok := out.Aliases[alias]; ok && old != field {
+			return Schema{}, SchemaError(fmt.Sprintf("alias '%s' names '%s' and '%s' in the merged schemas", alias, old, field))
+		}
+		if out.Aliases == nil {
+			out.Aliases = make(map[string]string, len(other.Aliases))
+		}
+		out.Aliases[alias] = field
+	}
+	switch {
+	case out.FTSTokenizer == "":
+		out.FTSTokenizer = other.FTSTokenizer
+	case other.FTSTokenizer != "" && other.FTSTokenizer != out.FTSTokenizer:
+		return Schema{}, SchemaError(fmt.Sprintf("FTS tokenizers %q and %q conflict", out.FTSTokenizer, other.FTSTokenizer))
+	}
+	out.Strict = out.Strict || other.Strict
+
+	if err := out.Validate(); err != nil {
+		return Schema{}, err
+	}
+	return out, nil
+}
+
+func sameFieldSpec(a, b FieldSpec) bool {
+	if (a.Weight == nil) != (b.Weight == nil) || (a.Weight != nil && *a.Weight != *b.Weight) {
+		return false
+	}
+	return a.Type == b.Type && a.Multi == b.Multi && a.CaseFold == b.CaseFold && a.Required == b.Required && a.Normalizer == b.Normalizer && a.FullText == b.FullText
+}
+
 // TextFieldsInStorageFormat returns text fields in storage format
 func (s *Schema) TextFieldsInStorageFormat() []storage.TextField {
 	fields := s.TextFieldsInOrder()
@@ -184,6 +445,21 @@ func (s schemaStorageAdapter) HasField(name string) bool {
 	return s.Schema.HasField(name)
 }
 
+// FTSTokenizer implements storage.Schema
+func (s schemaStorageAdapter) FTSTokenizer() string {
+	return s.Schema.FTSTokenizer
+}
+
+// Strict implements storage.Schema
+func (s schemaStorageAdapter) Strict() bool {
+	return s.Schema.Strict
+}
+
+// Aliases implements storage.Schema
+func (s schemaStorageAdapter) Aliases() map[string]string {
+	return s.Schema.Aliases
+}
+
 // AsStorageSchema returns a storage.Schema adapter
 func (s *Schema) AsStorageSchema() storage.Schema {
 	return schemaStorageAdapter{s}
@@ -0,0 +1,475 @@
+// Package server exposes an open ministore index over HTTP. Response bodies
+// mirror the CLI's --format json output.
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ministore/ministore/ministore"
+)
+
+// MaxLineBytes bounds a single JSONL document accepted by POST /put
+const MaxLineBytes = 16 << 20
+
+// Server serves one index. It does not own the index; callers close it.
+type Server struct {
+	ix  *ministore.Index
+	mux *http.ServeMux
+}
+
+// New returns a handler for ix with these routes:
+//
+//	GET  /healthz
+//	POST /put               JSONL body, one document per line
+//	GET  /get?path=
+//	POST /delete?path=      or ?where=<query>
+//	POST /search            JSON body, see SearchRequest
+//	GET  /discover/fields
+//	GET  /discover/values?field=&where=&top=
+//	GET  /discover/facets?fields=a,b&where=&top=
+//	GET  /stats?field=&where=&percentiles=90,95,99
+//	GET  /histogram?field=&where=&width=   or &interval=day|week|month
+func New(ix *ministore.Index) *Server {
+	s := &Server{ix: ix, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.HandleFunc("POST /put", s.handlePut)
+	s.mux.HandleFunc("GET /get", s.handleGet)
+	s.mux.HandleFunc("POST /delete", s.handleDelete)
+	s.mux.HandleFunc("POST /search", s.handleSearch)
+	s.mux.HandleFunc("GET /discover/fields", s.handleDiscoverFields)
+	s.mux.HandleFunc("GET /discover/values", s.handleDiscoverValues)
+	s.mux.HandleFunc("GET /discover/facets", s.handleDiscoverFacets)
+	s.mux.HandleFunc("GET /stats", s.handleStats)
+	s.mux.HandleFunc("GET /histogram", s.handleHistogram)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// SearchRequest is the POST /search body. It mirrors ministore.SearchOptions
+// plus the query string.
+type SearchRequest struct {
+	Query      string `json:"query"`
+	Limit      int    `json:"limit,omitempty"`
+	After      string `json:"after,omitempty"`
+	CursorMode string `json:"cursor_mode,omitempty"` // short|full
+	Rank       struct {
+		Kind  string `json:"kind,omitempty"` // default|recency|field|none
+		Field string `json:"field,omitempty"`
+		Keys  []struct {
+			Field string `json:"field"`
+			Desc  bool   `json:"desc,omitempty"`
+		} `json:"keys,omitempty"`
+		Ascending   bool `json:"ascending,omitempty"`
+		MissingLast bool `json:"missing_last,omitempty"`
+	} `json:"rank"`
+	Show struct {
+		Kind   string   `json:"kind,omitempty"` // none|all|fields
+		Fields []string `json:"fields,omitempty"`
+		Nested bool     `json:"nested,omitempty"`
+	} `json:"show"`
+	Explain       bool   `json:"explain,omitempty"`
+	ExplainFormat string `json:"explain_format,omitempty"` // "" or json
+	Profile       bool   `json:"profile,omitempty"`
+	Highlight     *struct {
+		Tokens int    `json:"tokens,omitempty"`
+		Open   string `json:"open,omitempty"`
+		Close  string `json:"close,omitempty"`
+	} `json:"highlight,omitempty"`
+	MatchPositions bool     `json:"match_positions,omitempty"`
+	IncludeDeleted bool     `json:"include_deleted,omitempty"`
+	MinScore       *float64 `json:"min_score,omitempty"`
+	Snapshot       bool     `json:"snapshot,omitempty"`
+	DistinctBy     string   `json:"distinct_by,omitempty"`
+	TimeoutMS      int64    `json:"timeout_ms,omitempty"`
+	SuggestOnEmpty bool     `json:"suggest_on_empty,omitempty"`
+	EstimateTotal  bool     `json:"estimate_total,omitempty"`
+}
+
+// Options converts the request to search options
+func (req SearchRequest) Options() ministore.SearchOptions {
+	opts := ministore.SearchOptions{
+		Limit:          req.Limit,
+		After:          req.After,
+		CursorMode:     ministore.CursorMode(req.CursorMode),
+		Explain:        req.Explain,
+		ExplainFormat:  ministore.ExplainFormat(req.ExplainFormat),
+		Profile:        req.Profile,
+		IncludeDeleted: req.IncludeDeleted,
+		MinScore:       req.MinScore,
+		Snapshot:       req.Snapshot,
+		DistinctBy:     req.DistinctBy,
+		Timeout:        time.Duration(req.TimeoutMS) * time.Millisecond,
+		SuggestOnEmpty: req.SuggestOnEmpty,
+		EstimateTotal:  req.EstimateTotal,
+	}
+	if opts.CursorMode == "" {
+		opts.CursorMode = ministore.CursorShort
+	}
+
+	opts.Rank.Kind = ministore.RankModeKind(req.Rank.Kind)
+	if opts.Rank.Kind == "" {
+		opts.Rank.Kind = ministore.RankDefault
+	}
+	opts.Rank.Field = req.Rank.Field
+	opts.Rank.Ascending = req.Rank.Ascending
+	opts.Rank.MissingLast = req.Rank.MissingLast
+	for _, k := range req.Rank.Keys {
+		opts.Rank.Keys = append(opts.Rank.Keys, ministore.SortKey{Field: k.Field, Desc: k.Desc})
+	}
+
+	opts.Show.Kind = ministore.OutputFieldSelectorKind(req.Show.Kind)
+	if opts.Show.Kind == "" {
+		opts.Show.Kind = ministore.ShowNone
+		if len(req.Show.Fields) > 0 {
+			opts.Show.Kind = ministore.ShowFields
+		}
+	}
+	opts.Show.Fields = req.Show.Fields
+	opts.Show.Nested = req.Show.Nested
+
+	if h := req.Highlight; h != nil {
+		opts.Highlight = &ministore.HighlightSpec{Tokens: h.Tokens, Open: h.Open, Close: h.Close}
+	}
+	opts.ReturnMatchPositions = req.MatchPositions
+	return opts
+}
+
+// SearchResultJSON is the JSON shape of a search page, shared with the CLI
+func SearchResultJSON(result ministore.SearchResultPage) map[string]any {
+	items := make([]any, 0, len(result.Items))
+	for _, item := range result.Items {
+		var obj any
+		if json.Unmarshal(item, &obj) == nil {
+			items = append(items, obj)
+		}
+	}
+	output := map[string]any{
+		"items":    items,
+		"has_more": result.HasMore,
+		"limit":    result.Limit,
+		"returned": result.Returned,
+	}
+	if result.EstimatedTotal != nil {
+		output["estimated_total"] = *result.EstimatedTotal
+	}
+	if result.NextCursor != "" {
+		output["next_cursor"] = result.NextCursor
+	}
+	if len(result.Suggestions) > 0 {
+		output["suggest_field"] = result.SuggestField
+		output["suggestions"] = result.Suggestions
+	}
+	if result.ExplainJSON != nil {
+		output["explain_plan"] = json.RawMessage(result.ExplainJSON)
+	}
+	if len(result.Profile) > 0 {
+		steps := make([]map[string]any, 0, len(result.Profile))
+		for _, p := range result.Profile {
+			steps = append(steps, map[string]any{
+				"cte":        p.CTE,
+				"step":       p.Step,
+				"rows":       p.Rows,
+				"elapsed_ms": float64(p.Elapsed) / float64(time.Millisecond),
+			})
+		}
+		output["profile"] = steps
+	}
+	return output
+}
+
+// StatsJSON is the JSON shape of field statistics, shared with the CLI
+func StatsJSON(stats ministore.StatsResult) map[string]any {
+	output := map[string]any{
+		"field": stats.Field,
+		"count": stats.Count,
+	}
+	if stats.Min != nil {
+		output["min"] = *stats.Min
+	}
+	if stats.Max != nil {
+		output["max"] = *stats.Max
+	}
+	if stats.Avg != nil {
+		output["avg"] = *stats.Avg
+	}
+	if stats.Median != nil {
+		output["median"] = *stats.Median
+	}
+	if len(stats.Percentiles) > 0 {
+		percentiles := make(map[string]float64, len(stats.Percentiles))
+		for p, v := range stats.Percentiles {
+			percentiles[PercentileKey(p)] = v
+		}
+		output["percentiles"] = percentiles
+	}
+	return output
+}
+
+// PercentileKey names percentile p in JSON output: 90 is "p90", 99.9 "p99.9"
+func PercentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := s.ix.DB().PingContext(r.Context()); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	batch := ministore.NewBatch()
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		doc := strings.TrimSpace(scanner.Text())
+		if doc == "" {
+			continue
+		}
+		if err := batch.PutJSON([]byte(doc)); err != nil {
+			writeError(w, fmt.Errorf("line %d: %w", line, err))
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		writeError(w, ministore.Wrap(ministore.ErrIO, "read body", err))
+		return
+	}
+
+	paths, err := s.ix.BatchPaths(r.Context(), batch)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"imported": len(paths), "paths": paths})
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, ministore.New(ministore.ErrSchema, "missing 'path' parameter"))
+		return
+	}
+	item, err := s.ix.Get(r.Context(), path)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"path":    item.Path,
+		"created": item.Meta.CreatedAtMS,
+		"updated": item.Meta.UpdatedAtMS,
+		"doc":     json.RawMessage(item.DocJSON),
+	})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	path, where := q.Get("path"), q.Get("where")
+	switch {
+	case path != "":
+		deleted, err := s.ix.Delete(r.Context(), path)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"deleted": deleted})
+	case where != "":
+		count, err := s.ix.DeleteWhere(r.Context(), where)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"deleted": count})
+	default:
+		writeError(w, ministore.New(ministore.ErrSchema, "missing 'path' or 'where' parameter"))
+	}
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, ministore.Wrap(ministore.ErrSchema, "search request json", err))
+		return
+	}
+	opts := req.Options()
+	result, err := s.ix.Search(r.Context(), req.Query, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	output := SearchResultJSON(result)
+	if opts.Explain {
+		output["explain_sql"] = result.ExplainSQL
+		output["explain_steps"] = result.ExplainSteps
+	}
+	writeJSON(w, http.StatusOK, output)
+}
+
+func (s *Server) handleDiscoverFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := s.ix.DiscoverFields(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fields)
+}
+
+func (s *Server) handleDiscoverValues(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	field := q.Get("field")
+	if field == "" {
+		writeError(w, ministore.New(ministore.ErrSchema, "missing 'field' parameter"))
+		return
+	}
+	top := 20
+	if t := q.Get("top"); t != "" {
+		n, err := strconv.Atoi(t)
+		if err != nil || n <= 0 {
+			writeError(w, ministore.New(ministore.ErrSchema, "'top' must be a positive integer"))
+			return
+		}
+		top = n
+	}
+	values, err := s.ix.DiscoverValues(r.Context(), field, q.Get("where"), top)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, values)
+}
+
+func (s *Server) handleDiscoverFacets(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("fields") == "" {
+		writeError(w, ministore.New(ministore.ErrSchema, "missing 'fields' parameter"))
+		return
+	}
+	top := 20
+	if t := q.Get("top"); t != "" {
+		n, err := strconv.Atoi(t)
+		if err != nil || n <= 0 {
+			writeError(w, ministore.New(ministore.ErrSchema, "'top' must be a positive integer"))
+			return
+		}
+		top = n
+	}
+	facets, err := s.ix.Facets(r.Context(), q.Get("where"), strings.Split(q.Get("fields"), ","), top)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, facets)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	field := q.Get("field")
+	if field == "" {
+		writeError(w, ministore.New(ministore.ErrSchema, "missing 'field' parameter"))
+		return
+	}
+	var percentiles []float64
+	if ps := q.Get("percentiles"); ps != "" {
+		for _, v := range strings.Split(ps, ",") {
+			p, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				writeError(w, ministore.New(ministore.ErrSchema, fmt.Sprintf("invalid percentile %q", v)))
+				return
+			}
+			percentiles = append(percentiles, p)
+		}
+	}
+	stats, err := s.ix.Stats(r.Context(), field, q.Get("where"), percentiles...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, StatsJSON(stats))
+}
+
+func (s *Server) handleHistogram(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	field := q.Get("field")
+	if field == "" {
+		writeError(w, ministore.New(ministore.ErrSchema, "missing 'field' parameter"))
+		return
+	}
+	if interval := q.Get("interval"); interval != "" {
+		buckets, err := s.ix.DateHistogram(r.Context(), field, q.Get("where"), ministore.DateInterval(interval))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, buckets)
+		return
+	}
+	width, err := strconv.ParseFloat(q.Get("width"), 64)
+	if err != nil || width <= 0 {
+		writeError(w, ministore.New(ministore.ErrSchema, "'width' must be a positive number, or pass 'interval'"))
+		return
+	}
+	buckets, err := s.ix.Histogram(r.Context(), field, q.Get("where"), width)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, buckets)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError reports err as {"error", "kind", "field"} with a status derived
+// from its ErrorKind
+func writeError(w http.ResponseWriter, err error) {
+	body := map[string]any{"error": err.Error()}
+	status := http.StatusInternalServerError
+	var e *ministore.Error
+	if errors.As(err, &e) {
+		body["kind"] = e.Kind
+		if e.Field != "" {
+			body["field"] = e.Field
+		}
+		status = statusFor(e.Kind)
+	}
+	writeJSON(w, status, body)
+}
+
+func statusFor(kind ministore.ErrorKind) int {
+	switch kind {
+	case ministore.ErrNotFound:
+		return http.StatusNotFound
+	case ministore.ErrSchema, ministore.ErrQueryParse, ministore.ErrQueryRejected, ministore.ErrNoTextFields,
+		ministore.ErrUnknownField, ministore.ErrTypeMismatch, ministore.ErrCursor:
+		return http.StatusBadRequest
+	case ministore.ErrReadOnly:
+		return http.StatusForbidden
+	case ministore.ErrFeature:
+		return http.StatusNotImplemented
+	case ministore.ErrTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ministore/ministore/ministore"
+	"github.com/ministore/ministore/ministore/server"
+	"github.com/ministore/ministore/ministore/storage/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	schema := ministore.Schema{
+		Fields: map[string]ministore.FieldSpec{
+			"title":    {Type: ministore.FieldText},
+			"tags":     {Type: ministore.FieldKeyword, Multi: true},
+			"priority": {Type: ministore.FieldNumber},
+		},
+	}
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	ix, err := ministore.Create(context.Background(), sqlite.New(dbPath), schema, ministore.DefaultIndexOptions())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(func() { _ = ix.Close() })
+
+	ts := httptest.NewServer(server.New(ix))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, method, url, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	ts := newServer(t)
+
+	if code := do(t, "GET", ts.URL+"/healthz", "", nil); code != http.StatusOK {
+		t.Fatalf("healthz = %d", code)
+	}
+
+	var put map[string]any
+	body := `{"path":"/a","title":"hello world","tags":["x"],"priority":3}
+{"path":"/b","title":"hello again","tags":["y"],"priority":7}
+`
+	if code := do(t, "POST", ts.URL+"/put", body, &put); code != http.StatusOK || put["imported"] != float64(2) {
+		t.Fatalf("put = %d %v", code, put)
+	}
+	if paths, _ := put["paths"].([]any); len(paths) != 2 || paths[0] != "/a" || paths[1] != "/b" {
+		t.Errorf("put paths = %v, want [/a /b]", put["paths"])
+	}
+
+	var item struct {
+		Path string         `json:"path"`
+		Doc  map[string]any `json:"doc"`
+	}
+	if code := do(t, "GET", ts.URL+"/get?path=/a", "", &item); code != http.StatusOK || item.Doc["title"] != "hello world" {
+		t.Errorf("get = %d %+v", code, item)
+	}
+	var errBody map[string]any
+	if code := do(t, "GET", ts.URL+"/get?path=/missing", "", &errBody); code != http.StatusNotFound || errBody["kind"] != "not_found" {
+		t.Errorf("get missing = %d %v", code, errBody)
+	}
+
+	var page struct {
+		Items      []map[string]any `json:"items"`
+		HasMore    bool             `json:"has_more"`
+		NextCursor string           `json:"next_cursor"`
+	}
+	req := `{"query":"title:hello","limit":1,"rank":{"kind":"field","field":"priority"},"show":{"kind":"all"}}`
+	if code := do(t, "POST", ts.URL+"/search", req, &page); code != http.StatusOK {
+		t.Fatalf("search = %d", code)
+	}
+	if len(page.Items) != 1 || page.Items[0]["path"] != "/b" || !page.HasMore || page.NextCursor == "" {
+		t.Errorf("search page = %+v", page)
+	}
+
+	if code := do(t, "POST", ts.URL+"/search", `{"query":"title:(("}`, &errBody); code != http.StatusBadRequest || errBody["kind"] != "query_parse" {
+		t.Errorf("bad query = %d %v", code, errBody)
+	}
+	if code := do(t, "POST", ts.URL+"/delete?where=title:((", "", &errBody); code != http.StatusBadRequest || errBody["kind"] != "query_parse" {
+		t.Errorf("bad delete query = %d %v", code, errBody)
+	}
+
+	var fields []map[string]any
+	if code := do(t, "GET", ts.URL+"/discover/fields", "", &fields); code != http.StatusOK || len(fields) != 3 {
+		t.Errorf("discover fields = %d %v", code, fields)
+	}
+
+	var facets map[string][]map[string]any
+	if code := do(t, "GET", ts.URL+"/discover/facets?fields=tags&where=priority>5", "", &facets); code != http.StatusOK ||
+		len(facets["tags"]) != 1 || facets["tags"][0]["Value"] != "y" {
+		t.Errorf("discover facets = %d %v", code, facets)
+	}
+
+	var stats map[string]any
+	if code := do(t, "GET", ts.URL+"/stats?field=priority", "", &stats); code != http.StatusOK || stats["max"] != float64(7) {
+		t.Errorf("stats = %d %v", code, stats)
+	}
+
+	var pstats struct {
+		Percentiles map[string]float64 `json:"percentiles"`
+	}
+	if code := do(t, "GET", ts.URL+"/stats?field=priority&percentiles=50,90", "", &pstats); code != http.StatusOK ||
+		pstats.Percentiles["p50"] != 5 || math.Abs(pstats.Percentiles["p90"]-6.6) > 1e-9 {
+		t.Errorf("stats percentiles = %d %v", code, pstats)
+	}
+
+	var buckets []map[string]any
+	if code := do(t, "GET", ts.URL+"/histogram?field=priority&width=5", "", &buckets); code != http.StatusOK ||
+		len(buckets) != 2 || buckets[0]["Count"] != float64(1) || buckets[1]["Lo"] != float64(5) {
+		t.Errorf("histogram = %d %v", code, buckets)
+	}
+
+	var del map[string]any
+	if code := do(t, "POST", ts.URL+"/delete?path=/a", "", &del); code != http.StatusOK || del["deleted"] != true {
+		t.Errorf("delete = %d %v", code, del)
+	}
+}
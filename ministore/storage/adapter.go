@@ -3,6 +3,11 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/ministore/ministore/ministore/storage/sqlbuilder"
 )
@@ -14,12 +19,23 @@ const (
 	BackendPostgres Backend = "postgres"
 )
 
+// OptimizeOptions configures Adapter.Optimize
+type OptimizeOptions struct {
+	// Vacuum also rewrites the whole database to reclaim free space. It is
+	// slow on large indexes and needs as much free disk as the database.
+	Vacuum bool
+}
+
 // Adapter abstracts database-specific operations
 type Adapter interface {
 	Backend() Backend
 	PlaceholderStyle() sqlbuilder.PlaceholderStyle
 	IndexID() string
+	Capabilities() Capabilities
 
+	// SetReadOnly makes Connect open a read-only connection and OpenIndex
+	// skip in-place upgrades. Call it before Connect.
+	SetReadOnly(readOnly bool)
 	Connect(ctx context.Context) (*sql.DB, error)
 	Close() error
 
@@ -27,26 +43,318 @@ type Adapter interface {
 	OpenIndex(ctx context.Context, db *sql.DB) (schemaJSON []byte, err error)
 	VerifyFTS(ctx context.Context, db *sql.DB, schema Schema) error
 	ApplySchemaAdditive(ctx context.Context, db *sql.DB, old, new Schema) error
-	Optimize(ctx context.Context, db *sql.DB) error
+	// ApplySchemaDDL returns the statements ApplySchemaAdditive runs
+	ApplySchemaDDL(old, new Schema) ([]string, error)
+	Optimize(ctx context.Context, db *sql.DB, schema Schema, opts OptimizeOptions) error
+	// MergeFTS does one bounded step of full-text index compaction, writing
+	// about pages pages. done is true when there was nothing left to merge.
+	MergeFTS(ctx context.Context, db *sql.DB, schema Schema, pages int) (done bool, err error)
+	// Snapshot writes a consistent, standalone copy of the index to dst (a
+	// file path on SQLite, a new schema name on Postgres) while writers
+	// carry on
+	Snapshot(ctx context.Context, db *sql.DB, dst string) error
+	// DiskSize returns the on-disk size of the index in bytes and, where the
+	// backend can tell, of each of tables with its indexes. Tables it cannot
+	// size are absent from perTable.
+	DiskSize(ctx context.Context, db *sql.DB, tables []string) (total int64, perTable map[string]int64, err error)
 
 	SQL() SQL
 	FTS() FTS
 }
 
+// PoolConfig limits the database/sql connection pool opened by Connect.
+// Zero fields keep the database/sql default unless the adapter documents
+// its own; a negative MaxOpenConns means unlimited.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Apply sets the configured limits on db
+func (c PoolConfig) Apply(db *sql.DB) {
+	if c.MaxOpenConns != 0 {
+		db.SetMaxOpenConns(max(c.MaxOpenConns, 0))
+	}
+	if c.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+}
+
+// Capabilities describes optional backend features
+type Capabilities struct {
+	// EditDistance is true when the backend computes bounded edit distances
+	// natively (Postgres with fuzzystrmatch). Without it, fuzzy keyword
+	// matching falls back to a client-side edit-distance filter when
+	// FuzzyKeyword is set. Both give the same matches.
+	EditDistance bool
+	// FuzzyKeyword is true when field~term queries are supported at all
+	FuzzyKeyword bool
+}
+
 // Schema is a minimal interface to avoid circular dependency
 type Schema interface {
 	ToJSON() ([]byte, error)
 	TextFieldsInOrder() []TextField
 	Get(name string) (FieldSpec, bool)
 	HasField(name string) bool
+	// FTSTokenizer returns the full-text tokenizer spec, or "" for the
+	// backend default
+	FTSTokenizer() string
+	// Strict reports whether documents with fields outside the schema are
+	// rejected rather than having those fields skipped
+	Strict() bool
+	// RequiredFields returns the names of fields every document must have,
+	// sorted
+	RequiredFields() []string
+	// KeywordFields returns the names of keyword fields, sorted
+	KeywordFields() []string
+	// Aliases maps query-facing field names to schema field names
+	Aliases() map[string]string
 }
 
 type FieldType string
 
 type FieldSpec struct {
-	Type   FieldType
-	Multi  bool
-	Weight *float64
+	Type     FieldType
+	Multi    bool
+	Weight   *float64
+	CaseFold bool
+	Required bool
+
+	// Normalizer names the transform applied to keyword values before they
+	// are stored and to query terms before they are matched; "" stores
+	// values verbatim. See NormalizeKeyword.
+	Normalizer string
+
+	// FullText marks a keyword field that also has a full-text column
+	FullText bool
+}
+
+// HasFTS reports whether the field has a full-text column
+func (s FieldSpec) HasFTS() bool {
+	return s.Type == FieldType("text") || (s.Type == FieldType("keyword") && s.FullText)
+}
+
+// Built-in keyword normalizers
+const (
+	NormalizerLowercase = "lowercase" // lower case
+	NormalizerSlug      = "slug"      // lower case, runs of other characters than letters and digits become one '-'
+	NormalizerTrim      = "trim"      // strip surrounding whitespace
+)
+
+// ValidKeywordNormalizer reports whether name is "" or a built-in normalizer
+func ValidKeywordNormalizer(name string) bool {
+	switch name {
+	case "", NormalizerLowercase, NormalizerSlug, NormalizerTrim:
+		return true
+	}
+	return false
+}
+
+// NormalizeKeyword applies the named normalizer to a keyword value. Unknown
+// names leave it unchanged; schemas reject them on validation.
+func NormalizeKeyword(normalizer, s string) string {
+	switch normalizer {
+	case NormalizerLowercase:
+		return strings.ToLower(s)
+	case NormalizerSlug:
+		var sb strings.Builder
+		dash := false
+		for _, r := range strings.ToLower(s) {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				if dash && sb.Len() > 0 {
+					sb.WriteByte('-')
+				}
+				sb.WriteRune(r)
+				dash = false
+				continue
+			}
+			dash = true
+		}
+		return sb.String()
+	case NormalizerTrim:
+		return strings.TrimSpace(s)
+	default:
+		return s
+	}
+}
+
+// NormalizeKeywordPattern normalizes the literal runs of a keyword pattern,
+// keeping the * and ? wildcards between them in place. A backslash escapes a
+// literal '*', '?' or '\\' in patterns; escapes are kept in the result.
+func NormalizeKeywordPattern(normalizer, pattern string) string {
+	if normalizer == "" {
+		return pattern
+	}
+	return rewriteKeywordPattern(pattern, func(sb *strings.Builder, literal string) {
+		for _, r := range NormalizeKeyword(normalizer, literal) {
+			if r == '*' || r == '?' || r == '\\' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		}
+	}, func(sb *strings.Builder, wildcard rune) {
+		sb.WriteRune(wildcard)
+	})
+}
+
+// KeywordPatternLike converts a keyword pattern to a LIKE pattern, to be used
+// with ESCAPE '\\'. Literal %, _ and \ are escaped.
+func KeywordPatternLike(pattern string) string {
+	return rewriteKeywordPattern(pattern, func(sb *strings.Builder, literal string) {
+		for _, r := range literal {
+			if r == '%' || r == '_' || r == '\\' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		}
+	}, func(sb *strings.Builder, wildcard rune) {
+		if wildcard == '*' {
+			sb.WriteByte('%')
+		} else {
+			sb.WriteByte('_')
+		}
+	})
+}
+
+// KeywordPatternGlob converts a keyword pattern to a SQLite GLOB pattern,
+// which has no escape character: literal *, ? and [ become bracket
+// expressions
+func KeywordPatternGlob(pattern string) string {
+	return rewriteKeywordPattern(pattern, func(sb *strings.Builder, literal string) {
+		for _, r := range literal {
+			if r == '*' || r == '?' || r == '[' {
+				sb.WriteByte('[')
+				sb.WriteRune(r)
+				sb.WriteByte(']')
+				continue
+			}
+			sb.WriteRune(r)
+		}
+	}, func(sb *strings.Builder, wildcard rune) {
+		sb.WriteRune(wildcard)
+	})
+}
+
+// rewriteKeywordPattern splits pattern into unescaped literal runs and
+// wildcards and rebuilds it through the two callbacks
+func rewriteKeywordPattern(pattern string, literal func(*strings.Builder, string), wildcard func(*strings.Builder, rune)) string {
+	var sb, run strings.Builder
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			run.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*' || r == '?':
+			literal(&sb, run.String())
+			run.Reset()
+			wildcard(&sb, r)
+		default:
+			run.WriteRune(r)
+		}
+	}
+	literal(&sb, run.String())
+	return sb.String()
+}
+
+// FoldKeyword returns the case-folded form of a keyword value, as stored in
+// kw_dict.value_folded for CaseFold fields and compared against at query time
+func FoldKeyword(s string) string {
+	return strings.ToLower(s)
+}
+
+// EarthRadiusKm is the mean Earth radius used for geo distances
+const EarthRadiusKm = 6371.0088
+
+// GeoPoint is a latitude and longitude in degrees
+type GeoPoint struct {
+	Lat float64
+	Lon float64
+}
+
+// UnitVector returns the point on the unit sphere, as stored in field_geo's
+// x, y and z columns. Two points are within d km of each other when the dot
+// product of their vectors is at least cos(d / EarthRadiusKm), which is the
+// haversine test without trigonometry in SQL.
+func (p GeoPoint) UnitVector() (x, y, z float64) {
+	lat := p.Lat * math.Pi / 180
+	lon := p.Lon * math.Pi / 180
+	return math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)
+}
+
+// DefaultFTSTokenizer is the tokenizer used when the schema does not set one
+const DefaultFTSTokenizer = "unicode61"
+
+// NormalizeFTSTokenizer validates a tokenizer spec such as
+// "porter unicode61 remove_diacritics 2" against an allowlist and returns it
+// with single spaces between words. The spec ends up inside DDL, so anything
+// outside the allowlist is rejected rather than escaped. "" yields
+// DefaultFTSTokenizer.
+func NormalizeFTSTokenizer(spec string) (string, error) {
+	words := strings.Fields(spec)
+	if len(words) == 0 {
+		return DefaultFTSTokenizer, nil
+	}
+
+	rest := words
+	if rest[0] == "porter" {
+		rest = rest[1:]
+		if len(rest) == 0 {
+			// porter with no base tokenizer wraps unicode61
+			return "porter " + DefaultFTSTokenizer, nil
+		}
+	}
+
+	var options map[string][]string
+	switch rest[0] {
+	case "unicode61":
+		options = map[string][]string{"remove_diacritics": {"0", "1", "2"}}
+	case "ascii":
+		options = map[string][]string{}
+	case "trigram":
+		options = map[string][]string{"case_sensitive": {"0", "1"}, "remove_diacritics": {"0", "1"}}
+	default:
+		return "", fmt.Errorf("unsupported fts tokenizer %q (want unicode61, ascii or trigram, optionally prefixed by porter)", rest[0])
+	}
+
+	opts := rest[1:]
+	if len(opts)%2 != 0 {
+		return "", fmt.Errorf("fts tokenizer %s: options must be name/value pairs", rest[0])
+	}
+	seen := map[string]bool{}
+	for i := 0; i < len(opts); i += 2 {
+		name, value := opts[i], opts[i+1]
+		allowed, ok := options[name]
+		if !ok {
+			return "", fmt.Errorf("fts tokenizer %s: unsupported option %q", rest[0], name)
+		}
+		if seen[name] {
+			return "", fmt.Errorf("fts tokenizer %s: duplicate option %q", rest[0], name)
+		}
+		seen[name] = true
+		valid := false
+		for _, a := range allowed {
+			if value == a {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf("fts tokenizer %s: invalid value %q for %s (want one of %s)", rest[0], value, name, strings.Join(allowed, ", "))
+		}
+	}
+	return strings.Join(words, " "), nil
 }
 
 type TextField struct {
@@ -61,11 +369,32 @@ type SQL struct {
 
 	FindItemIDByPath string
 	GetItemByPath    string
+	ItemExists       string
+	LockItemByPath   string
+	ListItemsAfterID string
+
+	// GetItemByPathForUpdate is GetItemByPath, also locking the row where
+	// the backend supports it
+	GetItemByPathForUpdate string
+
+	GetItemStateByPath string
+	SoftDeleteItem     string
+	RestoreItem        string
+
+	// SetItemData replaces data_json and data_enc of an item, keeping its
+	// timestamps
+	SetItemData string
+
+	FindItemsByKeyword string
+	RenameItem         string
 
 	CleanupExpiredCursors string
 	GetCursor             string
 	PutCursor             string
 
+	UpsertSavedQuery string
+	GetSavedQuery    string
+
 	GetValueIDsByItem string
 	IncrementDocFreq  string
 	DecrementDocFreq  string
@@ -76,6 +405,7 @@ type SQL struct {
 	DeleteNumberByItem   string
 	DeleteDateByItem     string
 	DeleteBoolByItem     string
+	DeleteGeoByItem      string
 	DeleteItemsByID      string
 
 	InsertOrIgnoreKwDict    string
@@ -86,14 +416,26 @@ type SQL struct {
 	InsertFieldNumber  string
 	InsertFieldDate    string
 	InsertFieldBool    string
+	InsertFieldGeo     string
 
 	UpsertItem       UpsertItemSQL
 	UpsertItemWithTS UpsertItemSQL
+
+	UpsertItemIfUpdatedAt ConditionalUpsertItemSQL
 }
 
-// UpsertItemSQL handles item insertion/update
+// UpsertItemSQL handles item insertion/update. dataEnc is stored in
+// items.data_enc; "" means dataJSON is plain JSON.
 type UpsertItemSQL interface {
-	Build(path string, dataJSON []byte, createdAtMS, updatedAtMS int64, nowMode bool) (string, []any)
+	Build(path string, dataJSON []byte, dataEnc string, createdAtMS, updatedAtMS int64, nowMode bool) (string, []any)
+}
+
+// ConditionalUpsertItemSQL builds an upsert that only overwrites an existing
+// live row whose updated_at equals expectedUpdatedAtMS, and only inserts a
+// missing row when expectedUpdatedAtMS is 0. Otherwise the statement returns
+// no rows.
+type ConditionalUpsertItemSQL interface {
+	Build(path string, dataJSON []byte, dataEnc string, nowMS, expectedUpdatedAtMS int64) (string, []any)
 }
 
 // FTS handles full-text search operations
@@ -101,17 +443,28 @@ type FTS interface {
 	HasFTS(schema Schema) bool
 	CreateFTS(ctx context.Context, db *sql.DB, schema Schema) error
 	VerifyFTS(ctx context.Context, db *sql.DB, schema Schema) error
-	AddTextColumns(ctx context.Context, db *sql.DB, old, new Schema) error
+	// AddTextColumnsDDL returns the statements that extend the FTS table
+	// from old's text fields to new's, creating it if old had none
+	AddTextColumnsDDL(old, new Schema) ([]string, error)
 
 	DeleteRow(ctx context.Context, tx *sql.Tx, itemID int64) error
+	DeleteAll(ctx context.Context, tx *sql.Tx, schema Schema) error
 	UpsertRow(ctx context.Context, tx *sql.Tx, itemID int64, schema Schema, textVals map[string]*string) error
 
 	// CompileTextPredicate returns SQL body (without WITH name) that yields item_id
 	CompileTextPredicate(b Builder, schema Schema, pred TextPredicate) (sql string, args []any, err error)
 
+	// CompileTextPresent returns SQL body that yields the item_id of every
+	// item whose full-text column for field has content
+	CompileTextPresent(field string) string
+
 	// ScoreCTEsAndJoin returns extra CTEs, join SQL fragment, and a score expression
 	// It may use builder to allocate placeholders
 	ScoreCTEsAndJoin(b Builder, schema Schema, preds []TextPredicate) (extraCTEs []CTE, joinSQL string, scoreExpr string, err error)
+
+	// HighlightCTEsAndCols returns extra CTEs, a join SQL fragment, and one
+	// snippet expression per highlighted text field
+	HighlightCTEsAndCols(b Builder, schema Schema, preds []TextPredicate, spec HighlightSpec) (extraCTEs []CTE, joinSQL string, cols []HighlightCol, err error)
 }
 
 // Builder interface for placeholder management
@@ -123,8 +476,90 @@ type Builder interface {
 
 // TextPredicate represents a text search predicate
 type TextPredicate struct {
-	Field *string
-	Query string
+	Field  *string
+	Fields []string // restricts a bare query to these text fields; ignored when Field is set
+	Query  string
+	Prefix bool     // Query is a prefix: match any token starting with it
+	AnyOf  []string // when set, match any of these terms instead of Query
+
+	// Near, when set, matches its two terms within NearDistance tokens of
+	// each other instead of Query. Backends differ: FTS5 NEAR allows up to
+	// NearDistance tokens between them in either order, Postgres requires
+	// the second exactly NearDistance positions after the first.
+	Near         []string
+	NearDistance int
+
+	// Boost multiplies this predicate's score; 0 means 1
+	Boost float64
+}
+
+// BoostFactor returns the factor p's score is multiplied by
+func (p TextPredicate) BoostFactor() float64 {
+	if p.Boost == 0 {
+		return 1
+	}
+	return p.Boost
+}
+
+// Boosted reports whether any of preds has a boost other than 1
+func Boosted(preds []TextPredicate) bool {
+	for _, p := range preds {
+		if p.BoostFactor() != 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// HighlightSpec configures snippets around matched terms
+type HighlightSpec struct {
+	Tokens int    // tokens of context per snippet
+	Open   string // inserted before each matched term
+	Close  string // inserted after each matched term
+	Whole  bool   // mark matches in the whole field instead of cutting snippets
+}
+
+// MatchOpen and MatchClose mark matched terms in the whole-field highlights
+// that match positions are read from
+const (
+	MatchOpen  = "\x02"
+	MatchClose = "\x03"
+)
+
+// MatchSpec is the highlight that marks every match in a whole field with
+// MatchOpen and MatchClose
+var MatchSpec = HighlightSpec{Open: MatchOpen, Close: MatchClose, Whole: true}
+
+// HighlightCol is a snippet expression for one text field
+type HighlightCol struct {
+	Field string
+	Expr  string
+}
+
+// HighlightFields returns the text fields targeted by preds, in schema order
+func HighlightFields(schema Schema, preds []TextPredicate) []string {
+	want := map[string]bool{}
+	all := false
+	for _, p := range preds {
+		switch {
+		case p.Field != nil:
+			want[*p.Field] = true
+		case len(p.Fields) > 0:
+			for _, f := range p.Fields {
+				want[f] = true
+			}
+		default:
+			all = true
+		}
+	}
+
+	var names []string
+	for _, tf := range schema.TextFieldsInOrder() {
+		if all || want[tf.Name] {
+			names = append(names, tf.Name)
+		}
+	}
+	return names
 }
 
 // CTE represents a Common Table Expression
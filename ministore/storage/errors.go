@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoTextFields is returned when a bare text query (no field: prefix) is
+// compiled against a schema without text fields
+var ErrNoTextFields = errors.New("no text fields in schema for bare text query")
+
+// SQLError is a failed SQL statement. It records the statement and how many
+// arguments were bound to it, but never the argument values, which may hold
+// document content.
+type SQLError struct {
+	Op    string // stable name of the operation, e.g. "insert number"
+	Field string // schema field being written or read, if any
+	SQL   string
+	NArgs int
+	Err   error
+}
+
+func (e *SQLError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s (field=%s): %v", e.Op, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *SQLError) Unwrap() error {
+	return e.Err
+}
+
+// Statement returns SQL on one line, with runs of whitespace collapsed
+func (e *SQLError) Statement() string {
+	return strings.Join(strings.Fields(e.SQL), " ")
+}
+
+// WrapSQL wraps err from running query with nargs bound arguments as a
+// *SQLError. A nil err stays nil.
+func WrapSQL(op, query string, nargs int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &SQLError{Op: op, SQL: query, NArgs: nargs, Err: err}
+}
+
+// WrapFieldSQL is WrapSQL for a statement reading or writing one field
+func WrapFieldSQL(op, field, query string, nargs int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &SQLError{Op: op, Field: field, SQL: query, NArgs: nargs, Err: err}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/stdlib"
@@ -17,6 +18,16 @@ import (
 type Adapter struct {
 	DSN    string
 	Schema string // used as dedicated schema via search_path
+	// TextSearchConfig is the regconfig (english, german, ...) used for both
+	// to_tsvector and tsquery. Only read by CreateIndex, which records it in
+	// meta; OpenIndex restores the recorded value. Empty means "simple".
+	TextSearchConfig string
+	// Pool limits the connection pool; zero fields keep database/sql defaults
+	Pool storage.PoolConfig
+
+	editDistance bool   // fuzzystrmatch available; detected by CreateIndex/OpenIndex
+	tsConfig     string // effective text search config, set by CreateIndex/OpenIndex
+	readOnly     bool
 }
 
 func New(dsn, schema string) *Adapter {
@@ -29,11 +40,49 @@ func (a *Adapter) PlaceholderStyle() sqlbuilder.PlaceholderStyle { return sqlbui
 
 func (a *Adapter) IndexID() string { return "postgres:" + a.Schema }
 
+func (a *Adapter) Capabilities() storage.Capabilities {
+	return storage.Capabilities{EditDistance: a.editDistance, FuzzyKeyword: true}
+}
+
 func (a *Adapter) Close() error { return nil }
 
+func (a *Adapter) SetReadOnly(readOnly bool) { a.readOnly = readOnly }
+
 func (a *Adapter) SQL() storage.SQL { return SQLTemplates }
 
-func (a *Adapter) FTS() storage.FTS { return FTS{} }
+func (a *Adapter) FTS() storage.FTS { return FTS{Config: a.textSearchConfig()} }
+
+// DefaultTextSearchConfig is used when no text search config was chosen,
+// and for indexes created before it was recorded
+const DefaultTextSearchConfig = "simple"
+
+const metaKeyTSConfig = "ts_config"
+
+func (a *Adapter) textSearchConfig() string {
+	if a.tsConfig != "" {
+		return a.tsConfig
+	}
+	if a.TextSearchConfig != "" {
+		return a.TextSearchConfig
+	}
+	return DefaultTextSearchConfig
+}
+
+// checkTextSearchConfig rejects config names that are not plain identifiers
+// (they are spliced into SQL as literals) or that the server does not know
+func checkTextSearchConfig(ctx context.Context, db *sql.DB, cfg string) error {
+	if !schemaNameRe.MatchString(cfg) {
+		return fmt.Errorf("invalid text search config %q (must match %s)", cfg, schemaNameRe.String())
+	}
+	var ok bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pg_ts_config WHERE cfgname = $1)", cfg).Scan(&ok); err != nil {
+		return fmt.Errorf("check text search config: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("unknown text search config %q (see pg_ts_config)", cfg)
+	}
+	return nil
+}
 
 var schemaNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
@@ -51,6 +100,10 @@ func (a *Adapter) ensureSchema(ctx context.Context, db *sql.DB) error {
 }
 
 func (a *Adapter) Connect(ctx context.Context) (*sql.DB, error) {
+	if a.readOnly {
+		return a.connectReadOnly(ctx)
+	}
+
 	// 1) Connect without search_path to ensure schema exists
 	cfg0, err := pgx.ParseConfig(a.DSN)
 	if err != nil {
@@ -79,6 +132,33 @@ func (a *Adapter) Connect(ctx context.Context) (*sql.DB, error) {
 	cfg.RuntimeParams["search_path"] = fmt.Sprintf("%s,public", quoteIdent(a.Schema))
 
 	db := stdlib.OpenDB(*cfg)
+	a.Pool.Apply(db)
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// connectReadOnly connects without creating the schema or extensions, with
+// every transaction read-only
+func (a *Adapter) connectReadOnly(ctx context.Context) (*sql.DB, error) {
+	if a.Schema == "" || !schemaNameRe.MatchString(a.Schema) {
+		return nil, fmt.Errorf("invalid postgres schema name %q (must match %s)", a.Schema, schemaNameRe.String())
+	}
+	cfg, err := pgx.ParseConfig(a.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RuntimeParams == nil {
+		cfg.RuntimeParams = make(map[string]string)
+	}
+	cfg.RuntimeParams["search_path"] = fmt.Sprintf("%s,public", quoteIdent(a.Schema))
+	cfg.RuntimeParams["default_transaction_read_only"] = "on"
+
+	db := stdlib.OpenDB(*cfg)
+	a.Pool.Apply(db)
 	if err := db.PingContext(ctx); err != nil {
 		_ = db.Close()
 		return nil, err
@@ -91,6 +171,10 @@ func (a *Adapter) CreateIndex(ctx context.Context, db *sql.DB, schemaJSON []byte
 	if _, err := db.ExecContext(ctx, ddlBase); err != nil {
 		return err
 	}
+	enableFuzzy(ctx, db)
+	if err := a.detectEditDistance(ctx, db); err != nil {
+		return err
+	}
 
 	sqlt := a.SQL()
 	if _, err := db.ExecContext(ctx, sqlt.SetMeta, "ministore_magic", "ministore"); err != nil {
@@ -103,6 +187,18 @@ func (a *Adapter) CreateIndex(ctx context.Context, db *sql.DB, schemaJSON []byte
 		return err
 	}
 
+	cfg := a.TextSearchConfig
+	if cfg == "" {
+		cfg = DefaultTextSearchConfig
+	}
+	if err := checkTextSearchConfig(ctx, db, cfg); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, sqlt.SetMeta, metaKeyTSConfig, cfg); err != nil {
+		return err
+	}
+	a.tsConfig = cfg
+
 	// FTS (if any text fields)
 	schema, err := parseSchema(schemaJSON)
 	if err != nil {
@@ -130,9 +226,53 @@ func (a *Adapter) OpenIndex(ctx context.Context, db *sql.DB) ([]byte, error) {
 	if err := db.QueryRowContext(ctx, sqlt.GetMeta, "schema_json").Scan(&schemaStr); err != nil {
 		return nil, err
 	}
+	if !a.readOnly {
+		for _, stmt := range ddlUpgrades {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return nil, fmt.Errorf("upgrade index: %w", err)
+			}
+		}
+		enableFuzzy(ctx, db)
+	}
+
+	// The config used at create time must be used for queries too, or
+	// tsquery lexemes will not line up with the stored tsvectors
+	var cfg string
+	err := db.QueryRowContext(ctx, sqlt.GetMeta, metaKeyTSConfig).Scan(&cfg)
+	switch {
+	case err == sql.ErrNoRows:
+		cfg = DefaultTextSearchConfig
+	case err != nil:
+		return nil, fmt.Errorf("read text search config: %w", err)
+	}
+	if !schemaNameRe.MatchString(cfg) {
+		return nil, fmt.Errorf("invalid text search config %q in meta", cfg)
+	}
+	a.tsConfig = cfg
+
+	if err := a.detectEditDistance(ctx, db); err != nil {
+		return nil, err
+	}
 	return []byte(schemaStr), nil
 }
 
+// enableFuzzy creates fuzzystrmatch if it is missing. Roles that may not
+// create extensions keep working: the error is ignored and
+// detectEditDistance picks the client-side path.
+func enableFuzzy(ctx context.Context, db *sql.DB) {
+	_, _ = db.ExecContext(ctx, ddlFuzzy)
+}
+
+// detectEditDistance records whether levenshtein_less_equal is callable, so
+// fuzzy predicates compile to SQL instead of resolving client-side
+func (a *Adapter) detectEditDistance(ctx context.Context, db *sql.DB) error {
+	err := db.QueryRowContext(ctx, "SELECT to_regprocedure('levenshtein_less_equal(text,text,integer)') IS NOT NULL").Scan(&a.editDistance)
+	if err != nil {
+		return fmt.Errorf("detect fuzzystrmatch: %w", err)
+	}
+	return nil
+}
+
 func (a *Adapter) VerifyFTS(ctx context.Context, db *sql.DB, schema storage.Schema) error {
 	if !a.FTS().HasFTS(schema) {
 		return nil
@@ -141,9 +281,13 @@ func (a *Adapter) VerifyFTS(ctx context.Context, db *sql.DB, schema storage.Sche
 }
 
 func (a *Adapter) ApplySchemaAdditive(ctx context.Context, db *sql.DB, old, new storage.Schema) error {
-	if a.FTS().HasFTS(new) {
-		if err := a.FTS().AddTextColumns(ctx, db, old, new); err != nil {
-			return err
+	stmts, err := a.ApplySchemaDDL(old, new)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("alter fts: %w", err)
 		}
 	}
 	b, err := new.ToJSON()
@@ -154,25 +298,142 @@ func (a *Adapter) ApplySchemaAdditive(ctx context.Context, db *sql.DB, old, new
 	return err
 }
 
-func (a *Adapter) Optimize(ctx context.Context, db *sql.DB) error {
-	// Best-effort: ANALYZE
-	_, _ = db.ExecContext(ctx, "ANALYZE")
+func (a *Adapter) ApplySchemaDDL(old, new storage.Schema) ([]string, error) {
+	return a.FTS().AddTextColumnsDDL(old, new)
+}
+
+// Optimize refreshes the query planner statistics, vacuuming first when
+// opts.Vacuum is set. The GIN indexes maintain themselves.
+func (a *Adapter) Optimize(ctx context.Context, db *sql.DB, schema storage.Schema, opts storage.OptimizeOptions) error {
+	stmt := "ANALYZE"
+	if opts.Vacuum {
+		stmt = "VACUUM (ANALYZE)"
+	}
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("%s: %w", stmt, err)
+	}
 	return nil
 }
 
+// MergeFTS has nothing to do: GIN indexes merge their pending entries on
+// their own (and on VACUUM)
+func (a *Adapter) MergeFTS(ctx context.Context, db *sql.DB, schema storage.Schema, pages int) (bool, error) {
+	return true, nil
+}
+
+// snapshotTables lists the index tables in an order that satisfies their
+// foreign keys
+var snapshotTables = []string{
+	"meta", "items", "kw_dict", "kw_postings", "field_present",
+	"field_number", "field_date", "field_bool", "field_geo", "cursor_store", "saved_query",
+}
+
+// Snapshot copies the index into the new schema dst. The tables are created
+// from the current DDL and filled in one REPEATABLE READ transaction, so the
+// copy sees a single consistent state while writers carry on; serial
+// sequences continue after the copied ids.
+func (a *Adapter) Snapshot(ctx context.Context, db *sql.DB, dst string) error {
+	if !schemaNameRe.MatchString(dst) {
+		return fmt.Errorf("invalid postgres schema name %q (must match %s)", dst, schemaNameRe.String())
+	}
+	if dst == a.Schema {
+		return fmt.Errorf("snapshot schema must differ from the index schema %q", a.Schema)
+	}
+	src, to := quoteIdent(a.Schema), quoteIdent(dst)
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var schemaStr string
+	if err := tx.QueryRowContext(ctx, a.SQL().GetMeta, "schema_json").Scan(&schemaStr); err != nil {
+		return fmt.Errorf("read schema: %w", err)
+	}
+	schema, err := parseSchema([]byte(schemaStr))
+	if err != nil {
+		return err
+	}
+
+	// Create the tables in dst, then point unqualified names back at the
+	// source for the copy
+	stmts := []string{
+		"CREATE SCHEMA " + to,
+		fmt.Sprintf("SET LOCAL search_path = %s, public", to),
+		ddlBase,
+	}
+	stmts = append(stmts, createFTSStatements(schema)...)
+	stmts = append(stmts, fmt.Sprintf("SET LOCAL search_path = %s, public", src))
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("create snapshot schema: %w", err)
+		}
+	}
+
+	tables := snapshotTables
+	if a.FTS().HasFTS(schema) {
+		tables = append(tables[:len(tables):len(tables)], "search")
+	}
+	for _, table := range tables {
+		// Upgraded indexes may order their columns differently from ddlBase
+		rows, err := tx.QueryContext(ctx, "SELECT column_name FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 ORDER BY ordinal_position", dst, table)
+		if err != nil {
+			return fmt.Errorf("list columns of %s: %w", table, err)
+		}
+		var cols []string
+		for rows.Next() {
+			var c string
+			if err := rows.Scan(&c); err != nil {
+				rows.Close()
+				return err
+			}
+			cols = append(cols, quoteIdent(c))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		list := strings.Join(cols, ", ")
+		stmt := fmt.Sprintf("INSERT INTO %s.%s (%s) SELECT %s FROM %s.%s", to, table, list, list, src, table)
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("copy %s: %w", table, err)
+		}
+	}
+
+	for _, table := range []string{"items", "kw_dict"} {
+		stmt := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s.%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s.%s", to, table, to, table)
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("reset %s sequence: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
 type fieldSpec struct {
-	Type   string
-	Multi  bool
-	Weight *float64
+	Type       string
+	Multi      bool
+	Weight     *float64
+	CaseFold   bool
+	Required   bool
+	Normalizer string
+	FullText   bool
 }
 
 func parseSchema(schemaJSON []byte) (storage.Schema, error) {
 	var raw struct {
 		Fields map[string]struct {
-			Type   string   `json:"type"`
-			Multi  bool     `json:"multi,omitempty"`
-			Weight *float64 `json:"weight,omitempty"`
+			Type       string   `json:"type"`
+			Multi      bool     `json:"multi,omitempty"`
+			Weight     *float64 `json:"weight,omitempty"`
+			CaseFold   bool     `json:"case_fold,omitempty"`
+			Required   bool     `json:"required,omitempty"`
+			Normalizer string   `json:"normalizer,omitempty"`
+			FullText   bool     `json:"full_text,omitempty"`
 		} `json:"fields"`
+		FTSTokenizer string            `json:"fts_tokenizer,omitempty"`
+		Strict       bool              `json:"strict,omitempty"`
+		Aliases      map[string]string `json:"aliases,omitempty"`
 	}
 	if err := json.Unmarshal(schemaJSON, &raw); err != nil {
 		return nil, fmt.Errorf("parse schema: %w", err)
@@ -180,14 +441,17 @@ func parseSchema(schemaJSON []byte) (storage.Schema, error) {
 
 	fields := make(map[string]fieldSpec, len(raw.Fields))
 	for name, spec := range raw.Fields {
-		fields[name] = fieldSpec{Type: spec.Type, Multi: spec.Multi, Weight: spec.Weight}
+		fields[name] = fieldSpec{Type: spec.Type, Multi: spec.Multi, Weight: spec.Weight, CaseFold: spec.CaseFold, Required: spec.Required, Normalizer: spec.Normalizer, FullText: spec.FullText}
 	}
-	return &parsedSchema{data: schemaJSON, fields: fields}, nil
+	return &parsedSchema{data: schemaJSON, fields: fields, tokenizer: raw.FTSTokenizer, strict: raw.Strict, aliases: raw.Aliases}, nil
 }
 
 type parsedSchema struct {
-	data   []byte
-	fields map[string]fieldSpec
+	data      []byte
+	fields    map[string]fieldSpec
+	tokenizer string
+	strict    bool
+	aliases   map[string]string
 }
 
 func (s *parsedSchema) ToJSON() ([]byte, error) { return s.data, nil }
@@ -195,7 +459,7 @@ func (s *parsedSchema) ToJSON() ([]byte, error) { return s.data, nil }
 func (s *parsedSchema) TextFieldsInOrder() []storage.TextField {
 	var names []string
 	for name, spec := range s.fields {
-		if spec.Type == "text" {
+		if spec.Type == "text" || (spec.Type == "keyword" && spec.FullText) {
 			names = append(names, name)
 		}
 	}
@@ -220,9 +484,13 @@ func (s *parsedSchema) Get(name string) (storage.FieldSpec, bool) {
 		return storage.FieldSpec{}, false
 	}
 	return storage.FieldSpec{
-		Type:   storage.FieldType(spec.Type),
-		Multi:  spec.Multi,
-		Weight: spec.Weight,
+		Type:       storage.FieldType(spec.Type),
+		Multi:      spec.Multi,
+		Weight:     spec.Weight,
+		CaseFold:   spec.CaseFold,
+		Required:   spec.Required,
+		Normalizer: spec.Normalizer,
+		FullText:   spec.FullText,
 	}, true
 }
 
@@ -230,3 +498,54 @@ func (s *parsedSchema) HasField(name string) bool {
 	_, ok := s.fields[name]
 	return ok
 }
+
+func (s *parsedSchema) FTSTokenizer() string { return s.tokenizer }
+
+func (s *parsedSchema) Strict() bool { return s.strict }
+
+func (s *parsedSchema) Aliases() map[string]string { return s.aliases }
+
+func (s *parsedSchema) RequiredFields() []string {
+	var names []string
+	for name, spec := range s.fields {
+		if spec.Required {
+			names = append(names, name)
+		}
+	}
+	sqlbuilder.SortStrings(names)
+	return names
+}
+
+func (s *parsedSchema) KeywordFields() []string {
+	var names []string
+	for name, spec := range s.fields {
+		if spec.Type == "keyword" {
+			names = append(names, name)
+		}
+	}
+	sqlbuilder.SortStrings(names)
+	return names
+}
+
+// DiskSize sums pg_total_relation_size, which includes indexes and TOAST,
+// over the tables of the index schema
+func (a *Adapter) DiskSize(ctx context.Context, db *sql.DB, tables []string) (int64, map[string]int64, error) {
+	var total int64
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(SUM(pg_total_relation_size(c.oid)), 0)::bigint
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relkind IN ('r', 'p')`, a.Schema).Scan(&total)
+	if err != nil {
+		return 0, nil, fmt.Errorf("schema size: %w", err)
+	}
+	perTable := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		var size sql.NullInt64
+		if err := db.QueryRowContext(ctx, "SELECT pg_total_relation_size(to_regclass($1))", quoteIdent(a.Schema)+"."+quoteIdent(t)).Scan(&size); err != nil {
+			return 0, nil, fmt.Errorf("size of %s: %w", t, err)
+		}
+		if size.Valid {
+			perTable[t] = size.Int64
+		}
+	}
+	return total, perTable, nil
+}
@@ -1,5 +1,40 @@
 package postgres
 
+// ddlUpgrades brings indexes created by older versions up to the current base
+// schema. Statements are idempotent and applied on open.
+var ddlUpgrades = []string{
+	"ALTER TABLE kw_dict ADD COLUMN IF NOT EXISTS value_folded TEXT",
+	"CREATE INDEX IF NOT EXISTS idx_kw_dict_folded ON kw_dict(field, value_folded)",
+	`CREATE TABLE IF NOT EXISTS saved_query (
+  name         TEXT PRIMARY KEY,
+  query        TEXT NOT NULL,
+  options_json TEXT NOT NULL,
+  created_at   BIGINT NOT NULL,
+  updated_at   BIGINT NOT NULL
+)`,
+	"ALTER TABLE items ADD COLUMN IF NOT EXISTS deleted_at BIGINT",
+	`CREATE TABLE IF NOT EXISTS field_geo (
+  item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+  field   TEXT   NOT NULL,
+  lat     DOUBLE PRECISION NOT NULL,
+  lon     DOUBLE PRECISION NOT NULL,
+  x       DOUBLE PRECISION NOT NULL,
+  y       DOUBLE PRECISION NOT NULL,
+  z       DOUBLE PRECISION NOT NULL,
+  PRIMARY KEY (item_id, field, lat, lon)
+)`,
+	"CREATE INDEX IF NOT EXISTS idx_geo_lookup ON field_geo(field, lat, lon)",
+	"ALTER TABLE items ADD COLUMN IF NOT EXISTS data_enc TEXT",
+}
+
+// ddlFuzzy enables levenshtein_less_equal for fuzzy keyword matching. It is
+// used instead of pg_trgm's % operator, which matches by trigram similarity
+// and ignores the edit-distance bound: short terms one edit apart (rust,
+// bust) share too few trigrams to match. Creating the extension is best
+// effort; without it fuzzy terms are resolved client-side with the same
+// results (see detectEditDistance).
+const ddlFuzzy = "CREATE EXTENSION IF NOT EXISTS fuzzystrmatch"
+
 const ddlBase = `
 CREATE TABLE IF NOT EXISTS meta (
   key   TEXT PRIMARY KEY,
@@ -10,8 +45,10 @@ CREATE TABLE IF NOT EXISTS items (
   id         BIGSERIAL PRIMARY KEY,
   path       TEXT UNIQUE NOT NULL,
   data_json  JSONB NOT NULL,
+  data_enc   TEXT, -- always NULL: JSONB documents are not compressed
   created_at BIGINT NOT NULL,
-  updated_at BIGINT NOT NULL
+  updated_at BIGINT NOT NULL,
+  deleted_at BIGINT
 );
 CREATE INDEX IF NOT EXISTS idx_items_path    ON items(path);
 CREATE INDEX IF NOT EXISTS idx_items_updated ON items(updated_at);
@@ -28,10 +65,12 @@ CREATE TABLE IF NOT EXISTS kw_dict (
   id       BIGSERIAL PRIMARY KEY,
   field    TEXT NOT NULL,
   value    TEXT NOT NULL,
+  value_folded TEXT,
   doc_freq BIGINT NOT NULL DEFAULT 0,
   UNIQUE (field, value)
 );
 CREATE INDEX IF NOT EXISTS idx_kw_dict_lookup ON kw_dict(field, value);
+CREATE INDEX IF NOT EXISTS idx_kw_dict_folded ON kw_dict(field, value_folded);
 
 CREATE TABLE IF NOT EXISTS kw_postings (
   field    TEXT NOT NULL,
@@ -66,6 +105,19 @@ CREATE TABLE IF NOT EXISTS field_bool (
 );
 CREATE INDEX IF NOT EXISTS idx_bool_lookup ON field_bool(field, value);
 
+-- x, y, z is the point on the unit sphere; see the SQLite DDL
+CREATE TABLE IF NOT EXISTS field_geo (
+  item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+  field   TEXT   NOT NULL,
+  lat     DOUBLE PRECISION NOT NULL,
+  lon     DOUBLE PRECISION NOT NULL,
+  x       DOUBLE PRECISION NOT NULL,
+  y       DOUBLE PRECISION NOT NULL,
+  z       DOUBLE PRECISION NOT NULL,
+  PRIMARY KEY (item_id, field, lat, lon)
+);
+CREATE INDEX IF NOT EXISTS idx_geo_lookup ON field_geo(field, lat, lon);
+
 CREATE TABLE IF NOT EXISTS cursor_store (
   handle     TEXT PRIMARY KEY,
   payload    TEXT NOT NULL,
@@ -73,4 +125,12 @@ CREATE TABLE IF NOT EXISTS cursor_store (
   expires_at BIGINT NOT NULL
 );
 CREATE INDEX IF NOT EXISTS idx_cursor_expires ON cursor_store(expires_at);
+
+CREATE TABLE IF NOT EXISTS saved_query (
+  name         TEXT PRIMARY KEY,
+  query        TEXT NOT NULL,
+  options_json TEXT NOT NULL,
+  created_at   BIGINT NOT NULL,
+  updated_at   BIGINT NOT NULL
+);
 `
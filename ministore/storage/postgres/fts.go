@@ -4,18 +4,41 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/ministore/ministore/ministore/storage"
 )
 
-type FTS struct{}
+// FTS implements storage.FTS with tsvector columns. Config is the regconfig
+// name shared by indexing and querying; the adapter validates it.
+type FTS struct {
+	Config string
+}
+
+func (f FTS) config() string {
+	if f.Config == "" {
+		return DefaultTextSearchConfig
+	}
+	return f.Config
+}
 
 func (f FTS) HasFTS(schema storage.Schema) bool {
 	return len(schema.TextFieldsInOrder()) > 0
 }
 
 func (f FTS) CreateFTS(ctx context.Context, db *sql.DB, schema storage.Schema) error {
+	for _, stmt := range createFTSStatements(schema) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("create search table: %w", err)
+		}
+	}
+	return nil
+}
+
+// createFTSStatements returns the statements creating the search table and
+// its GIN indexes for schema's text fields
+func createFTSStatements(schema storage.Schema) []string {
 	fields := schema.TextFieldsInOrder()
 	if len(fields) == 0 {
 		return nil
@@ -27,19 +50,15 @@ func (f FTS) CreateFTS(ctx context.Context, db *sql.DB, schema storage.Schema) e
 		cols = append(cols, fmt.Sprintf("%s TSVECTOR NOT NULL DEFAULT ''::tsvector", tf.Name))
 	}
 
-	createSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS search (%s)", strings.Join(cols, ", "))
-	if _, err := db.ExecContext(ctx, createSQL); err != nil {
-		return fmt.Errorf("create search table: %w", err)
-	}
-
+	stmts := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS search (%s)", strings.Join(cols, ", "))}
 	for _, tf := range fields {
-		idx := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_search_%s ON search USING GIN(%s)", tf.Name, tf.Name)
-		if _, err := db.ExecContext(ctx, idx); err != nil {
-			return fmt.Errorf("create gin index for %s: %w", tf.Name, err)
-		}
+		stmts = append(stmts, ginIndexSQL(tf.Name))
 	}
+	return stmts
+}
 
-	return nil
+func ginIndexSQL(field string) string {
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_search_%s ON search USING GIN(%s)", field, field)
 }
 
 func (f FTS) VerifyFTS(ctx context.Context, db *sql.DB, schema storage.Schema) error {
@@ -67,31 +86,88 @@ func (f FTS) VerifyFTS(ctx context.Context, db *sql.DB, schema storage.Schema) e
 	return nil
 }
 
-func (f FTS) AddTextColumns(ctx context.Context, db *sql.DB, old, new storage.Schema) error {
+func (f FTS) AddTextColumnsDDL(old, new storage.Schema) ([]string, error) {
+	// If old had no FTS but new does, create full FTS table.
+	if len(old.TextFieldsInOrder()) == 0 {
+		return createFTSStatements(new), nil
+	}
+
 	oldFields := map[string]bool{}
 	for _, tf := range old.TextFieldsInOrder() {
 		oldFields[tf.Name] = true
 	}
-
-	// If old had no FTS but new does, create full FTS table.
-	if len(old.TextFieldsInOrder()) == 0 && len(new.TextFieldsInOrder()) > 0 {
-		return f.CreateFTS(ctx, db, new)
-	}
-
+	var stmts []string
 	for _, tf := range new.TextFieldsInOrder() {
 		if oldFields[tf.Name] {
 			continue
 		}
-		alter := fmt.Sprintf("ALTER TABLE search ADD COLUMN %s TSVECTOR NOT NULL DEFAULT ''::tsvector", tf.Name)
-		if _, err := db.ExecContext(ctx, alter); err != nil {
-			return fmt.Errorf("alter search add column %s: %w", tf.Name, err)
+		stmts = append(stmts,
+			fmt.Sprintf("ALTER TABLE search ADD COLUMN %s TSVECTOR NOT NULL DEFAULT ''::tsvector", tf.Name),
+			ginIndexSQL(tf.Name),
+		)
+	}
+
+	// A weight change can move a field to another class; setweight relabels
+	// the stored vectors without reparsing the documents
+	oldClasses, newClasses := weightClasses(old), weightClasses(new)
+	for _, tf := range new.TextFieldsInOrder() {
+		if oldFields[tf.Name] && oldClasses[tf.Name] != newClasses[tf.Name] {
+			stmts = append(stmts, fmt.Sprintf("UPDATE search SET %s = setweight(%s, '%c')", tf.Name, tf.Name, newClasses[tf.Name]))
 		}
-		idx := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_search_%s ON search USING GIN(%s)", tf.Name, tf.Name)
-		if _, err := db.ExecContext(ctx, idx); err != nil {
-			return fmt.Errorf("create gin index for %s: %w", tf.Name, err)
+	}
+	return stmts, nil
+}
+
+// defaultRankWeights are ts_rank's own weights for labels D, C, B and A
+var defaultRankWeights = [4]float64{0.1, 0.2, 0.4, 1.0}
+
+// weightClasses assigns each text field a tsvector weight label: the
+// largest schema weight gets 'A', the next distinct weight 'B', the next
+// 'C', and all smaller weights 'D'
+func weightClasses(schema storage.Schema) map[string]byte {
+	var distinct []float64
+	for _, tf := range schema.TextFieldsInOrder() {
+		if !slices.Contains(distinct, tf.Weight) {
+			distinct = append(distinct, tf.Weight)
 		}
 	}
-	return nil
+	slices.Sort(distinct)
+	slices.Reverse(distinct)
+
+	classes := map[string]byte{}
+	for _, tf := range schema.TextFieldsInOrder() {
+		rank := min(slices.Index(distinct, tf.Weight), 3)
+		classes[tf.Name] = "ABCD"[rank]
+	}
+	return classes
+}
+
+// weightArray returns the ts_rank_cd weights literal, ordered {D,C,B,A}.
+// ts_rank rejects weights above 1, so each class weighs its largest field
+// weight divided by the schema's largest; unused classes keep the default.
+func weightArray(schema storage.Schema) string {
+	top := 0.0
+	for _, tf := range schema.TextFieldsInOrder() {
+		top = max(top, tf.Weight)
+	}
+	var classMax [4]float64 // indexed like the literal: D, C, B, A
+	var used [4]bool
+	classes := weightClasses(schema)
+	for _, tf := range schema.TextFieldsInOrder() {
+		i := int('D' - classes[tf.Name])
+		classMax[i] = max(classMax[i], tf.Weight)
+		used[i] = true
+	}
+
+	parts := make([]string, len(classMax))
+	for i := range classMax {
+		w := defaultRankWeights[i]
+		if used[i] && top > 0 {
+			w = classMax[i] / top
+		}
+		parts[i] = fmt.Sprintf("%g", w)
+	}
+	return "'{" + strings.Join(parts, ",") + "}'::float4[]"
 }
 
 func (f FTS) DeleteRow(ctx context.Context, tx *sql.Tx, itemID int64) error {
@@ -103,6 +179,16 @@ func (f FTS) DeleteRow(ctx context.Context, tx *sql.Tx, itemID int64) error {
 	return err
 }
 
+func (f FTS) DeleteAll(ctx context.Context, tx *sql.Tx, schema storage.Schema) error {
+	if !f.HasFTS(schema) {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM search"); err != nil {
+		return fmt.Errorf("clear fts table: %w", err)
+	}
+	return nil
+}
+
 func (f FTS) UpsertRow(ctx context.Context, tx *sql.Tx, itemID int64, schema storage.Schema, textVals map[string]*string) error {
 	fields := schema.TextFieldsInOrder()
 	if len(fields) == 0 {
@@ -118,11 +204,12 @@ func (f FTS) UpsertRow(ctx context.Context, tx *sql.Tx, itemID int64, schema sto
 	vals = append(vals, "$1")
 	args = append(args, itemID)
 
+	classes := weightClasses(schema)
 	for i, tf := range fields {
 		cols = append(cols, tf.Name)
 		ph := fmt.Sprintf("$%d", i+2)
-		// Compute vector inside SQL
-		vals = append(vals, fmt.Sprintf("to_tsvector('simple', %s)", ph))
+		// Compute vector inside SQL, labelled with the field's weight class
+		vals = append(vals, fmt.Sprintf("setweight(to_tsvector('%s', %s), '%c')", f.config(), ph, classes[tf.Name]))
 		sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", tf.Name, tf.Name))
 
 		v := textVals[tf.Name]
@@ -148,7 +235,7 @@ func (f FTS) UpsertRow(ctx context.Context, tx *sql.Tx, itemID int64, schema sto
 }
 
 func (f FTS) CompileTextPredicate(b storage.Builder, schema storage.Schema, pred storage.TextPredicate) (string, []any, error) {
-	tsq := tsQueryExpr(b, pred.Query)
+	tsq := f.tsQueryExpr(b, pred)
 	cond, err := matchCond(schema, pred, tsq)
 	if err != nil {
 		return "", nil, err
@@ -156,15 +243,18 @@ func (f FTS) CompileTextPredicate(b storage.Builder, schema storage.Schema, pred
 	return fmt.Sprintf("SELECT item_id FROM search WHERE %s", cond), nil, nil
 }
 
+// CompileTextPresent matches rows with a non-empty tsvector, so a value of
+// only stop words counts as empty
+func (f FTS) CompileTextPresent(field string) string {
+	return fmt.Sprintf("SELECT item_id FROM search WHERE %s <> ''::tsvector", field)
+}
+
 func (f FTS) ScoreCTEsAndJoin(b storage.Builder, schema storage.Schema, preds []storage.TextPredicate) ([]storage.CTE, string, string, error) {
 	if len(preds) == 0 {
 		return nil, "", "NULL", nil
 	}
 
-	weights := map[string]float64{}
-	for _, tf := range schema.TextFieldsInOrder() {
-		weights[tf.Name] = tf.Weight
-	}
+	weights := weightArray(schema)
 
 	var ctes []storage.CTE
 	var joins []string
@@ -172,7 +262,7 @@ func (f FTS) ScoreCTEsAndJoin(b storage.Builder, schema storage.Schema, preds []
 
 	for i, p := range preds {
 		name := fmt.Sprintf("fts_score_%d", i)
-		tsq := tsQueryExpr(b, p.Query)
+		tsq := f.tsQueryExpr(b, p)
 		cond, err := matchCond(schema, p, tsq)
 		if err != nil {
 			return nil, "", "", err
@@ -183,24 +273,85 @@ func (f FTS) ScoreCTEsAndJoin(b storage.Builder, schema storage.Schema, preds []
 			return nil, "", "", err
 		}
 
+		// ts_rank_cd returns real; scores are double precision as with
+		// SQLite's bm25, so a cursor's float64 score compares equal to the
+		// row it was read from and item_id decides ties
 		ctes = append(ctes, storage.CTE{
 			Name: name,
-			SQL:  fmt.Sprintf("SELECT item_id, (%s) AS score FROM search WHERE %s", scoreExpr, cond),
+			SQL:  fmt.Sprintf("SELECT item_id, CAST((%s) AS DOUBLE PRECISION) AS score FROM search WHERE %s", scoreExpr, cond),
 		})
 		joins = append(joins, fmt.Sprintf("LEFT JOIN %s ON %s.item_id = i.id", name, name))
-		scoreParts = append(scoreParts, fmt.Sprintf("COALESCE(%s.score, 0)", name))
+		part := fmt.Sprintf("COALESCE(%s.score, 0)", name)
+		if boost := p.BoostFactor(); boost != 1 {
+			part = fmt.Sprintf("%s * %g", part, boost)
+		}
+		scoreParts = append(scoreParts, part)
 	}
 
 	return ctes, strings.Join(joins, "\n  "), strings.Join(scoreParts, " + "), nil
 }
 
-func tsQueryExpr(b storage.Builder, q string) string {
+func (f FTS) HighlightCTEsAndCols(b storage.Builder, schema storage.Schema, preds []storage.TextPredicate, spec storage.HighlightSpec) ([]storage.CTE, string, []storage.HighlightCol, error) {
+	fields := storage.HighlightFields(schema, preds)
+	if len(fields) == 0 {
+		return nil, "", nil, nil
+	}
+
+	// search only holds tsvectors, so headlines are built from the stored document
+	tsqs := make([]string, 0, len(preds))
+	for _, p := range preds {
+		tsqs = append(tsqs, f.tsQueryExpr(b, p))
+	}
+	tsq := strings.Join(tsqs, " || ")
+
+	maxWords := max(spec.Tokens, 2)
+	opts := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxWords=%d, MinWords=%d, FragmentDelimiter=" ... "`,
+		strings.ReplaceAll(spec.Open, `"`, `""`), strings.ReplaceAll(spec.Close, `"`, `""`), maxWords, maxWords/2)
+	if spec.Whole {
+		opts = fmt.Sprintf(`StartSel="%s", StopSel="%s", HighlightAll=true`,
+			strings.ReplaceAll(spec.Open, `"`, `""`), strings.ReplaceAll(spec.Close, `"`, `""`))
+	}
+	phOpts := b.Arg(opts)
+
+	cols := make([]storage.HighlightCol, 0, len(fields))
+	for _, name := range fields {
+		phName := b.Arg(name)
+		cols = append(cols, storage.HighlightCol{
+			Field: name,
+			Expr:  fmt.Sprintf("ts_headline('%s', i.data_json ->> %s, %s, %s)", f.config(), phName, tsq, phOpts),
+		})
+	}
+	return nil, "", cols, nil
+}
+
+func (f FTS) tsQueryExpr(b storage.Builder, pred storage.TextPredicate) string {
+	if len(pred.AnyOf) > 0 {
+		parts := make([]string, len(pred.AnyOf))
+		for i, t := range pred.AnyOf {
+			parts[i] = f.tsQueryExpr(b, storage.TextPredicate{Query: t})
+		}
+		return fmt.Sprintf("(%s)", strings.Join(parts, " || "))
+	}
+	if len(pred.Near) > 0 {
+		// tsquery_phrase(a, b, n) is a <n> b
+		expr := f.tsQueryExpr(b, storage.TextPredicate{Query: pred.Near[0]})
+		for _, t := range pred.Near[1:] {
+			expr = fmt.Sprintf("tsquery_phrase(%s, %s, %d)", expr, f.tsQueryExpr(b, storage.TextPredicate{Query: t}), pred.NearDistance)
+		}
+		return expr
+	}
+
+	q := pred.Query
 	ph := b.Arg(q)
+	if pred.Prefix {
+		// quote_literal keeps tsquery operators in q from being interpreted
+		return fmt.Sprintf("to_tsquery('%s', quote_literal(%s) || ':*')", f.config(), ph)
+	}
 	// Phrase queries if whitespace, otherwise plain.
 	if strings.IndexFunc(q, func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' || r == '\r' }) >= 0 {
-		return fmt.Sprintf("phraseto_tsquery('simple', %s)", ph)
+		return fmt.Sprintf("phraseto_tsquery('%s', %s)", f.config(), ph)
 	}
-	return fmt.Sprintf("plainto_tsquery('simple', %s)", ph)
+	return fmt.Sprintf("plainto_tsquery('%s', %s)", f.config(), ph)
 }
 
 func matchCond(schema storage.Schema, pred storage.TextPredicate, tsq string) (string, error) {
@@ -209,36 +360,64 @@ func matchCond(schema storage.Schema, pred storage.TextPredicate, tsq string) (s
 		if !ok {
 			return "", fmt.Errorf("unknown field: %s", *pred.Field)
 		}
-		if spec.Type != storage.FieldType("text") {
+		if !spec.HasFTS() {
 			return "", fmt.Errorf("FTS predicate used on non-text field %s", *pred.Field)
 		}
 		return fmt.Sprintf("search.%s @@ %s", *pred.Field, tsq), nil
 	}
 
-	fields := schema.TextFieldsInOrder()
-	if len(fields) == 0 {
-		return "", fmt.Errorf("no text fields in schema for bare text query")
+	names, err := predFieldNames(schema, pred)
+	if err != nil {
+		return "", err
 	}
-	parts := make([]string, 0, len(fields))
-	for _, tf := range fields {
-		parts = append(parts, fmt.Sprintf("search.%s @@ %s", tf.Name, tsq))
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("search.%s @@ %s", name, tsq))
 	}
 	return fmt.Sprintf("(%s)", strings.Join(parts, " OR ")), nil
 }
 
-func rankExpr(schema storage.Schema, weights map[string]float64, pred storage.TextPredicate, tsq string) (string, error) {
+func rankExpr(schema storage.Schema, weights string, pred storage.TextPredicate, tsq string) (string, error) {
 	if pred.Field != nil {
-		w := weights[*pred.Field]
-		return fmt.Sprintf("(%g * ts_rank_cd(search.%s, %s))", w, *pred.Field, tsq), nil
+		return fmt.Sprintf("ts_rank_cd(%s, search.%s, %s)", weights, *pred.Field, tsq), nil
 	}
+	if len(pred.Fields) == 0 && len(schema.TextFieldsInOrder()) == 0 {
+		return "0", nil
+	}
+	names, err := predFieldNames(schema, pred)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("ts_rank_cd(%s, search.%s, %s)", weights, name, tsq))
+	}
+	return strings.Join(parts, " + "), nil
+}
+
+// predFieldNames returns the text columns a bare (unfielded) predicate searches:
+// pred.Fields if given, otherwise every text field in the schema
+func predFieldNames(schema storage.Schema, pred storage.TextPredicate) ([]string, error) {
+	if len(pred.Fields) > 0 {
+		for _, name := range pred.Fields {
+			spec, ok := schema.Get(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown field: %s", name)
+			}
+			if !spec.HasFTS() {
+				return nil, fmt.Errorf("FTS predicate used on non-text field %s", name)
+			}
+		}
+		return pred.Fields, nil
+	}
+
 	fields := schema.TextFieldsInOrder()
 	if len(fields) == 0 {
-		return "0", nil
+		return nil, storage.ErrNoTextFields
 	}
-	parts := make([]string, 0, len(fields))
+	names := make([]string, 0, len(fields))
 	for _, tf := range fields {
-		w := weights[tf.Name]
-		parts = append(parts, fmt.Sprintf("(%g * ts_rank_cd(search.%s, %s))", w, tf.Name, tsq))
+		names = append(names, tf.Name)
 	}
-	return strings.Join(parts, " + "), nil
+	return names, nil
 }
@@ -6,7 +6,9 @@ type upsertItem struct {
 	withTimestamps bool
 }
 
-func (u upsertItem) Build(path string, dataJSON []byte, createdAtMS, updatedAtMS int64, nowMode bool) (string, []any) {
+// Documents are stored as JSONB and never encoded, so dataEnc is ignored and
+// data_enc stays NULL
+func (u upsertItem) Build(path string, dataJSON []byte, dataEnc string, createdAtMS, updatedAtMS int64, nowMode bool) (string, []any) {
 	c := createdAtMS
 	uMs := updatedAtMS
 	if nowMode {
@@ -19,7 +21,8 @@ func (u upsertItem) Build(path string, dataJSON []byte, createdAtMS, updatedAtMS
 		        ON CONFLICT(path) DO UPDATE
 		          SET data_json=EXCLUDED.data_json,
 		              created_at=EXCLUDED.created_at,
-		              updated_at=EXCLUDED.updated_at
+		              updated_at=EXCLUDED.updated_at,
+		              deleted_at=NULL
 		        RETURNING id, created_at`
 		return sql, []any{path, dataJSON, c, uMs}
 	}
@@ -28,19 +31,48 @@ func (u upsertItem) Build(path string, dataJSON []byte, createdAtMS, updatedAtMS
 	        VALUES($1, $2::jsonb, $3, $4)
 	        ON CONFLICT(path) DO UPDATE
 	          SET data_json=EXCLUDED.data_json,
-	              updated_at=EXCLUDED.updated_at
+	              updated_at=EXCLUDED.updated_at,
+	              deleted_at=NULL
 	        RETURNING id, created_at`
 	return sql, []any{path, dataJSON, c, uMs}
 }
 
+type upsertItemIfUpdatedAt struct{}
+
+func (upsertItemIfUpdatedAt) Build(path string, dataJSON []byte, dataEnc string, nowMS, expectedUpdatedAtMS int64) (string, []any) {
+	// A missing row is only inserted when the caller expects none ($4 = 0);
+	// a tombstone is never resurrected
+	sql := `INSERT INTO items(path, data_json, created_at, updated_at)
+	        SELECT $1, $2::jsonb, $3::bigint, $3::bigint
+	        WHERE $4::bigint = 0 OR EXISTS (SELECT 1 FROM items WHERE path = $1)
+	        ON CONFLICT(path) DO UPDATE
+	          SET data_json=EXCLUDED.data_json,
+	              updated_at=EXCLUDED.updated_at
+	          WHERE items.updated_at = $4 AND items.deleted_at IS NULL
+	        RETURNING id, created_at`
+	return sql, []any{path, dataJSON, nowMS, expectedUpdatedAtMS}
+}
+
 var SQLTemplates = storage.SQL{
 	GetMeta:                   "SELECT value FROM meta WHERE key = $1",
 	SetMeta:                   "INSERT INTO meta(key,value) VALUES($1,$2) ON CONFLICT(key) DO UPDATE SET value=EXCLUDED.value",
 	FindItemIDByPath:          "SELECT id, created_at FROM items WHERE path = $1",
-	GetItemByPath:             "SELECT id, data_json, created_at, updated_at FROM items WHERE path = $1",
-	CleanupExpiredCursors:     "DELETE FROM cursor_store WHERE expires_at < $1",
+	GetItemByPath:             "SELECT id, data_json, data_enc, created_at, updated_at FROM items WHERE path = $1 AND deleted_at IS NULL",
+	ItemExists:                "SELECT 1 FROM items WHERE path = $1 AND deleted_at IS NULL LIMIT 1",
+	LockItemByPath:            "SELECT data_json, data_enc FROM items WHERE path = $1 AND deleted_at IS NULL FOR UPDATE",
+	GetItemByPathForUpdate:    "SELECT id, data_json, data_enc, created_at, updated_at FROM items WHERE path = $1 AND deleted_at IS NULL FOR UPDATE",
+	ListItemsAfterID:          "SELECT id, path, data_json, data_enc, created_at, updated_at, deleted_at FROM items WHERE id > $1 ORDER BY id LIMIT $2",
+	GetItemStateByPath:        "SELECT id, data_json, data_enc, deleted_at FROM items WHERE path = $1",
+	SoftDeleteItem:            "UPDATE items SET deleted_at = $2 WHERE id = $1",
+	RestoreItem:               "UPDATE items SET deleted_at = NULL WHERE id = $1",
+	SetItemData:               "UPDATE items SET data_json = $2::jsonb, data_enc = $3 WHERE id = $1",
+	FindItemsByKeyword:        "SELECT i.id, i.path FROM kw_dict d JOIN kw_postings p ON p.value_id = d.id JOIN items i ON i.id = p.item_id WHERE d.field = $1 AND d.value = $2 AND i.path <> $3",
+	RenameItem:                "UPDATE items SET path = $2 WHERE id = $1",
+	CleanupExpiredCursors:     "DELETE FROM cursor_store WHERE expires_at <= $1",
 	GetCursor:                 "SELECT payload, expires_at FROM cursor_store WHERE handle = $1",
 	PutCursor:                 "INSERT INTO cursor_store(handle, payload, created_at, expires_at) VALUES($1,$2,$3,$4)",
+	UpsertSavedQuery:          "INSERT INTO saved_query(name, query, options_json, created_at, updated_at) VALUES($1, $2, $3, $4, $4) ON CONFLICT(name) DO UPDATE SET query = excluded.query, options_json = excluded.options_json, updated_at = excluded.updated_at",
+	GetSavedQuery:             "SELECT query, options_json FROM saved_query WHERE name = $1",
 	GetValueIDsByItem:         "SELECT value_id FROM kw_postings WHERE item_id = $1",
 	DecrementDocFreq:          "UPDATE kw_dict SET doc_freq = GREATEST(doc_freq - 1, 0) WHERE id = $1",
 	IncrementDocFreq:          "UPDATE kw_dict SET doc_freq = doc_freq + 1 WHERE id = $1",
@@ -50,14 +82,17 @@ var SQLTemplates = storage.SQL{
 	DeleteNumberByItem:        "DELETE FROM field_number WHERE item_id = $1",
 	DeleteDateByItem:          "DELETE FROM field_date WHERE item_id = $1",
 	DeleteBoolByItem:          "DELETE FROM field_bool WHERE item_id = $1",
+	DeleteGeoByItem:           "DELETE FROM field_geo WHERE item_id = $1",
 	DeleteItemsByID:           "DELETE FROM items WHERE id = $1",
-	InsertOrIgnoreKwDict:      "INSERT INTO kw_dict(field, value, doc_freq) VALUES($1, $2, 0) ON CONFLICT(field, value) DO NOTHING",
+	InsertOrIgnoreKwDict:      "INSERT INTO kw_dict(field, value, value_folded, doc_freq) VALUES($1, $2, $3, 0) ON CONFLICT(field, value) DO NOTHING",
 	GetKwDictID:               "SELECT id FROM kw_dict WHERE field = $1 AND value = $2",
 	InsertOrIgnoreKwPosting:   "INSERT INTO kw_postings(field, value_id, item_id) VALUES($1, $2, $3) ON CONFLICT(value_id, item_id) DO NOTHING",
 	InsertFieldPresent:        "INSERT INTO field_present(item_id, field) VALUES($1, $2) ON CONFLICT(item_id, field) DO NOTHING",
 	InsertFieldNumber:         "INSERT INTO field_number(item_id, field, value) VALUES($1, $2, $3)",
 	InsertFieldDate:           "INSERT INTO field_date(item_id, field, value) VALUES($1, $2, $3)",
 	InsertFieldBool:           "INSERT INTO field_bool(item_id, field, value) VALUES($1, $2, $3)",
+	InsertFieldGeo:            "INSERT INTO field_geo(item_id, field, lat, lon, x, y, z) VALUES($1, $2, $3, $4, $5, $6, $7)",
 	UpsertItem:                upsertItem{withTimestamps: false},
 	UpsertItemWithTS:          upsertItem{withTimestamps: true},
+	UpsertItemIfUpdatedAt:     upsertItemIfUpdatedAt{},
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 
@@ -15,6 +16,20 @@ import (
 type Adapter struct {
 	Path       string
 	DriverName string
+	// Immutable adds immutable=1 to read-only connections, letting SQLite
+	// skip locking. Only safe for files nothing else will modify.
+	Immutable bool
+	// Pool limits the connection pool. MaxOpenConns defaults to 1 for
+	// writable connections: SQLite allows one writer at a time, and a second
+	// pooled connection would wait out _busy_timeout (5s) and then fail with
+	// SQLITE_BUSY instead of queueing in database/sql. Read-only connections
+	// keep the database/sql default.
+	Pool storage.PoolConfig
+
+	readOnly bool
+	// ftsColumns is the column order of the search table, read when the
+	// index is created, opened or its schema changes
+	ftsColumns []string
 }
 
 func New(path string) *Adapter {
@@ -37,8 +52,30 @@ func (a *Adapter) IndexID() string {
 	return a.Path
 }
 
+func (a *Adapter) Capabilities() storage.Capabilities {
+	return storage.Capabilities{EditDistance: false, FuzzyKeyword: true}
+}
+
+func (a *Adapter) SetReadOnly(readOnly bool) {
+	a.readOnly = readOnly
+}
+
 func (a *Adapter) Connect(ctx context.Context) (*sql.DB, error) {
 	dsn := a.Path
+	if a.readOnly {
+		// mode and immutable are URI parameters, so the path must be a URI
+		if !strings.HasPrefix(dsn, "file:") {
+			dsn = "file:" + dsn
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&mode=ro"
+		} else {
+			dsn += "?mode=ro"
+		}
+		if a.Immutable {
+			dsn += "&immutable=1"
+		}
+	}
 	if !strings.Contains(dsn, "?") {
 		dsn = dsn + "?_busy_timeout=5000&_foreign_keys=on"
 	} else {
@@ -48,6 +85,11 @@ func (a *Adapter) Connect(ctx context.Context) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	pool := a.Pool
+	if pool.MaxOpenConns == 0 && !a.readOnly {
+		pool.MaxOpenConns = 1
+	}
+	pool.Apply(db)
 	if err := db.PingContext(ctx); err != nil {
 		return nil, err
 	}
@@ -63,13 +105,26 @@ func (a *Adapter) SQL() storage.SQL {
 }
 
 func (a *Adapter) FTS() storage.FTS {
-	return FTS5{}
+	return FTS5{Columns: a.ftsColumns}
+}
+
+// loadFTSColumns records the column order of the search table
+func (a *Adapter) loadFTSColumns(ctx context.Context, db *sql.DB) error {
+	cols, err := readFTSColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+	a.ftsColumns = cols
+	return nil
 }
 
 func (a *Adapter) CreateIndex(ctx context.Context, db *sql.DB, schemaJSON []byte) error {
 	if _, err := db.ExecContext(ctx, ddlBase); err != nil {
 		return err
 	}
+	if _, err := db.ExecContext(ctx, ddlItemIDHigh); err != nil {
+		return err
+	}
 	_, _ = db.ExecContext(ctx, "PRAGMA journal_mode=WAL;")
 	_, _ = db.ExecContext(ctx, "PRAGMA synchronous=NORMAL;")
 	_, _ = db.ExecContext(ctx, "PRAGMA foreign_keys=ON;")
@@ -95,7 +150,7 @@ func (a *Adapter) CreateIndex(ctx context.Context, db *sql.DB, schemaJSON []byte
 			return err
 		}
 	}
-	return nil
+	return a.loadFTSColumns(ctx, db)
 }
 
 func (a *Adapter) OpenIndex(ctx context.Context, db *sql.DB) ([]byte, error) {
@@ -111,6 +166,14 @@ func (a *Adapter) OpenIndex(ctx context.Context, db *sql.DB) ([]byte, error) {
 	if err := db.QueryRowContext(ctx, sqlt.GetMeta, "schema_json").Scan(&schemaStr); err != nil {
 		return nil, err
 	}
+	if a.readOnly {
+		return []byte(schemaStr), nil
+	}
+	for _, stmt := range ddlUpgrades {
+		if _, err := db.ExecContext(ctx, stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			return nil, fmt.Errorf("upgrade index: %w", err)
+		}
+	}
 	return []byte(schemaStr), nil
 }
 
@@ -118,43 +181,135 @@ func (a *Adapter) VerifyFTS(ctx context.Context, db *sql.DB, schema storage.Sche
 	if !a.FTS().HasFTS(schema) {
 		return nil
 	}
-	return a.FTS().VerifyFTS(ctx, db, schema)
+	if err := a.FTS().VerifyFTS(ctx, db, schema); err != nil {
+		return err
+	}
+	return a.loadFTSColumns(ctx, db)
 }
 
 func (a *Adapter) ApplySchemaAdditive(ctx context.Context, db *sql.DB, old, new storage.Schema) error {
-	if a.FTS().HasFTS(new) {
-		if err := a.FTS().AddTextColumns(ctx, db, old, new); err != nil {
-			return err
-		}
+	stmts, err := a.ApplySchemaDDL(old, new)
+	if err != nil {
+		return err
 	}
 	b, err := new.ToJSON()
 	if err != nil {
 		return err
 	}
-	_, err = db.ExecContext(ctx, a.SQL().SetMeta, "schema_json", string(b))
-	return err
+	// Appending FTS columns copies the table in several statements
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("alter fts: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, a.SQL().SetMeta, "schema_json", string(b)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return a.loadFTSColumns(ctx, db)
+}
+
+func (a *Adapter) ApplySchemaDDL(old, new storage.Schema) ([]string, error) {
+	return a.FTS().AddTextColumnsDDL(old, new)
+}
+
+// Optimize merges the FTS5 index b-trees, optionally vacuums, refreshes the
+// query planner statistics and truncates the WAL file, which otherwise only
+// shrinks when the last connection closes
+func (a *Adapter) Optimize(ctx context.Context, db *sql.DB, schema storage.Schema, opts storage.OptimizeOptions) error {
+	var stmts []string
+	if a.FTS().HasFTS(schema) {
+		stmts = append(stmts, "INSERT INTO search(search) VALUES('optimize')")
+	}
+	if opts.Vacuum {
+		stmts = append(stmts, "VACUUM")
+	}
+	stmts = append(stmts, "ANALYZE", "PRAGMA optimize", "PRAGMA wal_checkpoint(TRUNCATE)")
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// MergeFTS runs FTS5's incremental merge. Unlike 'optimize', which merges
+// every segment into one in a single statement, 'merge' stops after about
+// pages pages, so each call holds the write lock briefly. FTS5 reports no
+// result; a total_changes() delta below 2 on the same connection means the
+// merge found nothing to do.
+func (a *Adapter) MergeFTS(ctx context.Context, db *sql.DB, schema storage.Schema, pages int) (bool, error) {
+	if !a.FTS().HasFTS(schema) {
+		return true, nil
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var before, after int64
+	if err := conn.QueryRowContext(ctx, "SELECT total_changes()").Scan(&before); err != nil {
+		return false, fmt.Errorf("total_changes: %w", err)
+	}
+	stmt := "INSERT INTO search(search, rank) VALUES('merge', ?1)"
+	if _, err := conn.ExecContext(ctx, stmt, pages); err != nil {
+		return false, fmt.Errorf("%s: %w", stmt, err)
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT total_changes()").Scan(&after); err != nil {
+		return false, fmt.Errorf("total_changes: %w", err)
+	}
+	return after-before < 2, nil
 }
 
-func (a *Adapter) Optimize(ctx context.Context, db *sql.DB) error {
-	_, _ = db.ExecContext(ctx, "INSERT INTO search(search) VALUES('optimize')")
-	_, _ = db.ExecContext(ctx, "VACUUM")
+// Snapshot copies the database with VACUUM INTO, which reads one consistent
+// snapshot (WAL contents included) and writes a compacted file that needs
+// no -wal or -shm companions. dst must not exist.
+func (a *Adapter) Snapshot(ctx context.Context, db *sql.DB, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("snapshot destination %s already exists", dst)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	stmt := "VACUUM INTO ?1"
+	if _, err := db.ExecContext(ctx, stmt, dst); err != nil {
+		return fmt.Errorf("%s: %w", stmt, err)
+	}
 	return nil
 }
 
 type fieldSpec struct {
-	Type   string
-	Multi  bool
-	Weight *float64
+	Type       string
+	Multi      bool
+	Weight     *float64
+	CaseFold   bool
+	Required   bool
+	Normalizer string
+	FullText   bool
 }
 
 // parseSchema parses schema JSON and returns a storage.Schema compatible wrapper
 func parseSchema(schemaJSON []byte) (storage.Schema, error) {
 	var rawSchema struct {
 		Fields map[string]struct {
-			Type   string   `json:"type"`
-			Multi  bool     `json:"multi,omitempty"`
-			Weight *float64 `json:"weight,omitempty"`
+			Type       string   `json:"type"`
+			Multi      bool     `json:"multi,omitempty"`
+			Weight     *float64 `json:"weight,omitempty"`
+			CaseFold   bool     `json:"case_fold,omitempty"`
+			Required   bool     `json:"required,omitempty"`
+			Normalizer string   `json:"normalizer,omitempty"`
+			FullText   bool     `json:"full_text,omitempty"`
 		} `json:"fields"`
+		FTSTokenizer string            `json:"fts_tokenizer,omitempty"`
+		Strict       bool              `json:"strict,omitempty"`
+		Aliases      map[string]string `json:"aliases,omitempty"`
 	}
 
 	if err := json.Unmarshal(schemaJSON, &rawSchema); err != nil {
@@ -165,22 +320,32 @@ func parseSchema(schemaJSON []byte) (storage.Schema, error) {
 	fields := make(map[string]fieldSpec)
 	for name, spec := range rawSchema.Fields {
 		fields[name] = fieldSpec{
-			Type:   spec.Type,
-			Multi:  spec.Multi,
-			Weight: spec.Weight,
+			Type:       spec.Type,
+			Multi:      spec.Multi,
+			Weight:     spec.Weight,
+			CaseFold:   spec.CaseFold,
+			Required:   spec.Required,
+			Normalizer: spec.Normalizer,
+			FullText:   spec.FullText,
 		}
 	}
 
 	return &parsedSchema{
-		data:   schemaJSON,
-		fields: fields,
+		data:      schemaJSON,
+		fields:    fields,
+		tokenizer: rawSchema.FTSTokenizer,
+		strict:    rawSchema.Strict,
+		aliases:   rawSchema.Aliases,
 	}, nil
 }
 
 // parsedSchema implements storage.Schema interface
 type parsedSchema struct {
-	data   []byte
-	fields map[string]fieldSpec
+	data      []byte
+	fields    map[string]fieldSpec
+	tokenizer string
+	strict    bool
+	aliases   map[string]string
 }
 
 func (s *parsedSchema) ToJSON() ([]byte, error) {
@@ -193,7 +358,7 @@ func (s *parsedSchema) TextFieldsInOrder() []storage.TextField {
 
 	// Collect text field names
 	for name, spec := range s.fields {
-		if spec.Type == "text" {
+		if spec.Type == "text" || (spec.Type == "keyword" && spec.FullText) {
 			names = append(names, name)
 		}
 	}
@@ -223,9 +388,13 @@ func (s *parsedSchema) Get(name string) (storage.FieldSpec, bool) {
 		return storage.FieldSpec{}, false
 	}
 	return storage.FieldSpec{
-		Type:   storage.FieldType(spec.Type),
-		Multi:  spec.Multi,
-		Weight: spec.Weight,
+		Type:       storage.FieldType(spec.Type),
+		Multi:      spec.Multi,
+		Weight:     spec.Weight,
+		CaseFold:   spec.CaseFold,
+		Required:   spec.Required,
+		Normalizer: spec.Normalizer,
+		FullText:   spec.FullText,
 	}, true
 }
 
@@ -233,3 +402,80 @@ func (s *parsedSchema) HasField(name string) bool {
 	_, ok := s.fields[name]
 	return ok
 }
+
+func (s *parsedSchema) FTSTokenizer() string {
+	return s.tokenizer
+}
+
+func (s *parsedSchema) Strict() bool {
+	return s.strict
+}
+
+func (s *parsedSchema) Aliases() map[string]string {
+	return s.aliases
+}
+
+func (s *parsedSchema) RequiredFields() []string {
+	var names []string
+	for name, spec := range s.fields {
+		if spec.Required {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *parsedSchema) KeywordFields() []string {
+	var names []string
+	for name, spec := range s.fields {
+		if spec.Type == "keyword" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DiskSize reports page_count * page_size, which leaves out the WAL file.
+// Per-table sizes come from the dbstat virtual table, counting indexes and
+// FTS5 shadow tables (search_data, ...) towards their table; builds without
+// dbstat only report the total.
+func (a *Adapter) DiskSize(ctx context.Context, db *sql.DB, tables []string) (int64, map[string]int64, error) {
+	var pages, pageSize int64
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, nil, fmt.Errorf("page_count: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, nil, fmt.Errorf("page_size: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT m.tbl_name, SUM(s.pgsize) FROM dbstat s
+JOIN sqlite_master m ON m.name = s.name GROUP BY m.tbl_name`)
+	if err != nil {
+		return pages * pageSize, nil, nil
+	}
+	defer rows.Close()
+	want := map[string]bool{}
+	for _, t := range tables {
+		want[t] = true
+	}
+	perTable := map[string]int64{}
+	for rows.Next() {
+		var name string
+		var size int64
+		if err := rows.Scan(&name, &size); err != nil {
+			return 0, nil, fmt.Errorf("scan dbstat: %w", err)
+		}
+		if strings.HasPrefix(name, "search_") {
+			name = "search"
+		}
+		if want[name] {
+			perTable[name] += size
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("iterate dbstat: %w", err)
+	}
+	return pages * pageSize, perTable, nil
+}
@@ -1,5 +1,44 @@
 package sqlite
 
+// ddlUpgrades brings indexes created by older versions up to the current base
+// schema. Each statement is applied on open; "duplicate column" errors mean the
+// upgrade was already applied.
+var ddlUpgrades = []string{
+	"ALTER TABLE kw_dict ADD COLUMN value_folded TEXT",
+	"CREATE INDEX IF NOT EXISTS idx_kw_dict_folded ON kw_dict(field, value_folded)",
+	`CREATE TABLE IF NOT EXISTS saved_query (
+  name TEXT PRIMARY KEY,
+  query TEXT NOT NULL,
+  options_json TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+)`,
+	"ALTER TABLE items ADD COLUMN deleted_at INTEGER",
+	`CREATE TABLE IF NOT EXISTS field_geo (
+  item_id INTEGER NOT NULL REFERENCES items(id),
+  field TEXT NOT NULL,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  x REAL NOT NULL,
+  y REAL NOT NULL,
+  z REAL NOT NULL,
+  PRIMARY KEY (item_id, field, lat, lon)
+)`,
+	"CREATE INDEX IF NOT EXISTS idx_geo_lookup ON field_geo(field, lat, lon)",
+	"ALTER TABLE items ADD COLUMN data_enc TEXT",
+	"CREATE INDEX IF NOT EXISTS idx_items_encoded ON items(id) WHERE data_enc IS NOT NULL",
+	ddlItemIDHigh,
+}
+
+// ddlItemIDHigh records the id of a deleted newest item in meta, so
+// nextItemID never hands it out again
+const ddlItemIDHigh = `CREATE TRIGGER IF NOT EXISTS items_id_high AFTER DELETE ON items
+WHEN OLD.id > COALESCE((SELECT MAX(id) FROM items), 0)
+BEGIN
+  INSERT INTO meta(key, value) VALUES('item_id_high', OLD.id)
+    ON CONFLICT(key) DO UPDATE SET value = MAX(CAST(value AS INTEGER), CAST(excluded.value AS INTEGER));
+END`
+
 const ddlBase = `
 CREATE TABLE IF NOT EXISTS meta (
   key TEXT PRIMARY KEY,
@@ -10,12 +49,15 @@ CREATE TABLE IF NOT EXISTS items (
   id INTEGER PRIMARY KEY,
   path TEXT UNIQUE NOT NULL,
   data_json TEXT NOT NULL,
+  data_enc TEXT, -- NULL for plain JSON, 'gzip' for a compressed BLOB
   created_at INTEGER NOT NULL,
-  updated_at INTEGER NOT NULL
+  updated_at INTEGER NOT NULL,
+  deleted_at INTEGER
 );
 CREATE INDEX IF NOT EXISTS idx_items_path ON items(path);
 CREATE INDEX IF NOT EXISTS idx_items_updated ON items(updated_at);
 CREATE INDEX IF NOT EXISTS idx_items_created ON items(created_at);
+CREATE INDEX IF NOT EXISTS idx_items_encoded ON items(id) WHERE data_enc IS NOT NULL;
 
 CREATE TABLE IF NOT EXISTS field_present (
   item_id INTEGER NOT NULL REFERENCES items(id),
@@ -28,10 +70,12 @@ CREATE TABLE IF NOT EXISTS kw_dict (
   id INTEGER PRIMARY KEY,
   field TEXT NOT NULL,
   value TEXT NOT NULL,
+  value_folded TEXT,
   doc_freq INTEGER DEFAULT 0,
   UNIQUE (field, value)
 );
 CREATE INDEX IF NOT EXISTS idx_kw_dict_lookup ON kw_dict(field, value);
+CREATE INDEX IF NOT EXISTS idx_kw_dict_folded ON kw_dict(field, value_folded);
 
 CREATE TABLE IF NOT EXISTS kw_postings (
   field TEXT NOT NULL,
@@ -66,6 +110,20 @@ CREATE TABLE IF NOT EXISTS field_bool (
 );
 CREATE INDEX IF NOT EXISTS idx_bool_lookup ON field_bool(field, value);
 
+-- x, y, z is the point on the unit sphere, so a radius filter is a dot
+-- product and needs no trigonometric SQL functions
+CREATE TABLE IF NOT EXISTS field_geo (
+  item_id INTEGER NOT NULL REFERENCES items(id),
+  field TEXT NOT NULL,
+  lat REAL NOT NULL,
+  lon REAL NOT NULL,
+  x REAL NOT NULL,
+  y REAL NOT NULL,
+  z REAL NOT NULL,
+  PRIMARY KEY (item_id, field, lat, lon)
+);
+CREATE INDEX IF NOT EXISTS idx_geo_lookup ON field_geo(field, lat, lon);
+
 CREATE TABLE IF NOT EXISTS cursor_store (
   handle TEXT PRIMARY KEY,
   payload TEXT NOT NULL,
@@ -73,4 +131,12 @@ CREATE TABLE IF NOT EXISTS cursor_store (
   expires_at INTEGER NOT NULL
 );
 CREATE INDEX IF NOT EXISTS idx_cursor_expires ON cursor_store(expires_at);
+
+CREATE TABLE IF NOT EXISTS saved_query (
+  name TEXT PRIMARY KEY,
+  query TEXT NOT NULL,
+  options_json TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
 `
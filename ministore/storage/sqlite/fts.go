@@ -9,27 +9,58 @@ import (
 	"github.com/ministore/ministore/ministore/storage"
 )
 
-type FTS5 struct{}
+// FTS5 implements storage.FTS with an FTS5 table named search. Columns is
+// the column order of the existing table, which stops matching the schema's
+// sorted text fields once a field has been appended to it; nil means the
+// table was created from the schema and follows its order.
+type FTS5 struct {
+	Columns []string
+}
+
+// columns returns the search table's columns in table order
+func (f FTS5) columns(schema storage.Schema) []string {
+	if f.Columns != nil {
+		return f.Columns
+	}
+	fields := schema.TextFieldsInOrder()
+	cols := make([]string, 0, len(fields))
+	for _, tf := range fields {
+		cols = append(cols, tf.Name)
+	}
+	return cols
+}
 
 func (f FTS5) HasFTS(schema storage.Schema) bool {
 	return len(schema.TextFieldsInOrder()) > 0
 }
 
 func (f FTS5) CreateFTS(ctx context.Context, db *sql.DB, schema storage.Schema) error {
-	fields := schema.TextFieldsInOrder()
-	if len(fields) == 0 {
+	if !f.HasFTS(schema) {
 		return nil
 	}
+	sqlStmt, err := createFTSSQL(schema)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, sqlStmt); err != nil {
+		return fmt.Errorf("create fts: %w", err)
+	}
+	return nil
+}
+
+// createFTSSQL returns the statement creating the search table for schema's
+// text fields
+func createFTSSQL(schema storage.Schema) (string, error) {
+	fields := schema.TextFieldsInOrder()
 	cols := make([]string, 0, len(fields))
 	for _, tf := range fields {
 		cols = append(cols, tf.Name)
 	}
-	sqlStmt := fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS search USING fts5(%s, tokenize='unicode61')", strings.Join(cols, ", "))
-	_, err := db.ExecContext(ctx, sqlStmt)
+	tokenizer, err := storage.NormalizeFTSTokenizer(schema.FTSTokenizer())
 	if err != nil {
-		return fmt.Errorf("create fts: %w", err)
+		return "", err
 	}
-	return nil
+	return fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS search USING fts5(%s, tokenize='%s')", strings.Join(cols, ", "), tokenizer), nil
 }
 
 func (f FTS5) VerifyFTS(ctx context.Context, db *sql.DB, schema storage.Schema) error {
@@ -54,29 +85,136 @@ func (f FTS5) VerifyFTS(ctx context.Context, db *sql.DB, schema storage.Schema)
 	// Verify we can query each expected column
 	for _, tf := range fields {
 		testQuery := fmt.Sprintf("SELECT %s FROM search WHERE 0=1", tf.Name)
-		_, err := db.QueryContext(ctx, testQuery)
+		rows, err := db.QueryContext(ctx, testQuery)
 		if err != nil {
 			return fmt.Errorf("FTS column '%s' not found or invalid: %w", tf.Name, err)
 		}
+		rows.Close()
+	}
+
+	// The tokenizer is fixed when the table is created, so the schema must
+	// still agree with it
+	want, err := storage.NormalizeFTSTokenizer(schema.FTSTokenizer())
+	if err != nil {
+		return err
+	}
+	var ddl string
+	if err := db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'search'").Scan(&ddl); err != nil {
+		return fmt.Errorf("read FTS table definition: %w", err)
+	}
+	if got := tokenizerFromDDL(ddl); got != want {
+		return fmt.Errorf("FTS tokenizer is %q but schema wants %q; changing it requires a rebuild", got, want)
 	}
 
 	return nil
 }
 
-func (f FTS5) AddTextColumns(ctx context.Context, db *sql.DB, old, new storage.Schema) error {
-	oldFields := map[string]bool{}
-	for _, tf := range old.TextFieldsInOrder() {
-		oldFields[tf.Name] = true
+// readFTSColumns returns the columns of the search table in table order, or
+// nil if there is no such table
+func readFTSColumns(ctx context.Context, db *sql.DB) ([]string, error) {
+	var ddl string
+	err := db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'search'").Scan(&ddl)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	for _, tf := range new.TextFieldsInOrder() {
-		if !oldFields[tf.Name] {
-			_, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE search ADD COLUMN %s", tf.Name))
-			if err != nil {
-				return fmt.Errorf("alter fts: %w", err)
+	if err != nil {
+		return nil, fmt.Errorf("read FTS table definition: %w", err)
+	}
+	return columnsFromDDL(ddl), nil
+}
+
+// columnsFromDDL extracts the column names from a CREATE VIRTUAL TABLE ...
+// USING fts5(...) statement, skipping options such as tokenize='...'
+func columnsFromDDL(ddl string) []string {
+	open, end := strings.Index(ddl, "("), strings.LastIndex(ddl, ")")
+	if open < 0 || end < open {
+		return nil
+	}
+	body := ddl[open+1 : end]
+	var cols []string
+	var quote rune
+	start := 0
+	for i, c := range body {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
 			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			cols = appendFTSColumn(cols, body[start:i])
+			start = i + 1
 		}
 	}
-	return nil
+	return appendFTSColumn(cols, body[start:])
+}
+
+func appendFTSColumn(cols []string, arg string) []string {
+	arg = strings.TrimSpace(arg)
+	if arg == "" || strings.Contains(arg, "=") {
+		return cols
+	}
+	// Columns may carry an UNINDEXED flag after the name
+	name := strings.Fields(arg)[0]
+	return append(cols, strings.Trim(name, "\"`[]"))
+}
+
+// tokenizerFromDDL extracts the tokenize='...' argument from a CREATE
+// VIRTUAL TABLE statement, defaulting to unicode61 when absent
+func tokenizerFromDDL(ddl string) string {
+	const marker = "tokenize='"
+	i := strings.Index(ddl, marker)
+	if i < 0 {
+		return storage.DefaultFTSTokenizer
+	}
+	rest := ddl[i+len(marker):]
+	j := strings.Index(rest, "'")
+	if j < 0 {
+		return storage.DefaultFTSTokenizer
+	}
+	return strings.Join(strings.Fields(rest[:j]), " ")
+}
+
+func (f FTS5) AddTextColumnsDDL(old, new storage.Schema) ([]string, error) {
+	if !f.HasFTS(old) {
+		if !f.HasFTS(new) {
+			return nil, nil
+		}
+		stmt, err := createFTSSQL(new)
+		if err != nil {
+			return nil, err
+		}
+		return []string{stmt}, nil
+	}
+	// FTS5 virtual tables cannot be altered, so new columns mean copying the
+	// table into one with them appended. Existing columns keep their place,
+	// and ScoreCTEsAndJoin and HighlightCTEsAndCols go by the table's order.
+	cols := f.columns(old)
+	have := map[string]bool{}
+	for _, c := range cols {
+		have[c] = true
+	}
+	var added []string
+	for _, tf := range new.TextFieldsInOrder() {
+		if !have[tf.Name] {
+			added = append(added, tf.Name)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	tokenizer, err := storage.NormalizeFTSTokenizer(new.FTSTokenizer())
+	if err != nil {
+		return nil, err
+	}
+	oldCols := strings.Join(cols, ", ")
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE search_new USING fts5(%s, tokenize='%s')", strings.Join(append(append([]string(nil), cols...), added...), ", "), tokenizer),
+		fmt.Sprintf("INSERT INTO search_new(rowid, %s) SELECT rowid, %s FROM search", oldCols, oldCols),
+		"DROP TABLE search",
+		"ALTER TABLE search_new RENAME TO search",
+	}, nil
 }
 
 func (f FTS5) DeleteRow(ctx context.Context, tx *sql.Tx, itemID int64) error {
@@ -90,6 +228,16 @@ func (f FTS5) DeleteRow(ctx context.Context, tx *sql.Tx, itemID int64) error {
 	return nil
 }
 
+func (f FTS5) DeleteAll(ctx context.Context, tx *sql.Tx, schema storage.Schema) error {
+	if !f.HasFTS(schema) {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM search"); err != nil {
+		return fmt.Errorf("clear fts table: %w", err)
+	}
+	return nil
+}
+
 func (f FTS5) UpsertRow(ctx context.Context, tx *sql.Tx, itemID int64, schema storage.Schema, textVals map[string]*string) error {
 	fields := schema.TextFieldsInOrder()
 	if len(fields) == 0 {
@@ -128,40 +276,139 @@ func (f FTS5) CompileTextPredicate(b storage.Builder, schema storage.Schema, pre
 	return fmt.Sprintf("SELECT rowid AS item_id FROM search WHERE search MATCH %s", ph), nil, nil
 }
 
+// CompileTextPresent matches rows whose column is neither NULL (field
+// absent) nor empty
+func (f FTS5) CompileTextPresent(field string) string {
+	return fmt.Sprintf("SELECT rowid AS item_id FROM search WHERE %s <> ''", field)
+}
+
 func (f FTS5) ScoreCTEsAndJoin(b storage.Builder, schema storage.Schema, preds []storage.TextPredicate) ([]storage.CTE, string, string, error) {
 	if len(preds) == 0 {
 		return nil, "", "NULL", nil
 	}
+	// bm25 takes weights by column position, so follow the table's order
+	weights := map[string]float64{}
+	for _, tf := range schema.TextFieldsInOrder() {
+		weights[tf.Name] = tf.Weight
+	}
+	cols := f.columns(schema)
+	wparts := make([]string, 0, len(cols))
+	for _, name := range cols {
+		wparts = append(wparts, fmt.Sprintf("%g", weights[name]))
+	}
+	wstr := strings.Join(wparts, ", ")
+
+	// Without boosts one bm25 scores the predicates together. Boosted
+	// predicates are scored one CTE each, so each score can be weighted.
+	if !storage.Boosted(preds) {
+		parts := make([]string, 0, len(preds))
+		for _, p := range preds {
+			parts = append(parts, buildMatchString(schema, p))
+		}
+		ph := b.Arg(strings.Join(parts, " AND "))
+		cte := storage.CTE{
+			Name: "fts_score",
+			SQL:  fmt.Sprintf("SELECT rowid AS item_id, (-bm25(search, %s)) AS score FROM search WHERE search MATCH %s", wstr, ph),
+		}
+		joinSQL := "LEFT JOIN fts_score ON fts_score.item_id = i.id"
+		scoreExpr := "COALESCE(fts_score.score, 0)"
+		return []storage.CTE{cte}, joinSQL, scoreExpr, nil
+	}
+
+	ctes := make([]storage.CTE, 0, len(preds))
+	joins := make([]string, 0, len(preds))
+	scoreParts := make([]string, 0, len(preds))
+	for i, p := range preds {
+		name := fmt.Sprintf("fts_score_%d", i)
+		ph := b.Arg(buildMatchString(schema, p))
+		ctes = append(ctes, storage.CTE{
+			Name: name,
+			SQL:  fmt.Sprintf("SELECT rowid AS item_id, (-bm25(search, %s)) AS score FROM search WHERE search MATCH %s", wstr, ph),
+		})
+		joins = append(joins, fmt.Sprintf("LEFT JOIN %s ON %s.item_id = i.id", name, name))
+		scoreParts = append(scoreParts, fmt.Sprintf("COALESCE(%s.score, 0) * %g", name, p.BoostFactor()))
+	}
+	return ctes, strings.Join(joins, "\n  "), strings.Join(scoreParts, " + "), nil
+}
+
+func (f FTS5) HighlightCTEsAndCols(b storage.Builder, schema storage.Schema, preds []storage.TextPredicate, spec storage.HighlightSpec) ([]storage.CTE, string, []storage.HighlightCol, error) {
+	fields := storage.HighlightFields(schema, preds)
+	if len(fields) == 0 {
+		return nil, "", nil, nil
+	}
+
+	colIndex := map[string]int{}
+	for i, name := range f.columns(schema) {
+		colIndex[name] = i
+	}
+
+	// snippet() only works alongside a MATCH on the same table, so snippets
+	// come from their own CTE matched against any of the text predicates.
 	parts := make([]string, 0, len(preds))
 	for _, p := range preds {
 		parts = append(parts, buildMatchString(schema, p))
 	}
-	match := strings.Join(parts, " AND ")
-	ph := b.Arg(match)
-	weights := schema.TextFieldsInOrder()
-	wparts := make([]string, 0, len(weights))
-	for _, tf := range weights {
-		wparts = append(wparts, fmt.Sprintf("%g", tf.Weight))
+	tokens := min(spec.Tokens, 64) // FTS5 caps snippet length at 64 tokens
+	cteName := "fts_highlight"
+	if spec.Whole {
+		cteName = "fts_marked"
 	}
-	wstr := strings.Join(wparts, ", ")
+
+	// Placeholders are positional, so allocate them in text order
+	selects := make([]string, 0, len(fields))
+	cols := make([]storage.HighlightCol, 0, len(fields))
+	for i, name := range fields {
+		alias := fmt.Sprintf("hl_%d", i)
+		phOpen := b.Arg(spec.Open)
+		phClose := b.Arg(spec.Close)
+		if spec.Whole {
+			selects = append(selects, fmt.Sprintf("highlight(search, %d, %s, %s) AS %s", colIndex[name], phOpen, phClose, alias))
+		} else {
+			selects = append(selects, fmt.Sprintf("snippet(search, %d, %s, %s, '...', %d) AS %s", colIndex[name], phOpen, phClose, tokens, alias))
+		}
+		cols = append(cols, storage.HighlightCol{Field: name, Expr: cteName + "." + alias})
+	}
+	phMatch := b.Arg(strings.Join(parts, " OR "))
+
 	cte := storage.CTE{
-		Name: "fts_score",
-		SQL:  fmt.Sprintf("SELECT rowid AS item_id, (-bm25(search, %s)) AS score FROM search WHERE search MATCH %s", wstr, ph),
+		Name: cteName,
+		SQL:  fmt.Sprintf("SELECT rowid AS item_id, %s FROM search WHERE search MATCH %s", strings.Join(selects, ", "), phMatch),
 	}
-	joinSQL := "LEFT JOIN fts_score ON fts_score.item_id = i.id"
-	scoreExpr := "COALESCE(fts_score.score, 0)"
-	return []storage.CTE{cte}, joinSQL, scoreExpr, nil
+	joinSQL := fmt.Sprintf("LEFT JOIN %s ON %s.item_id = i.id", cteName, cteName)
+	return []storage.CTE{cte}, joinSQL, cols, nil
 }
 
 func buildMatchString(schema storage.Schema, pred storage.TextPredicate) string {
 	term := quoteFTSTerm(pred.Query)
+	if pred.Prefix {
+		term += "*"
+	}
+	if len(pred.AnyOf) > 0 {
+		terms := make([]string, len(pred.AnyOf))
+		for i, t := range pred.AnyOf {
+			terms[i] = quoteFTSTerm(t)
+		}
+		term = fmt.Sprintf("(%s)", strings.Join(terms, " OR "))
+	}
+	if len(pred.Near) > 0 {
+		terms := make([]string, len(pred.Near))
+		for i, t := range pred.Near {
+			terms[i] = quoteFTSTerm(t)
+		}
+		term = fmt.Sprintf("NEAR(%s, %d)", strings.Join(terms, " "), pred.NearDistance)
+	}
 	if pred.Field != nil {
 		return fmt.Sprintf("%s:%s", *pred.Field, term)
 	}
-	fields := schema.TextFieldsInOrder()
-	parts := make([]string, 0, len(fields))
-	for _, tf := range fields {
-		parts = append(parts, fmt.Sprintf("%s:%s", tf.Name, term))
+	names := pred.Fields
+	if len(names) == 0 {
+		for _, tf := range schema.TextFieldsInOrder() {
+			names = append(names, tf.Name)
+		}
+	}
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s:%s", name, term))
 	}
 	return fmt.Sprintf("(%s)", strings.Join(parts, " OR "))
 }
@@ -0,0 +1,130 @@
+package ministore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ministore/ministore/ministore/ops"
+)
+
+// IndexTx is the transactional handle passed to the callback of
+// Index.WithTx. Its reads see its own writes; nothing is visible to other
+// readers until the callback returns nil. It must not be used after the
+// callback returns.
+type IndexTx struct {
+	ix *Index
+	tx *sql.Tx
+
+	// docs holds DocStore writes, applied around the commit so a rolled
+	// back transaction leaves the store untouched
+	docs    *docWrites
+	written bool
+}
+
+// WithTx runs fn in one transaction, committing when fn returns nil and
+// rolling back when it returns an error (returned as is) or panics. It
+// allows read-modify-write and conditional multi-document updates. On
+// PostgreSQL, Get locks the row it reads until the transaction ends; on
+// SQLite, a concurrent write between a Get and a Put makes the Put or the
+// commit fail with ErrSQL rather than overwrite it.
+func (ix *Index) WithTx(ctx context.Context, fn func(tx *IndexTx) error) error {
+	if err := ix.checkWritable("transaction"); err != nil {
+		return err
+	}
+	tx, err := ix.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Wrap(ErrSQL, "begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	itx := &IndexTx{ix: ix, tx: tx, docs: ix.newDocWrites()}
+	if err := fn(itx); err != nil {
+		return err
+	}
+
+	if err := ix.commitDocs(tx, itx.docs); err != nil {
+		return err
+	}
+	if itx.written {
+		ix.invalidateCache()
+	}
+	return ix.removeDocs(itx.docs)
+}
+
+// Put inserts or updates an item from JSON, like Index.PutJSON
+func (t *IndexTx) Put(ctx context.Context, docJSON []byte) error {
+	_, err := t.PutPath(ctx, docJSON)
+	return err
+}
+
+// PutPath is Put returning the path the item was stored under, which
+// IndexOptions.AutoPath chose if the document had none
+func (t *IndexTx) PutPath(ctx context.Context, docJSON []byte) (string, error) {
+	docJSON, _, err := t.ix.autoPath(docJSON)
+	if err != nil {
+		return "", err
+	}
+	schema := t.ix.currentSchema()
+	prep, err := t.ix.preparePut(schema, docJSON)
+	if err != nil {
+		return "", prepareError("prepare put", err)
+	}
+	_, _, err = ops.ExecutePut(ctx, t.tx, t.ix.adapter.SQL(), t.ix.adapter.FTS(), schema.AsStorageSchema(), prep, t.ix.nowMS())
+	if err != nil {
+		return "", putError(err)
+	}
+	t.docs.recordPut(prep, docJSON)
+	t.written = true
+	return prep.Path, nil
+}
+
+// Delete removes an item by path, reporting whether it existed
+func (t *IndexTx) Delete(ctx context.Context, path string) (bool, error) {
+	sqlt := t.ix.adapter.SQL()
+	var itemID, createdAt int64
+	err := t.tx.QueryRowContext(ctx, sqlt.FindItemIDByPath, path).Scan(&itemID, &createdAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, Wrap(ErrSQL, "find item", err)
+	}
+	if err := ops.DeleteByItemID(ctx, t.tx, sqlt, t.ix.adapter.FTS(), itemID); err != nil {
+		return false, Wrap(ErrSQL, "delete item", err)
+	}
+	t.docs.remove(path)
+	t.written = true
+	return true, nil
+}
+
+// Get retrieves an item by path, like Index.Get, including writes made
+// earlier in the transaction
+func (t *IndexTx) Get(ctx context.Context, path string) (ItemView, error) {
+	var itemID int64
+	var dataJSON string
+	var dataEnc sql.NullString
+	var createdAt, updatedAt int64
+
+	err := t.tx.QueryRowContext(ctx, t.ix.adapter.SQL().GetItemByPathForUpdate, path).Scan(&itemID, &dataJSON, &dataEnc, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return ItemView{}, NotFoundError(path)
+	}
+	if err != nil {
+		return ItemView{}, Wrap(ErrSQL, "get item", err)
+	}
+	doc, ok := t.docs.get(path)
+	if !ok {
+		if doc, err = t.ix.readDoc(path, []byte(dataJSON), dataEnc); err != nil {
+			return ItemView{}, err
+		}
+	}
+
+	return ItemView{
+		Path:    path,
+		DocJSON: doc,
+		Meta: ItemMeta{
+			CreatedAtMS: createdAt,
+			UpdatedAtMS: updatedAt,
+		},
+	}, nil
+}
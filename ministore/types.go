@@ -1,6 +1,9 @@
 package ministore
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // CursorMode specifies how cursors are returned
 type CursorMode string
@@ -23,7 +26,25 @@ const (
 // RankMode configures result ranking
 type RankMode struct {
 	Kind  RankModeKind
-	Field string // only used when Kind==RankField
+	Field string    // only used when Kind==RankField; sorts descending
+	Keys  []SortKey // only used when Kind==RankField; overrides Field
+
+	// Ascending reverses RankRecency (oldest first) and RankField (smallest
+	// first, or each key's direction flipped), tie-breakers included
+	Ascending bool
+
+	// MissingLast keeps RankField results that lack a sort key's field,
+	// ordering them after every item holding it, in either direction.
+	// Their score (and sort value) is then ±math.MaxFloat64.
+	MissingLast bool
+}
+
+// SortKey is one number or date field in a RankField sort. Items missing any
+// sort key's field are left out of the results unless RankMode.MissingLast
+// is set.
+type SortKey struct {
+	Field string
+	Desc  bool
 }
 
 // OutputFieldSelectorKind specifies which fields to include in output
@@ -39,15 +60,70 @@ const (
 type OutputFieldSelector struct {
 	Kind   OutputFieldSelectorKind
 	Fields []string // only used when Kind==ShowFields
+	// Nested returns dotted fields (author.name) as nested objects,
+	// {"author":{"name":...}}, rather than flat "author.name" keys
+	Nested bool
 }
 
 // IndexOptions configures index behavior
 type IndexOptions struct {
-	CursorTTL          time.Duration // default 1h
-	Now                func() time.Time
+	CursorTTL time.Duration // default 1h; a short cursor expires exactly CursorTTL after its page
+	Now       func() time.Time
+	Location  *time.Location // time zone for bare dates; default UTC, persisted at Create
+	// MinContainsLen and MinPrefixLen are the shortest contains and prefix
+	// patterns a query may use; MaxPrefixExpansion rejects keyword prefixes
+	// matching more distinct values than this. Zero keeps the default.
 	MinContainsLen     int
 	MinPrefixLen       int
 	MaxPrefixExpansion int
+	// ReadOnly opens the database read-only (SQLite mode=ro, Postgres
+	// default_transaction_read_only) and makes every write method fail with
+	// ErrReadOnly. Short cursors are not stored; pages get full tokens.
+	ReadOnly bool
+	// DocStore, if set, holds full documents; the index keeps only path and
+	// indexed fields in data_json. Nil stores whole documents in the index.
+	DocStore DocStore
+	// CacheSize enables an in-memory LRU of up to this many search pages;
+	// 0 disables it. Writes through this Index invalidate the cache, writes
+	// from other processes do not, and relative dates (now-1d, today) are
+	// resolved when a page is cached, so keep CacheTTL short if either matters.
+	CacheSize int
+	CacheTTL  time.Duration // 0 keeps pages until evicted or invalidated
+	// UpsertKey names a single-valued keyword field that identifies items
+	// instead of path. Every put must carry it; a put whose key value is
+	// held by an item at another path moves that item to the new path,
+	// keeping its created time, and a put onto a path held by an item with a
+	// different key value fails with ErrSchema.
+	UpsertKey string
+	// Observer, if set, is called after searches, puts and deletes with
+	// their duration and outcome, for metrics; see MetricsObserver
+	Observer Observer
+	// DefaultLimit is the page size of searches leaving SearchOptions.Limit
+	// at 0; zero means DefaultSearchLimit. MaxLimit, if positive, rejects
+	// searches asking for more results per page with ErrQueryRejected.
+	DefaultLimit int
+	MaxLimit     int
+	// CompressDocs gzips each document before storing it in data_json
+	// (SQLite only). Rows carry a data_enc marker, so toggling it between
+	// opens leaves existing rows readable; they are compressed when rewritten.
+	// like(...) is rejected while any compressed row remains.
+	CompressDocs bool
+	// CanonicalizeJSON stores documents re-encoded with sorted keys and no
+	// extra whitespace, so equal documents store, and Get returns, the same
+	// bytes however they were formatted. Numbers keep their written form.
+	// Existing rows change only when rewritten.
+	CanonicalizeJSON bool
+	// AutoPath, if set, names documents put without a path: PutJSON,
+	// PutJSONPath, Batch, IndexTx.Put and Import call it with the decoded
+	// document and store the document under the path it returns. See
+	// UUIDPath and ContentHashPath.
+	AutoPath func(doc map[string]any) (string, error)
+	// AllowUnanchoredOrBranches accepts an OR with a positive anchor on only
+	// one side. The other side is evaluated within the rest of an enclosing
+	// AND: status:open AND (tags:rust OR NOT archived) also matches open
+	// items that are not archived. Outside any AND it is ignored, so
+	// tags:rust OR NOT archived matches the same items as tags:rust.
+	AllowUnanchoredOrBranches bool
 }
 
 // DefaultIndexOptions returns sensible defaults
@@ -55,9 +131,11 @@ func DefaultIndexOptions() IndexOptions {
 	return IndexOptions{
 		CursorTTL:          DefaultCursorTTL,
 		Now:                time.Now,
+		Location:           time.UTC,
 		MinContainsLen:     DefaultMinContainsLen,
 		MinPrefixLen:       DefaultMinPrefixLen,
 		MaxPrefixExpansion: DefaultMaxPrefixExpansion,
+		DefaultLimit:       DefaultSearchLimit,
 	}
 }
 
@@ -69,6 +147,81 @@ type SearchOptions struct {
 	CursorMode CursorMode
 	Show       OutputFieldSelector
 	Explain    bool
+	Highlight  *HighlightSpec // nil disables highlights
+
+	// ReturnMatchPositions adds a "match_positions" object to each result,
+	// mapping each matched text field to [start, end, start, end, ...] byte
+	// offsets of its matched terms in the field value, for clients that
+	// highlight on their own
+	ReturnMatchPositions bool
+
+	// IncludeDeleted also returns soft-deleted items. They have no index
+	// entries, so they only match predicates on path, created or updated, or
+	// negations.
+	IncludeDeleted bool
+
+	// ExplainFormat selects the explain output when Explain is set.
+	// ExplainFormatJSON adds ExplainJSON, a tree of typed plan nodes with
+	// the fraction of items each one matches; measuring that runs one
+	// extra query per node.
+	ExplainFormat ExplainFormat
+
+	// MinScore drops results whose relevance score is below it. It only
+	// applies under RankDefault to queries with text predicates, and the
+	// score is the backend's raw one: on SQLite the negated bm25 (0 and up,
+	// unbounded, scaled by field weights), on PostgreSQL the sum of
+	// ts_rank_cd over the query's text predicates, with field weights
+	// applied as tsvector weight labels. Thresholds do not carry over
+	// between backends.
+	MinScore *float64
+
+	// Profile, with Explain, runs each step of the query on its own and
+	// reports its row count and elapsed time in Profile. It is for finding
+	// the expensive step of a slow query; profiled pages are never cached.
+	Profile bool
+
+	// Snapshot keeps paging stable on a live index: the first page records
+	// the highest item id in its cursor, and it and every later page leave
+	// out items inserted after that. Updates and deletions of existing items
+	// still show through.
+	Snapshot bool
+
+	// DistinctBy collapses results to the top-ranked item per value of this
+	// keyword, number or date field, e.g. one result per author. Items
+	// without the field are left out; a multi-valued field groups by its
+	// smallest value. Cursors page over the collapsed results.
+	DistinctBy string
+
+	// Timeout, if positive, bounds how long the search may run, on top of any
+	// deadline already on the caller's context. A query still running when it
+	// expires is interrupted and Search fails with ErrTimeout.
+	Timeout time.Duration
+
+	// SuggestOnEmpty, when a first page comes back empty, looks for an
+	// exact keyword predicate (tags:rus) whose value no item holds and
+	// returns the most frequent values starting with it in Suggestions
+	SuggestOnEmpty bool
+
+	// EstimateTotal fills SearchResultPage.EstimatedTotal on every page. It
+	// costs one count query per page; without it the total is only known on
+	// a first page that holds every result.
+	EstimateTotal bool
+}
+
+// ExplainFormat selects how a query plan is reported
+type ExplainFormat string
+
+const (
+	ExplainFormatText ExplainFormat = ""     // ExplainSQL and ExplainSteps only
+	ExplainFormatJSON ExplainFormat = "json" // also ExplainJSON
+)
+
+// HighlightSpec requests snippets around matched terms for text fields. Each
+// result then carries a "highlights" object mapping field name to snippet.
+type HighlightSpec struct {
+	Tokens int    // tokens of context per snippet [default: 10]
+	Open   string // marker before a matched term [default: "<b>"]
+	Close  string // marker after a matched term [default: "</b>"]
 }
 
 // ItemMeta holds item metadata
@@ -91,6 +244,44 @@ type SearchResultPage struct {
 	HasMore      bool
 	ExplainSQL   string
 	ExplainSteps []string
+	ExplainJSON  []byte        // plan tree, only with ExplainFormat == ExplainFormatJSON
+	Profile      []StepProfile // only with SearchOptions.Profile
+
+	// Suggestions are values of SuggestField completing an unknown keyword
+	// value, most frequent first; only with SearchOptions.SuggestOnEmpty
+	// and no results
+	Suggestions  []ValueCount
+	SuggestField string
+
+	// Limit is the page size asked for, after IndexOptions.DefaultLimit, and
+	// Returned the number of Items, which is less on a last page
+	Limit    int
+	Returned int
+
+	// EstimatedTotal is the number of items the query matches across all
+	// pages, for "showing 20 of ~340". It is set with
+	// SearchOptions.EstimateTotal, and on a first page that holds every
+	// result; nil otherwise. It counts items as of this page, and ignores
+	// MinScore and DistinctBy, so it can run above what paging yields.
+	EstimatedTotal *uint64
+
+	fts bool // the query ran text predicates, for SearchEvent.FTS
+}
+
+// StepProfile is the measured cost of one query step. A step that combines
+// others (AND, OR, NOT) is timed together with its inputs.
+type StepProfile struct {
+	CTE     string
+	Step    string
+	Rows    uint64
+	Elapsed time.Duration
+}
+
+// CacheStats reports search result cache usage
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
 }
 
 // ValueCount is a field value with count
@@ -99,6 +290,41 @@ type ValueCount struct {
 	Count uint64
 }
 
+// ValuesPage is the result of DiscoverValuesTotal: the top values, how many
+// distinct values there are in all, and whether Values leaves some out
+type ValuesPage struct {
+	Values        []ValueCount
+	TotalDistinct uint64
+	HasMore       bool
+}
+
+// SizeStats describes what an index holds and where its space goes
+type SizeStats struct {
+	Items        uint64           // live items
+	DeletedItems uint64           // soft-deleted items, kept for Restore
+	Rows         map[string]int64 // row count per table, e.g. "kw_postings"
+	Bytes        int64            // on-disk size of the index
+	// TableBytes is the on-disk size per table with its indexes, for the
+	// tables the backend can size
+	TableBytes map[string]int64
+}
+
+// KeywordStat is a keyword value with the number of items containing it and
+// the size of the collection, enough to compute IDF
+type KeywordStat struct {
+	Value      string
+	DocFreq    uint64
+	TotalItems uint64
+}
+
+// IDF returns the inverse document frequency ln(TotalItems / DocFreq)
+func (s KeywordStat) IDF() float64 {
+	if s.DocFreq == 0 || s.TotalItems == 0 {
+		return 0
+	}
+	return math.Log(float64(s.TotalItems) / float64(s.DocFreq))
+}
+
 // FieldOverview describes a field's statistics
 type FieldOverview struct {
 	Field    string
@@ -118,4 +344,37 @@ type StatsResult struct {
 	Max    *float64
 	Avg    *float64
 	Median *float64
+
+	// Percentiles maps each percentile requested from Stats (0-100) to its
+	// value; nil when none were requested or the field has no values
+	Percentiles map[float64]float64
+}
+
+// OptimizeOptions configures Index.Optimize
+type OptimizeOptions struct {
+	Vacuum bool // also VACUUM, rewriting the whole database file
+}
+
+// Bucket is a number histogram bucket covering [Lo, Hi)
+type Bucket struct {
+	Lo    float64
+	Hi    float64
+	Count uint64
+}
+
+// DateInterval is the calendar width of a date histogram bucket
+type DateInterval string
+
+const (
+	IntervalDay   DateInterval = "day"
+	IntervalWeek  DateInterval = "week" // weeks start on Monday
+	IntervalMonth DateInterval = "month"
+)
+
+// DateBucket is a date histogram bucket covering [Start, End) in the index
+// time zone
+type DateBucket struct {
+	Start time.Time
+	End   time.Time
+	Count uint64
 }
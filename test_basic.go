@@ -144,7 +144,7 @@ func main() {
 
 	// Test 10: Test Optimize
 	fmt.Println("\nTest 10: Testing optimize operation...")
-	if err := ix.Optimize(ctx); err != nil {
+	if err := ix.Optimize(ctx, ministore.OptimizeOptions{Vacuum: true}); err != nil {
 		log.Fatalf("Failed to optimize: %v", err)
 	}
 	fmt.Println("✓ Optimize completed")